@@ -4,76 +4,470 @@
 package audio
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
-	"os"
-
-	"github.com/Comcast/gaad"
 )
 
-// AACFormat implements the Format interface for AAC audio files.
+// AACFormat implements the Format interface for AAC audio, both raw ADTS
+// streams and AAC tracks in MP4 (M4A) files.
 type AACFormat struct{}
 
-// GetMetadata extracts metadata from an AAC file.
+func init() {
+	Register(Registration{
+		Name:       "aac",
+		Container:  ContainerADTS,
+		Codec:      "AAC",
+		MIMETypes:  []string{"audio/aac", "audio/aacp"},
+		Extensions: []string{".aac"},
+		Sniff:      isADTS,
+		New:        func() Format { return &AACFormat{} },
+		Decodable:  true,
+	})
+	Register(Registration{
+		Name:       "m4a",
+		Container:  ContainerMP4,
+		Codec:      "AAC",
+		MIMETypes:  []string{"audio/mp4", "audio/x-m4a", "audio/m4a"},
+		Extensions: []string{".m4a", ".m4b", ".mp4"},
+		Sniff:      isMP4,
+		New:        func() Format { return &AACFormat{} },
+		Decodable:  true,
+	})
+}
+
+// GetMetadata extracts metadata from an AAC or M4A file.
 func (f *AACFormat) GetMetadata(filename string, fileSize int64) (AudioMetadata, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return AudioMetadata{}, fmt.Errorf("failed to open AAC file: %v", err)
+	return streamMetadata(f, filename, fileSize)
+}
+
+// ConvertToSamples converts an AAC or M4A file to a slice of float32 samples.
+func (f *AACFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
+	return streamSamples(f, filename, targetSampleRate)
+}
+
+// Decode streams the audio of an ADTS stream or of the first AAC track in an
+// MP4 file, telling them apart by content, mixed to mono without the LFE
+// channel. AAC-LC is decoded in full. HE-AAC is decoded at its core rate,
+// half the output rate, without the spectral band replication that restores
+// the upper frequencies; the core still carries everything below a quarter of
+// the output rate, which covers speech.
+func (f *AACFormat) Decode(r io.Reader) (SampleStream, AudioMetadata, error) {
+	return f.decode(r, false)
+}
+
+// DecodeChannels is like Decode but keeps the channels interleaved, in the
+// order of the stream's elements.
+func (f *AACFormat) DecodeChannels(r io.Reader) (SampleStream, AudioMetadata, error) {
+	return f.decode(r, true)
+}
+
+func (f *AACFormat) decode(r io.Reader, channels bool) (SampleStream, AudioMetadata, error) {
+	var header []byte
+	if ra, ok := r.(io.ReaderAt); ok {
+		header = make([]byte, 12)
+		n, _ := ra.ReadAt(header, 0)
+		header = header[:n]
+	} else {
+		br := bufio.NewReader(r)
+		header, _ = br.Peek(12)
+		r = br
+	}
+	if isMP4(header) {
+		return decodeMP4(r, channels)
 	}
-	defer file.Close()
+	return decodeADTS(r, channels)
+}
 
-	// Read the file to parse ADTS header
-	data, err := io.ReadAll(file)
+// decodeMP4 decodes the first AAC track of an MP4 file. The duration comes
+// from the track's edit list, or its media header if there is none, and the
+// samples the edit leaves out are dropped. The channels are interleaved if
+// channels is set and mixed to mono otherwise.
+func decodeMP4(r io.Reader, channels bool) (SampleStream, AudioMetadata, error) {
+	ra, size, err := mp4Source(r)
+	if err != nil {
+		return nil, AudioMetadata{}, fmt.Errorf("failed to read MP4 file: %v", err)
+	}
+	track, err := parseMP4(ra, size)
 	if err != nil {
-		return AudioMetadata{}, fmt.Errorf("failed to read AAC file: %v", err)
+		return nil, AudioMetadata{}, err
+	}
+	config, err := parseAudioSpecificConfig(track.config)
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+	dec, err := newAACDecoder(config)
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+
+	metadata := aacMetadata(config)
+	metadata.Format = "M4A"
+	if channels {
+		dec.interleave = metadata.Channels
+	}
+	metadata.Bitrate = track.bitrate / 1000
+
+	// Track times are in its timescale, normally the output rate, which is
+	// twice the core rate with SBR
+	toCore := func(t int64) int64 {
+		if track.timescale == 0 {
+			return t
+		}
+		return t * int64(config.sampleRate) / int64(track.timescale)
+	}
+	skip, length := toCore(track.editStart), int64(-1)
+	if track.editDuration > 0 {
+		length = toCore(track.editDuration)
+	} else if track.duration > 0 {
+		length = toCore(int64(track.duration)) - skip
+	}
+	if length > 0 {
+		metadata.Duration = float64(length) / float64(config.sampleRate)
+	}
+
+	// The decoder buffer bounds an access unit, so larger sizes in the
+	// sample table are corrupt and must not be allocated
+	maxUnit := aacMaxUnit * aacMaxChannels
+	if config.channels > 0 {
+		maxUnit = aacMaxUnit * config.channels
+	}
+	for i, size := range track.sizes {
+		if int64(size) > int64(maxUnit) {
+			return nil, AudioMetadata{}, fmt.Errorf("MP4 sample %d too large: %d bytes", i, size)
+		}
+	}
+
+	i := 0
+	var unit []byte
+	next := func() ([]byte, error) {
+		if i >= len(track.sizes) {
+			return nil, io.EOF
+		}
+		if cap(unit) < int(track.sizes[i]) {
+			unit = make([]byte, track.sizes[i])
+		}
+		unit = unit[:track.sizes[i]]
+		n, err := ra.ReadAt(unit, track.offsets[i])
+		i++
+		if n < len(unit) {
+			if err == io.EOF {
+				// A truncated file ends with the last complete frame
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		return unit, nil
 	}
+	return newAACStream(next, dec.decodeFrame, max(dec.interleave, 1), skip, length), metadata, nil
+}
 
-	// Parse ADTS header
-	adts, err := gaad.ParseADTS(data)
+// decodeADTS decodes an ADTS stream. When r can seek, the frame headers are
+// scanned first for the exact duration. The channels are interleaved if
+// channels is set and mixed to mono otherwise.
+func decodeADTS(r io.Reader, channels bool) (SampleStream, AudioMetadata, error) {
+	var duration int64 // In samples
+	if rs, ok := r.(io.ReadSeeker); ok {
+		pos, err := rs.Seek(0, io.SeekCurrent)
+		if err == nil {
+			adts := &adtsReader{r: bufio.NewReaderSize(rs, adtsMaxFrame)}
+			for {
+				_, h, err := adts.next()
+				if err != nil {
+					break
+				}
+				duration += int64(h.blocks) * aacFrameLength
+			}
+			if _, err := rs.Seek(pos, io.SeekStart); err != nil {
+				return nil, AudioMetadata{}, err
+			}
+		}
+	}
+
+	adts := &adtsReader{r: bufio.NewReaderSize(r, adtsMaxFrame)}
+	frame, h, err := adts.peek()
+	if err == io.EOF {
+		return nil, AudioMetadata{}, fmt.Errorf("no ADTS frames found")
+	} else if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+	config := aacConfig{
+		objectType:      h.objectType,
+		sampleRateIndex: h.sampleRateIndex,
+		sampleRate:      aacSampleRates[h.sampleRateIndex],
+		channels:        aacChannelCount(h.channelConfig),
+	}
+	if config.channels == 0 {
+		// The channels are described by a program config element in the stream
+		if pce := adtsProgramConfig(frame, h); pce > 0 {
+			config.channels = pce
+		}
+	}
+	dec, err := newAACDecoder(config)
 	if err != nil {
-		return AudioMetadata{}, fmt.Errorf("failed to parse ADTS: %v", err)
+		return nil, AudioMetadata{}, err
+	}
+
+	metadata := aacMetadata(config)
+	metadata.Format = "AAC"
+	if channels {
+		dec.interleave = metadata.Channels
 	}
+	metadata.Duration = float64(duration) / float64(config.sampleRate)
 
-	// Extract metadata from ADTS
-	channels := int(adts.ChannelConfiguration)
+	next := func() ([]byte, error) {
+		frame, _, err := adts.next()
+		return frame, err
+	}
+	return newAACStream(next, dec.decodeADTS, max(dec.interleave, 1), 0, -1), metadata, nil
+}
+
+// aacMetadata describes the audio decoded with config.
+func aacMetadata(config aacConfig) AudioMetadata {
+	codec := "AAC-LC"
+	if config.ps {
+		codec = "HE-AAC v2"
+	} else if config.sbr {
+		codec = "HE-AAC"
+	}
+	channels := config.channels
 	if channels == 0 {
 		// Default to mono if channel configuration is not defined
 		channels = 1
 	}
+	return AudioMetadata{
+		Codec:      codec,
+		SampleRate: config.sampleRate,
+		Channels:   channels,
+		BitDepth:   16, // AAC typically uses 16-bit equivalent
+	}
+}
 
-	// Calculate approximate duration
-	var duration float64
-	if adts.Bitrate > 0 && adts.SamplingFrequency > 0 {
-		// Estimate duration from file size and bitrate
-		duration = float64(fileSize*8) / float64(adts.Bitrate)
+// aacChannelCount returns the number of channels for a channel
+// configuration, or 0 if they are given by a program config element.
+func aacChannelCount(config int) int {
+	switch {
+	case config == 7:
+		return 8
+	case config > 7:
+		return 0
 	}
+	return config
+}
 
-	// Get profile description
-	var codec string
-	if int(adts.Profile) < len(gaad.AACProfileType) {
-		codec = gaad.AACProfileType[adts.Profile]
-	} else {
-		codec = "AAC"
+// newAACStream decodes the access units returned by next to the given number
+// of interleaved channels, dropping skip samples per channel from the start
+// and ending after length samples per channel unless length is negative.
+// Units that fail to decode are replaced with silence to keep the timing.
+func newAACStream(next func() ([]byte, error), decode func([]byte) ([]float32, error), channels int, skip, length int64) SampleStream {
+	skip *= int64(channels)
+	if length >= 0 {
+		length *= int64(channels)
 	}
+	var emitted int64
+	valid := 0        // Units decoded successfully
+	var failure error // Error from the first unit that failed to decode
 
-	return AudioMetadata{
-		Duration:     duration,
-		SampleRate:   int(adts.SamplingFrequency),
-		Channels:     channels,
-		Bitrate:      int(adts.Bitrate),
-		Format:       "AAC",
-		Codec:        codec,
-		BitDepth:     16, // AAC typically uses 16-bit equivalent
-		OriginalSize: fileSize,
-	}, nil
-}
-
-// ConvertToSamples converts AAC audio data to float32 samples at the target sample rate.
-func (f *AACFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
-	// Note: The gaad library appears to be primarily a parser for ADTS headers
-	// and doesn't provide full AAC decoding capabilities. For full AAC decoding,
-	// we would need a different library or external tool like ffmpeg.
-	// For now, we'll return an error indicating that AAC decoding is not fully implemented.
-	
-	return nil, fmt.Errorf("AAC audio decoding is not fully implemented yet - the gaad library only provides ADTS parsing. Consider using ffmpeg or another decoder library for full AAC support")
+	return &blockStream{
+		next: func() ([]float32, error) {
+			for {
+				if length >= 0 && emitted >= length {
+					return nil, io.EOF
+				}
+				unit, err := next()
+				if err == io.EOF {
+					if valid == 0 {
+						if failure != nil {
+							return nil, fmt.Errorf("no valid AAC frames decoded: %v", failure)
+						}
+						return nil, fmt.Errorf("no valid AAC frames decoded")
+					}
+					return nil, io.EOF
+				} else if err != nil {
+					return nil, err
+				}
+
+				samples, err := decode(unit)
+				if err != nil {
+					if failure == nil {
+						failure = err
+					}
+					samples = make([]float32, aacFrameLength*channels)
+				} else {
+					valid++
+				}
+
+				if skip > 0 {
+					n := min(skip, int64(len(samples)))
+					samples = samples[n:]
+					skip -= n
+				}
+				if length >= 0 {
+					samples = samples[:min(int64(len(samples)), length-emitted)]
+				}
+				emitted += int64(len(samples))
+				if len(samples) > 0 {
+					return samples, nil
+				}
+			}
+		},
+	}
+}
+
+// adtsMaxFrame is the largest ADTS frame, whose length field has 13 bits.
+const adtsMaxFrame = 1<<13 - 1
+
+const (
+	// aacMaxUnit is the most bytes an access unit holds per channel: the
+	// decoder input buffer of 6144 bits (ISO/IEC 14496-3 4.5.3.1).
+	aacMaxUnit = 6144 / 8
+
+	// aacMaxChannels bounds the channels of a stream whose program config
+	// element has not been read: 16 elements of each kind, as pairs.
+	aacMaxChannels = 48
+)
+
+// adtsFrameHeader holds the fields of an ADTS frame header (ISO/IEC 13818-7
+// 6.2) needed to decode it.
+type adtsFrameHeader struct {
+	objectType      int
+	sampleRateIndex int
+	channelConfig   int
+	protected       bool // CRCs are present
+	frameLength     int  // Including the header
+	headerLength    int
+	blocks          int // raw_data_blocks in the frame
+}
+
+// parseADTSHeader parses the header at the start of h.
+func parseADTSHeader(h []byte) (adtsFrameHeader, bool) {
+	if !isADTS(h) {
+		return adtsFrameHeader{}, false
+	}
+	a := adtsFrameHeader{
+		objectType:      int(h[2]>>6) + 1,
+		sampleRateIndex: int(h[2] >> 2 & 0x0F),
+		channelConfig:   int(h[2]&1)<<2 | int(h[3]>>6),
+		protected:       h[1]&1 == 0,
+		frameLength:     int(h[3]&3)<<11 | int(h[4])<<3 | int(h[5]>>5),
+		headerLength:    7,
+		blocks:          int(h[6]&3) + 1,
+	}
+	if a.protected {
+		// A CRC, preceded by the position of each block after the first
+		a.headerLength += 2 * a.blocks
+	}
+	if a.frameLength <= a.headerLength {
+		return adtsFrameHeader{}, false
+	}
+	return a, true
+}
+
+// adtsReader splits an ADTS stream into frames.
+type adtsReader struct {
+	r     *bufio.Reader
+	first *adtsFrameHeader // Header of the first frame
+	frame []byte
+}
+
+// peek returns the next frame without consuming it.
+func (a *adtsReader) peek() ([]byte, adtsFrameHeader, error) {
+	h, err := a.sync()
+	if err != nil {
+		return nil, adtsFrameHeader{}, err
+	}
+	frame, err := a.r.Peek(h.frameLength)
+	if errors.Is(err, io.EOF) {
+		return nil, adtsFrameHeader{}, io.EOF
+	} else if err != nil {
+		return nil, adtsFrameHeader{}, err
+	}
+	return frame, h, nil
+}
+
+// next returns the next frame, header included. A stream cut off mid-frame
+// ends at the last complete one.
+func (a *adtsReader) next() ([]byte, adtsFrameHeader, error) {
+	h, err := a.sync()
+	if err != nil {
+		return nil, adtsFrameHeader{}, err
+	}
+	if cap(a.frame) < h.frameLength {
+		a.frame = make([]byte, h.frameLength)
+	}
+	a.frame = a.frame[:h.frameLength]
+	if _, err := io.ReadFull(a.r, a.frame); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, adtsFrameHeader{}, io.EOF
+		}
+		return nil, adtsFrameHeader{}, err
+	}
+	return a.frame, h, nil
+}
+
+// sync skips to the next frame header, passing over tags and damaged data.
+// Once the first frame is found, later headers must match its format.
+func (a *adtsReader) sync() (adtsFrameHeader, error) {
+	for {
+		header, err := a.r.Peek(7)
+		if len(header) < 7 {
+			if errors.Is(err, io.EOF) {
+				return adtsFrameHeader{}, io.EOF
+			}
+			return adtsFrameHeader{}, err
+		}
+		h, ok := parseADTSHeader(header)
+		if ok && a.first != nil && (h.objectType != a.first.objectType || h.sampleRateIndex != a.first.sampleRateIndex) {
+			ok = false
+		}
+		if ok {
+			if a.first == nil {
+				a.first = &h
+			}
+			return h, nil
+		}
+		if _, err := a.r.Discard(1); err != nil {
+			return adtsFrameHeader{}, err
+		}
+	}
+}
+
+// decodeADTS decodes the raw_data_blocks of an ADTS frame.
+func (d *aacDecoder) decodeADTS(frame []byte) ([]float32, error) {
+	h, ok := parseADTSHeader(frame)
+	if !ok {
+		return nil, fmt.Errorf("invalid ADTS header")
+	}
+	br := &bitReader{data: frame[:h.frameLength]}
+	br.skip(8 * h.headerLength)
+
+	var samples []float32
+	for i := 0; i < h.blocks; i++ {
+		block, err := d.decodeBlock(br)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, block...)
+		br.align()
+		if h.protected && h.blocks > 1 {
+			br.skip(16) // CRC of the block
+		}
+	}
+	return samples, nil
+}
+
+// adtsProgramConfig returns the number of channels described by a program
+// config element at the start of the first raw_data_block in frame, or 0.
+func adtsProgramConfig(frame []byte, h adtsFrameHeader) int {
+	br := &bitReader{data: frame}
+	br.skip(8 * h.headerLength)
+	if br.read(3) != aacPCE {
+		return 0
+	}
+	channels := parseProgramConfig(br)
+	if br.overrun() {
+		return 0
+	}
+	return channels
 }
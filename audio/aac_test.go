@@ -4,6 +4,9 @@
 package audio
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -24,7 +27,7 @@ func TestAACFormat_GetMetadata(t *testing.T) {
 	assert.NoError(t, err)
 
 	metadata, err := format.GetMetadata(testFile, fileInfo.Size())
-	
+
 	// AAC parsing might fail if file format is different than expected
 	if err != nil {
 		t.Logf("AAC metadata extraction failed (this is expected if test.aac is not in ADTS format): %v", err)
@@ -43,14 +46,156 @@ func TestAACFormat_ConvertToSamples(t *testing.T) {
 	testFile := filepath.Join("..", "test_fixtures", "test.aac")
 
 	// Check if test file exists
-	if _, err := os.Stat(testFile); os.IsNotExist(err) {
+	if info, err := os.Stat(testFile); os.IsNotExist(err) || (err == nil && info.Size() == 0) {
 		t.Skip("test.aac not found, skipping AAC conversion test")
 	}
 
 	samples, err := format.ConvertToSamples(testFile, 16000)
-	
-	// This should fail with the current implementation as noted in the code
+	assert.NoError(t, err)
+	assert.NotEmpty(t, samples)
+}
+
+// adtsFrame wraps a raw_data_block in an ADTS header for AAC-LC at the given
+// sampling frequency index.
+func adtsFrame(block []byte, rateIndex, channels int) []byte {
+	length := 7 + len(block)
+	header := []byte{
+		0xFF, 0xF1, // MPEG-4, no CRC
+		byte(1<<6 | rateIndex<<2 | channels>>2),
+		byte(channels&3<<6 | length>>11),
+		byte(length >> 3),
+		byte(length&7<<5 | 0x1F),
+		0xFC,
+	}
+	return append(header, block...)
+}
+
+func TestParseADTSHeader(t *testing.T) {
+	h, ok := parseADTSHeader(adtsFrame(make([]byte, 100), 4, 2))
+	assert.True(t, ok)
+	assert.Equal(t, adtsFrameHeader{
+		objectType:      aacObjectLC,
+		sampleRateIndex: 4,
+		channelConfig:   2,
+		frameLength:     107,
+		headerLength:    7,
+		blocks:          1,
+	}, h)
+
+	// With a CRC
+	frame := adtsFrame(make([]byte, 100), 3, 1)
+	frame[1] &^= 1
+	h, ok = parseADTSHeader(frame)
+	assert.True(t, ok)
+	assert.True(t, h.protected)
+	assert.Equal(t, 9, h.headerLength)
+
+	_, ok = parseADTSHeader(adtsFrame(nil, 4, 2))
+	assert.False(t, ok)
+	_, ok = parseADTSHeader([]byte("ID3\x04\x00\x00\x00"))
+	assert.False(t, ok)
+}
+
+func TestAACFormat_DecodeADTS(t *testing.T) {
+	var data []byte
+	for i := 0; i < 5; i++ {
+		data = append(data, adtsFrame(silentBlock(true), 4, 2)...)
+		if i == 2 {
+			data = append(data, "junk"...) // Skipped when resynchronizing
+		}
+	}
+	data = append(data, adtsFrame(silentBlock(true), 4, 2)[:10]...) // Truncated
+
+	format := &AACFormat{}
+	stream, metadata, err := format.Decode(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "AAC", metadata.Format)
+	assert.Equal(t, "AAC-LC", metadata.Codec)
+	assert.Equal(t, 44100, metadata.SampleRate)
+	assert.Equal(t, 2, metadata.Channels)
+	// The duration is exact when the frames can be counted
+	assert.InDelta(t, 5*1024/44100.0, metadata.Duration, 1e-9)
+	samples, err := ReadAll(stream)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 5*1024)
+
+	// Streams decode the same without a duration
+	stream, metadata, err = format.Decode(io.MultiReader(bytes.NewReader(data)))
+	if assert.NoError(t, err) {
+		assert.Zero(t, metadata.Duration)
+		samples, err := ReadAll(stream)
+		assert.NoError(t, err)
+		assert.Len(t, samples, 5*1024)
+	}
+
+	// Unsupported profiles are reported
+	main := adtsFrame(silentBlock(false), 4, 1)
+	main[2] &^= 0xC0
+	_, _, err = format.Decode(bytes.NewReader(main))
+	assert.ErrorContains(t, err, "object type 1")
+
+	_, _, err = format.Decode(bytes.NewReader([]byte("not aac at all")))
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "not fully implemented")
-	assert.Empty(t, samples)
+}
+
+func TestAACStream_Trim(t *testing.T) {
+	units := 4
+	next := func() ([]byte, error) {
+		if units == 0 {
+			return nil, io.EOF
+		}
+		units--
+		return []byte{byte(units)}, nil
+	}
+	decode := func(unit []byte) ([]float32, error) {
+		if unit[0] == 2 {
+			return nil, fmt.Errorf("bad frame")
+		}
+		samples := make([]float32, aacFrameLength)
+		for i := range samples {
+			samples[i] = 1
+		}
+		return samples, nil
+	}
+
+	// The encoder delay is dropped and the padding cut, and a bad frame is
+	// replaced with silence
+	samples, err := ReadAll(newAACStream(next, decode, 1, 100, 2500))
+	assert.NoError(t, err)
+	if assert.Len(t, samples, 2500) {
+		assert.Equal(t, float32(1), samples[0])
+		assert.Equal(t, float32(0), samples[1024-100])
+		assert.Equal(t, float32(1), samples[2048-100])
+	}
+
+	// A stream with no good frames is an error
+	units = 2
+	_, err = ReadAll(newAACStream(next, func([]byte) ([]float32, error) {
+		return nil, fmt.Errorf("bad frame")
+	}, 1, 0, -1))
+	assert.ErrorContains(t, err, "no valid AAC frames decoded: bad frame")
+}
+
+func TestAACFormat_M4A(t *testing.T) {
+	format := &AACFormat{}
+	testFile := filepath.Join("..", "test_fixtures", "test.m4a")
+	fileInfo, err := os.Stat(testFile)
+	if err != nil {
+		t.Skip("test.m4a not found, skipping M4A test")
+	}
+
+	metadata, err := format.GetMetadata(testFile, fileInfo.Size())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "M4A", metadata.Format)
+	assert.Equal(t, "AAC-LC", metadata.Codec)
+	assert.NotZero(t, metadata.Duration)
+
+	// The edit list trims the output to the exact duration
+	samples, err := format.ConvertToSamples(testFile, 16000)
+	assert.NoError(t, err)
+	assert.InDelta(t, metadata.Duration*16000, len(samples), 16)
 }
@@ -0,0 +1,983 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// AAC audio object types
+const (
+	aacObjectLC  = 2
+	aacObjectSBR = 5  // HE-AAC
+	aacObjectPS  = 29 // HE-AAC v2
+)
+
+// Syntactic elements of a raw_data_block
+const (
+	aacSCE = iota // Single channel
+	aacCPE        // Channel pair
+	aacCCE        // Coupling channel
+	aacLFE        // Low-frequency effects
+	aacDSE        // Data stream
+	aacPCE        // Program config
+	aacFIL        // Fill
+	aacEND
+)
+
+// Window sequences
+const (
+	aacOnlyLong = iota
+	aacLongStart
+	aacEightShort
+	aacLongStop
+)
+
+// Section codebooks with special meanings
+const (
+	aacZeroHCB       = 0
+	aacEscHCB        = 11
+	aacNoiseHCB      = 13 // Perceptual noise substitution
+	aacIntensityHCB2 = 14 // Intensity stereo, out of phase
+	aacIntensityHCB  = 15 // Intensity stereo, in phase
+)
+
+// aacFrameLength is the number of samples per channel in an AAC-LC frame.
+const aacFrameLength = 1024
+
+var errAACTruncated = errors.New("truncated AAC frame")
+
+// aacCodebookInfo describes the spectral codebooks: the number of values per
+// codeword, whether the values are signed in the index or followed by sign
+// bits, and the base the values are packed into the index with.
+var aacCodebookInfo = [12]struct {
+	dim    int
+	signed bool
+	base   int
+}{
+	{}, {4, true, 3}, {4, true, 3}, {4, false, 3}, {4, false, 3}, {2, true, 9},
+	{2, true, 9}, {2, false, 8}, {2, false, 8}, {2, false, 13}, {2, false, 13}, {2, false, 17},
+}
+
+var (
+	aacScalefactorTree = newHuffmanTree(len(aacScalefactorCodes), func(i int) (uint32, int) {
+		return aacScalefactorCodes[i], int(aacScalefactorBits[i])
+	})
+	aacSpectralTrees = func() [11]huffmanTree {
+		var trees [11]huffmanTree
+		for cb := range trees {
+			trees[cb] = newHuffmanTree(len(aacSpectralCodes[cb]), func(i int) (uint32, int) {
+				return uint32(aacSpectralCodes[cb][i]), int(aacSpectralBits[cb][i])
+			})
+		}
+		return trees
+	}()
+
+	// Rising halves of the sine and KBD windows, by window_shape
+	aacLongWindows  = [2][]float32{sineWindow(1024), kbdWindow(1024, 4)}
+	aacShortWindows = [2][]float32{sineWindow(128), kbdWindow(128, 6)}
+
+	// aacPow43 holds |q|^(4/3) for the largest quantized values: 8191 from an
+	// escape plus 15 from a pulse
+	aacPow43 = func() []float32 {
+		t := make([]float32, 8207)
+		for i := range t {
+			t[i] = float32(math.Pow(float64(i), 4.0/3))
+		}
+		return t
+	}()
+)
+
+// aacConfig is the decoder configuration from an AudioSpecificConfig or an
+// ADTS header.
+type aacConfig struct {
+	objectType      int // Audio object type of the core
+	sampleRateIndex int
+	sampleRate      int  // Core sampling frequency
+	channels        int  // Output channels, counting LFE
+	sbr             bool // Spectral band replication, which is not decoded
+	ps              bool // Parametric stereo, which is not decoded
+}
+
+// parseAudioSpecificConfig parses an MPEG-4 AudioSpecificConfig (ISO/IEC
+// 14496-3 1.6.2.1), as found in MP4 esds boxes. HE-AAC streams are decoded
+// at their AAC-LC core rate, without the spectral band replication that
+// restores the upper half of the spectrum.
+func parseAudioSpecificConfig(data []byte) (aacConfig, error) {
+	br := &bitReader{data: data}
+	var c aacConfig
+	c.objectType = aacObjectType(br)
+	c.sampleRateIndex, c.sampleRate = aacSampleRate(br)
+	c.channels = aacChannelCount(int(br.read(4)))
+	if c.objectType == aacObjectSBR || c.objectType == aacObjectPS {
+		c.sbr = true
+		c.ps = c.objectType == aacObjectPS
+		aacSampleRate(br) // Output rate with SBR
+		c.objectType = aacObjectType(br)
+	}
+	if br.overrun() {
+		return aacConfig{}, fmt.Errorf("truncated AudioSpecificConfig")
+	}
+	if c.objectType != aacObjectLC {
+		return aacConfig{}, fmt.Errorf("unsupported AAC object type %d: only AAC-LC and HE-AAC are supported", c.objectType)
+	}
+	if c.sampleRate == 0 {
+		return aacConfig{}, fmt.Errorf("invalid AAC sampling frequency index %d", c.sampleRateIndex)
+	}
+
+	// GASpecificConfig
+	if br.read(1) == 1 {
+		return aacConfig{}, fmt.Errorf("unsupported AAC frame length: 960 samples")
+	}
+	if br.read(1) == 1 {
+		br.skip(14) // coreCoderDelay
+	}
+	br.skip(1) // extensionFlag
+	if c.channels == 0 {
+		c.channels = parseProgramConfig(br)
+	}
+	if br.overrun() {
+		return aacConfig{}, fmt.Errorf("truncated AudioSpecificConfig")
+	}
+	return c, nil
+}
+
+// aacObjectType reads an audio object type, with its escape for types above 30.
+func aacObjectType(br *bitReader) int {
+	t := int(br.read(5))
+	if t == 31 {
+		t = 32 + int(br.read(6))
+	}
+	return t
+}
+
+// aacSampleRate reads a sampling frequency index, or an explicit frequency,
+// and returns both. The index for an explicit frequency is that of the
+// nearest standard rate, which selects the band tables.
+func aacSampleRate(br *bitReader) (int, int) {
+	index := int(br.read(4))
+	if index == 15 {
+		rate := int(br.read(24))
+		return aacRateIndex(rate), rate
+	}
+	if index >= len(aacSampleRates) {
+		return index, 0
+	}
+	return index, aacSampleRates[index]
+}
+
+// aacRateIndex returns the sampling frequency index whose band tables suit
+// rate best (ISO/IEC 14496-3 table 4.82).
+func aacRateIndex(rate int) int {
+	for i, threshold := range []int{92017, 75132, 55426, 46009, 37566, 27713, 23004, 18783, 13856, 11502, 9391} {
+		if rate >= threshold {
+			return i
+		}
+	}
+	return 11
+}
+
+// parseProgramConfig reads a program_config_element and returns the number of
+// channels it describes.
+func parseProgramConfig(br *bitReader) int {
+	br.skip(4 + 2 + 4) // element_instance_tag, object_type, sampling_frequency_index
+	front, side, back := int(br.read(4)), int(br.read(4)), int(br.read(4))
+	lfe, assoc, cc := int(br.read(2)), int(br.read(3)), int(br.read(4))
+	if br.read(1) == 1 {
+		br.skip(4) // mono_mixdown_element_number
+	}
+	if br.read(1) == 1 {
+		br.skip(4) // stereo_mixdown_element_number
+	}
+	if br.read(1) == 1 {
+		br.skip(3) // matrix_mixdown_idx, pseudo_surround_enable
+	}
+
+	channels := lfe
+	for i := 0; i < front+side+back; i++ {
+		channels += 1 + int(br.read(1)) // is_cpe
+		br.skip(4)
+	}
+	br.skip(4*(lfe+assoc) + 5*cc)
+	br.align()
+	br.skip(8 * int(br.read(8))) // comment_field_data
+	return channels
+}
+
+// aacDecoder decodes AAC-LC raw_data_blocks (ISO/IEC 14496-3 subpart 4) to
+// mono samples, or to interleaved channels if interleave is set. Main, SSR
+// and LTP profile tools, and coupling channels, are not supported.
+type aacDecoder struct {
+	config      aacConfig
+	interleave  int // Channels to output interleaved, or 0 for the mono mix
+	longBands   []uint16
+	shortBands  []uint16
+	tnsMaxLong  int
+	tnsMaxShort int
+	channels    []*aacChannel // In the order their elements appear
+	long, short *imdct
+	random      uint32 // Noise substitution generator state
+}
+
+// aacChannel holds a channel's data for the current frame and its filterbank
+// state across frames.
+type aacChannel struct {
+	ics       aacICS
+	quant     [1024]int32
+	spec      [1024]float32
+	out       [2048]float32 // IMDCT output
+	windowed  [2048]float32
+	overlap   [1024]float32
+	prevShape int
+	output    [1024]float32
+}
+
+// aacICS is the side information of an individual_channel_stream.
+type aacICS struct {
+	windowSequence int
+	windowShape    int
+	maxSFB         int
+	numWindows     int
+	numGroups      int
+	groupLen       [8]int
+	bands          []uint16 // Band offsets within a window
+	numBands       int
+	codebooks      [8][64]uint8 // By group and band
+	scalefactors   [8][64]int
+	pulses         []aacPulse
+	tns            [8][]aacTNSFilter // By window
+}
+
+type aacPulse struct {
+	offset int // Spectral line
+	amp    int32
+}
+
+type aacTNSFilter struct {
+	length int
+	order  int
+	down   bool
+	lpc    [13]float64 // lpc[0] is 1
+}
+
+func newAACDecoder(c aacConfig) (*aacDecoder, error) {
+	if c.objectType != aacObjectLC {
+		return nil, fmt.Errorf("unsupported AAC object type %d: only AAC-LC and HE-AAC are supported", c.objectType)
+	}
+	if c.sampleRateIndex >= len(aacLongBands) {
+		return nil, fmt.Errorf("invalid AAC sampling frequency index %d", c.sampleRateIndex)
+	}
+	return &aacDecoder{
+		config:      c,
+		longBands:   aacLongBands[c.sampleRateIndex],
+		shortBands:  aacShortBands[c.sampleRateIndex],
+		tnsMaxLong:  aacTNSMaxBandsLong[c.sampleRateIndex],
+		tnsMaxShort: aacTNSMaxBandsShort[c.sampleRateIndex],
+		long:        newIMDCT(2048),
+		short:       newIMDCT(256),
+		random:      0x1f2e3d4c,
+	}, nil
+}
+
+// channel returns the state for the i'th channel in stream order.
+func (d *aacDecoder) channel(i int) *aacChannel {
+	for len(d.channels) <= i {
+		d.channels = append(d.channels, &aacChannel{})
+	}
+	return d.channels[i]
+}
+
+// decodeFrame decodes one raw_data_block and returns the average of its
+// channels, leaving out LFE, or its channels interleaved.
+func (d *aacDecoder) decodeFrame(data []byte) ([]float32, error) {
+	return d.decodeBlock(&bitReader{data: data})
+}
+
+func (d *aacDecoder) decodeBlock(br *bitReader) ([]float32, error) {
+	mix := make([]float32, aacFrameLength)
+	mixed := 0
+	next := 0
+	add := func(ch *aacChannel) {
+		for i, v := range ch.output {
+			mix[i] += v
+		}
+		mixed++
+	}
+	var outputs []*aacChannel // All channels, in element order
+
+	for {
+		id := int(br.read(3))
+		switch id {
+		case aacSCE, aacLFE:
+			br.skip(4) // element_instance_tag
+			ch := d.channel(next)
+			next++
+			if err := d.decodeICS(br, ch, false); err != nil {
+				return nil, err
+			}
+			d.dequantize(ch)
+			d.applyTNS(ch)
+			d.synthesize(ch)
+			outputs = append(outputs, ch)
+			if id == aacSCE {
+				add(ch)
+			}
+
+		case aacCPE:
+			br.skip(4)
+			left, right := d.channel(next), d.channel(next+1)
+			next += 2
+			if err := d.decodePair(br, left, right); err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, left, right)
+			add(left)
+			add(right)
+
+		case aacCCE:
+			return nil, fmt.Errorf("unsupported AAC coupling channel element")
+
+		case aacDSE:
+			br.skip(4)
+			align := br.read(1) == 1
+			count := int(br.read(8))
+			if count == 255 {
+				count += int(br.read(8))
+			}
+			if align {
+				br.align()
+			}
+			br.skip(8 * count)
+
+		case aacPCE:
+			parseProgramConfig(br)
+
+		case aacFIL:
+			// Extension payloads, including SBR, are skipped
+			count := int(br.read(4))
+			if count == 15 {
+				count += int(br.read(8)) - 1
+			}
+			br.skip(8 * count)
+
+		case aacEND:
+			if br.overrun() {
+				return nil, errAACTruncated
+			}
+			if d.interleave > 0 {
+				return d.interleaved(outputs), nil
+			}
+			if mixed > 1 {
+				scale := 1 / float32(mixed)
+				for i := range mix {
+					mix[i] *= scale
+				}
+			}
+			return mix, nil
+		}
+		if br.overrun() {
+			return nil, errAACTruncated
+		}
+	}
+}
+
+// interleaved interleaves the output of channels into d.interleave channels.
+// Missing channels are silent, except that a lone channel fills them all, as
+// when parametric stereo would have made a stereo stream from it.
+func (d *aacDecoder) interleaved(channels []*aacChannel) []float32 {
+	n := d.interleave
+	out := make([]float32, aacFrameLength*n)
+	for c := 0; c < n; c++ {
+		var ch *aacChannel
+		if c < len(channels) {
+			ch = channels[c]
+		} else if len(channels) == 1 {
+			ch = channels[0]
+		} else {
+			continue
+		}
+		for i, v := range ch.output {
+			out[i*n+c] = v
+		}
+	}
+	return out
+}
+
+// decodePair decodes a channel_pair_element and applies joint stereo.
+func (d *aacDecoder) decodePair(br *bitReader, left, right *aacChannel) error {
+	common := br.read(1) == 1
+	msPresent := 0
+	var msUsed [8][64]bool
+	if common {
+		if err := d.decodeICSInfo(br, &left.ics); err != nil {
+			return err
+		}
+		right.ics = left.ics
+		msPresent = int(br.read(2))
+		switch msPresent {
+		case 1:
+			for g := 0; g < left.ics.numGroups; g++ {
+				for sfb := 0; sfb < left.ics.maxSFB; sfb++ {
+					msUsed[g][sfb] = br.read(1) == 1
+				}
+			}
+		case 2:
+			for g := range msUsed {
+				for sfb := range msUsed[g] {
+					msUsed[g][sfb] = true
+				}
+			}
+		case 3:
+			return fmt.Errorf("invalid AAC ms_mask_present")
+		}
+	}
+
+	if err := d.decodeICS(br, left, common); err != nil {
+		return err
+	}
+	if err := d.decodeICS(br, right, common); err != nil {
+		return err
+	}
+	d.dequantize(left)
+	d.dequantize(right)
+	d.applyStereo(left, right, msPresent == 1, &msUsed)
+	d.applyTNS(left)
+	d.applyTNS(right)
+	d.synthesize(left)
+	d.synthesize(right)
+	return nil
+}
+
+// decodeICSInfo reads ics_info.
+func (d *aacDecoder) decodeICSInfo(br *bitReader, ics *aacICS) error {
+	br.skip(1) // ics_reserved_bit
+	ics.windowSequence = int(br.read(2))
+	ics.windowShape = int(br.read(1))
+
+	if ics.windowSequence == aacEightShort {
+		ics.maxSFB = int(br.read(4))
+		grouping := br.read(7)
+		ics.numWindows = 8
+		ics.numGroups = 1
+		ics.groupLen = [8]int{1}
+		// Each bit, from the most significant, puts the next window in the
+		// same group as the one before it
+		for i := 6; i >= 0; i-- {
+			if grouping>>uint(i)&1 == 1 {
+				ics.groupLen[ics.numGroups-1]++
+			} else {
+				ics.groupLen[ics.numGroups] = 1
+				ics.numGroups++
+			}
+		}
+		ics.bands = d.shortBands
+	} else {
+		ics.maxSFB = int(br.read(6))
+		ics.numWindows = 1
+		ics.numGroups = 1
+		ics.groupLen = [8]int{1}
+		if br.read(1) == 1 {
+			return fmt.Errorf("unsupported AAC prediction: only AAC-LC is supported")
+		}
+		ics.bands = d.longBands
+	}
+	ics.numBands = len(ics.bands) - 1
+	if ics.maxSFB > ics.numBands {
+		return fmt.Errorf("invalid AAC max_sfb %d", ics.maxSFB)
+	}
+	return nil
+}
+
+// decodeICS reads an individual_channel_stream into ch.quant.
+func (d *aacDecoder) decodeICS(br *bitReader, ch *aacChannel, common bool) error {
+	ics := &ch.ics
+	globalGain := int(br.read(8))
+	if !common {
+		if err := d.decodeICSInfo(br, ics); err != nil {
+			return err
+		}
+	}
+	if err := d.decodeSections(br, ics); err != nil {
+		return err
+	}
+	if err := d.decodeScalefactors(br, ics, globalGain); err != nil {
+		return err
+	}
+
+	ics.pulses = ics.pulses[:0]
+	if br.read(1) == 1 {
+		if ics.windowSequence == aacEightShort {
+			return fmt.Errorf("invalid AAC pulse data in short window")
+		}
+		count := int(br.read(2)) + 1
+		offset := int(ics.bands[min(int(br.read(6)), ics.numBands)])
+		for i := 0; i < count; i++ {
+			offset += int(br.read(5))
+			ics.pulses = append(ics.pulses, aacPulse{offset: offset, amp: int32(br.read(4))})
+		}
+	}
+
+	for w := range ics.tns {
+		ics.tns[w] = ics.tns[w][:0]
+	}
+	if br.read(1) == 1 {
+		if err := d.decodeTNS(br, ics); err != nil {
+			return err
+		}
+	}
+
+	if br.read(1) == 1 {
+		return fmt.Errorf("unsupported AAC gain control: only AAC-LC is supported")
+	}
+	if br.overrun() {
+		return errAACTruncated
+	}
+	return d.decodeSpectralData(br, ch)
+}
+
+// decodeSections reads section_data: the codebook of each band.
+func (d *aacDecoder) decodeSections(br *bitReader, ics *aacICS) error {
+	bits := 5
+	if ics.windowSequence == aacEightShort {
+		bits = 3
+	}
+	escape := 1<<uint(bits) - 1
+
+	for g := 0; g < ics.numGroups; g++ {
+		for sfb := 0; sfb < ics.maxSFB; {
+			cb := uint8(br.read(4))
+			if cb == 12 {
+				return fmt.Errorf("invalid AAC section codebook 12")
+			}
+			length := 0
+			for {
+				incr := int(br.read(bits))
+				length += incr
+				if incr != escape || br.overrun() {
+					break
+				}
+			}
+			if br.overrun() {
+				return errAACTruncated
+			}
+			if sfb+length > ics.maxSFB {
+				return fmt.Errorf("invalid AAC section length")
+			}
+			for end := sfb + length; sfb < end; sfb++ {
+				ics.codebooks[g][sfb] = cb
+			}
+		}
+	}
+	return nil
+}
+
+// decodeScalefactors reads scale_factor_data. Bands coded with spectral
+// codebooks get scalefactors, intensity bands their position and noise bands
+// their energy, each differentially coded in its own sequence.
+func (d *aacDecoder) decodeScalefactors(br *bitReader, ics *aacICS, globalGain int) error {
+	sf := globalGain
+	position := 0
+	energy := globalGain - 90
+	firstNoise := true
+
+	delta := func() (int, error) {
+		v := aacScalefactorTree.decode(br)
+		if v < 0 {
+			return 0, fmt.Errorf("invalid AAC scalefactor codeword")
+		}
+		return v - 60, nil
+	}
+
+	for g := 0; g < ics.numGroups; g++ {
+		for sfb := 0; sfb < ics.maxSFB; sfb++ {
+			switch ics.codebooks[g][sfb] {
+			case aacZeroHCB:
+				ics.scalefactors[g][sfb] = 0
+			case aacIntensityHCB, aacIntensityHCB2:
+				v, err := delta()
+				if err != nil {
+					return err
+				}
+				position += v
+				ics.scalefactors[g][sfb] = position
+			case aacNoiseHCB:
+				if firstNoise {
+					energy += int(br.read(9)) - 256
+					firstNoise = false
+				} else {
+					v, err := delta()
+					if err != nil {
+						return err
+					}
+					energy += v
+				}
+				ics.scalefactors[g][sfb] = energy
+			default:
+				v, err := delta()
+				if err != nil {
+					return err
+				}
+				sf += v
+				if sf < 0 || sf > 255 {
+					return fmt.Errorf("invalid AAC scalefactor %d", sf)
+				}
+				ics.scalefactors[g][sfb] = sf
+			}
+		}
+	}
+	return nil
+}
+
+// decodeTNS reads tns_data.
+func (d *aacDecoder) decodeTNS(br *bitReader, ics *aacICS) error {
+	long := ics.windowSequence != aacEightShort
+	filtBits, lengthBits, orderBits, maxOrder := 1, 4, 3, 7
+	if long {
+		filtBits, lengthBits, orderBits, maxOrder = 2, 6, 5, 12
+	}
+
+	for w := 0; w < ics.numWindows; w++ {
+		count := int(br.read(filtBits))
+		if count == 0 {
+			continue
+		}
+		resolution := int(br.read(1)) + 3
+		for f := 0; f < count; f++ {
+			filter := aacTNSFilter{
+				length: int(br.read(lengthBits)),
+				order:  int(br.read(orderBits)),
+			}
+			if filter.order > maxOrder {
+				return fmt.Errorf("invalid AAC TNS order %d", filter.order)
+			}
+			if filter.order > 0 {
+				filter.down = br.read(1) == 1
+				bits := resolution - int(br.read(1)) // coef_compress
+				filter.lpc = tnsLPC(br, filter.order, resolution, bits)
+			}
+			ics.tns[w] = append(ics.tns[w], filter)
+		}
+	}
+	return nil
+}
+
+// tnsLPC reads the quantized reflection coefficients of a TNS filter and
+// converts them to LPC coefficients (ISO/IEC 14496-3 4.6.9.3).
+func tnsLPC(br *bitReader, order, resolution, bits int) [13]float64 {
+	iqfac := (float64(int(1)<<uint(resolution-1)) - 0.5) / (math.Pi / 2)
+	iqfacM := (float64(int(1)<<uint(resolution-1)) + 0.5) / (math.Pi / 2)
+
+	var parcor [12]float64
+	for i := 0; i < order; i++ {
+		v := int(br.read(bits))
+		if v >= 1<<uint(bits-1) {
+			v -= 1 << uint(bits)
+		}
+		if v >= 0 {
+			parcor[i] = math.Sin(float64(v) / iqfac)
+		} else {
+			parcor[i] = math.Sin(float64(v) / iqfacM)
+		}
+	}
+
+	var a, b [13]float64
+	a[0] = 1
+	for m := 1; m <= order; m++ {
+		for i := 1; i < m; i++ {
+			b[i] = a[i] + parcor[m-1]*a[m-i]
+		}
+		for i := 1; i < m; i++ {
+			a[i] = b[i]
+		}
+		a[m] = parcor[m-1]
+	}
+	return a
+}
+
+// decodeSpectralData reads spectral_data into ch.quant, with short windows at
+// 128-line offsets, and adds the pulses.
+func (d *aacDecoder) decodeSpectralData(br *bitReader, ch *aacChannel) error {
+	ics := &ch.ics
+	ch.quant = [1024]int32{}
+	var values [4]int32
+
+	w0 := 0
+	for g := 0; g < ics.numGroups; g++ {
+		for sfb := 0; sfb < ics.maxSFB; sfb++ {
+			cb := int(ics.codebooks[g][sfb])
+			if cb == aacZeroHCB || cb > aacEscHCB {
+				continue
+			}
+			dim := aacCodebookInfo[cb].dim
+			start, end := int(ics.bands[sfb]), int(ics.bands[sfb+1])
+			for w := w0; w < w0+ics.groupLen[g]; w++ {
+				q := ch.quant[w*128:]
+				for k := start; k < end; k += dim {
+					if err := decodeSpectralValues(br, cb, values[:dim]); err != nil {
+						return err
+					}
+					copy(q[k:k+dim], values[:dim])
+				}
+			}
+		}
+		w0 += ics.groupLen[g]
+	}
+	if br.overrun() {
+		return errAACTruncated
+	}
+
+	for _, p := range ics.pulses {
+		if p.offset >= len(ch.quant) {
+			return fmt.Errorf("invalid AAC pulse offset")
+		}
+		if ch.quant[p.offset] > 0 {
+			ch.quant[p.offset] += p.amp
+		} else {
+			ch.quant[p.offset] -= p.amp
+		}
+	}
+	return nil
+}
+
+// decodeSpectralValues reads one spectral codeword with its sign bits and
+// escapes.
+func decodeSpectralValues(br *bitReader, cb int, out []int32) error {
+	info := aacCodebookInfo[cb]
+	index := aacSpectralTrees[cb-1].decode(br)
+	if index < 0 {
+		return fmt.Errorf("invalid AAC spectral codeword")
+	}
+
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = int32(index % info.base)
+		index /= info.base
+		if info.signed {
+			out[i] -= int32(info.base / 2)
+		}
+	}
+	if info.signed {
+		return nil
+	}
+
+	for i := range out {
+		if out[i] != 0 && br.bit() == 1 {
+			out[i] = -out[i]
+		}
+	}
+	if cb == aacEscHCB {
+		for i := range out {
+			if out[i] != 16 && out[i] != -16 {
+				continue
+			}
+			// escape_prefix of N ones and a zero, then an N+4 bit word
+			n := 4
+			for br.bit() == 1 {
+				if n++; n > 12 {
+					return fmt.Errorf("invalid AAC escape sequence")
+				}
+			}
+			v := int32(1)<<uint(n) | int32(br.read(n))
+			if out[i] < 0 {
+				v = -v
+			}
+			out[i] = v
+		}
+	}
+	return nil
+}
+
+// dequantize scales ch.quant into ch.spec and substitutes noise bands.
+func (d *aacDecoder) dequantize(ch *aacChannel) {
+	ics := &ch.ics
+	ch.spec = [1024]float32{}
+
+	w0 := 0
+	for g := 0; g < ics.numGroups; g++ {
+		for sfb := 0; sfb < ics.maxSFB; sfb++ {
+			cb := ics.codebooks[g][sfb]
+			start, end := int(ics.bands[sfb]), int(ics.bands[sfb+1])
+			sf := ics.scalefactors[g][sfb]
+
+			for w := w0; w < w0+ics.groupLen[g]; w++ {
+				q, spec := ch.quant[w*128+start:w*128+end], ch.spec[w*128+start:w*128+end]
+				switch {
+				case cb == aacZeroHCB || cb >= aacIntensityHCB2:
+					// Intensity bands are filled from the other channel
+				case cb == aacNoiseHCB:
+					var energy float64
+					for i := range spec {
+						d.random = d.random*1664525 + 1013904223
+						spec[i] = float32(int32(d.random))
+						energy += float64(spec[i]) * float64(spec[i])
+					}
+					scale := float32(math.Exp2(0.25*float64(sf)) / math.Sqrt(energy))
+					for i := range spec {
+						spec[i] *= scale
+					}
+				default:
+					gain := float32(math.Exp2(0.25 * float64(sf-100)))
+					for i, v := range q {
+						spec[i] = dequantizeValue(v) * gain
+					}
+				}
+			}
+		}
+		w0 += ics.groupLen[g]
+	}
+}
+
+// dequantizeValue returns sign(q) * |q|^(4/3).
+func dequantizeValue(q int32) float32 {
+	if q < 0 {
+		return -dequantizeValue(-q)
+	}
+	if int(q) < len(aacPow43) {
+		return aacPow43[q]
+	}
+	return float32(math.Pow(float64(q), 4.0/3))
+}
+
+// applyStereo applies mid/side and intensity stereo to a channel pair.
+func (d *aacDecoder) applyStereo(left, right *aacChannel, msMask bool, msUsed *[8][64]bool) {
+	ics := &right.ics
+	w0 := 0
+	for g := 0; g < ics.numGroups; g++ {
+		for sfb := 0; sfb < ics.maxSFB; sfb++ {
+			cbL, cbR := left.ics.codebooks[g][sfb], ics.codebooks[g][sfb]
+			start, end := int(ics.bands[sfb]), int(ics.bands[sfb+1])
+			ms := msUsed[g][sfb]
+
+			for w := w0; w < w0+ics.groupLen[g]; w++ {
+				l, r := left.spec[w*128+start:w*128+end], right.spec[w*128+start:w*128+end]
+				switch {
+				case cbR == aacIntensityHCB || cbR == aacIntensityHCB2:
+					scale := float32(math.Exp2(-0.25 * float64(ics.scalefactors[g][sfb])))
+					if (cbR == aacIntensityHCB2) != (msMask && ms) {
+						scale = -scale
+					}
+					for i := range r {
+						r[i] = l[i] * scale
+					}
+				case ms && cbL == aacNoiseHCB && cbR == aacNoiseHCB:
+					// Noise in both channels is the same noise
+					scale := float32(math.Exp2(0.25 * float64(ics.scalefactors[g][sfb]-left.ics.scalefactors[g][sfb])))
+					for i := range r {
+						r[i] = l[i] * scale
+					}
+				case ms && cbL < aacNoiseHCB && cbR < aacNoiseHCB:
+					for i := range r {
+						l[i], r[i] = l[i]+r[i], l[i]-r[i]
+					}
+				}
+			}
+		}
+		w0 += ics.groupLen[g]
+	}
+}
+
+// applyTNS filters the spectrum with the channel's temporal noise shaping
+// filters, from the highest band down.
+func (d *aacDecoder) applyTNS(ch *aacChannel) {
+	ics := &ch.ics
+	maxBands := d.tnsMaxLong
+	if ics.windowSequence == aacEightShort {
+		maxBands = d.tnsMaxShort
+	}
+	maxBands = min(maxBands, ics.maxSFB)
+
+	for w := 0; w < ics.numWindows; w++ {
+		spec := ch.spec[w*128:]
+		bottom := ics.numBands
+		for _, f := range ics.tns[w] {
+			top := bottom
+			bottom = max(top-f.length, 0)
+			if f.order == 0 {
+				continue
+			}
+			start, end := int(ics.bands[min(bottom, maxBands)]), int(ics.bands[min(top, maxBands)])
+			size := end - start
+			if size <= 0 {
+				continue
+			}
+
+			pos, inc := start, 1
+			if f.down {
+				pos, inc = end-1, -1
+			}
+			for m := 0; m < size; m, pos = m+1, pos+inc {
+				v := float64(spec[pos])
+				for i := 1; i <= min(m, f.order); i++ {
+					v -= float64(spec[pos-i*inc]) * f.lpc[i]
+				}
+				spec[pos] = float32(v)
+			}
+		}
+	}
+}
+
+// synthesize runs the filterbank: the IMDCT, windowing and overlap-add,
+// leaving 1024 samples scaled to [-1, 1] in ch.output.
+func (d *aacDecoder) synthesize(ch *aacChannel) {
+	ics := &ch.ics
+	prevLong, curLong := aacLongWindows[ch.prevShape], aacLongWindows[ics.windowShape]
+	prevShort, curShort := aacShortWindows[ch.prevShape], aacShortWindows[ics.windowShape]
+	buf := &ch.windowed
+
+	if ics.windowSequence == aacEightShort {
+		*buf = [2048]float32{}
+		for w := 0; w < 8; w++ {
+			d.short.transform(ch.spec[w*128:w*128+128], ch.out[:256])
+			left := curShort
+			if w == 0 {
+				left = prevShort
+			}
+			o := buf[448+128*w:]
+			for i := 0; i < 128; i++ {
+				o[i] += ch.out[i] * left[i]
+				o[128+i] += ch.out[128+i] * curShort[127-i]
+			}
+		}
+	} else {
+		d.long.transform(ch.spec[:], ch.out[:])
+		out := &ch.out
+
+		// First half
+		if ics.windowSequence == aacLongStop {
+			for i := 0; i < 448; i++ {
+				buf[i] = 0
+			}
+			for i := 0; i < 128; i++ {
+				buf[448+i] = out[448+i] * prevShort[i]
+			}
+			copy(buf[576:1024], out[576:1024])
+		} else {
+			for i := 0; i < 1024; i++ {
+				buf[i] = out[i] * prevLong[i]
+			}
+		}
+
+		// Second half
+		if ics.windowSequence == aacLongStart {
+			copy(buf[1024:1472], out[1024:1472])
+			for i := 0; i < 128; i++ {
+				buf[1472+i] = out[1472+i] * curShort[127-i]
+			}
+			for i := 1600; i < 2048; i++ {
+				buf[i] = 0
+			}
+		} else {
+			for i := 0; i < 1024; i++ {
+				buf[1024+i] = out[1024+i] * curLong[1023-i]
+			}
+		}
+	}
+
+	for i := 0; i < 1024; i++ {
+		ch.output[i] = (buf[i] + ch.overlap[i]) / 32768
+	}
+	copy(ch.overlap[:], buf[1024:])
+	ch.prevShape = ics.windowShape
+}
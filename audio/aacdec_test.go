@@ -0,0 +1,353 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bitWriter builds bitstreams for tests, most significant bit first.
+type bitWriter struct {
+	data []byte
+	n    int // Bits written
+}
+
+func (w *bitWriter) write(v uint32, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if v>>uint(i)&1 == 1 {
+			w.data[w.n/8] |= 0x80 >> uint(w.n%8)
+		}
+		w.n++
+	}
+}
+
+// writeSilentICS writes an individual_channel_stream with a long window and
+// no bands.
+func writeSilentICS(w *bitWriter) {
+	w.write(100, 8) // global_gain
+	w.write(0, 11)  // ics_info: reserved, sequence, shape, max_sfb, predictor
+	w.write(0, 3)   // No pulse, TNS or gain control data
+}
+
+// silentBlock returns a raw_data_block with a single channel, or a channel
+// pair if stereo is set, that decodes to silence.
+func silentBlock(stereo bool) []byte {
+	w := &bitWriter{}
+	if stereo {
+		w.write(aacCPE, 3)
+		w.write(0, 4) // element_instance_tag
+		w.write(0, 1) // common_window
+		writeSilentICS(w)
+		writeSilentICS(w)
+	} else {
+		w.write(aacSCE, 3)
+		w.write(0, 4)
+		writeSilentICS(w)
+	}
+	w.write(aacEND, 3)
+	return w.data
+}
+
+// toneBlock returns a raw_data_block at 44.1 kHz with a single spectral
+// line, line, set to q using the escape codebook.
+func toneBlock(line int, q int) []byte {
+	bands := aacLongBands[4]
+	maxSFB := 0
+	for int(bands[maxSFB]) <= line {
+		maxSFB++
+	}
+
+	w := &bitWriter{}
+	w.write(aacSCE, 3)
+	w.write(0, 4)
+	w.write(140, 8) // global_gain
+	w.write(0, 4)   // reserved, sequence, shape
+	w.write(uint32(maxSFB), 6)
+	w.write(0, 1) // predictor_data_present
+
+	// One section with the escape codebook
+	w.write(aacEscHCB, 4)
+	for n := maxSFB; ; n -= 31 {
+		if n < 31 {
+			w.write(uint32(n), 5)
+			break
+		}
+		w.write(31, 5)
+	}
+	for sfb := 0; sfb < maxSFB; sfb++ {
+		// Every scalefactor equals the global gain
+		w.write(aacScalefactorCodes[60], int(aacScalefactorBits[60]))
+	}
+	w.write(0, 3) // No pulse, TNS or gain control data
+
+	for k := 0; k < int(bands[maxSFB]); k += 2 {
+		var values [2]int
+		if k == line&^1 {
+			values[line&1] = q
+		}
+		index := min(values[0], 16)*17 + min(values[1], 16)
+		w.write(uint32(aacSpectralCodes[10][index]), int(aacSpectralBits[10][index]))
+		for _, v := range values {
+			if v != 0 {
+				w.write(0, 1) // Positive
+			}
+		}
+		for _, v := range values {
+			if v >= 16 {
+				n := 4
+				for v >= 1<<uint(n+1) {
+					n++
+				}
+				w.write(1<<uint(n-4+1)-2, n-4+1) // n-4 ones and a zero
+				w.write(uint32(v-1<<uint(n)), n)
+			}
+		}
+	}
+	w.write(aacEND, 3)
+	return w.data
+}
+
+func TestAACHuffmanTables(t *testing.T) {
+	check := func(name string, n int, code func(int) (uint32, int), tree huffmanTree) {
+		// The codes are complete, and each decodes to its own symbol
+		var kraft float64
+		for s := 0; s < n; s++ {
+			c, bits := code(s)
+			kraft += math.Exp2(-float64(bits))
+
+			w := &bitWriter{}
+			w.write(c, bits)
+			assert.Equal(t, s, tree.decode(&bitReader{data: w.data}), "%s symbol %d", name, s)
+		}
+		assert.InDelta(t, 1, kraft, 1e-9, name)
+	}
+
+	check("scalefactor", len(aacScalefactorCodes), func(i int) (uint32, int) {
+		return aacScalefactorCodes[i], int(aacScalefactorBits[i])
+	}, aacScalefactorTree)
+	for cb := range aacSpectralCodes {
+		assert.Len(t, aacSpectralBits[cb], len(aacSpectralCodes[cb]))
+		check("spectral", len(aacSpectralCodes[cb]), func(i int) (uint32, int) {
+			return uint32(aacSpectralCodes[cb][i]), int(aacSpectralBits[cb][i])
+		}, aacSpectralTrees[cb])
+	}
+
+	// Band tables run to the end of the window
+	for i := range aacLongBands {
+		assert.Equal(t, uint16(1024), aacLongBands[i][len(aacLongBands[i])-1])
+		assert.Equal(t, uint16(128), aacShortBands[i][len(aacShortBands[i])-1])
+	}
+}
+
+func TestIMDCT(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{256, 2048} {
+		in := make([]float32, n/2)
+		for i := range in {
+			in[i] = float32(rng.NormFloat64())
+		}
+		out := make([]float32, n)
+		newIMDCT(n).transform(in, out)
+
+		// ISO/IEC 14496-3 4.6.11.3.1
+		n0 := (float64(n)/2 + 1) / 2
+		for i := 0; i < n; i += 7 {
+			var want float64
+			for k, v := range in {
+				want += float64(v) * math.Cos(2*math.Pi/float64(n)*(float64(i)+n0)*(float64(k)+0.5))
+			}
+			assert.InDelta(t, 2/float64(n)*want, out[i], 1e-4, "n=%d sample %d", n, i)
+		}
+	}
+}
+
+func TestIMDCT_Reconstruction(t *testing.T) {
+	// Windowed overlapping blocks reconstruct the signal between them
+	const n = 256
+	rng := rand.New(rand.NewSource(2))
+	signal := make([]float64, 3*n/2)
+	for i := range signal {
+		signal[i] = rng.NormFloat64()
+	}
+	window := sineWindow(n / 2)
+	win := func(i int) float64 {
+		if i < n/2 {
+			return float64(window[i])
+		}
+		return float64(window[n-1-i])
+	}
+
+	transform := newIMDCT(n)
+	var blocks [2][]float32
+	for b := range blocks {
+		spec := make([]float32, n/2)
+		for k := range spec {
+			var sum float64
+			for i := 0; i < n; i++ {
+				sum += win(i) * signal[b*n/2+i] * math.Cos(2*math.Pi/n*(float64(i)+(n/2+1)/2.0)*(float64(k)+0.5))
+			}
+			spec[k] = float32(2 * sum)
+		}
+		blocks[b] = make([]float32, n)
+		transform.transform(spec, blocks[b])
+	}
+	for i := 0; i < n/2; i++ {
+		got := float64(blocks[0][n/2+i])*win(n/2+i) + float64(blocks[1][i])*win(i)
+		assert.InDelta(t, signal[n/2+i], got, 1e-3, "sample %d", i)
+	}
+}
+
+func TestParseAudioSpecificConfig(t *testing.T) {
+	c, err := parseAudioSpecificConfig([]byte{0x12, 0x10})
+	assert.NoError(t, err)
+	assert.Equal(t, aacConfig{objectType: aacObjectLC, sampleRateIndex: 4, sampleRate: 44100, channels: 2}, c)
+
+	c, err = parseAudioSpecificConfig([]byte{0x11, 0x88})
+	assert.NoError(t, err)
+	assert.Equal(t, 48000, c.sampleRate)
+	assert.Equal(t, 1, c.channels)
+
+	// HE-AAC is decoded at the core rate
+	w := &bitWriter{}
+	w.write(aacObjectSBR, 5)
+	w.write(6, 4) // 24 kHz
+	w.write(2, 4)
+	w.write(3, 4) // 48 kHz with SBR
+	w.write(aacObjectLC, 5)
+	w.write(0, 3)
+	c, err = parseAudioSpecificConfig(w.data)
+	assert.NoError(t, err)
+	assert.Equal(t, 24000, c.sampleRate)
+	assert.True(t, c.sbr)
+	assert.False(t, c.ps)
+
+	// An explicit rate uses the band tables of the nearest standard rate
+	w = &bitWriter{}
+	w.write(aacObjectLC, 5)
+	w.write(15, 4)
+	w.write(44000, 24)
+	w.write(1, 4)
+	w.write(0, 3)
+	c, err = parseAudioSpecificConfig(w.data)
+	assert.NoError(t, err)
+	assert.Equal(t, 44000, c.sampleRate)
+	assert.Equal(t, 4, c.sampleRateIndex)
+
+	// Channels from a program config element: a pair, a single channel and LFE
+	w = &bitWriter{}
+	w.write(aacObjectLC, 5)
+	w.write(3, 4)
+	w.write(0, 4)
+	w.write(0, 3)
+	w.write(0, 4+2+4)
+	w.write(2, 4)    // Front elements
+	w.write(0, 8)    // Side, back
+	w.write(1, 2)    // LFE
+	w.write(0, 7)    // Associated data, coupling
+	w.write(0, 3)    // No mixdowns
+	w.write(1<<4, 5) // Front: CPE
+	w.write(0, 5)    // Front: SCE
+	w.write(0, 4)    // LFE
+	w.write(0, (8-w.n%8)%8)
+	w.write(0, 8) // Comment length
+	c, err = parseAudioSpecificConfig(w.data)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, c.channels)
+
+	for name, data := range map[string][]byte{
+		"AAC Main":     {0x0A, 0x10},
+		"960 samples":  {0x12, 0x14},
+		"truncated":    {0x12},
+		"invalid rate": {0x16, 0x90},
+	} {
+		_, err := parseAudioSpecificConfig(data)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestAACDecoder_Silence(t *testing.T) {
+	dec, err := newAACDecoder(aacConfig{objectType: aacObjectLC, sampleRateIndex: 4, sampleRate: 44100, channels: 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, stereo := range []bool{false, true} {
+		samples, err := dec.decodeFrame(silentBlock(stereo))
+		assert.NoError(t, err)
+		assert.Equal(t, make([]float32, aacFrameLength), samples)
+	}
+
+	_, err = dec.decodeFrame(silentBlock(false)[:2])
+	assert.Error(t, err)
+}
+
+func TestAACDecoder_Tone(t *testing.T) {
+	const line = 46 // About 1 kHz at 44.1 kHz
+	block := toneBlock(line, 1000)
+	dec, err := newAACDecoder(aacConfig{objectType: aacObjectLC, sampleRateIndex: 4, sampleRate: 44100, channels: 1})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var samples []float32
+	for i := 0; i < 3; i++ {
+		br := &bitReader{data: block}
+		samples, err = dec.decodeBlock(br)
+		if !assert.NoError(t, err) {
+			return
+		}
+		// Every bit is consumed
+		br.align()
+		assert.Equal(t, 8*len(block), br.pos)
+	}
+
+	// Once the overlap is filled, the output is a tone at the line's
+	// frequency, modulated by the window as each frame starts afresh
+	power := func(freq float64) float64 {
+		var re, im float64
+		for i, v := range samples {
+			re += float64(v) * math.Cos(2*math.Pi*freq*float64(i))
+			im += float64(v) * math.Sin(2*math.Pi*freq*float64(i))
+		}
+		return re*re + im*im
+	}
+	peak, peakPower := 0, 0.0
+	for bin := 0; bin < len(samples)/2; bin++ {
+		if p := power(float64(bin) / float64(len(samples))); p > peakPower {
+			peak, peakPower = bin, p
+		}
+	}
+	assert.InDelta(t, line/2, peak, 1)
+}
+
+func TestAACDecoder_Interleave(t *testing.T) {
+	block := toneBlock(46, 1000)
+	mono, err := newAACDecoder(aacConfig{objectType: aacObjectLC, sampleRateIndex: 4, sampleRate: 44100, channels: 1})
+	if !assert.NoError(t, err) {
+		return
+	}
+	stereo, err := newAACDecoder(aacConfig{objectType: aacObjectLC, sampleRateIndex: 4, sampleRate: 44100, channels: 1})
+	if !assert.NoError(t, err) {
+		return
+	}
+	stereo.interleave = 2
+
+	for i := 0; i < 2; i++ {
+		want, err := mono.decodeFrame(block)
+		assert.NoError(t, err)
+		samples, err := stereo.decodeFrame(block)
+		assert.NoError(t, err)
+		// A lone channel fills both
+		if assert.Len(t, samples, 2*aacFrameLength) {
+			assert.Equal(t, want, Deinterleave(samples, 2)[0])
+			assert.Equal(t, want, Deinterleave(samples, 2)[1])
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+// bitReader reads most-significant-bit-first fields from a byte slice. Reads
+// past the end return zero bits and are reported by overrun, so decoders can
+// check once per syntax element instead of after every read.
+type bitReader struct {
+	data []byte
+	pos  int // Position in bits
+}
+
+// read returns the next n bits, n <= 32.
+func (b *bitReader) read(n int) uint32 {
+	var v uint32
+	for n > 0 {
+		i := b.pos >> 3
+		if i >= len(b.data) {
+			v <<= uint(n)
+			b.pos += n
+			return v
+		}
+		avail := 8 - b.pos&7
+		take := min(avail, n)
+		v = v<<uint(take) | uint32(b.data[i]>>uint(avail-take))&(1<<uint(take)-1)
+		n -= take
+		b.pos += take
+	}
+	return v
+}
+
+// bit returns the next bit.
+func (b *bitReader) bit() uint32 {
+	i := b.pos >> 3
+	shift := 7 - uint(b.pos&7)
+	b.pos++
+	if i >= len(b.data) {
+		return 0
+	}
+	return uint32(b.data[i]>>shift) & 1
+}
+
+// skip advances n bits.
+func (b *bitReader) skip(n int) {
+	b.pos += n
+}
+
+// align advances to the next byte boundary.
+func (b *bitReader) align() {
+	b.pos = (b.pos + 7) &^ 7
+}
+
+// overrun reports whether a read went past the end of the data.
+func (b *bitReader) overrun() bool {
+	return b.pos > 8*len(b.data)
+}
+
+// huffmanTree decodes a prefix code a bit at a time. Each node holds the next
+// node for a 0 and a 1 bit; leaves are stored as the bitwise complement of
+// their symbol, and missing children as 0.
+type huffmanTree [][2]int32
+
+// newHuffmanTree builds a tree from a codebook of n symbols. The codebooks
+// used are complete, so every path ends in a leaf.
+func newHuffmanTree(n int, code func(symbol int) (uint32, int)) huffmanTree {
+	t := huffmanTree{{}}
+	for symbol := 0; symbol < n; symbol++ {
+		c, length := code(symbol)
+		node := 0
+		for i := length - 1; i >= 0; i-- {
+			bit := c >> uint(i) & 1
+			if i == 0 {
+				t[node][bit] = ^int32(symbol)
+				break
+			}
+			if t[node][bit] == 0 {
+				t = append(t, [2]int32{})
+				t[node][bit] = int32(len(t) - 1)
+			}
+			node = int(t[node][bit])
+		}
+	}
+	return t
+}
+
+// decode reads one codeword and returns its symbol, or -1 if the bits do not
+// form a codeword.
+func (t huffmanTree) decode(b *bitReader) int {
+	node := int32(0)
+	for {
+		next := t[node][b.bit()]
+		if next < 0 {
+			return int(^next)
+		}
+		if next == 0 || b.overrun() {
+			return -1
+		}
+		node = next
+	}
+}
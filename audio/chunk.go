@@ -0,0 +1,69 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+// Chunk is a window of samples to transcribe on its own. Samples [Start, End)
+// are decoded, and the chunk supplies the transcription of [KeepStart,
+// KeepEnd); the rest is overlap shared with its neighbours, where a word cut
+// off at the edge of one chunk is heard whole in the other.
+type Chunk struct {
+	Start     int
+	End       int
+	KeepStart int
+	KeepEnd   int
+}
+
+// SplitChunks divides samples into chunks of at most window seconds, each
+// sharing overlap seconds with the next. Chunks are cut in the longest pause
+// DetectSpeech finds in the second half of each window, or at the end of the
+// window when there is none. The kept spans of the chunks cover the samples
+// exactly once; audio no longer than window is one chunk.
+func SplitChunks(samples []float32, sampleRate int, window, overlap float64, opts VADOptions) []Chunk {
+	size := int(window * float64(sampleRate))
+	half := int(overlap * float64(sampleRate) / 2)
+	if len(samples) <= size || size <= 2*half {
+		return []Chunk{{Start: 0, End: len(samples), KeepStart: 0, KeepEnd: len(samples)}}
+	}
+
+	// Pauses are the gaps between speech, including before and after it
+	regions := DetectSpeech(samples, sampleRate, opts)
+	var pauses []SpeechRegion
+	end := 0
+	for _, r := range regions {
+		if r.Start > end {
+			pauses = append(pauses, SpeechRegion{Start: end, End: r.Start})
+		}
+		end = r.End
+	}
+	if end < len(samples) {
+		pauses = append(pauses, SpeechRegion{Start: end, End: len(samples)})
+	}
+
+	var chunks []Chunk
+	keep := 0
+	for {
+		start := max(keep-half, 0)
+		if len(samples)-start <= size {
+			chunks = append(chunks, Chunk{Start: start, End: len(samples), KeepStart: keep, KeepEnd: len(samples)})
+			return chunks
+		}
+
+		// The cut leaves room for the overlap after it within the window,
+		// and is at least half a window on so chunks do not get too short
+		latest := start + size - half
+		earliest := keep + (latest-keep)/2
+		cut := latest
+		longest := 0
+		for _, p := range pauses {
+			from, to := max(p.Start, earliest), min(p.End, latest)
+			if to-from > longest {
+				// As near the middle of the pause as the window allows
+				cut, longest = min(max((p.Start+p.End)/2, from), to), to-from
+			}
+		}
+
+		chunks = append(chunks, Chunk{Start: start, End: cut + half, KeepStart: keep, KeepEnd: cut})
+		keep = cut
+	}
+}
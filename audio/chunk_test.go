@@ -0,0 +1,55 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertChunks checks that chunks fit the window and keep every sample once.
+func assertChunks(t *testing.T, chunks []Chunk, samples, window int) {
+	t.Helper()
+	keep := 0
+	for _, c := range chunks {
+		assert.LessOrEqual(t, c.End-c.Start, window)
+		assert.Equal(t, keep, c.KeepStart)
+		assert.LessOrEqual(t, c.Start, c.KeepStart)
+		assert.GreaterOrEqual(t, c.End, c.KeepEnd)
+		keep = c.KeepEnd
+	}
+	assert.Equal(t, samples, keep)
+}
+
+func TestSplitChunks(t *testing.T) {
+	// Short audio is one chunk
+	samples := vadSignal(5, 0.002)
+	assert.Equal(t, []Chunk{{0, 80000, 0, 80000}}, SplitChunks(samples, 16000, 10, 1, DefaultVADOptions))
+
+	// Speech with a pause in each window is cut in the pauses
+	samples = vadSignal(24, 0.002)
+	addTone(samples, 0.5, 7)
+	addTone(samples, 8, 15)
+	addTone(samples, 16, 23.5)
+	chunks := SplitChunks(samples, 16000, 10, 1, DefaultVADOptions)
+	assertChunks(t, chunks, len(samples), 160000)
+	if assert.Len(t, chunks, 3) {
+		assert.InDelta(t, 7.5, float64(chunks[0].KeepEnd)/16000, 0.05)
+		assert.InDelta(t, 15.5, float64(chunks[1].KeepEnd)/16000, 0.05)
+		assert.Equal(t, chunks[0].KeepEnd-8000, chunks[1].Start)
+		assert.Equal(t, chunks[0].KeepEnd+8000, chunks[0].End)
+	}
+
+	// Speech without pauses is cut where the window ends
+	samples = vadSignal(25, 0.002)
+	addTone(samples, 3, 25)
+	chunks = SplitChunks(samples, 16000, 10, 1, DefaultVADOptions)
+	assertChunks(t, chunks, len(samples), 160000)
+	if assert.Len(t, chunks, 3) {
+		assert.Equal(t, Chunk{0, 160000, 0, 152000}, chunks[0])
+		assert.Equal(t, Chunk{144000, 304000, 152000, 296000}, chunks[1])
+		assert.Equal(t, Chunk{288000, 400000, 296000, 400000}, chunks[2])
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Containers identified by content sniffing.
+const (
+	ContainerWAV      = "WAV"
+	ContainerFLAC     = "FLAC"
+	ContainerMPEG     = "MPEG" // MPEG audio elementary stream (MP2/MP3)
+	ContainerADTS     = "ADTS" // Raw AAC with ADTS headers
+	ContainerMP4      = "MP4"
+	ContainerOgg      = "OGG"
+	ContainerMatroska = "MATROSKA"
+	ContainerWebM     = "WEBM"
+)
+
+// sniffLen is the number of leading bytes read to identify a format.
+const sniffLen = 4096
+
+// Detection describes the format of an audio file as identified by Detect.
+type Detection struct {
+	Format    string // Name of the registered format
+	Container string // One of the Container constants
+	Codec     string // Codec inside the container (e.g. "Vorbis", "Opus")
+	Extension string // Lowercase file extension, used only as a hint
+	Sniffed   bool   // The format was identified from the content
+	Mismatch  bool   // The content does not match the file extension
+}
+
+func (d Detection) String() string {
+	if d.Codec == "" || d.Codec == d.Container {
+		return d.Container
+	}
+	return d.Container + "/" + d.Codec
+}
+
+// Formats that are recognized so they can be reported accurately, but have no
+// handler in this package.
+func init() {
+	Register(Registration{
+		Name:       "mp2",
+		Container:  ContainerMPEG,
+		Codec:      "MP2",
+		MIMETypes:  []string{"audio/mpeg"},
+		Extensions: []string{".mp2"},
+		Sniff:      func(h []byte) bool { return mpegLayer(h) == 2 },
+		Note:       "MPEG Layer II decoding is not supported",
+	})
+	Register(Registration{
+		Name:       "ogg-flac",
+		Container:  ContainerOgg,
+		Codec:      "FLAC",
+		MIMETypes:  []string{"audio/ogg"},
+		Extensions: []string{".oga", ".ogg"},
+		Sniff:      func(h []byte) bool { return oggCodec(h) == "FLAC" },
+		Note:       "FLAC in Ogg is not supported; use native FLAC",
+	})
+	Register(Registration{
+		Name:       "speex",
+		Container:  ContainerOgg,
+		Codec:      "Speex",
+		MIMETypes:  []string{"audio/ogg", "audio/speex"},
+		Extensions: []string{".spx", ".ogg"},
+		Sniff:      func(h []byte) bool { return oggCodec(h) == "Speex" },
+		Note:       "Speex decoding is not supported",
+	})
+}
+
+// Sniff identifies an audio format from the leading bytes of a file using the
+// registered formats. It returns false if no format recognizes the bytes. ID3v2
+// tags must already have been skipped; see DetectReader.
+func Sniff(header []byte) (Detection, bool) {
+	r, ok := sniffRegistered(header)
+	if !ok {
+		return Detection{}, false
+	}
+	d := r.detection()
+	d.Sniffed = true
+	return d, true
+}
+
+// isRIFFWave reports whether header starts a RIFF, RF64 or BW64 WAVE file.
+func isRIFFWave(header []byte) bool {
+	if len(header) < 12 || !bytes.Equal(header[8:12], []byte("WAVE")) {
+		return false
+	}
+	id := string(header[:4])
+	return id == "RIFF" || id == "RF64" || id == "BW64"
+}
+
+// isMP4 reports whether header starts with an ISO base media file type box.
+func isMP4(header []byte) bool {
+	return len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp"))
+}
+
+// ebmlDocType returns the DocType of a Matroska or WebM file, or "" if header
+// does not start with an EBML header.
+func ebmlDocType(header []byte) string {
+	if !bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}) {
+		return ""
+	}
+	// The EBML header is short and carries the DocType near the start
+	if bytes.Contains(header[:min(len(header), 64)], []byte("webm")) {
+		return "webm"
+	}
+	return "matroska"
+}
+
+// oggCodec identifies the codec of an Ogg stream from the first packet of its
+// first page, which carries the codec identification header.
+func oggCodec(header []byte) string {
+	if len(header) < 27 {
+		return ""
+	}
+	segments := int(header[26])
+	start := 27 + segments
+	if len(header) < start {
+		return ""
+	}
+	packet := header[start:]
+
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")):
+		return "Vorbis"
+	case bytes.HasPrefix(packet, []byte("OpusHead")):
+		return "Opus"
+	case bytes.HasPrefix(packet, []byte("\x7fFLAC")):
+		return "FLAC"
+	case bytes.HasPrefix(packet, []byte("Speex   ")):
+		return "Speex"
+	}
+	return ""
+}
+
+// isADTS reports whether header starts with an ADTS frame header: a 12-bit sync
+// word, MPEG layer 0 and a valid sampling frequency index.
+func isADTS(header []byte) bool {
+	if len(header) < 7 || header[0] != 0xFF || header[1]&0xF6 != 0xF0 {
+		return false
+	}
+	return (header[2]>>2)&0x0F < 13
+}
+
+// mpegLayer returns the layer (1-3) of an MPEG audio frame header at the start
+// of header: an 11-bit sync word with valid version, layer, bitrate and sample
+// rate fields. It returns 0 if there is none.
+func mpegLayer(header []byte) int {
+	if len(header) < 4 || header[0] != 0xFF || header[1]&0xE0 != 0xE0 {
+		return 0
+	}
+	version := (header[1] >> 3) & 0x03
+	layer := (header[1] >> 1) & 0x03
+	bitrate := header[2] >> 4
+	sampleRate := (header[2] >> 2) & 0x03
+	if version == 1 || layer == 0 || bitrate == 0x0F || sampleRate == 0x03 {
+		return 0
+	}
+	return 4 - int(layer)
+}
+
+// id3Size returns the total size of an ID3v2 tag at the start of header, or 0
+// if there is none.
+func id3Size(header []byte) int64 {
+	if len(header) < 10 || !bytes.Equal(header[:3], []byte("ID3")) {
+		return 0
+	}
+	// The size is a 28-bit syncsafe integer excluding the 10-byte header
+	size := int64(header[6]&0x7F)<<21 | int64(header[7]&0x7F)<<14 | int64(header[8]&0x7F)<<7 | int64(header[9]&0x7F)
+	size += 10
+	if header[5]&0x10 != 0 {
+		size += 10 // Footer present
+	}
+	return size
+}
+
+// DetectReader identifies the format of the audio in r from its content,
+// skipping any ID3v2 tags. It returns false if the format is not recognized.
+// r is left at the start of the stream.
+func DetectReader(r io.ReadSeeker) (Detection, bool, error) {
+	d, ok, _, err := detectAt(r)
+	if err != nil {
+		return Detection{}, false, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return Detection{}, false, err
+	}
+	return d, ok, nil
+}
+
+// detectAt is DetectReader that also returns the offset where the audio starts
+// after any ID3v2 tags, leaving r at an unspecified position.
+func detectAt(r io.ReadSeeker) (Detection, bool, int64, error) {
+	var offset int64
+	header := make([]byte, sniffLen)
+
+	// MP3, AAC and even FLAC files may carry one or more ID3v2 tags up front
+	for {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return Detection{}, false, 0, err
+		}
+		n, err := io.ReadFull(r, header)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return Detection{}, false, 0, err
+		}
+
+		size := id3Size(header[:n])
+		if size == 0 {
+			d, ok := Sniff(header[:n])
+			return d, ok, offset, nil
+		}
+		offset += size
+	}
+}
+
+// Detect identifies the format of an audio file and returns its handler. The
+// content decides the format; the file extension is only used when the content
+// is not recognized and exactly one registered format uses that extension. A
+// file whose content contradicts its extension is still handled, with Mismatch
+// set in the returned Detection.
+func Detect(filename string) (Format, Detection, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, Detection{}, fmt.Errorf("failed to open audio file: %v", err)
+	}
+	defer file.Close()
+
+	d, sniffed, err := DetectReader(file)
+	if err != nil {
+		return nil, Detection{}, fmt.Errorf("failed to read audio file: %v", err)
+	}
+
+	r, d, err := resolveFormat(d, sniffed, strings.ToLower(filepath.Ext(filename)))
+	if err != nil {
+		return nil, d, err
+	}
+	return r.New(), d, nil
+}
+
+// resolveFormat picks the registered format for a detection, falling back to
+// the extension hint when the content was not recognized.
+func resolveFormat(d Detection, sniffed bool, ext string) (Registration, Detection, error) {
+	hinted := formatsForExtension(ext)
+	if !sniffed {
+		if len(hinted) != 1 {
+			return Registration{}, Detection{Extension: ext}, fmt.Errorf("unrecognized audio format")
+		}
+		d = hinted[0].detection()
+	}
+	d.Extension = ext
+
+	r, _ := Lookup(d.Format)
+	d.Mismatch = sniffed && len(hinted) > 0 && !r.hasExtension(ext)
+
+	if r.New == nil {
+		return r, d, fmt.Errorf("unsupported audio format: %s", d)
+	}
+	return r, d, nil
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// oggPage builds a first Ogg page holding a single packet.
+func oggPage(packet string) []byte {
+	page := []byte("OggS\x00\x02")
+	page = append(page, make([]byte, 20)...) // Granule, serial, sequence, CRC
+	page = append(page, 1, byte(len(packet)))
+	return append(page, packet...)
+}
+
+// id3Tag builds an ID3v2.4 tag with size bytes of padding.
+func id3Tag(size int) []byte {
+	tag := []byte{'I', 'D', '3', 4, 0, 0,
+		byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	return append(tag, make([]byte, size)...)
+}
+
+var (
+	wavHeader  = []byte("RIFF\x24\x00\x00\x00WAVEfmt \x10\x00\x00\x00\x01\x00\x01\x00")
+	mp3Header  = []byte{0xFF, 0xFB, 0x90, 0x64, 0x00}
+	adtsHeader = []byte{0xFF, 0xF1, 0x50, 0x80, 0x02, 0x1F, 0xFC}
+)
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    []byte
+		format    string
+		container string
+		codec     string
+	}{
+		{"wav", wavHeader, "wav", ContainerWAV, "PCM"},
+		{"rf64", []byte("RF64\xff\xff\xff\xffWAVEds64"), "wav", ContainerWAV, "PCM"},
+		{"flac", []byte("fLaC\x00\x00\x00\x22"), "flac", ContainerFLAC, "FLAC"},
+		{"mp3", mp3Header, "mp3", ContainerMPEG, "MP3"},
+		{"mp2", []byte{0xFF, 0xFD, 0x90, 0x64}, "mp2", ContainerMPEG, "MP2"},
+		{"adts", adtsHeader, "aac", ContainerADTS, "AAC"},
+		{"m4a", []byte("\x00\x00\x00\x20ftypM4A \x00\x00\x02\x00"), "m4a", ContainerMP4, "AAC"},
+		{"mp4", []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00"), "m4a", ContainerMP4, "AAC"},
+		{"ogg vorbis", oggPage("\x01vorbis\x00\x00\x00\x00"), "vorbis", ContainerOgg, "Vorbis"},
+		{"ogg opus", oggPage("OpusHead\x01\x02"), "opus", ContainerOgg, "Opus"},
+		{"ogg flac", oggPage("\x7fFLAC\x01\x00"), "ogg-flac", ContainerOgg, "FLAC"},
+		{"ogg speex", oggPage("Speex   1.2.0"), "speex", ContainerOgg, "Speex"},
+		{"webm", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm"), "webm", ContainerWebM, ""},
+		{"matroska", []byte("\x1a\x45\xdf\xa3\xa3\x42\x86\x81\x01\x42\x82\x88matroska"), "matroska", ContainerMatroska, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, ok := Sniff(tt.header)
+			assert.True(t, ok)
+			assert.Equal(t, tt.format, d.Format)
+			assert.Equal(t, tt.container, d.Container)
+			assert.Equal(t, tt.codec, d.Codec)
+			assert.True(t, d.Sniffed)
+		})
+	}
+}
+
+func TestSniff_Unrecognized(t *testing.T) {
+	for _, header := range [][]byte{
+		nil,
+		[]byte("RIFF\x24\x00\x00\x00AVI "),
+		[]byte("plain text"),
+		oggPage("\x80theora"),
+		{0xFF, 0xFF, 0x90, 0x64},                   // MPEG Layer I
+		{0xFF, 0xEA, 0x90, 0x64},                   // Reserved MPEG version
+		{0xFF, 0xFB, 0xF0, 0x64},                   // Invalid bitrate index
+		{0xFF, 0xF1, 0x3C, 0x80, 0x02, 0x1F, 0xFC}, // Invalid ADTS sampling index
+	} {
+		_, ok := Sniff(header)
+		assert.False(t, ok, "%q", header)
+	}
+}
+
+func TestDetectReader_SkipsID3(t *testing.T) {
+	data := append(id3Tag(5000), mp3Header...)
+	data = append(data, make([]byte, 100)...)
+
+	r := bytes.NewReader(data)
+	d, ok, err := DetectReader(r)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, ContainerMPEG, d.Container)
+
+	// The reader is rewound for the decoder
+	pos, _ := r.Seek(0, io.SeekCurrent)
+	assert.Equal(t, int64(0), pos)
+
+	// A tag with nothing after it is not audio
+	_, ok, err = DetectReader(bytes.NewReader(id3Tag(20)))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestDetect(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, data, 0644))
+		return path
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		data     []byte
+		format   Format
+		mismatch bool
+		wantErr  bool
+	}{
+		{"wav", "clip.wav", wavHeader, &WAVFormat{}, false, false},
+		{"wav without extension", "recording.bin", wavHeader, &WAVFormat{}, false, false},
+		{"wav named mp3", "clip.MP3", wavHeader, &WAVFormat{}, true, false},
+		{"mp3 with id3", "song.mp3", append(id3Tag(64), mp3Header...), &MP3Format{}, false, false},
+		{"adts as m4a", "voice.m4a", adtsHeader, &AACFormat{}, true, false},
+		{"opus in ogg", "note.ogg", oggPage("OpusHead\x01\x02"), &OpusFormat{}, false, false},
+		{"opus named opus", "note.opus", oggPage("OpusHead\x01\x02"), &OpusFormat{}, false, false},
+		{"vorbis named opus", "note.opus", oggPage("\x01vorbis\x00"), &VorbisFormat{}, true, false},
+		{"matroska named webm", "a.webm", []byte("\x1a\x45\xdf\xa3\x42\x82\x88matroska"), &MatroskaFormat{}, true, false},
+		{"speex", "a.spx", oggPage("Speex   1.2.0"), nil, false, true},
+		{"speex named ogg", "a.ogg", oggPage("Speex   1.2.0"), nil, false, true},
+		{"unrecognized falls back to extension", "clip.flac", []byte("garbage"), &FLACFormat{}, false, false},
+		{"unrecognized without hint", "clip.bin", []byte("garbage"), nil, false, true},
+		{"unrecognized with ambiguous hint", "clip.ogg", []byte("garbage"), nil, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, d, err := Detect(write(tt.file, tt.data))
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.format, format)
+			assert.Equal(t, tt.mismatch, d.Mismatch)
+			assert.Equal(t, strings.ToLower(filepath.Ext(tt.file)), d.Extension)
+		})
+	}
+}
@@ -0,0 +1,325 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+// DiarizeOptions controls how Diarize tells speakers apart.
+type DiarizeOptions struct {
+	MaxSpeakers int     // Most speakers to find; 0 for no limit
+	Threshold   float64 // Voice distance, in standard deviations, below which turns are one speaker
+}
+
+// DefaultDiarizeOptions suits two-way radio and telephone audio.
+var DefaultDiarizeOptions = DiarizeOptions{Threshold: 0.5}
+
+const (
+	cepstra          = 12    // Coefficients kept, leaving out c0, the loudness
+	melBands         = 24    // Filters of the mel filterbank
+	melLowHz         = 100.0 // Band the filterbank spans; telephone audio ends at 3.4 kHz
+	melHighHz        = 7600.0
+	voicedRange      = 30.0 // dB below the loud frames that still carry a voice
+	minSpeakerFrames = 100  // Voiced frames, of 10 ms, a speaker needs to be told apart
+)
+
+// Span is a stretch of audio, from sample Start to End, in which one speaker
+// is talking.
+type Span struct {
+	Start, End int
+}
+
+// Diarize tells which speaker is talking in each span. It describes each
+// voice by its average spectral envelope, as mel-frequency cepstral
+// coefficients, and merges the closest voices until they are more than
+// opts.Threshold apart and there are no more than opts.MaxSpeakers. Voices
+// heard for less than a second are put with the nearest other. Speakers are
+// numbered from 0 in the order they first talk; spans without a voice go to
+// the speaker before them.
+func Diarize(samples []float32, sampleRate int, spans []Span, opts DiarizeOptions) []int {
+	labels := make([]int, len(spans))
+	if len(spans) == 0 {
+		return labels
+	}
+	hop := sampleRate / 100
+	features, voiced := cepstralFeatures(samples, sampleRate, hop)
+
+	// One voice per span that has any
+	var voices []voice
+	owner := make([]int, len(spans)) // Voice of each span, or -1
+	for i, span := range spans {
+		owner[i] = -1
+		v := voice{sum: make([]float64, cepstra)}
+		for f := max(span.Start, 0) / hop; f < len(features) && f*hop < span.End; f++ {
+			if !voiced[f] {
+				continue
+			}
+			for k, c := range features[f] {
+				v.sum[k] += c
+			}
+			v.weight++
+		}
+		if v.weight > 0 {
+			owner[i] = len(voices)
+			voices = append(voices, v)
+		}
+	}
+
+	clusters := clusterVoices(voices, opts)
+
+	// Number the speakers by first appearance
+	number := map[int]int{}
+	last := 0
+	for i := range spans {
+		if owner[i] >= 0 {
+			c := clusters[owner[i]]
+			if _, ok := number[c]; !ok {
+				number[c] = len(number)
+			}
+			last = number[c]
+		}
+		labels[i] = last
+	}
+	return labels
+}
+
+// voice is the summed features of the voiced frames of one or more spans.
+type voice struct {
+	sum    []float64
+	weight float64
+}
+
+func (v voice) distance(o voice) float64 {
+	var d float64
+	for k := range v.sum {
+		diff := v.sum[k]/v.weight - o.sum[k]/o.weight
+		d += diff * diff
+	}
+	return math.Sqrt(d / float64(len(v.sum)))
+}
+
+// clusterVoices merges voices by centroid linkage and returns the cluster of
+// each. Each cluster remembers its nearest neighbour, so a merge only
+// rescans the clusters that were nearest to one of the pair.
+func clusterVoices(voices []voice, opts DiarizeOptions) []int {
+	n := len(voices)
+	cluster := make([]int, n)
+	active := make([]bool, n)
+	for i := range voices {
+		cluster[i] = i
+		active[i] = true
+	}
+	nearest := make([]int, n)
+	nearestDist := make([]float64, n)
+	findNearest := func(i int) {
+		nearest[i], nearestDist[i] = -1, math.Inf(1)
+		for j := range voices {
+			if j != i && active[j] {
+				if d := voices[i].distance(voices[j]); d < nearestDist[i] {
+					nearest[i], nearestDist[i] = j, d
+				}
+			}
+		}
+	}
+	for i := range voices {
+		findNearest(i)
+	}
+
+	merge := func(a, b int) {
+		for k := range voices[a].sum {
+			voices[a].sum[k] += voices[b].sum[k]
+		}
+		voices[a].weight += voices[b].weight
+		active[b] = false
+		for i := range cluster {
+			if cluster[i] == b {
+				cluster[i] = a
+			}
+		}
+		for i := range voices {
+			if !active[i] || i == a {
+				continue
+			}
+			if nearest[i] == a || nearest[i] == b {
+				findNearest(i)
+			} else if d := voices[i].distance(voices[a]); d < nearestDist[i] {
+				nearest[i], nearestDist[i] = a, d
+			}
+		}
+		findNearest(a)
+	}
+
+	count := n
+	for count > 1 {
+		closest := -1
+		for i := range voices {
+			if active[i] && nearest[i] >= 0 && (closest < 0 || nearestDist[i] < nearestDist[closest]) {
+				closest = i
+			}
+		}
+		tooMany := opts.MaxSpeakers > 0 && count > opts.MaxSpeakers
+		if !tooMany && nearestDist[closest] >= opts.Threshold {
+			break
+		}
+		merge(closest, nearest[closest])
+		count--
+	}
+
+	// A voice heard too briefly cannot be told apart reliably
+	for count > 1 {
+		smallest := -1
+		for i := range voices {
+			if active[i] && voices[i].weight < minSpeakerFrames && (smallest < 0 || voices[i].weight < voices[smallest].weight) {
+				smallest = i
+			}
+		}
+		if smallest < 0 {
+			break
+		}
+		merge(nearest[smallest], smallest)
+		count--
+	}
+	return cluster
+}
+
+// cepstralFeatures returns mel-frequency cepstral coefficients for frames of
+// 25 ms every hop samples, normalized to zero mean and unit variance over the
+// voiced frames, and whether each frame is voiced: within voicedRange of the
+// loud frames.
+func cepstralFeatures(samples []float32, sampleRate, hop int) ([][]float64, []bool) {
+	size := sampleRate / 40
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	if len(samples) < size || hop <= 0 {
+		return nil, nil
+	}
+	frames := (len(samples)-size)/hop + 1
+
+	window := make([]float64, size)
+	for i := range window {
+		window[i] = 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(size-1))
+	}
+	twiddle := make([]complex128, n/2)
+	for k := range twiddle {
+		twiddle[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(n)))
+	}
+	filters := melFilterbank(n, sampleRate)
+	dct := make([][]float64, cepstra)
+	for k := range dct {
+		dct[k] = make([]float64, melBands)
+		for b := range dct[k] {
+			dct[k][b] = math.Cos(math.Pi * float64(k+1) * (float64(b) + 0.5) / melBands)
+		}
+	}
+
+	features := make([][]float64, frames)
+	energy := make([]float64, frames)
+	buf := make([]complex128, n)
+	power := make([]float64, n/2+1)
+	bands := make([]float64, melBands)
+	for f := range features {
+		frame := samples[f*hop : f*hop+size]
+		for i := range buf {
+			buf[i] = 0
+			if i < size {
+				// Pre-emphasis flattens the spectral tilt of voice
+				x := float64(frame[i])
+				if i > 0 {
+					x -= 0.97 * float64(frame[i-1])
+				}
+				buf[i] = complex(x*window[i], 0)
+			}
+		}
+		fft(buf, twiddle)
+		for k := range power {
+			power[k] = real(buf[k])*real(buf[k]) + imag(buf[k])*imag(buf[k])
+		}
+		for b, filter := range filters {
+			bands[b] = 0
+			for _, w := range filter {
+				bands[b] += w.weight * power[w.bin]
+			}
+			energy[f] += bands[b]
+			bands[b] = math.Log(bands[b] + 1e-10)
+		}
+		features[f] = make([]float64, cepstra)
+		for k := range features[f] {
+			for b, c := range dct[k] {
+				features[f][k] += c * bands[b]
+			}
+		}
+	}
+
+	// Frames near the level of the loud ones carry a voice
+	sorted := append([]float64(nil), energy...)
+	sort.Float64s(sorted)
+	floor := sorted[int(0.95*float64(frames-1))] * math.Pow(10, -voicedRange/10)
+	voiced := make([]bool, frames)
+	var count float64
+	for f, e := range energy {
+		voiced[f] = e > floor && e > 1e-8
+		if voiced[f] {
+			count++
+		}
+	}
+	if count == 0 {
+		return features, voiced
+	}
+
+	// Normalize each coefficient, so the channel and the microphone matter
+	// less than the voice
+	for k := 0; k < cepstra; k++ {
+		var sum, sq float64
+		for f := range features {
+			if voiced[f] {
+				sum += features[f][k]
+				sq += features[f][k] * features[f][k]
+			}
+		}
+		mean := sum / count
+		std := math.Sqrt(math.Max(sq/count-mean*mean, 1e-12))
+		for f := range features {
+			features[f][k] = (features[f][k] - mean) / std
+		}
+	}
+	return features, voiced
+}
+
+// binWeight is the weight of one FFT bin in a mel filter.
+type binWeight struct {
+	bin    int
+	weight float64
+}
+
+// melFilterbank returns melBands triangular filters over the bins of an
+// n-point FFT, evenly spaced on the mel scale between melLowHz and melHighHz.
+func melFilterbank(n, sampleRate int) [][]binWeight {
+	mel := func(hz float64) float64 { return 2595 * math.Log10(1+hz/700) }
+	hz := func(m float64) float64 { return 700 * (math.Pow(10, m/2595) - 1) }
+	low, high := mel(melLowHz), mel(math.Min(melHighHz, float64(sampleRate)/2))
+	edges := make([]float64, melBands+2) // In bins
+	for i := range edges {
+		edges[i] = hz(low+(high-low)*float64(i)/float64(melBands+1)) * float64(n) / float64(sampleRate)
+	}
+
+	filters := make([][]binWeight, melBands)
+	for b := range filters {
+		left, centre, right := edges[b], edges[b+1], edges[b+2]
+		for k := int(math.Ceil(left)); float64(k) < right && k <= n/2; k++ {
+			w := (float64(k) - left) / (centre - left)
+			if float64(k) > centre {
+				w = (right - float64(k)) / (right - centre)
+			}
+			if w > 0 {
+				filters[b] = append(filters[b], binWeight{k, w})
+			}
+		}
+	}
+	return filters
+}
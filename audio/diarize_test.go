@@ -0,0 +1,90 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// synthVoice returns seconds of a vowel-like sound: the harmonics of f0
+// shaped by resonances at the formants, with a little pitch wobble and noise.
+func synthVoice(f0 float64, formants []float64, seconds float64, rate int, rng *rand.Rand) []float32 {
+	samples := make([]float32, int(seconds*float64(rate)))
+	f0 *= 1 + 0.03*rng.NormFloat64()
+	for h := 1; float64(h)*f0 < float64(rate)/2; h++ {
+		f := float64(h) * f0
+		var amplitude float64
+		for _, formant := range formants {
+			amplitude += 1 / (1 + math.Pow((f-formant)/100, 2))
+		}
+		phase := rng.Float64() * 2 * math.Pi
+		for i := range samples {
+			wobble := 1 + 0.01*math.Sin(2*math.Pi*5*float64(i)/float64(rate))
+			samples[i] += float32(0.05 * amplitude * math.Sin(2*math.Pi*f*wobble*float64(i)/float64(rate)+phase))
+		}
+	}
+	for i := range samples {
+		samples[i] += float32(0.002 * rng.NormFloat64())
+	}
+	return samples
+}
+
+var (
+	dispatcher = []float64{700, 1200, 2600}
+	unit       = []float64{350, 2100, 3000}
+)
+
+// conversation joins turns with short pauses and returns the spans of the turns.
+func conversation(turns [][]float32, rate int) ([]float32, []Span) {
+	var samples []float32
+	var spans []Span
+	for _, turn := range turns {
+		spans = append(spans, Span{len(samples), len(samples) + len(turn)})
+		samples = append(samples, turn...)
+		samples = append(samples, make([]float32, rate/4)...)
+	}
+	return samples, spans
+}
+
+func TestDiarize(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var turns [][]float32
+	for i := 0; i < 6; i++ {
+		if i%2 == 0 {
+			turns = append(turns, synthVoice(120, dispatcher, 2, 16000, rng))
+		} else {
+			turns = append(turns, synthVoice(200, unit, 2, 16000, rng))
+		}
+	}
+	samples, spans := conversation(turns, 16000)
+
+	// Two voices taking turns
+	assert.Equal(t, []int{0, 1, 0, 1, 0, 1}, Diarize(samples, 16000, spans, DefaultDiarizeOptions))
+
+	// The hint caps the speakers found
+	assert.Equal(t, []int{0, 0, 0, 0, 0, 0}, Diarize(samples, 16000, spans, DiarizeOptions{MaxSpeakers: 1, Threshold: 0.5}))
+
+	// A span without a voice goes to the speaker before it
+	spans = append(spans, Span{len(samples) - 4000, len(samples)})
+	assert.Equal(t, []int{0, 1, 0, 1, 0, 1, 1}, Diarize(samples, 16000, spans, DefaultDiarizeOptions))
+}
+
+func TestDiarize_OneSpeaker(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	var turns [][]float32
+	for i := 0; i < 6; i++ {
+		turns = append(turns, synthVoice(120, dispatcher, 1.5, 16000, rng))
+	}
+
+	// A voice heard too briefly to tell apart joins the nearest
+	turns = append(turns, synthVoice(200, unit, 0.5, 16000, rng))
+	samples, spans := conversation(turns, 16000)
+	assert.Equal(t, []int{0, 0, 0, 0, 0, 0, 0}, Diarize(samples, 16000, spans, DefaultDiarizeOptions))
+
+	assert.Empty(t, Diarize(samples, 16000, nil, DefaultDiarizeOptions))
+}
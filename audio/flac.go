@@ -4,9 +4,9 @@
 package audio
 
 import (
+	"bytes"
 	"fmt"
 	"io"
-	"os"
 
 	"github.com/mewkiz/flac"
 	"github.com/mewkiz/flac/frame"
@@ -16,18 +16,47 @@ import (
 // FLACFormat implements the Format interface for FLAC audio files.
 type FLACFormat struct{}
 
+func init() {
+	Register(Registration{
+		Name:       "flac",
+		Container:  ContainerFLAC,
+		Codec:      "FLAC",
+		MIMETypes:  []string{"audio/flac", "audio/x-flac"},
+		Extensions: []string{".flac"},
+		Sniff:      func(h []byte) bool { return bytes.HasPrefix(h, []byte("fLaC")) },
+		New:        func() Format { return &FLACFormat{} },
+		Decodable:  true,
+	})
+}
+
 // GetMetadata extracts metadata from a FLAC file.
 func (f *FLACFormat) GetMetadata(filename string, fileSize int64) (AudioMetadata, error) {
-	stream, err := flac.ParseFile(filename)
+	return streamMetadata(f, filename, fileSize)
+}
+
+// ConvertToSamples converts FLAC audio data to float32 samples at the target sample rate.
+func (f *FLACFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
+	return streamSamples(f, filename, targetSampleRate)
+}
+
+// Decode reads the FLAC StreamInfo block and streams frames as they are
+// parsed, mixed to mono.
+func (f *FLACFormat) Decode(r io.Reader) (SampleStream, AudioMetadata, error) {
+	return downmixed(f.DecodeChannels(r))
+}
+
+// DecodeChannels is like Decode but keeps the channels of each frame
+// interleaved.
+func (f *FLACFormat) DecodeChannels(r io.Reader) (SampleStream, AudioMetadata, error) {
+	stream, err := flac.New(r)
 	if err != nil {
-		return AudioMetadata{}, fmt.Errorf("failed to parse FLAC file: %v", err)
+		return nil, AudioMetadata{}, fmt.Errorf("failed to create FLAC stream: %v", err)
 	}
-	defer stream.Close()
 
 	// Get StreamInfo from the stream
 	streamInfo := stream.Info
 	if streamInfo == nil {
-		return AudioMetadata{}, fmt.Errorf("no StreamInfo found in FLAC stream")
+		return nil, AudioMetadata{}, fmt.Errorf("no StreamInfo found in FLAC stream")
 	}
 
 	// Calculate duration
@@ -36,77 +65,32 @@ func (f *FLACFormat) GetMetadata(filename string, fileSize int64) (AudioMetadata
 		durationSeconds = float64(streamInfo.NSamples) / float64(streamInfo.SampleRate)
 	}
 
-	// Calculate bitrate (approximate)
-	var bitrate int
-	if durationSeconds > 0 {
-		bitrate = int((fileSize * 8) / int64(durationSeconds) / 1000)
-	}
-
-	return AudioMetadata{
+	metadata := AudioMetadata{
 		Duration:   durationSeconds,
 		SampleRate: int(streamInfo.SampleRate),
 		Channels:   int(streamInfo.NChannels),
-		Bitrate:    bitrate,
 		Format:     "FLAC",
 		Codec:      "FLAC",
 		BitDepth:   int(streamInfo.BitsPerSample),
-		OriginalSize: fileSize,
-	}, nil
-}
-
-// ConvertToSamples converts FLAC audio data to float32 samples at the target sample rate.
-func (f *FLACFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open FLAC file: %v", err)
 	}
-	defer file.Close()
 
-	stream, err := flac.New(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create FLAC stream: %v", err)
-	}
-
-	// Get StreamInfo from the stream
-	streamInfo := stream.Info
-	if streamInfo == nil {
-		return nil, fmt.Errorf("no StreamInfo found in FLAC stream")
-	}
-
-	var allSamples []float32
-
-	// Read frames and decode audio samples
-	for {
-		frame, err := stream.ParseNext()
-		if err != nil {
+	samples := &blockStream{
+		next: func() ([]float32, error) {
+			frame, err := stream.ParseNext()
 			if err == io.EOF {
-				break
+				return nil, io.EOF
 			}
-			return nil, fmt.Errorf("failed to parse frame: %v", err)
-		}
-
-		frameSamples, err := f.convertFLACFrame(frame, streamInfo)
-		if err != nil {
-			return nil, fmt.Errorf("failed to convert frame: %v", err)
-		}
-
-		allSamples = append(allSamples, frameSamples...)
-	}
-
-	// Resample to target rate if necessary
-	if streamInfo.SampleRate != uint32(targetSampleRate) {
-		allSamples = ResampleAudio(allSamples, int(streamInfo.SampleRate), targetSampleRate)
-	}
-
-	// Convert to mono if necessary
-	if streamInfo.NChannels > 1 {
-		allSamples = ConvertToMono(allSamples, int(streamInfo.NChannels))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse frame: %v", err)
+			}
+			return f.convertFLACFrame(frame, streamInfo)
+		},
+		close: stream.Close,
 	}
-
-	return allSamples, nil
+	return samples, metadata, nil
 }
 
-// convertFLACFrame converts a FLAC frame to float32 samples.
+// convertFLACFrame converts a FLAC frame to interleaved float32 samples.
 func (f *FLACFormat) convertFLACFrame(frame *frame.Frame, streamInfo *meta.StreamInfo) ([]float32, error) {
 	if len(frame.Subframes) == 0 {
 		return nil, fmt.Errorf("frame has no subframes")
@@ -132,29 +116,16 @@ func (f *FLACFormat) convertFLACFrame(frame *frame.Frame, streamInfo *meta.Strea
 		}
 	}
 
-	// Convert to float32 samples
-	result := make([]float32, blockSize)
+	// Convert to interleaved float32 samples
+	result := make([]float32, blockSize*nChannels)
 	
 	// Scale factor to convert from integer samples to float32 range [-1.0, 1.0]
 	maxValue := int32(1 << (bitsPerSample - 1))
 	scale := float32(1.0) / float32(maxValue)
 	
-	if nChannels == 1 {
-		// Mono audio
-		for i := 0; i < blockSize; i++ {
-			sample := samples[0][i]
-			result[i] = float32(sample) * scale
-		}
-	} else {
-		// Multi-channel audio - mix to mono
-		for i := 0; i < blockSize; i++ {
-			var sum int64
-			for ch := 0; ch < nChannels; ch++ {
-				sum += int64(samples[ch][i])
-			}
-			// Average the channels
-			avg := sum / int64(nChannels)
-			result[i] = float32(avg) * scale
+	for i := 0; i < blockSize; i++ {
+		for ch := 0; ch < nChannels; ch++ {
+			result[i*nChannels+ch] = float32(samples[ch][i]) * scale
 		}
 	}
 
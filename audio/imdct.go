@@ -0,0 +1,135 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// imdct computes the inverse modified discrete cosine transform of n/2
+// coefficients to n samples, scaled by 2/n as in ISO/IEC 14496-3 4.6.11.
+// It uses a DCT-IV of length n/2 computed with a complex FFT of length n/4.
+type imdct struct {
+	n         int
+	pre, post []complex128 // DCT-IV twiddle factors
+	twiddle   []complex128 // FFT twiddle factors
+	buf       []complex128
+	u         []float64 // DCT-IV output
+}
+
+func newIMDCT(n int) *imdct {
+	m := n / 2
+	t := &imdct{
+		n:       n,
+		pre:     make([]complex128, m/2),
+		post:    make([]complex128, m/2),
+		twiddle: make([]complex128, m/4),
+		buf:     make([]complex128, m/2),
+		u:       make([]float64, m),
+	}
+	for k := range t.pre {
+		t.pre[k] = cmplx.Exp(complex(0, -math.Pi*(float64(k)+0.25)/float64(m)))
+		t.post[k] = cmplx.Exp(complex(0, -math.Pi*float64(k)/float64(m)))
+	}
+	for k := range t.twiddle {
+		t.twiddle[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(m/2)))
+	}
+	return t
+}
+
+// transform writes the n samples for the coefficients in to out.
+func (t *imdct) transform(in []float32, out []float32) {
+	m := t.n / 2
+	h := m / 2
+
+	// DCT-IV: pair even coefficients with reversed odd ones, rotate, FFT,
+	// rotate back and unpack
+	for k := 0; k < h; k++ {
+		t.buf[k] = complex(float64(in[2*k]), float64(in[m-1-2*k])) * t.pre[k]
+	}
+	fft(t.buf, t.twiddle)
+	for k := 0; k < h; k++ {
+		c := t.buf[k] * t.post[k]
+		t.u[2*k] = real(c)
+		t.u[m-1-2*k] = -imag(c)
+	}
+
+	// The IMDCT is the DCT-IV shifted by a quarter and unfolded with odd
+	// symmetry
+	scale := 2 / float64(t.n)
+	for i := 0; i < m/2; i++ {
+		out[i] = float32(t.u[i+m/2] * scale)
+	}
+	for i := m / 2; i < 3*m/2; i++ {
+		out[i] = float32(-t.u[3*m/2-1-i] * scale)
+	}
+	for i := 3 * m / 2; i < 2*m; i++ {
+		out[i] = float32(-t.u[i-3*m/2] * scale)
+	}
+}
+
+// fft is an in-place radix-2 FFT. twiddle holds exp(-2πik/n) for k < n/2.
+func fft(a []complex128, twiddle []complex128) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := n / size
+		for s := 0; s < n; s += size {
+			for k := 0; k < size/2; k++ {
+				v := twiddle[k*step] * a[s+k+size/2]
+				a[s+k+size/2] = a[s+k] - v
+				a[s+k] += v
+			}
+		}
+	}
+}
+
+// sineWindow returns the rising half of a sine window of length 2n.
+func sineWindow(n int) []float32 {
+	w := make([]float32, n)
+	for i := range w {
+		w[i] = float32(math.Sin(math.Pi / float64(2*n) * (float64(i) + 0.5)))
+	}
+	return w
+}
+
+// kbdWindow returns the rising half of a Kaiser-Bessel-derived window of
+// length 2n.
+func kbdWindow(n int, alpha float64) []float32 {
+	kernel := make([]float64, n+1)
+	var total float64
+	for i := range kernel {
+		x := float64(i-n/2) / float64(n/2)
+		kernel[i] = besselI0(math.Pi * alpha * math.Sqrt(1-x*x))
+		total += kernel[i]
+	}
+
+	w := make([]float32, n)
+	var sum float64
+	for i := range w {
+		sum += kernel[i]
+		w[i] = float32(math.Sqrt(sum / total))
+	}
+	return w
+}
+
+// besselI0 is the zeroth-order modified Bessel function of the first kind.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		term *= (x / 2 / float64(k)) * (x / 2 / float64(k))
+		sum += term
+	}
+	return sum
+}
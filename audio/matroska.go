@@ -0,0 +1,807 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// EBML and Matroska element IDs, including their length markers
+const (
+	ebmlHeaderID  = 0x1A45DFA3
+	ebmlDocTypeID = 0x4282
+
+	mkvSegmentID           = 0x18538067
+	mkvInfoID              = 0x1549A966
+	mkvTimestampScaleID    = 0x2AD7B1
+	mkvDurationID          = 0x4489
+	mkvTracksID            = 0x1654AE6B
+	mkvTrackEntryID        = 0xAE
+	mkvTrackNumberID       = 0xD7
+	mkvTrackTypeID         = 0x83
+	mkvCodecID             = 0x86
+	mkvCodecPrivateID      = 0x63A2
+	mkvAudioID             = 0xE1
+	mkvSamplingFrequencyID = 0xB5
+	mkvChannelsID          = 0x9F
+	mkvContentEncodingsID  = 0x6D80
+	mkvClusterID           = 0x1F43B675
+	mkvTimestampID         = 0xE7
+	mkvSimpleBlockID       = 0xA3
+	mkvBlockGroupID        = 0xA0
+	mkvBlockID             = 0xA1
+	mkvDiscardPaddingID    = 0x75A2
+)
+
+// mkvAudioTrack is the TrackType of audio tracks.
+const mkvAudioTrack = 2
+
+// matroskaMaxElement bounds the size of elements read into memory: headers,
+// blocks and block groups. Larger elements, such as attachments, are skipped.
+const matroskaMaxElement = 16 << 20
+
+// MatroskaFormat implements the Format interface for Matroska and WebM files,
+// such as browser MediaRecorder uploads, with Opus, Vorbis or AAC audio.
+type MatroskaFormat struct{}
+
+func init() {
+	Register(Registration{
+		Name:       "webm",
+		Container:  ContainerWebM,
+		MIMETypes:  []string{"audio/webm"},
+		Extensions: []string{".webm"},
+		Sniff:      func(h []byte) bool { return ebmlDocType(h) == "webm" },
+		New:        func() Format { return &MatroskaFormat{} },
+		Decodable:  true,
+	})
+	Register(Registration{
+		Name:       "matroska",
+		Container:  ContainerMatroska,
+		MIMETypes:  []string{"audio/x-matroska"},
+		Extensions: []string{".mka", ".mkv"},
+		Sniff:      func(h []byte) bool { return ebmlDocType(h) == "matroska" },
+		New:        func() Format { return &MatroskaFormat{} },
+		Decodable:  true,
+	})
+}
+
+// GetMetadata extracts metadata from a Matroska or WebM file.
+func (f *MatroskaFormat) GetMetadata(filename string, fileSize int64) (AudioMetadata, error) {
+	return streamMetadata(f, filename, fileSize)
+}
+
+// ConvertToSamples converts a Matroska or WebM file to a slice of float32 samples.
+func (f *MatroskaFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
+	return streamSamples(f, filename, targetSampleRate)
+}
+
+// Decode streams the first audio track, mixed to mono. The duration comes
+// from the Segment Info; live recordings have none, so when r can seek the
+// blocks are scanned for the end of the last one instead.
+func (f *MatroskaFormat) Decode(r io.Reader) (SampleStream, AudioMetadata, error) {
+	return f.decode(r, false)
+}
+
+// DecodeChannels is like Decode but keeps the track's channels interleaved.
+func (f *MatroskaFormat) DecodeChannels(r io.Reader) (SampleStream, AudioMetadata, error) {
+	return f.decode(r, true)
+}
+
+func (f *MatroskaFormat) decode(r io.Reader, channels bool) (SampleStream, AudioMetadata, error) {
+	rs, seekable := r.(io.ReadSeeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = rs.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	m, err := newMatroskaReader(r)
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+	track, err := m.audioTrack()
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+
+	metadata := AudioMetadata{
+		Format:   "MATROSKA",
+		Channels: track.channels,
+		BitDepth: 16,
+		Duration: m.seconds(m.duration),
+	}
+	if m.docType == "webm" {
+		metadata.Format = "WEBM"
+	}
+
+	var stream SampleStream
+	mixed := false                       // Whether stream is already mono
+	var delay float64                    // Seconds decoded before the start, from the pre-skip
+	var frameLength func([]byte) float64 // Seconds in a frame, if known without decoding
+	switch {
+	case track.codec == "A_OPUS":
+		head, err := parseOpusHead(track.private)
+		if err != nil {
+			return nil, AudioMetadata{}, err
+		}
+		dec, err := newOpusMultistream(head)
+		if err != nil {
+			return nil, AudioMetadata{}, err
+		}
+		stream = newOpusStream(&matroskaOpusPackets{m: m}, head, dec)
+		delay = float64(head.preSkip) / OpusSampleRate
+		frameLength = func(frame []byte) float64 {
+			return float64(opusPacketSamples(frame)) / OpusSampleRate
+		}
+		metadata.Codec = "Opus"
+		metadata.SampleRate = OpusSampleRate
+		metadata.Channels = head.channels
+
+	case track.codec == "A_VORBIS":
+		headers, err := splitXiphHeaders(track.private)
+		if err != nil {
+			return nil, AudioMetadata{}, err
+		}
+		var rate, channels int
+		stream, rate, channels, err = newVorbisPacketStream(m, headers)
+		if err != nil {
+			return nil, AudioMetadata{}, err
+		}
+		metadata.Codec = "Vorbis"
+		metadata.SampleRate = rate
+		metadata.Channels = channels
+
+	case strings.HasPrefix(track.codec, "A_AAC"):
+		config, err := matroskaAACConfig(track)
+		if err != nil {
+			return nil, AudioMetadata{}, err
+		}
+		dec, err := newAACDecoder(config)
+		if err != nil {
+			return nil, AudioMetadata{}, err
+		}
+		aac := aacMetadata(config)
+		if channels {
+			dec.interleave = aac.Channels
+		} else {
+			mixed = true // Without the LFE channel
+		}
+		// Blocks timed before the start of the segment, as in files cut from
+		// a longer recording, are pre-roll
+		var skip int64
+		if err := m.readBlock(); err != nil && err != io.EOF {
+			return nil, AudioMetadata{}, err
+		} else if err == nil && m.blockTime < 0 {
+			skip = int64(math.Round(-m.seconds(float64(m.blockTime)) * float64(aac.SampleRate)))
+		}
+		next := func() ([]byte, error) {
+			frame, _, err := m.nextFrame()
+			return frame, err
+		}
+		stream = newAACStream(next, dec.decodeFrame, max(dec.interleave, 1), skip, -1)
+		frameLength = func([]byte) float64 { return float64(aacFrameLength) / float64(config.sampleRate) }
+		metadata.Codec = aac.Codec
+		metadata.SampleRate = aac.SampleRate
+		metadata.Channels = aac.Channels
+
+	default:
+		return nil, AudioMetadata{}, fmt.Errorf("unsupported Matroska audio codec %q: only Opus, Vorbis and AAC are supported", track.codec)
+	}
+
+	// The stream has not been read from yet, so the reader can seek back to
+	// where it is
+	if metadata.Duration == 0 && seekable {
+		pos, err := rs.Seek(0, io.SeekCurrent)
+		if err == nil {
+			if _, err := rs.Seek(start, io.SeekStart); err == nil {
+				metadata.Duration = max(matroskaDuration(rs, track.number, frameLength)-delay, 0)
+			}
+			if _, err := rs.Seek(pos, io.SeekStart); err != nil {
+				return nil, AudioMetadata{}, err
+			}
+		}
+	}
+	if !channels && !mixed {
+		stream = Downmix(stream, metadata.Channels)
+	}
+	return stream, metadata, nil
+}
+
+// matroskaTrack is a track described in the Tracks element.
+type matroskaTrack struct {
+	number     uint64
+	trackType  uint64
+	codec      string
+	private    []byte  // CodecPrivate
+	sampleRate float64 // From the Audio element
+	channels   int
+	encoded    bool // Compressed or encrypted with ContentEncodings
+}
+
+// matroskaReader reads the frames of one track from a Matroska or WebM file
+// front to back. Elements are read in sequence rather than by descending into
+// them by size, so segments and clusters of unknown size, as written by live
+// recorders, are handled like any other.
+type matroskaReader struct {
+	r              *bufio.Reader
+	docType        string
+	timestampScale int64   // Nanoseconds per tick
+	duration       float64 // Segment duration in ticks, 0 if unknown
+	tracks         []matroskaTrack
+	track          uint64 // Number of the track whose frames are returned
+
+	clusterTime int64    // Timestamp of the current cluster, in ticks
+	blockTime   int64    // Timestamp of the current block, in ticks
+	frames      [][]byte // Frames left from the current block
+	discard     int64    // Discard padding of the current block, in nanoseconds
+}
+
+// newMatroskaReader reads the EBML header and the segment's Info and Tracks
+// elements, leaving r at the start of the first cluster.
+func newMatroskaReader(r io.Reader) (*matroskaReader, error) {
+	m := &matroskaReader{r: bufio.NewReader(r), timestampScale: 1000000}
+
+	id, size, err := m.readHeader()
+	if err != nil || id != ebmlHeaderID {
+		return nil, fmt.Errorf("not a Matroska file")
+	}
+	header, err := m.readBody(size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EBML header: %v", err)
+	}
+	err = eachElement(header, func(id uint32, body []byte) error {
+		if id == ebmlDocTypeID {
+			m.docType = strings.TrimRight(string(body), "\x00")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		id, size, err := m.readHeader()
+		if err != nil {
+			return nil, fmt.Errorf("no audio found in Matroska file: %v", err)
+		}
+		switch id {
+		case mkvSegmentID:
+			// Its children follow
+		case mkvInfoID:
+			body, err := m.readBody(size)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read Matroska segment info: %v", err)
+			}
+			if err := m.parseInfo(body); err != nil {
+				return nil, err
+			}
+		case mkvTracksID:
+			body, err := m.readBody(size)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read Matroska tracks: %v", err)
+			}
+			if err := m.parseTracks(body); err != nil {
+				return nil, err
+			}
+		case mkvClusterID:
+			if m.tracks == nil {
+				return nil, fmt.Errorf("no tracks before the first Matroska cluster")
+			}
+			return m, nil
+		default:
+			if err := m.skip(size); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (m *matroskaReader) parseInfo(info []byte) error {
+	return eachElement(info, func(id uint32, body []byte) error {
+		switch id {
+		case mkvTimestampScaleID:
+			if scale := int64(ebmlUint(body)); scale > 0 {
+				m.timestampScale = scale
+			}
+		case mkvDurationID:
+			m.duration = ebmlFloat(body)
+		}
+		return nil
+	})
+}
+
+func (m *matroskaReader) parseTracks(tracks []byte) error {
+	return eachElement(tracks, func(id uint32, entry []byte) error {
+		if id != mkvTrackEntryID {
+			return nil
+		}
+		track := matroskaTrack{channels: 1}
+		err := eachElement(entry, func(id uint32, body []byte) error {
+			switch id {
+			case mkvTrackNumberID:
+				track.number = ebmlUint(body)
+			case mkvTrackTypeID:
+				track.trackType = ebmlUint(body)
+			case mkvCodecID:
+				track.codec = strings.TrimRight(string(body), "\x00")
+			case mkvCodecPrivateID:
+				track.private = body
+			case mkvContentEncodingsID:
+				track.encoded = true
+			case mkvAudioID:
+				return eachElement(body, func(id uint32, body []byte) error {
+					switch id {
+					case mkvSamplingFrequencyID:
+						track.sampleRate = ebmlFloat(body)
+					case mkvChannelsID:
+						track.channels = int(ebmlUint(body))
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		m.tracks = append(m.tracks, track)
+		return err
+	})
+}
+
+// audioTrack selects the first audio track as the one to read.
+func (m *matroskaReader) audioTrack() (matroskaTrack, error) {
+	for _, t := range m.tracks {
+		if t.trackType != mkvAudioTrack {
+			continue
+		}
+		if t.encoded {
+			return matroskaTrack{}, fmt.Errorf("compressed or encrypted Matroska tracks are not supported")
+		}
+		m.track = t.number
+		return t, nil
+	}
+	return matroskaTrack{}, fmt.Errorf("no audio track in Matroska file")
+}
+
+// seconds converts a time in ticks to seconds.
+func (m *matroskaReader) seconds(ticks float64) float64 {
+	return ticks * float64(m.timestampScale) / 1e9
+}
+
+// nextFrame returns the next frame of the selected track. The discard padding
+// of a block, in nanoseconds, is returned with its last frame.
+func (m *matroskaReader) nextFrame() ([]byte, int64, error) {
+	for len(m.frames) == 0 {
+		if err := m.readBlock(); err != nil {
+			return nil, 0, err
+		}
+	}
+	frame := m.frames[0]
+	m.frames = m.frames[1:]
+	if len(m.frames) > 0 {
+		return frame, 0, nil
+	}
+	return frame, m.discard, nil
+}
+
+// nextPacket implements packetReader. Matroska has no granule positions.
+func (m *matroskaReader) nextPacket() (oggPacket, error) {
+	frame, _, err := m.nextFrame()
+	return oggPacket{data: frame, granule: -1}, err
+}
+
+// readBlock reads elements up to the next block of the selected track. A file
+// cut off mid-element, as a recording that was interrupted can be, ends at
+// the last complete block.
+func (m *matroskaReader) readBlock() error {
+	for {
+		id, size, err := m.readHeader()
+		if err != nil {
+			return matroskaEOF(err)
+		}
+		switch id {
+		case mkvSegmentID:
+		case mkvClusterID:
+			m.clusterTime = 0
+		case mkvTimestampID:
+			body, err := m.readBody(size)
+			if err != nil {
+				return matroskaEOF(err)
+			}
+			m.clusterTime = int64(ebmlUint(body))
+		case mkvSimpleBlockID:
+			body, err := m.readBody(size)
+			if err != nil {
+				return matroskaEOF(err)
+			}
+			if ok, err := m.parseBlock(body, 0); ok || err != nil {
+				return err
+			}
+		case mkvBlockGroupID:
+			body, err := m.readBody(size)
+			if err != nil {
+				return matroskaEOF(err)
+			}
+			var block []byte
+			var discard int64
+			err = eachElement(body, func(id uint32, body []byte) error {
+				switch id {
+				case mkvBlockID:
+					block = body
+				case mkvDiscardPaddingID:
+					discard = ebmlInt(body)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if block == nil {
+				continue
+			}
+			if ok, err := m.parseBlock(block, discard); ok || err != nil {
+				return err
+			}
+		case ebmlHeaderID:
+			// Only the first of chained segments is read
+			return io.EOF
+		default:
+			if err := m.skip(size); err != nil {
+				return matroskaEOF(err)
+			}
+		}
+	}
+}
+
+// matroskaEOF treats running out of data as the end of the file.
+func matroskaEOF(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return io.EOF
+	}
+	return err
+}
+
+// parseBlock splits a block of the selected track into its frames, reporting
+// whether it belonged to that track.
+func (m *matroskaReader) parseBlock(block []byte, discard int64) (bool, error) {
+	track, n, ok := ebmlVint(block)
+	if !ok || len(block) < n+3 {
+		return false, fmt.Errorf("invalid Matroska block")
+	}
+	if track != m.track {
+		return false, nil
+	}
+	frames, err := matroskaLacing(block[n+2]>>1&3, block[n+3:])
+	if err != nil {
+		return false, err
+	}
+	m.blockTime = m.clusterTime + int64(int16(binary.BigEndian.Uint16(block[n:])))
+	m.frames = frames
+	m.discard = discard
+	return true, nil
+}
+
+// matroskaLacing splits the frames of a block with the given lacing: none,
+// Xiph, fixed-size or EBML.
+func matroskaLacing(lacing byte, data []byte) ([][]byte, error) {
+	if lacing == 0 {
+		return [][]byte{data}, nil
+	}
+	if len(data) < 1 {
+		return nil, fmt.Errorf("invalid Matroska block lacing")
+	}
+	count := int(data[0]) + 1
+	data = data[1:]
+	sizes := make([]int, count)
+
+	switch lacing {
+	case 1: // Xiph: each size as a run of 255s and a final byte
+		for i := 0; i < count-1; i++ {
+			for {
+				if len(data) == 0 {
+					return nil, fmt.Errorf("invalid Matroska block lacing")
+				}
+				b := data[0]
+				data = data[1:]
+				sizes[i] += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+	case 2: // Fixed-size
+		if len(data)%count != 0 {
+			return nil, fmt.Errorf("invalid Matroska block lacing")
+		}
+		for i := range sizes {
+			sizes[i] = len(data) / count
+		}
+	case 3: // EBML: the first size, then signed differences
+		for i := 0; i < count-1; i++ {
+			v, n, ok := ebmlVint(data)
+			if !ok {
+				return nil, fmt.Errorf("invalid Matroska block lacing")
+			}
+			data = data[n:]
+			if i == 0 {
+				sizes[i] = int(v)
+			} else {
+				sizes[i] = sizes[i-1] + int(int64(v)-(int64(1)<<uint(7*n-1)-1))
+			}
+		}
+	}
+
+	if lacing != 2 {
+		total := 0
+		for _, size := range sizes[:count-1] {
+			if size < 0 {
+				return nil, fmt.Errorf("invalid Matroska block lacing")
+			}
+			total += size
+		}
+		if total > len(data) {
+			return nil, fmt.Errorf("invalid Matroska block lacing")
+		}
+		sizes[count-1] = len(data) - total
+	}
+
+	frames := make([][]byte, count)
+	for i, size := range sizes {
+		frames[i] = data[:size]
+		data = data[size:]
+	}
+	return frames, nil
+}
+
+// readHeader reads an element's ID and size. The size is -1 if unknown.
+func (m *matroskaReader) readHeader() (uint32, int64, error) {
+	first, err := m.r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	length := ebmlLength(first)
+	if length == 0 || length > 4 {
+		return 0, 0, fmt.Errorf("invalid Matroska element ID")
+	}
+	id := uint32(first)
+	for i := 1; i < length; i++ {
+		b, err := m.r.ReadByte()
+		if err != nil {
+			return 0, 0, matroskaEOF(err)
+		}
+		id = id<<8 | uint32(b)
+	}
+
+	first, err = m.r.ReadByte()
+	if err != nil {
+		return 0, 0, matroskaEOF(err)
+	}
+	length = ebmlLength(first)
+	if length == 0 {
+		return 0, 0, fmt.Errorf("invalid Matroska element size")
+	}
+	size := uint64(first) & (0xFF >> uint(length))
+	unknown := size == 0xFF>>uint(length)
+	for i := 1; i < length; i++ {
+		b, err := m.r.ReadByte()
+		if err != nil {
+			return 0, 0, matroskaEOF(err)
+		}
+		size = size<<8 | uint64(b)
+		unknown = unknown && b == 0xFF
+	}
+	if unknown {
+		return id, -1, nil
+	}
+	if size > math.MaxInt64 {
+		return 0, 0, fmt.Errorf("invalid Matroska element size")
+	}
+	return id, int64(size), nil
+}
+
+// readBody reads an element's data into memory.
+func (m *matroskaReader) readBody(size int64) ([]byte, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("unexpected Matroska element of unknown size")
+	}
+	if size > matroskaMaxElement {
+		return nil, fmt.Errorf("Matroska element too large: %d bytes", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(m.r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// skip passes over an element's data.
+func (m *matroskaReader) skip(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("unexpected Matroska element of unknown size")
+	}
+	_, err := m.r.Discard(int(min(size, math.MaxInt32)))
+	for size -= math.MaxInt32; err == nil && size > 0; size -= math.MaxInt32 {
+		_, err = m.r.Discard(int(min(size, math.MaxInt32)))
+	}
+	return err
+}
+
+// matroskaDuration scans the blocks of track for the end of the last one, in
+// seconds, adding the length of its frames when frameLength is set and
+// removing any discard padding. Without frameLength the start of the last
+// block is the best estimate.
+func matroskaDuration(r io.Reader, track uint64, frameLength func([]byte) float64) float64 {
+	m, err := newMatroskaReader(r)
+	if err != nil {
+		return 0
+	}
+	m.track = track
+
+	var end float64 // In seconds
+	for {
+		if err := m.readBlock(); err != nil {
+			return end
+		}
+		var length float64
+		if frameLength != nil {
+			for _, frame := range m.frames {
+				length += frameLength(frame)
+			}
+		}
+		end = max(end, m.seconds(float64(m.blockTime))+length-float64(m.discard)/1e9)
+		m.frames = nil
+	}
+}
+
+// matroskaOpusPackets presents the frames of a Matroska Opus track as packets
+// for newOpusStream, turning discard padding at the end of the stream into
+// the end position an Ogg granule would give.
+type matroskaOpusPackets struct {
+	m       *matroskaReader
+	decoded int64 // Samples in the frames so far, including the pre-skip
+}
+
+func (p *matroskaOpusPackets) nextPacket() (oggPacket, error) {
+	frame, discard, err := p.m.nextFrame()
+	if err != nil {
+		return oggPacket{}, err
+	}
+	p.decoded += int64(opusPacketSamples(frame))
+	packet := oggPacket{data: frame, granule: -1}
+	if discard > 0 {
+		packet.last = true
+		packet.granule = p.decoded - (discard*OpusSampleRate+5e8)/1e9
+	}
+	return packet, nil
+}
+
+// splitXiphHeaders splits the Vorbis headers packed into a CodecPrivate
+// element: the number of packets less one, the Xiph-laced sizes of all but the
+// last, then the packets.
+func splitXiphHeaders(data []byte) ([][]byte, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("missing Vorbis headers")
+	}
+	headers, err := matroskaLacing(1, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Vorbis headers: %v", err)
+	}
+	return headers, nil
+}
+
+// matroskaAACConfig returns the decoder configuration of an AAC track, from
+// its AudioSpecificConfig or, for the old codec IDs that name the profile,
+// from the track's sampling frequency and channels.
+func matroskaAACConfig(track matroskaTrack) (aacConfig, error) {
+	if len(track.private) > 0 {
+		return parseAudioSpecificConfig(track.private)
+	}
+	rate := int(track.sampleRate)
+	config := aacConfig{objectType: aacObjectLC, channels: track.channels}
+	switch {
+	case strings.HasSuffix(track.codec, "/LC"):
+	case strings.HasSuffix(track.codec, "/SBR"):
+		rate /= 2
+		config.sbr = true
+	default:
+		return aacConfig{}, fmt.Errorf("unsupported Matroska AAC codec %q", track.codec)
+	}
+	if rate <= 0 {
+		return aacConfig{}, fmt.Errorf("missing sampling frequency for Matroska AAC track")
+	}
+	config.sampleRate = rate
+	config.sampleRateIndex = aacRateIndex(rate)
+	return config, nil
+}
+
+// eachElement calls fn with the ID and data of each element in data.
+func eachElement(data []byte, fn func(id uint32, body []byte) error) error {
+	for len(data) > 0 {
+		length := ebmlLength(data[0])
+		if length == 0 || length > 4 || len(data) < length {
+			return fmt.Errorf("invalid Matroska element ID")
+		}
+		var id uint32
+		for _, b := range data[:length] {
+			id = id<<8 | uint32(b)
+		}
+		data = data[length:]
+
+		size, n, ok := ebmlVint(data)
+		if !ok || size > uint64(len(data)-n) {
+			return fmt.Errorf("invalid Matroska element size")
+		}
+		if err := fn(id, data[n:n+int(size)]); err != nil {
+			return err
+		}
+		data = data[n+int(size):]
+	}
+	return nil
+}
+
+// ebmlLength returns the length of a variable-size integer from its first
+// byte, given by the position of the first set bit, or 0 if it is invalid.
+func ebmlLength(first byte) int {
+	for i := 0; i < 8; i++ {
+		if first&(0x80>>uint(i)) != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// ebmlVint decodes the variable-size integer at the start of data, without
+// its length marker, and returns its length.
+func ebmlVint(data []byte) (uint64, int, bool) {
+	if len(data) == 0 {
+		return 0, 0, false
+	}
+	length := ebmlLength(data[0])
+	if length == 0 || len(data) < length {
+		return 0, 0, false
+	}
+	v := uint64(data[0]) & (0xFF >> uint(length))
+	for _, b := range data[1:length] {
+		v = v<<8 | uint64(b)
+	}
+	return v, length, true
+}
+
+// ebmlUint decodes an unsigned integer element.
+func ebmlUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// ebmlInt decodes a signed integer element.
+func ebmlInt(data []byte) int64 {
+	if len(data) == 0 {
+		return 0
+	}
+	v := int64(int8(data[0]))
+	for _, b := range data[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+// ebmlFloat decodes a 4 or 8-byte float element.
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ebml builds an EBML element from its ID and payload parts.
+func ebml(id uint32, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if v := byte(id >> uint(shift)); v != 0 || len(b) > 0 {
+			b = append(b, v)
+		}
+	}
+	// Sizes are written in 8 bytes, as muxers that patch them in later do
+	b = append(b, 0x01)
+	size := binary.BigEndian.AppendUint64(nil, uint64(len(body)))
+	return append(append(b, size[1:]...), body...)
+}
+
+// ebmlUnknown builds the header of an element of unknown size, whose
+// children follow it.
+func ebmlUnknown(id uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, id)
+	return append(b, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+}
+
+// ebmlUints encodes v as an unsigned integer element payload.
+func ebmlUints(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+// simpleBlock builds a SimpleBlock for track 1 at time with the given lacing.
+func simpleBlock(time int16, lacing byte, data ...[]byte) []byte {
+	block := []byte{0x81, byte(uint16(time) >> 8), byte(time), 0x80 | lacing<<1}
+	return ebml(mkvSimpleBlockID, block, bytes.Join(data, nil))
+}
+
+// matroskaOptions describes the file buildMatroska produces.
+type matroskaOptions struct {
+	docType  string
+	codec    string
+	private  []byte
+	duration float64    // In milliseconds; no Duration element if zero
+	live     bool       // Unknown-size segment and clusters, as recorders write
+	clusters [][][]byte // Blocks of each cluster, which start 1s apart
+}
+
+// buildMatroska builds a file with a video track and then an audio track
+// numbered 1.
+func buildMatroska(o matroskaOptions) []byte {
+	header := ebml(ebmlHeaderID, ebml(0x4286, []byte{1}), ebml(ebmlDocTypeID, []byte(o.docType)))
+
+	info := [][]byte{ebml(mkvTimestampScaleID, ebmlUints(1000000))}
+	if o.duration > 0 {
+		info = append(info, ebml(mkvDurationID, binary.BigEndian.AppendUint64(nil, math.Float64bits(o.duration))))
+	}
+	video := ebml(mkvTrackEntryID, ebml(mkvTrackNumberID, []byte{2}), ebml(mkvTrackTypeID, []byte{1}),
+		ebml(mkvCodecID, []byte("V_VP8")))
+	audio := ebml(mkvTrackEntryID, ebml(mkvTrackNumberID, []byte{1}), ebml(mkvTrackTypeID, []byte{mkvAudioTrack}),
+		ebml(mkvCodecID, []byte(o.codec)), ebml(mkvCodecPrivateID, o.private),
+		ebml(mkvAudioID, ebml(mkvSamplingFrequencyID, binary.BigEndian.AppendUint64(nil, math.Float64bits(48000))),
+			ebml(mkvChannelsID, []byte{1})))
+	children := [][]byte{ebml(mkvInfoID, info...), ebml(mkvTracksID, video, audio)}
+
+	for i, blocks := range o.clusters {
+		time := ebml(mkvTimestampID, ebmlUints(uint64(1000*i)))
+		if o.live {
+			children = append(children, ebmlUnknown(mkvClusterID), time, bytes.Join(blocks, nil))
+		} else {
+			children = append(children, ebml(mkvClusterID, time, bytes.Join(blocks, nil)))
+		}
+	}
+
+	if o.live {
+		return bytes.Join([][]byte{header, ebmlUnknown(mkvSegmentID), bytes.Join(children, nil)}, nil)
+	}
+	return append(header, ebml(mkvSegmentID, children...)...)
+}
+
+func TestMatroskaLacing(t *testing.T) {
+	frames := [][]byte{bytes.Repeat([]byte{1}, 300), {2, 2}, bytes.Repeat([]byte{3}, 4)}
+	tests := []struct {
+		name   string
+		lacing byte
+		data   []byte
+		want   [][]byte
+	}{
+		{"none", 0, []byte{1, 2, 3}, [][]byte{{1, 2, 3}}},
+		{"xiph", 1, append([]byte{2, 255, 45, 2}, bytes.Join(frames, nil)...), frames},
+		{"fixed", 2, []byte{2, 1, 1, 2, 2, 3, 3}, [][]byte{{1, 1}, {2, 2}, {3, 3}}},
+		// 300, then 2 as a difference of -298 in two bytes
+		{"ebml", 3, append([]byte{2, 0x41, 0x2C, 0x5E, 0xD5}, bytes.Join(frames, nil)...), frames},
+	}
+	for _, tt := range tests {
+		got, err := matroskaLacing(tt.lacing, tt.data)
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, tt.want, got, tt.name)
+	}
+
+	invalid := []struct {
+		name   string
+		lacing byte
+		data   []byte
+	}{
+		{"xiph overrun", 1, []byte{1, 10, 0}},
+		{"fixed uneven", 2, []byte{1, 1, 2, 3}},
+		{"ebml negative", 3, []byte{2, 0x81, 0x5F, 0}},
+		{"empty", 1, nil},
+	}
+	for _, tt := range invalid {
+		_, err := matroskaLacing(tt.lacing, tt.data)
+		assert.Error(t, err, tt.name)
+	}
+}
+
+func TestMatroskaFormat_DecodeAAC(t *testing.T) {
+	// AAC-LC at 48 kHz, mono: 1024 samples a frame
+	var blocks [][]byte
+	for i := 0; i < 4; i++ {
+		blocks = append(blocks, simpleBlock(int16(i*1024*1000/48000), 0, silentBlock(false)))
+	}
+	// Two frames laced into one block, and one for another track
+	blocks = append(blocks, simpleBlock(90, 1, []byte{1, byte(len(silentBlock(false)))}, silentBlock(false), silentBlock(false)))
+	blocks = append(blocks, ebml(mkvSimpleBlockID, []byte{0x82, 0, 0, 0x80}, []byte("video")))
+
+	for _, live := range []bool{false, true} {
+		data := buildMatroska(matroskaOptions{docType: "webm", codec: "A_AAC", private: []byte{0x11, 0x88},
+			live: live, clusters: [][][]byte{blocks, blocks[:2]}})
+
+		stream, metadata, err := (&MatroskaFormat{}).Decode(bytes.NewReader(data))
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "WEBM", metadata.Format)
+		assert.Equal(t, "AAC-LC", metadata.Codec)
+		assert.Equal(t, 48000, metadata.SampleRate)
+		assert.Equal(t, 1, metadata.Channels)
+		// Without a Duration element, the end of the last block
+		assert.InDelta(t, 1+float64(2*1024)/48000, metadata.Duration, 1e-3)
+
+		samples, err := ReadAll(stream)
+		assert.NoError(t, err)
+		assert.Len(t, samples, 8*1024, "live=%v", live)
+	}
+
+	// Audio timed before the segment starts is dropped
+	data := buildMatroska(matroskaOptions{docType: "webm", codec: "A_AAC", private: []byte{0x11, 0x88},
+		clusters: [][][]byte{{simpleBlock(-10, 0, silentBlock(false)), simpleBlock(11, 0, silentBlock(false))}}})
+	stream, _, err := (&MatroskaFormat{}).Decode(bytes.NewReader(data))
+	if assert.NoError(t, err) {
+		samples, err := ReadAll(stream)
+		assert.NoError(t, err)
+		assert.Len(t, samples, 2*1024-480)
+	}
+}
+
+func TestMatroskaFormat_OpusDiscardPadding(t *testing.T) {
+	head := opusHeadPacket(1, 312, 0, 0, 0, 0)
+	packet := []byte{0x48, 0} // 20ms SILK
+	group := ebml(mkvBlockGroupID, ebml(mkvBlockID, []byte{0x81, 0, 40, 0}, packet),
+		ebml(mkvDiscardPaddingID, ebmlUints(uint64(500*1000000000/OpusSampleRate))))
+	data := buildMatroska(matroskaOptions{docType: "webm", codec: "A_OPUS", private: head, live: true,
+		clusters: [][][]byte{{simpleBlock(0, 0, packet), simpleBlock(20, 0, packet), group}}})
+
+	m, err := newMatroskaReader(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+	track, err := m.audioTrack()
+	assert.NoError(t, err)
+	opusHead, err := parseOpusHead(track.private)
+	assert.NoError(t, err)
+
+	samples, err := ReadAll(newOpusStream(&matroskaOpusPackets{m: m}, opusHead, &countingDecoder{}))
+	assert.NoError(t, err)
+	// 2880 decoded, less 312 of pre-skip and 500 of padding
+	if assert.Len(t, samples, 2880-312-500) {
+		assert.Equal(t, float32(313), samples[0])
+		assert.Equal(t, float32(2380), samples[len(samples)-1])
+	}
+
+	// The scanned duration accounts for both
+	_, metadata, err := (&MatroskaFormat{}).Decode(bytes.NewReader(data))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Opus", metadata.Codec)
+		assert.InDelta(t, float64(2880-312-500)/OpusSampleRate, metadata.Duration, 1e-6)
+	}
+}
+
+func TestMatroskaFormat_Metadata(t *testing.T) {
+	data := buildMatroska(matroskaOptions{docType: "matroska", codec: "A_AAC", private: []byte{0x11, 0x88},
+		duration: 2500, clusters: [][][]byte{{simpleBlock(0, 0, silentBlock(false))}}})
+
+	// The Duration element is used as is, with or without seeking
+	for _, r := range []io.Reader{bytes.NewReader(data), io.MultiReader(bytes.NewReader(data))} {
+		_, metadata, err := (&MatroskaFormat{}).Decode(r)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "MATROSKA", metadata.Format)
+			assert.Equal(t, 2.5, metadata.Duration)
+		}
+	}
+
+	// Live recordings read from a stream have no known duration
+	data = buildMatroska(matroskaOptions{docType: "webm", codec: "A_AAC", private: []byte{0x11, 0x88}, live: true,
+		clusters: [][][]byte{{simpleBlock(0, 0, silentBlock(false))}}})
+	_, metadata, err := (&MatroskaFormat{}).Decode(io.MultiReader(bytes.NewReader(data)))
+	if assert.NoError(t, err) {
+		assert.Zero(t, metadata.Duration)
+	}
+
+	// The old codec IDs name the profile instead of carrying a config
+	config, err := matroskaAACConfig(matroskaTrack{codec: "A_AAC/MPEG4/LC", sampleRate: 44100, channels: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, aacConfig{objectType: aacObjectLC, sampleRateIndex: 4, sampleRate: 44100, channels: 2}, config)
+}
+
+func TestMatroskaFormat_Errors(t *testing.T) {
+	tests := map[string][]byte{
+		"unsupported codec": buildMatroska(matroskaOptions{docType: "matroska", codec: "A_FLAC", clusters: [][][]byte{nil}}),
+		"not matroska":      []byte("RIFF\x00\x00\x00\x00WAVE"),
+		"no tracks":         append(ebml(ebmlHeaderID), ebml(mkvSegmentID, ebml(mkvClusterID))...),
+		"video only": append(ebml(ebmlHeaderID), ebml(mkvSegmentID, ebml(mkvTracksID,
+			ebml(mkvTrackEntryID, ebml(mkvTrackNumberID, []byte{1}), ebml(mkvTrackTypeID, []byte{1}))), ebml(mkvClusterID))...),
+	}
+	for name, data := range tests {
+		_, _, err := (&MatroskaFormat{}).Decode(bytes.NewReader(data))
+		assert.Error(t, err, name)
+	}
+	_, _, err := (&MatroskaFormat{}).Decode(bytes.NewReader(tests["unsupported codec"]))
+	assert.ErrorContains(t, err, `"A_FLAC"`)
+}
@@ -4,9 +4,11 @@
 package audio
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
 
 	"github.com/amanitaverna/go-mp3"
 )
@@ -14,81 +16,346 @@ import (
 // MP3Format implements the Format interface for MP3 audio files.
 type MP3Format struct{}
 
+func init() {
+	Register(Registration{
+		Name:       "mp3",
+		Container:  ContainerMPEG,
+		Codec:      "MP3",
+		MIMETypes:  []string{"audio/mpeg", "audio/mp3"},
+		Extensions: []string{".mp3"},
+		Sniff:      func(h []byte) bool { return mpegLayer(h) == 3 },
+		New:        func() Format { return &MP3Format{} },
+		Decodable:  true,
+	})
+}
+
 // GetMetadata extracts metadata from an MP3 file.
 func (f *MP3Format) GetMetadata(filename string, fileSize int64) (AudioMetadata, error) {
-	file, err := os.Open(filename)
+	return streamMetadata(f, filename, fileSize)
+}
+
+// ConvertToSamples converts an MP3 file to a slice of float32 samples.
+func (f *MP3Format) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
+	return streamSamples(f, filename, targetSampleRate)
+}
+
+// Decode streams MP3 frames from r, mixed to mono.
+func (f *MP3Format) Decode(r io.Reader) (SampleStream, AudioMetadata, error) {
+	return downmixed(f.DecodeChannels(r))
+}
+
+// DecodeChannels streams MP3 frames from r with the file's channels
+// interleaved. The duration comes from the Xing, Info or VBRI header if the
+// encoder wrote one, and otherwise from counting the frames when r is
+// seekable. The silence the header frame decodes to is skipped, as are the
+// encoder delay and padding a LAME extension records.
+func (f *MP3Format) DecodeChannels(r io.Reader) (SampleStream, AudioMetadata, error) {
+	info, r, err := readMP3Info(r)
 	if err != nil {
-		return AudioMetadata{}, err
+		return nil, AudioMetadata{}, err
 	}
-	defer file.Close()
-
-	decoder, err := mp3.NewDecoder(file)
+	decoder, err := mp3.NewDecoder(r)
 	if err != nil {
-		return AudioMetadata{}, fmt.Errorf("failed to create MP3 decoder: %v", err)
+		return nil, AudioMetadata{}, fmt.Errorf("failed to create MP3 decoder: %v", err)
 	}
 
+	// The decoder always produces 16-bit stereo
+	const frameSize = 4
 	sampleRate := decoder.SampleRate()
-	numChannels := 2 // Assuming stereo for MP3
-
-	// Calculate duration
-	duration := float64(decoder.Length()) / float64(sampleRate*numChannels*4)
-
-	return AudioMetadata{
-		Format:       "MP3",
-		Codec:        "MP3",
-		SampleRate:   sampleRate,
-		Channels:     numChannels,
-		BitDepth:     16, // Assuming 16-bit for MP3
-		Duration:     duration,
-		OriginalSize: fileSize,
-		Bitrate:      int(float64(fileSize*8) / duration / 1000),
-	}, nil
+
+	metadata := AudioMetadata{
+		Format:     "MP3",
+		Codec:      "MP3",
+		SampleRate: sampleRate,
+		Channels:   info.channels,
+		BitDepth:   16, // The decoder's output precision
+		Bitrate:    info.bitrate,
+	}
+	if info.samples > 0 {
+		metadata.Duration = float64(info.samples) / float64(info.sampleRate)
+	}
+
+	buffer := make([]byte, streamChunk*frameSize)
+	var pending int   // Bytes of a partial frame left at the start of buffer
+	var decoded int64 // Frames decoded, including those skipped
+	stream := &blockStream{
+		next: func() ([]float32, error) {
+			n, err := decoder.Read(buffer[pending:])
+			n += pending
+			frames := n / frameSize
+
+			// Only the frames between skip and skip+keep are audio
+			from := int(min(max(info.skip-decoded, 0), int64(frames)))
+			to := frames
+			if info.keep >= 0 {
+				to = int(min(max(info.skip+info.keep-decoded, int64(from)), int64(frames)))
+			}
+			decoded += int64(frames)
+
+			// Mono files are decoded to two identical channels
+			block := make([]float32, (to-from)*info.channels)
+			for i := range block {
+				block[i] = float32(int16(binary.LittleEndian.Uint16(buffer[(from+i/info.channels)*frameSize+i%info.channels*2:]))) / 32768.0
+			}
+			pending = copy(buffer, buffer[frames*frameSize:n])
+			if info.keep >= 0 && decoded >= info.skip+info.keep && err == nil {
+				err = io.EOF
+			}
+
+			if err != nil && err != io.EOF {
+				return block, fmt.Errorf("failed to read MP3 data: %v", err)
+			}
+			return block, err
+		},
+	}
+	return stream, metadata, nil
 }
 
-// ConvertToSamples converts an MP3 file to a slice of float32 samples.
-func (f *MP3Format) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+// mp3SearchLen is how far into a stream the first frame is looked for.
+const mp3SearchLen = 8192
+
+// mp3Frame is the header of an MPEG-1, MPEG-2 or MPEG-2.5 Layer III frame.
+type mp3Frame struct {
+	sampleRate int
+	channels   int
+	bitrate    int // In kbps
+	size       int // Length of the frame in bytes, including the header
+	samples    int // Samples per channel
+	sideInfo   int // Length of the side information after the header
+}
+
+// Layer III bitrates in kbps by bitrate index, for MPEG-1 and for MPEG-2 and 2.5
+var mp3Bitrates = [2][15]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// Sample rates by version bits (2.5, reserved, 2, 1) and sampling frequency index
+var mp3SampleRates = [4][3]int{
+	{11025, 12000, 8000},
+	{},
+	{22050, 24000, 16000},
+	{44100, 48000, 32000},
+}
+
+// parseMP3Frame parses a Layer III frame header at the start of h. Free-format
+// frames, whose length is not recorded, are not accepted.
+func parseMP3Frame(h []byte) (mp3Frame, bool) {
+	if len(h) < 4 || h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	version := (h[1] >> 3) & 0x03
+	layer := (h[1] >> 1) & 0x03
+	bitrateIndex := h[2] >> 4
+	rateIndex := (h[2] >> 2) & 0x03
+	if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 0x0F || rateIndex == 0x03 {
+		return mp3Frame{}, false
 	}
-	defer file.Close()
+	padding := int(h[2]>>1) & 1
 
-	decoder, err := mp3.NewDecoder(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create MP3 decoder: %v", err)
+	f := mp3Frame{sampleRate: mp3SampleRates[version][rateIndex], channels: 2}
+	mono := h[3]>>6 == 3
+	if mono {
+		f.channels = 1
 	}
+	if version == 3 {
+		f.bitrate = mp3Bitrates[0][bitrateIndex]
+		f.size = 144000*f.bitrate/f.sampleRate + padding
+		f.samples = 1152
+		f.sideInfo = 32
+		if mono {
+			f.sideInfo = 17
+		}
+	} else {
+		f.bitrate = mp3Bitrates[1][bitrateIndex]
+		f.size = 72000*f.bitrate/f.sampleRate + padding
+		f.samples = 576
+		f.sideInfo = 17
+		if mono {
+			f.sideInfo = 9
+		}
+	}
+	return f, true
+}
 
-	// Read all samples
-	var samples []float32
-	buffer := make([]byte, 4096) // Adjust buffer size as needed
-	for {
-		n, err := decoder.Read(buffer)
-		if err == io.EOF {
-			break
+// findMP3Frame returns the offset of the first frame in buf. A header is only
+// accepted if the frame after it, when within buf, starts with a matching
+// header, so stray sync words in tags or junk are passed over.
+func findMP3Frame(buf []byte) (int, mp3Frame, bool) {
+	for i := 0; i+4 <= len(buf); i++ {
+		f, ok := parseMP3Frame(buf[i:])
+		if !ok {
+			continue
+		}
+		next := i + f.size
+		if next+4 > len(buf) {
+			return i, f, true
 		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read MP3 data: %v", err)
+		if g, ok := parseMP3Frame(buf[next:]); ok && g.sampleRate == f.sampleRate {
+			return i, f, true
 		}
+	}
+	return 0, mp3Frame{}, false
+}
+
+// mp3Tag is the information encoders write in place of the audio of the
+// first frame.
+type mp3Tag struct {
+	frames  int64 // Audio frames after the tag, or -1 if not recorded
+	lame    bool  // Whether delay and padding were recorded
+	delay   int   // Encoder delay in samples, from a LAME extension
+	padding int   // Samples added to fill the last frame
+}
+
+// mp3DecoderDelay is the delay, in samples, that decoding adds before the
+// encoder's delay.
+const mp3DecoderDelay = 529
+
+// parseMP3Tag parses a Xing, Info or VBRI header in frame, which starts with
+// a header described by f. It returns false if there is none.
+func parseMP3Tag(frame []byte, f mp3Frame) (mp3Tag, bool) {
+	tag := mp3Tag{frames: -1}
+
+	// VBRI always follows 32 bytes of side information
+	if len(frame) >= 36+18 && bytes.Equal(frame[36:40], []byte("VBRI")) {
+		tag.frames = int64(binary.BigEndian.Uint32(frame[36+14:]))
+		return tag, true
+	}
+
+	p := 4 + f.sideInfo
+	if len(frame) < p+8 || (!bytes.Equal(frame[p:p+4], []byte("Xing")) && !bytes.Equal(frame[p:p+4], []byte("Info"))) {
+		return mp3Tag{}, false
+	}
+	flags := binary.BigEndian.Uint32(frame[p+4:])
+	p += 8
+	if flags&0x01 != 0 && len(frame) >= p+4 {
+		tag.frames = int64(binary.BigEndian.Uint32(frame[p:]))
+		p += 4
+	}
+	if flags&0x02 != 0 {
+		p += 4 // Stream size in bytes
+	}
+	if flags&0x04 != 0 {
+		p += 100 // Seek table
+	}
+	if flags&0x08 != 0 {
+		p += 4 // VBR quality
+	}
 
-		// Convert bytes to float32 samples
-		for i := 0; i < n; i += 2 {
-			sample := int16(buffer[i]) | int16(buffer[i+1])<<8
-			samples = append(samples, float32(sample)/32768.0)
+	// The LAME extension, also written by FFmpeg, records the delay and
+	// padding in two 12-bit fields after the encoder name and 12 other bytes
+	if len(frame) >= p+24 {
+		encoder := string(frame[p : p+4])
+		if encoder == "LAME" || encoder == "Lavf" || encoder == "Lavc" {
+			tag.lame = true
+			tag.delay = int(frame[p+21])<<4 | int(frame[p+22]>>4)
+			tag.padding = int(frame[p+22]&0x0F)<<8 | int(frame[p+23])
 		}
 	}
+	return tag, true
+}
 
-	sampleRate := decoder.SampleRate()
-	numChannels := 2 // Assuming stereo for MP3
+// mp3Info describes an MP3 stream as found from its frame headers.
+type mp3Info struct {
+	sampleRate int
+	channels   int
+	bitrate    int   // In kbps, for streams without a VBR header
+	samples    int64 // Samples per channel, or 0 if unknown
+	skip       int64 // Samples per channel decoded before the audio starts
+	keep       int64 // Samples per channel of audio after skip, or -1 to keep all
+}
 
-	// Convert to mono if stereo
-	if numChannels > 1 {
-		samples = ConvertToMono(samples, numChannels)
+// readMP3Info reads the first frame of the MP3 stream in r and the tag it may
+// hold, counting the frames if there is no tag and r can seek. It returns the
+// reader to decode the stream from, positioned where r was.
+func readMP3Info(r io.Reader) (mp3Info, io.Reader, error) {
+	rs, seekable := r.(io.ReadSeeker)
+	if !seekable {
+		br := bufio.NewReaderSize(r, mp3SearchLen)
+		info, err := scanMP3(br, false)
+		return info, br, err
 	}
 
-	// Resample if needed
-	if sampleRate != targetSampleRate {
-		samples = ResampleAudio(samples, sampleRate, targetSampleRate)
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return mp3Info{}, nil, err
+	}
+	info, err := scanMP3(bufio.NewReaderSize(rs, mp3SearchLen), true)
+	if _, serr := rs.Seek(pos, io.SeekStart); serr != nil {
+		return mp3Info{}, nil, serr
+	}
+	return info, rs, err
+}
+
+// scanMP3 finds the first frame in br. If count is set, br is consumed to
+// count the frames when there is no tag recording them; otherwise br is only
+// peeked at, so the stream can still be decoded from it.
+func scanMP3(br *bufio.Reader, count bool) (mp3Info, error) {
+	head, _ := br.Peek(mp3SearchLen)
+	start := int(id3Size(head))
+	if start > len(head) {
+		if !count {
+			return mp3Info{}, fmt.Errorf("no MPEG audio frame found")
+		}
+		// Tags too large to peek past are skipped
+		if _, err := br.Discard(start); err != nil {
+			return mp3Info{}, fmt.Errorf("no MPEG audio frame found")
+		}
+		head, _ = br.Peek(mp3SearchLen)
+		start = 0
+	}
+
+	offset, first, ok := findMP3Frame(head[start:])
+	if !ok {
+		return mp3Info{}, fmt.Errorf("no MPEG audio frame found")
+	}
+	info := mp3Info{sampleRate: first.sampleRate, channels: first.channels, keep: -1}
+	frames := int64(-1)
+	tag, tagged := parseMP3Tag(head[start+offset:], first)
+	if tagged {
+		frames = tag.frames
+	} else {
+		info.bitrate = first.bitrate
 	}
 
-	return samples, nil
+	if frames < 0 && count {
+		if _, err := br.Discard(start + offset); err != nil {
+			return info, nil
+		}
+		frames = 0
+		for {
+			header, err := br.Peek(4)
+			if err != nil {
+				break
+			}
+			f, ok := parseMP3Frame(header)
+			if !ok || f.sampleRate != first.sampleRate {
+				// ID3v1 or APE tags, or the end of the audio
+				break
+			}
+			if _, err := br.Discard(f.size); err != nil {
+				// A truncated final frame is not decoded
+				break
+			}
+			frames++
+		}
+		if tagged {
+			// The tag frame holds no audio
+			frames--
+		}
+	}
+	if frames > 0 {
+		info.samples = max(frames*int64(first.samples)-int64(tag.delay+tag.padding), 0)
+	}
+	if tagged {
+		// The decoder turns the tag frame into silence
+		info.skip = int64(first.samples)
+		if tag.lame {
+			info.skip += int64(tag.delay + mp3DecoderDelay)
+		}
+		if frames > 0 {
+			info.keep = info.samples
+		}
+	}
+	return info, nil
 }
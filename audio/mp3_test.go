@@ -4,10 +4,14 @@
 package audio
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/amanitaverna/go-mp3"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -43,3 +47,153 @@ func TestMP3Format_ConvertToSamples(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, samples)
 }
+
+func TestMP3Format_TrimsEncoderDelayAndPadding(t *testing.T) {
+	// 32 frames of 32 kHz stereo from LAME, with a delay of 576 samples and
+	// 1297 of padding
+	file, err := os.Open(filepath.Join("..", "test_fixtures", "test-lame.mp3"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer file.Close()
+
+	stream, metadata, err := (&MP3Format{}).DecodeChannels(file)
+	if !assert.NoError(t, err) {
+		return
+	}
+	samples, err := ReadAll(stream)
+	assert.NoError(t, err)
+	want := 32*1152 - 576 - 1297
+	assert.Equal(t, 2, metadata.Channels)
+	assert.InDelta(t, float64(want)/32000, metadata.Duration, 1e-9)
+	assert.Len(t, samples, want*2)
+
+	// The samples kept are those after the silent tag frame and the encoder
+	// and decoder delays
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	decoder, err := mp3.NewDecoder(file)
+	if !assert.NoError(t, err) {
+		return
+	}
+	raw, err := io.ReadAll(decoder)
+	assert.NoError(t, err)
+	assert.Len(t, raw, 33*1152*4)
+	skip := (1152 + 576 + 529) * 4
+	for i := range samples {
+		if !assert.Equal(t, float32(int16(binary.LittleEndian.Uint16(raw[skip+i*2:])))/32768.0, samples[i], "sample %d", i) {
+			break
+		}
+	}
+}
+
+// Layer III frame headers: MPEG-1 128 kbps 44.1 kHz stereo, MPEG-2 64 kbps
+// 22.05 kHz mono and MPEG-2.5 8 kbps 8 kHz stereo
+var (
+	mpeg1Stereo = []byte{0xFF, 0xFB, 0x90, 0x00}
+	mpeg2Mono   = []byte{0xFF, 0xF3, 0x80, 0xC0}
+	mpeg25      = []byte{0xFF, 0xE3, 0x18, 0x00}
+)
+
+// mp3Frames builds n frames of silence with the given header.
+func mp3Frames(header []byte, n int) []byte {
+	f, _ := parseMP3Frame(header)
+	frame := make([]byte, f.size)
+	copy(frame, header)
+	return bytes.Repeat(frame, n)
+}
+
+// mp3TagFrame builds a frame with header holding tag after the side
+// information, or at the fixed offset of a VBRI tag.
+func mp3TagFrame(header []byte, tag []byte) []byte {
+	frame := mp3Frames(header, 1)
+	f, _ := parseMP3Frame(header)
+	if bytes.HasPrefix(tag, []byte("VBRI")) {
+		copy(frame[36:], tag)
+	} else {
+		copy(frame[4+f.sideInfo:], tag)
+	}
+	return frame
+}
+
+// xingTag builds a Xing or Info tag with a LAME extension. A negative frame
+// count is left out.
+func xingTag(id string, frames, delay, padding int) []byte {
+	flags := uint32(0x0E) // Bytes, seek table and quality
+	if frames >= 0 {
+		flags |= 0x01
+	}
+	tag := binary.BigEndian.AppendUint32([]byte(id), flags)
+	if frames >= 0 {
+		tag = binary.BigEndian.AppendUint32(tag, uint32(frames))
+	}
+	tag = append(tag, make([]byte, 4+100+4)...)
+
+	lame := make([]byte, 24)
+	copy(lame, "LAME3.100")
+	lame[21] = byte(delay >> 4)
+	lame[22] = byte(delay<<4 | padding>>8)
+	lame[23] = byte(padding)
+	return append(tag, lame...)
+}
+
+// vbriTag builds a VBRI tag recording frames.
+func vbriTag(frames int) []byte {
+	tag := append([]byte("VBRI"), make([]byte, 10)...)
+	return binary.BigEndian.AppendUint32(tag, uint32(frames))
+}
+
+func TestReadMP3Info(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		seekable   bool
+		sampleRate int
+		channels   int
+		bitrate    int
+		samples    int64
+		skip       int64
+		keep       int64
+	}{
+		{"cbr frames counted", mp3Frames(mpeg1Stereo, 10), true, 44100, 2, 128, 10 * 1152, 0, -1},
+		{"cbr stream", mp3Frames(mpeg1Stereo, 10), false, 44100, 2, 128, 0, 0, -1},
+		{"xing with lame delay and padding",
+			append(mp3TagFrame(mpeg1Stereo, xingTag("Xing", 10, 576, 1000)), mp3Frames(mpeg1Stereo, 10)...),
+			false, 44100, 2, 0, 10*1152 - 576 - 1000, 1152 + 576 + 529, 10*1152 - 576 - 1000},
+		{"info without frame count",
+			append(mp3TagFrame(mpeg1Stereo, xingTag("Info", -1, 576, 24)), mp3Frames(mpeg1Stereo, 4)...),
+			true, 44100, 2, 0, 4*1152 - 576 - 24, 1152 + 576 + 529, 4*1152 - 576 - 24},
+		{"vbri", append(mp3TagFrame(mpeg1Stereo, vbriTag(7)), mp3Frames(mpeg1Stereo, 7)...),
+			false, 44100, 2, 0, 7 * 1152, 1152, 7 * 1152},
+		{"mpeg-2 mono", mp3Frames(mpeg2Mono, 5), true, 22050, 1, 64, 5 * 576, 0, -1},
+		{"mpeg-2 mono xing", append(mp3TagFrame(mpeg2Mono, xingTag("Xing", 5, 0, 0)), mp3Frames(mpeg2Mono, 5)...),
+			false, 22050, 1, 0, 5 * 576, 576 + 529, 5 * 576},
+		{"mpeg-2.5", mp3Frames(mpeg25, 3), true, 8000, 2, 8, 3 * 576, 0, -1},
+		{"tags and junk around the frames",
+			bytes.Join([][]byte{id3Tag(100), {0xFF, 0xFB, 0x90, 0x00, 1, 2, 3}, mp3Frames(mpeg1Stereo, 6), []byte("TAG"), make([]byte, 125)}, nil),
+			true, 44100, 2, 128, 6 * 1152, 0, -1},
+		{"truncated last frame", mp3Frames(mpeg1Stereo, 3)[:3*417-10], true, 44100, 2, 128, 2 * 1152, 0, -1},
+	}
+	for _, tt := range tests {
+		var r io.Reader = bytes.NewReader(tt.data)
+		if !tt.seekable {
+			r = streamOnly{r}
+		}
+		info, r, err := readMP3Info(r)
+		if !assert.NoError(t, err, tt.name) {
+			continue
+		}
+		assert.Equal(t, mp3Info{sampleRate: tt.sampleRate, channels: tt.channels, bitrate: tt.bitrate,
+			samples: tt.samples, skip: tt.skip, keep: tt.keep}, info, tt.name)
+
+		// The stream can still be decoded from the start
+		rest, err := io.ReadAll(r)
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, tt.data, rest, tt.name)
+	}
+
+	for _, data := range [][]byte{nil, make([]byte, 1000), []byte("RIFF\x00\x00\x00\x00WAVE")} {
+		_, _, err := readMP3Info(bytes.NewReader(data))
+		assert.Error(t, err)
+	}
+}
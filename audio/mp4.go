@@ -0,0 +1,455 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// mp4MaxMoov bounds the size of the moov box read into memory, which holds
+// the sample tables and grows by a few bytes per frame.
+const mp4MaxMoov = 64 << 20
+
+// mp4Track is the audio track of an MP4 file: its decoder configuration and
+// where its samples, the AAC access units, are stored.
+type mp4Track struct {
+	codec     string // Sample entry type, e.g. "mp4a"
+	config    []byte // AudioSpecificConfig
+	bitrate   int    // Average bitrate from the esds box, in bits per second
+	timescale uint32 // Media time units per second
+	duration  uint64 // Media duration in timescale units
+
+	sizes   []uint32
+	offsets []int64
+
+	// The first edit selects the part of the media that is presented,
+	// usually leaving out the encoder delay at the start and padding at the
+	// end. Both are in media time units; editDuration is 0 without an edit.
+	editStart    int64
+	editDuration int64
+}
+
+// mp4Source returns r as an io.ReaderAt with its size. Streams are read into
+// memory, since the moov box describing the samples may follow them.
+func mp4Source(r io.Reader) (io.ReaderAt, int64, error) {
+	if ra, ok := r.(io.ReaderAt); ok {
+		if s, ok := r.(io.Seeker); ok {
+			size, err := s.Seek(0, io.SeekEnd)
+			if err == nil {
+				return ra, size, nil
+			}
+		}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+// parseMP4 finds the first audio track in an ISO base media file (ISO/IEC
+// 14496-12). Fragmented files, whose samples are described in moof boxes, are
+// not supported.
+func parseMP4(ra io.ReaderAt, size int64) (*mp4Track, error) {
+	var moov []byte
+	fragmented := false
+	var header [16]byte
+	for offset := int64(0); offset+8 <= size; {
+		n, err := ra.ReadAt(header[:], offset)
+		if n < 8 {
+			return nil, fmt.Errorf("failed to read MP4 box: %v", err)
+		}
+		boxSize, headerSize := int64(binary.BigEndian.Uint32(header[:4])), int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - offset
+		case 1:
+			if n < 16 {
+				return nil, fmt.Errorf("truncated MP4 box header")
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if boxSize < headerSize || boxSize > size-offset {
+			return nil, fmt.Errorf("invalid MP4 box size %d", boxSize)
+		}
+
+		switch string(header[4:8]) {
+		case "moov":
+			if boxSize > mp4MaxMoov {
+				return nil, fmt.Errorf("MP4 moov box too large: %d bytes", boxSize)
+			}
+			moov = make([]byte, boxSize-headerSize)
+			if _, err := ra.ReadAt(moov, offset+headerSize); err != nil {
+				return nil, fmt.Errorf("failed to read MP4 moov box: %v", err)
+			}
+		case "moof":
+			fragmented = true
+		}
+		offset += boxSize
+	}
+	if moov == nil {
+		return nil, fmt.Errorf("no moov box in MP4 file")
+	}
+
+	var movieTimescale uint32
+	var tracks []*mp4Track
+	err := eachBox(moov, func(typ string, body []byte) error {
+		switch typ {
+		case "mvhd":
+			movieTimescale = mp4Timescale(body)
+		case "trak":
+			track, err := parseTrak(body, size)
+			if err != nil {
+				return err
+			}
+			if track != nil {
+				tracks = append(tracks, track)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no audio track in MP4 file")
+	}
+
+	// Use the first track this package can decode, or report the first
+	track := tracks[0]
+	for _, t := range tracks {
+		if t.codec == "mp4a" && t.config != nil {
+			track = t
+			break
+		}
+	}
+	if track.codec != "mp4a" || track.config == nil {
+		return nil, fmt.Errorf("unsupported MP4 audio codec %q: only AAC is supported", track.codec)
+	}
+	if len(track.sizes) == 0 && fragmented {
+		return nil, fmt.Errorf("fragmented MP4 files are not supported")
+	}
+	if len(track.sizes) != len(track.offsets) {
+		return nil, fmt.Errorf("inconsistent MP4 sample tables: %d sizes, %d offsets", len(track.sizes), len(track.offsets))
+	}
+
+	// Edit durations are in movie time units
+	if track.editDuration > 0 && movieTimescale > 0 {
+		track.editDuration = track.editDuration * int64(track.timescale) / int64(movieTimescale)
+	}
+	return track, nil
+}
+
+// eachBox calls fn with the type and body of each box in data.
+func eachBox(data []byte, fn func(typ string, body []byte) error) error {
+	for len(data) >= 8 {
+		size, headerSize := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return fmt.Errorf("truncated MP4 box header")
+			}
+			size, headerSize = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return fmt.Errorf("invalid MP4 %q box size %d", data[4:8], size)
+		}
+		if err := fn(string(data[4:8]), data[headerSize:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// mp4Timescale returns the timescale of an mvhd or mdhd box, which share the
+// layout of their first fields.
+func mp4Timescale(body []byte) uint32 {
+	if len(body) >= 24 && body[0] == 1 {
+		return binary.BigEndian.Uint32(body[20:])
+	}
+	if len(body) >= 16 {
+		return binary.BigEndian.Uint32(body[12:])
+	}
+	return 0
+}
+
+// parseTrak parses a track, returning nil if it is not audio. size is the
+// size of the file, which every sample must lie within.
+func parseTrak(trak []byte, size int64) (*mp4Track, error) {
+	track := &mp4Track{}
+	audio := false
+	var stsc []byte
+	var chunkOffsets []int64
+
+	var parse func(typ string, body []byte) error
+	parse = func(typ string, body []byte) error {
+		switch typ {
+		case "mdia", "minf", "stbl", "edts":
+			return eachBox(body, parse)
+		case "hdlr":
+			audio = len(body) >= 12 && string(body[8:12]) == "soun"
+		case "mdhd":
+			track.timescale = mp4Timescale(body)
+			if len(body) >= 32 && body[0] == 1 {
+				track.duration = binary.BigEndian.Uint64(body[24:])
+			} else if len(body) >= 20 {
+				track.duration = uint64(binary.BigEndian.Uint32(body[16:]))
+			}
+		case "elst":
+			parseElst(track, body)
+		case "stsd":
+			return parseStsd(track, body)
+		case "stsz":
+			if len(body) < 12 {
+				return fmt.Errorf("truncated MP4 stsz box")
+			}
+			size, count := binary.BigEndian.Uint32(body[4:]), int(binary.BigEndian.Uint32(body[8:]))
+			if size == 0 && count > (len(body)-12)/4 {
+				return fmt.Errorf("truncated MP4 stsz box")
+			}
+			track.sizes = make([]uint32, count)
+			for i := range track.sizes {
+				if size != 0 {
+					track.sizes[i] = size
+				} else {
+					track.sizes[i] = binary.BigEndian.Uint32(body[12+4*i:])
+				}
+			}
+		case "stco", "co64":
+			width := 4
+			if typ == "co64" {
+				width = 8
+			}
+			if len(body) < 8 {
+				return fmt.Errorf("truncated MP4 %s box", typ)
+			}
+			count := int(binary.BigEndian.Uint32(body[4:]))
+			if count > (len(body)-8)/width {
+				return fmt.Errorf("truncated MP4 %s box", typ)
+			}
+			chunkOffsets = make([]int64, count)
+			for i := range chunkOffsets {
+				if width == 4 {
+					chunkOffsets[i] = int64(binary.BigEndian.Uint32(body[8+4*i:]))
+				} else {
+					chunkOffsets[i] = int64(binary.BigEndian.Uint64(body[8+8*i:]))
+				}
+			}
+		case "stsc":
+			stsc = body
+		}
+		return nil
+	}
+	if err := eachBox(trak, parse); err != nil {
+		return nil, err
+	}
+	if !audio {
+		return nil, nil
+	}
+
+	offsets, err := sampleOffsets(stsc, chunkOffsets, track.sizes, size)
+	if err != nil {
+		return nil, err
+	}
+	track.offsets = offsets
+	return track, nil
+}
+
+// parseElst takes the first edit that presents media, skipping empty edits
+// that only delay the track.
+func parseElst(track *mp4Track, body []byte) {
+	if len(body) < 8 {
+		return
+	}
+	count := int(binary.BigEndian.Uint32(body[4:]))
+	entry := body[8:]
+	for i := 0; i < count; i++ {
+		var duration, mediaTime int64
+		if body[0] == 1 {
+			if len(entry) < 20 {
+				return
+			}
+			duration, mediaTime = int64(binary.BigEndian.Uint64(entry)), int64(binary.BigEndian.Uint64(entry[8:]))
+			entry = entry[20:]
+		} else {
+			if len(entry) < 12 {
+				return
+			}
+			duration, mediaTime = int64(binary.BigEndian.Uint32(entry)), int64(int32(binary.BigEndian.Uint32(entry[4:])))
+			entry = entry[12:]
+		}
+		if mediaTime >= 0 {
+			track.editStart, track.editDuration = mediaTime, duration
+			return
+		}
+	}
+}
+
+// parseStsd reads the codec and decoder configuration from the first sample
+// entry.
+func parseStsd(track *mp4Track, body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("truncated MP4 stsd box")
+	}
+	done := false
+	return eachBox(body[8:], func(typ string, entry []byte) error {
+		if done {
+			return nil
+		}
+		done = true
+		track.codec = typ
+		if typ != "mp4a" {
+			return nil
+		}
+
+		// AudioSampleEntry, with the extra fields of QuickTime sound
+		// description versions 1 and 2
+		if len(entry) < 28 {
+			return fmt.Errorf("truncated MP4 mp4a box")
+		}
+		skip := 28
+		switch binary.BigEndian.Uint16(entry[8:]) {
+		case 1:
+			skip += 16
+		case 2:
+			skip += 36
+		}
+		if len(entry) < skip {
+			return fmt.Errorf("truncated MP4 mp4a box")
+		}
+		return eachBox(entry[skip:], func(typ string, body []byte) error {
+			if typ == "esds" {
+				return parseEsds(track, body)
+			}
+			return nil
+		})
+	})
+}
+
+// parseEsds reads the AudioSpecificConfig from an ES_Descriptor (ISO/IEC
+// 14496-1 7.2.6.5).
+func parseEsds(track *mp4Track, body []byte) error {
+	if len(body) < 4 {
+		return fmt.Errorf("truncated MP4 esds box")
+	}
+	data := body[4:]
+	for len(data) > 0 {
+		tag, payload, rest, ok := mp4Descriptor(data)
+		if !ok {
+			return fmt.Errorf("invalid MP4 esds descriptor")
+		}
+		switch tag {
+		case 0x03: // ES_Descriptor
+			if len(payload) < 3 {
+				return fmt.Errorf("truncated MP4 ES descriptor")
+			}
+			flags := payload[2]
+			payload = payload[3:]
+			if flags&0x80 != 0 && len(payload) >= 2 { // streamDependenceFlag
+				payload = payload[2:]
+			}
+			if flags&0x40 != 0 && len(payload) >= 1 { // URL_Flag
+				payload = payload[min(1+int(payload[0]), len(payload)):]
+			}
+			if flags&0x20 != 0 && len(payload) >= 2 { // OCRstreamFlag
+				payload = payload[2:]
+			}
+			data = payload
+			continue
+		case 0x04: // DecoderConfigDescriptor
+			if len(payload) < 13 {
+				return fmt.Errorf("truncated MP4 decoder config descriptor")
+			}
+			// Only MPEG-4 and MPEG-2 AAC are decoded
+			switch payload[0] {
+			case 0x40, 0x66, 0x67, 0x68:
+			case 0x69, 0x6B:
+				track.codec = "mp3"
+				return nil
+			default:
+				track.codec = fmt.Sprintf("mp4a object type 0x%02x", payload[0])
+				return nil
+			}
+			track.bitrate = int(binary.BigEndian.Uint32(payload[9:]))
+			data = payload[13:]
+			continue
+		case 0x05: // DecoderSpecificInfo
+			track.config = payload
+			return nil
+		}
+		data = rest
+	}
+	return nil
+}
+
+// mp4Descriptor splits the descriptor at the start of data into its tag and
+// payload, and returns what follows it. Sizes are coded in up to four bytes of
+// seven bits each.
+func mp4Descriptor(data []byte) (tag byte, payload, rest []byte, ok bool) {
+	if len(data) < 2 {
+		return 0, nil, nil, false
+	}
+	tag = data[0]
+	size, i := 0, 1
+	for ; i < len(data) && i <= 4; i++ {
+		size = size<<7 | int(data[i]&0x7F)
+		if data[i]&0x80 == 0 {
+			break
+		}
+	}
+	i++
+	if i > len(data) || size > len(data)-i {
+		return 0, nil, nil, false
+	}
+	return tag, data[i : i+size], data[i+size:], true
+}
+
+// sampleOffsets works out the file offset of each sample from the chunk
+// offsets and the sample-to-chunk table, in which each entry gives the number
+// of samples in the chunks from its first chunk up to the next entry's.
+// Samples that run past the end of a file of fileSize bytes are rejected.
+func sampleOffsets(stsc []byte, chunks []int64, sizes []uint32, fileSize int64) ([]int64, error) {
+	if len(stsc) < 8 {
+		if len(sizes) == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("missing MP4 stsc box")
+	}
+	count := int(binary.BigEndian.Uint32(stsc[4:]))
+	if count > (len(stsc)-8)/12 {
+		return nil, fmt.Errorf("truncated MP4 stsc box")
+	}
+
+	offsets := make([]int64, 0, len(sizes))
+	for e := 0; e < count && len(offsets) < len(sizes); e++ {
+		entry := stsc[8+12*e:]
+		first := int(binary.BigEndian.Uint32(entry)) - 1
+		perChunk := int(binary.BigEndian.Uint32(entry[4:]))
+		last := len(chunks)
+		if e+1 < count {
+			last = min(int(binary.BigEndian.Uint32(stsc[8+12*(e+1):]))-1, last)
+		}
+		if first < 0 {
+			return nil, fmt.Errorf("invalid MP4 stsc entry")
+		}
+		for chunk := first; chunk < last && len(offsets) < len(sizes); chunk++ {
+			offset := chunks[chunk]
+			for i := 0; i < perChunk && len(offsets) < len(sizes); i++ {
+				size := int64(sizes[len(offsets)])
+				if offset < 0 || offset > fileSize-size {
+					return nil, fmt.Errorf("MP4 sample %d lies outside the file", len(offsets))
+				}
+				offsets = append(offsets, offset)
+				offset += size
+			}
+		}
+	}
+	return offsets, nil
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// box builds an MP4 box from its type and payload parts.
+func box(typ string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+// u32s encodes values as big-endian 32-bit fields.
+func u32s(values ...uint32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// mp4Options describes the file buildMP4 produces.
+type mp4Options struct {
+	codec     string   // Sample entry type
+	config    []byte   // AudioSpecificConfig
+	samples   [][]byte // Access units, stored two to a chunk
+	timescale uint32   // Media timescale; the movie timescale is 1000
+	edit      []uint32 // Edit duration in movie units and media time, if any
+}
+
+// buildMP4 builds an MP4 file with an audio track after a video track, with
+// the moov box after the media data as files written in one pass have it.
+func buildMP4(o mp4Options) []byte {
+	ftyp := box("ftyp", []byte("M4A "), u32s(0), []byte("M4A isom"))
+	var media []byte
+	for _, s := range o.samples {
+		media = append(media, s...)
+	}
+	mdat := box("mdat", media)
+
+	var sizes, chunks []uint32
+	offset := uint32(len(ftyp) + 8)
+	for i, s := range o.samples {
+		if i%2 == 0 {
+			chunks = append(chunks, offset)
+		}
+		sizes = append(sizes, uint32(len(s)))
+		offset += uint32(len(s))
+	}
+
+	stsc := u32s(0, 1, 1, 2, 1)
+	if len(o.samples)%2 == 1 {
+		stsc = u32s(0, 2, 1, 2, 1, uint32(len(chunks)), 1, 1)
+	}
+
+	esds := box("esds", u32s(0),
+		[]byte{0x03, byte(3 + 2 + 13 + 2 + len(o.config)), 0, 1, 0},
+		[]byte{0x04, byte(13 + 2 + len(o.config)), 0x40, 0x15, 0, 0, 0}, u32s(128000, 96000),
+		[]byte{0x05, byte(len(o.config))}, o.config)
+	entry := box(o.codec, make([]byte, 6), []byte{0, 1}, make([]byte, 8), []byte{0, 2, 0, 16}, make([]byte, 4),
+		u32s(o.timescale<<16), esds)
+	stbl := box("stbl",
+		box("stsd", u32s(0, 1), entry),
+		box("stts", u32s(0, 1, uint32(len(o.samples)), 1024)),
+		box("stsc", stsc),
+		box("stsz", u32s(0, 0, uint32(len(sizes))), u32s(sizes...)),
+		box("stco", u32s(0, uint32(len(chunks))), u32s(chunks...)))
+	var edts []byte
+	if o.edit != nil {
+		edts = box("edts", box("elst", u32s(0, 2, 500, 0xFFFFFFFF, 1<<16), u32s(o.edit[0], o.edit[1], 1<<16)))
+	}
+	audio := box("trak", box("tkhd", make([]byte, 84)), edts,
+		box("mdia",
+			box("mdhd", u32s(0, 0, 0, o.timescale, uint32(1024*len(o.samples)), 0)),
+			box("hdlr", u32s(0, 0), []byte("soun"), make([]byte, 13)),
+			box("minf", stbl)))
+	video := box("trak", box("mdia", box("hdlr", u32s(0, 0), []byte("vide"), make([]byte, 13))))
+	moov := box("moov", box("mvhd", u32s(0, 0, 0, 1000, 0)), video, audio)
+	return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+}
+
+func TestParseMP4(t *testing.T) {
+	samples := [][]byte{[]byte("one"), []byte("two"), []byte("three"), []byte("four"), []byte("five")}
+	data := buildMP4(mp4Options{codec: "mp4a", config: []byte{0x12, 0x10}, samples: samples, timescale: 44100, edit: []uint32{100, 2112}})
+
+	track, err := parseMP4(bytes.NewReader(data), int64(len(data)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "mp4a", track.codec)
+	assert.Equal(t, []byte{0x12, 0x10}, track.config)
+	assert.Equal(t, 96000, track.bitrate)
+	assert.Equal(t, uint32(44100), track.timescale)
+	assert.Equal(t, uint64(5*1024), track.duration)
+	// The empty edit is passed over and the duration converted to media time
+	assert.Equal(t, int64(2112), track.editStart)
+	assert.Equal(t, int64(4410), track.editDuration)
+
+	if assert.Len(t, track.offsets, len(samples)) {
+		for i, s := range samples {
+			assert.Equal(t, s, data[track.offsets[i]:track.offsets[i]+int64(track.sizes[i])])
+		}
+	}
+}
+
+func TestParseMP4_Unsupported(t *testing.T) {
+	data := buildMP4(mp4Options{codec: "alac", samples: [][]byte{{0}}, timescale: 44100})
+	_, err := parseMP4(bytes.NewReader(data), int64(len(data)))
+	assert.ErrorContains(t, err, `"alac"`)
+
+	// Video only
+	data = box("moov", box("trak", box("mdia", box("hdlr", u32s(0, 0), []byte("vide")))))
+	_, err = parseMP4(bytes.NewReader(data), int64(len(data)))
+	assert.ErrorContains(t, err, "no audio track")
+
+	_, err = parseMP4(bytes.NewReader([]byte("\x00\x00\x00\x10ftypM4A ")), 12)
+	assert.Error(t, err)
+}
+
+func TestAACFormat_DecodeMP4(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 10; i++ {
+		samples = append(samples, silentBlock(true))
+	}
+	// One second at 8 kHz, after the usual 2112 samples of encoder delay
+	data := buildMP4(mp4Options{codec: "mp4a", config: []byte{0x15, 0x90}, samples: samples, timescale: 8000, edit: []uint32{1000, 2112}})
+
+	format := &AACFormat{}
+	for _, r := range []io.Reader{bytes.NewReader(data), io.MultiReader(bytes.NewReader(data))} {
+		stream, metadata, err := format.Decode(r)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "M4A", metadata.Format)
+		assert.Equal(t, "AAC-LC", metadata.Codec)
+		assert.Equal(t, 8000, metadata.SampleRate)
+		assert.Equal(t, 2, metadata.Channels)
+		assert.Equal(t, 96, metadata.Bitrate)
+		assert.Equal(t, 1.0, metadata.Duration)
+
+		decoded, err := ReadAll(stream)
+		assert.NoError(t, err)
+		assert.Len(t, decoded, 8000)
+	}
+
+	// An access unit larger than the decoder buffer of two channels
+	samples[3] = make([]byte, 2*aacMaxUnit+1)
+	data = buildMP4(mp4Options{codec: "mp4a", config: []byte{0x15, 0x90}, samples: samples, timescale: 8000})
+	_, _, err := format.Decode(bytes.NewReader(data))
+	assert.ErrorContains(t, err, "too large")
+}
+
+func TestSampleOffsets(t *testing.T) {
+	// Two chunks of three samples, then chunks of one
+	stsc := u32s(0, 2, 1, 3, 1, 3, 1, 1)
+	sizes := []uint32{1, 2, 3, 4, 5, 6, 7, 8}
+	offsets, err := sampleOffsets(stsc, []int64{100, 200, 300, 400}, sizes, 408)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{100, 101, 103, 200, 204, 209, 300, 400}, offsets)
+
+	_, err = sampleOffsets(u32s(0, 5), nil, sizes, 408)
+	assert.Error(t, err)
+
+	// A sample running past the end of the file
+	_, err = sampleOffsets(stsc, []int64{100, 200, 300, 400}, sizes, 407)
+	assert.Error(t, err)
+	_, err = sampleOffsets(stsc, []int64{100, 200, 300, 400}, []uint32{1, 2, 3, 4, 5, 6, 7, 1 << 31}, 408)
+	assert.Error(t, err)
+}
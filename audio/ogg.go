@@ -0,0 +1,175 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Ogg page header flags
+const (
+	oggContinued = 0x01 // The page starts with the rest of a packet
+	oggLast      = 0x04 // Last page of the logical bitstream
+)
+
+// oggMaxPage is the largest possible Ogg page: a header with 255 lacing values
+// of 255 bytes each.
+const oggMaxPage = 27 + 255 + 255*255
+
+// oggPacket is a packet read from an Ogg logical bitstream.
+type oggPacket struct {
+	data    []byte
+	granule int64 // Granule position of the page the packet ends on
+	last    bool  // The packet ends on the last page of the bitstream
+}
+
+// packetReader yields the packets of one stream in a container. Other
+// containers present their packets as Ogg's, with a granule position of -1
+// where they have none.
+type packetReader interface {
+	nextPacket() (oggPacket, error)
+}
+
+// oggReader reads the packets of the first logical bitstream in an Ogg file,
+// skipping pages of any other bitstreams multiplexed with it.
+type oggReader struct {
+	r       io.Reader
+	serial  uint32
+	started bool
+	partial []byte      // Start of a packet that continues on the next page
+	queue   []oggPacket // Packets completed on the current page
+	done    bool
+}
+
+func newOggReader(r io.Reader) *oggReader {
+	return &oggReader{r: r}
+}
+
+// nextPacket returns the next packet, or io.EOF after the last one.
+func (o *oggReader) nextPacket() (oggPacket, error) {
+	for len(o.queue) == 0 {
+		if o.done {
+			return oggPacket{}, io.EOF
+		}
+		if err := o.readPage(); err != nil {
+			return oggPacket{}, err
+		}
+	}
+	packet := o.queue[0]
+	o.queue = o.queue[1:]
+	return packet, nil
+}
+
+func (o *oggReader) readPage() error {
+	var header [27]byte
+	if _, err := io.ReadFull(o.r, header[:]); err != nil {
+		if o.started && (err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF)) {
+			// Truncated, or ended without a last-page flag
+			o.done = true
+			return nil
+		}
+		return fmt.Errorf("failed to read Ogg page: %v", err)
+	}
+	if !bytes.Equal(header[:4], []byte("OggS")) {
+		return fmt.Errorf("invalid Ogg page header")
+	}
+
+	flags := header[5]
+	granule := int64(binary.LittleEndian.Uint64(header[6:]))
+	serial := binary.LittleEndian.Uint32(header[14:])
+
+	lacing := make([]byte, header[26])
+	if _, err := io.ReadFull(o.r, lacing); err != nil {
+		return fmt.Errorf("failed to read Ogg segment table: %v", err)
+	}
+	size := 0
+	for _, l := range lacing {
+		size += int(l)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(o.r, body); err != nil {
+		return fmt.Errorf("failed to read Ogg page: %v", err)
+	}
+
+	if !o.started {
+		o.started = true
+		o.serial = serial
+	} else if serial != o.serial {
+		return nil
+	}
+
+	if flags&oggContinued == 0 {
+		// Drop a packet whose continuation was lost
+		o.partial = nil
+	}
+	pos := 0
+	for _, l := range lacing {
+		o.partial = append(o.partial, body[pos:pos+int(l)]...)
+		pos += int(l)
+		// A lacing value under 255 ends the packet
+		if l < 255 {
+			o.queue = append(o.queue, oggPacket{data: o.partial, granule: granule})
+			o.partial = nil
+		}
+	}
+
+	if flags&oggLast != 0 {
+		o.done = true
+		for i := range o.queue {
+			o.queue[i].last = true
+		}
+	}
+	return nil
+}
+
+// oggLength returns the granule position of the last page of the bitstream
+// whose first page is at the current position of rs, and restores the
+// position. Pages of other multiplexed bitstreams are passed over.
+func oggLength(rs io.ReadSeeker) (int64, error) {
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	var header [27]byte
+	if _, err := io.ReadFull(rs, header[:]); err != nil || !bytes.Equal(header[:4], []byte("OggS")) {
+		rs.Seek(pos, io.SeekStart)
+		return 0, fmt.Errorf("invalid Ogg page header")
+	}
+	granule, err := lastGranule(rs, binary.LittleEndian.Uint32(header[14:]))
+	if _, serr := rs.Seek(pos, io.SeekStart); serr != nil {
+		return 0, serr
+	}
+	return granule, err
+}
+
+// lastGranule returns the granule position of the last page of the given
+// bitstream, read from the end of rs. The position of rs is not restored.
+func lastGranule(rs io.ReadSeeker, serial uint32) (int64, error) {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := rs.Seek(max(size-oggMaxPage, 0), io.SeekStart); err != nil {
+		return 0, err
+	}
+	tail, err := io.ReadAll(rs)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+		if len(tail)-i < 27 || tail[i+4] != 0 || binary.LittleEndian.Uint32(tail[i+14:]) != serial {
+			continue
+		}
+		// -1 marks a page on which no packet ends
+		if granule := int64(binary.LittleEndian.Uint64(tail[i+6:])); granule != -1 {
+			return granule, nil
+		}
+	}
+	return 0, fmt.Errorf("no Ogg page with a granule position found")
+}
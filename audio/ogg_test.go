@@ -0,0 +1,117 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildOggPage builds an Ogg page holding packets. If open is set the last
+// packet, whose length must be a multiple of 255, continues on the next page.
+func buildOggPage(flags byte, granule int64, serial uint32, open bool, packets ...[]byte) []byte {
+	var lacing, body []byte
+	for i, p := range packets {
+		for n := len(p); n >= 255; n -= 255 {
+			lacing = append(lacing, 255)
+		}
+		if !open || i < len(packets)-1 {
+			lacing = append(lacing, byte(len(p)%255))
+		}
+		body = append(body, p...)
+	}
+
+	page := []byte("OggS\x00")
+	page = append(page, flags)
+	page = binary.LittleEndian.AppendUint64(page, uint64(granule))
+	page = binary.LittleEndian.AppendUint32(page, serial)
+	page = append(page, make([]byte, 8)...) // Sequence, CRC
+	page = append(page, byte(len(lacing)))
+	page = append(page, lacing...)
+	return append(page, body...)
+}
+
+func TestOggReader(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 255)
+	var data []byte
+	data = append(data, buildOggPage(0x02, 0, 1, true, []byte("a"), []byte("bb"), long)...)
+	data = append(data, buildOggPage(0x02, 7, 2, false, []byte("other stream"))...)
+	data = append(data, buildOggPage(0x05, 1000, 1, false, []byte("yz"), []byte("c"))...)
+
+	ogg := newOggReader(bytes.NewReader(data))
+	var packets []oggPacket
+	for {
+		p, err := ogg.nextPacket()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		packets = append(packets, p)
+	}
+
+	if assert.Len(t, packets, 4) {
+		assert.Equal(t, "a", string(packets[0].data))
+		assert.Equal(t, "bb", string(packets[1].data))
+		assert.Equal(t, string(long)+"yz", string(packets[2].data))
+		assert.Equal(t, "c", string(packets[3].data))
+
+		assert.False(t, packets[1].last)
+		assert.True(t, packets[3].last)
+		assert.Equal(t, int64(1000), packets[3].granule)
+	}
+	assert.Equal(t, uint32(1), ogg.serial)
+}
+
+func TestOggReader_Truncated(t *testing.T) {
+	// A stream cut off mid-page ends after the complete pages
+	data := buildOggPage(0x02, 0, 1, false, []byte("a"))
+	data = append(data, buildOggPage(0, 10, 1, false, []byte("b"))[:10]...)
+	ogg := newOggReader(bytes.NewReader(data))
+
+	p, err := ogg.nextPacket()
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(p.data))
+	_, err = ogg.nextPacket()
+	assert.Equal(t, io.EOF, err)
+
+	// Data that is not Ogg at all is an error
+	_, err = newOggReader(bytes.NewReader([]byte("RIFF0000WAVEfmt 0000000000000000000000"))).nextPacket()
+	assert.Error(t, err)
+}
+
+func TestLastGranule(t *testing.T) {
+	var data []byte
+	data = append(data, buildOggPage(0x02, 0, 1, false, []byte("head"))...)
+	data = append(data, buildOggPage(0, 480, 1, false, []byte("audio"))...)
+	data = append(data, buildOggPage(0x04, 900, 1, false, []byte("audio"))...)
+	data = append(data, buildOggPage(0x04, 50, 2, false, []byte("other"))...)
+
+	granule, err := lastGranule(bytes.NewReader(data), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(900), granule)
+
+	granule, err = lastGranule(bytes.NewReader(data), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), granule)
+
+	_, err = lastGranule(bytes.NewReader(data), 3)
+	assert.Error(t, err)
+
+	// oggLength follows the bitstream that starts at the current position
+	r := bytes.NewReader(data)
+	granule, err = oggLength(r)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(900), granule)
+	pos, _ := r.Seek(0, io.SeekCurrent)
+	assert.Zero(t, pos)
+
+	_, err = oggLength(bytes.NewReader([]byte("RIFF")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,476 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// OpusSampleRate is the rate Opus is decoded at. Granule positions and the
+// pre-skip in Ogg Opus streams are always counted at this rate.
+const OpusSampleRate = 48000
+
+// OpusFormat implements the Format interface for Ogg Opus audio files. Packets
+// are decoded by libopus in cgo builds and by a pure-Go decoder otherwise.
+type OpusFormat struct{}
+
+func init() {
+	Register(Registration{
+		Name:       "opus",
+		Container:  ContainerOgg,
+		Codec:      "Opus",
+		MIMETypes:  []string{"audio/ogg", "audio/opus"},
+		Extensions: []string{".opus", ".ogg"},
+		Sniff:      func(h []byte) bool { return oggCodec(h) == "Opus" },
+		New:        func() Format { return &OpusFormat{} },
+		Decodable:  opusDecodable,
+		Note:       opusNote,
+	})
+}
+
+// opusDecoder decodes single Opus packets at OpusSampleRate to interleaved
+// samples of the channel count it was created for.
+type opusDecoder interface {
+	decode(packet []byte) ([]float32, error)
+}
+
+// GetMetadata extracts metadata from an Opus file.
+func (f *OpusFormat) GetMetadata(filename string, fileSize int64) (AudioMetadata, error) {
+	return streamMetadata(f, filename, fileSize)
+}
+
+// ConvertToSamples converts an Opus file to a slice of float32 samples.
+func (f *OpusFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
+	return streamSamples(f, filename, targetSampleRate)
+}
+
+// Decode reads the Opus headers and streams packets as they are decoded,
+// mixed to mono.
+func (f *OpusFormat) Decode(r io.Reader) (SampleStream, AudioMetadata, error) {
+	return downmixed(f.DecodeChannels(r))
+}
+
+// DecodeChannels is like Decode but keeps the output channels interleaved.
+// The pre-skip is dropped from the start and the end is trimmed to the
+// granule position of the last page. The duration is only known when r can
+// seek.
+func (f *OpusFormat) DecodeChannels(r io.Reader) (SampleStream, AudioMetadata, error) {
+	granule := int64(-1)
+	if rs, ok := r.(io.ReadSeeker); ok {
+		if g, err := oggLength(rs); err == nil {
+			granule = g
+		}
+	}
+
+	ogg := newOggReader(r)
+	packet, err := ogg.nextPacket()
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+	head, err := parseOpusHead(packet.data)
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+	// The comment header follows; its tags are not used
+	if _, err := ogg.nextPacket(); err != nil {
+		return nil, AudioMetadata{}, fmt.Errorf("failed to read Opus comment header: %v", err)
+	}
+
+	metadata := AudioMetadata{
+		Format:     "OPUS",
+		Codec:      "Opus",
+		SampleRate: OpusSampleRate,
+		Channels:   head.channels,
+		BitDepth:   16, // Opus uses 16-bit samples internally
+	}
+	if granule > int64(head.preSkip) {
+		metadata.Duration = float64(granule-int64(head.preSkip)) / OpusSampleRate
+	}
+
+	dec, err := newOpusMultistream(head)
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+	return newOpusStream(ogg, head, dec), metadata, nil
+}
+
+// newOpusStream returns the audio packets read from packets as a SampleStream
+// of head.channels interleaved channels.
+func newOpusStream(packets packetReader, head opusHead, dec opusDecoder) SampleStream {
+	channels := int64(head.channels)
+	var decoded int64 // Samples per channel decoded, including the pre-skip
+	count := 0        // Audio packets decoded
+
+	return &blockStream{
+		next: func() ([]float32, error) {
+			for {
+				packet, err := packets.nextPacket()
+				if err == io.EOF {
+					if count == 0 {
+						return nil, fmt.Errorf("no valid Opus frames decoded")
+					}
+					return nil, io.EOF
+				} else if err != nil {
+					return nil, err
+				}
+				if len(packet.data) == 0 {
+					continue
+				}
+
+				// A packet that cannot be decoded fails the stream rather
+				// than leaving a gap in the transcript
+				samples, err := dec.decode(packet.data)
+				if err != nil {
+					return nil, fmt.Errorf("Opus packet %d: %v", count+1, err)
+				}
+				count++
+
+				frames := int64(len(samples)) / channels
+				start := decoded
+				decoded += frames
+				// The last page's granule position marks the end of the audio,
+				// which may fall inside the final packet
+				if packet.last && packet.granule >= 0 && decoded > packet.granule {
+					frames = max(frames-(decoded-packet.granule), 0)
+					samples = samples[:frames*channels]
+					decoded = packet.granule
+				}
+				if start < int64(head.preSkip) {
+					samples = samples[min(int64(head.preSkip)-start, frames)*channels:]
+				}
+				if head.gain != 1 {
+					for i := range samples {
+						samples[i] *= head.gain
+					}
+				}
+
+				if len(samples) > 0 {
+					return samples, nil
+				}
+			}
+		},
+	}
+}
+
+// OpusPacketDecoder decodes individual Opus packets, such as frames received
+// over a network stream, to mono samples at OpusSampleRate.
+type OpusPacketDecoder struct {
+	decoder  opusDecoder
+	channels int
+}
+
+// NewOpusPacketDecoder creates a decoder for packets with the given channel count.
+func NewOpusPacketDecoder(channels int) (*OpusPacketDecoder, error) {
+	if channels < 1 || channels > 2 {
+		return nil, fmt.Errorf("Opus packets have 1 or 2 channels, not %d", channels)
+	}
+	decoder, err := newOpusDecoder(channels)
+	if err != nil {
+		return nil, err
+	}
+	return &OpusPacketDecoder{decoder: decoder, channels: channels}, nil
+}
+
+// Decode decodes one packet.
+func (d *OpusPacketDecoder) Decode(packet []byte) ([]float32, error) {
+	samples, err := d.decoder.decode(packet)
+	if err != nil || d.channels == 1 {
+		return samples, err
+	}
+	return ConvertToMono(samples, d.channels), nil
+}
+
+// opusHead is the identification header of an Ogg Opus stream (RFC 7845 §5.1).
+type opusHead struct {
+	channels       int
+	preSkip        int     // Samples to drop from the start of the decoded audio
+	inputRate      int     // Sample rate of the original input, for information only
+	gain           float32 // Linear output gain
+	mappingFamily  int
+	streams        int
+	coupledStreams int
+	mapping        []byte // Stream channel of each output channel
+}
+
+// parseOpusHead parses and validates an OpusHead packet.
+func parseOpusHead(data []byte) (opusHead, error) {
+	if len(data) < 19 || !bytes.Equal(data[:8], []byte("OpusHead")) {
+		return opusHead{}, fmt.Errorf("invalid Opus identification header")
+	}
+	// Versions 0-15 share the same layout
+	if data[8] > 15 {
+		return opusHead{}, fmt.Errorf("unsupported Opus version: %d", data[8])
+	}
+
+	head := opusHead{
+		channels:      int(data[9]),
+		preSkip:       int(binary.LittleEndian.Uint16(data[10:])),
+		inputRate:     int(binary.LittleEndian.Uint32(data[12:])),
+		mappingFamily: int(data[18]),
+	}
+	// Output gain is Q7.8 dB
+	gainDB := float64(int16(binary.LittleEndian.Uint16(data[16:]))) / 256
+	head.gain = float32(math.Pow(10, gainDB/20))
+
+	if head.channels == 0 {
+		return opusHead{}, fmt.Errorf("invalid Opus channel count: 0")
+	}
+
+	if head.mappingFamily == 0 {
+		// A single stream in mono or stereo
+		if head.channels > 2 {
+			return opusHead{}, fmt.Errorf("invalid Opus channel count for mapping family 0: %d", head.channels)
+		}
+		head.streams = 1
+		head.coupledStreams = head.channels - 1
+		head.mapping = []byte{0, 1}[:head.channels]
+		return head, nil
+	}
+
+	if len(data) < 21+head.channels {
+		return opusHead{}, fmt.Errorf("truncated Opus channel mapping table")
+	}
+	head.streams = int(data[19])
+	head.coupledStreams = int(data[20])
+	head.mapping = data[21 : 21+head.channels]
+	if head.streams == 0 || head.coupledStreams > head.streams || head.streams+head.coupledStreams > 255 {
+		return opusHead{}, fmt.Errorf("invalid Opus stream counts: %d streams, %d coupled", head.streams, head.coupledStreams)
+	}
+	for _, m := range head.mapping {
+		if m != 255 && int(m) >= head.streams+head.coupledStreams {
+			return opusHead{}, fmt.Errorf("invalid Opus channel mapping: %d", m)
+		}
+	}
+	return head, nil
+}
+
+// opusMultistream decodes the packets of a stream with one Opus stream per
+// coupled pair or uncoupled channel (RFC 7845 §5.1.1) and interleaves the
+// output channels in the order of the channel mapping.
+type opusMultistream struct {
+	head     opusHead
+	decoders []opusDecoder
+}
+
+func newOpusMultistream(head opusHead) (*opusMultistream, error) {
+	m := &opusMultistream{head: head}
+	for i := 0; i < head.streams; i++ {
+		decoder, err := newOpusDecoder(m.streamChannels(i))
+		if err != nil {
+			return nil, err
+		}
+		m.decoders = append(m.decoders, decoder)
+	}
+	return m, nil
+}
+
+func (m *opusMultistream) decode(packet []byte) ([]float32, error) {
+	if m.head.streams == 1 {
+		return m.decoders[0].decode(packet)
+	}
+
+	packets, err := splitMultistream(packet, m.head.streams)
+	if err != nil {
+		return nil, err
+	}
+	streams := make([][]float32, len(packets))
+	n := math.MaxInt // Samples per channel
+	for i, p := range packets {
+		if streams[i], err = m.decoders[i].decode(p); err != nil {
+			return nil, err
+		}
+		n = min(n, len(streams[i])/m.streamChannels(i))
+	}
+
+	channels := len(m.head.mapping)
+	output := make([]float32, n*channels)
+	for o, c := range m.head.mapping {
+		if c == 255 {
+			continue // Silent channel
+		}
+		// Coupled streams come first, each carrying two channels
+		s, sc, stride := int(c), 0, 1
+		if s < 2*m.head.coupledStreams {
+			s, sc, stride = s/2, s%2, 2
+		} else {
+			s -= m.head.coupledStreams
+		}
+		for i := 0; i < n; i++ {
+			output[i*channels+o] = streams[s][i*stride+sc]
+		}
+	}
+	return output, nil
+}
+
+// streamChannels returns the number of channels in stream i.
+func (m *opusMultistream) streamChannels(i int) int {
+	if i < m.head.coupledStreams {
+		return 2
+	}
+	return 1
+}
+
+// splitMultistream splits a multistream packet into a normal packet per stream.
+// All but the last stream use self-delimited framing.
+func splitMultistream(packet []byte, streams int) ([][]byte, error) {
+	packets := make([][]byte, 0, streams)
+	for i := 0; i < streams-1; i++ {
+		p, n, err := selfDelimited(packet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Opus multistream packet: %v", err)
+		}
+		packets = append(packets, p)
+		packet = packet[n:]
+	}
+	return append(packets, packet), nil
+}
+
+// selfDelimited reads a self-delimited Opus packet (RFC 6716 Appendix B) from
+// the start of data. It returns the packet with normal framing and the number
+// of bytes it took up.
+func selfDelimited(data []byte) ([]byte, int, error) {
+	if len(data) == 0 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	toc := data[0]
+
+	switch toc & 3 {
+	case 0, 1:
+		// One frame, or two of the same size
+		length, n, err := frameLength(data[1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		end := 1 + n + length*int(toc&3+1)
+		if end > len(data) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return append([]byte{toc}, data[1+n:end]...), end, nil
+
+	case 2:
+		// Two frames; the first length is kept, the second dropped
+		length1, n1, err := frameLength(data[1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		length2, n2, err := frameLength(data[1+n1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		end := 1 + n1 + n2 + length1 + length2
+		if end > len(data) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		p := append([]byte{toc}, data[1:1+n1]...)
+		return append(p, data[1+n1+n2:end]...), end, nil
+
+	default:
+		// A frame count byte, optional padding, and then frame lengths:
+		// all but the last for VBR, or one shared length for CBR
+		if len(data) < 2 {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		frames := int(data[1] & 0x3f)
+		vbr := data[1]&0x80 != 0
+		if frames == 0 {
+			return nil, 0, fmt.Errorf("invalid frame count")
+		}
+
+		pos := 2
+		padding := 0
+		if data[1]&0x40 != 0 {
+			for {
+				if pos >= len(data) {
+					return nil, 0, io.ErrUnexpectedEOF
+				}
+				p := int(data[pos])
+				pos++
+				if p < 255 {
+					padding += p
+					break
+				}
+				padding += 254
+			}
+		}
+
+		total := 0
+		if vbr {
+			for i := 0; i < frames-1; i++ {
+				length, n, err := frameLength(data[pos:])
+				if err != nil {
+					return nil, 0, err
+				}
+				pos += n
+				total += length
+			}
+		}
+		// The extra length that makes the packet self-delimiting
+		delimiter := pos
+		length, n, err := frameLength(data[pos:])
+		if err != nil {
+			return nil, 0, err
+		}
+		pos += n
+		if vbr {
+			total += length
+		} else {
+			total = frames * length
+		}
+
+		end := pos + total + padding
+		if end > len(data) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		p := append([]byte{}, data[:delimiter]...)
+		return append(p, data[pos:end]...), end, nil
+	}
+}
+
+// frameLength reads a one or two byte Opus frame length (RFC 6716 §3.2.1).
+func frameLength(data []byte) (int, int, error) {
+	if len(data) == 0 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	if data[0] < 252 {
+		return int(data[0]), 1, nil
+	}
+	if len(data) < 2 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	return int(data[0]) + 4*int(data[1]), 2, nil
+}
+
+// opusPacketSamples returns the duration of a packet in samples at
+// OpusSampleRate, from its TOC byte (RFC 6716 §3.1).
+func opusPacketSamples(packet []byte) int {
+	if len(packet) == 0 {
+		return 0
+	}
+	config := packet[0] >> 3
+	var frame int
+	switch {
+	case config < 12: // SILK: 10, 20, 40 or 60ms
+		frame = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid: 10 or 20ms
+		frame = []int{480, 960}[config%2]
+	default: // CELT: 2.5, 5, 10 or 20ms
+		frame = 120 << (config % 4)
+	}
+
+	switch packet[0] & 3 {
+	case 0:
+		return frame
+	case 1, 2:
+		return 2 * frame
+	default:
+		if len(packet) < 2 {
+			return 0
+		}
+		return int(packet[1]&0x3f) * frame
+	}
+}
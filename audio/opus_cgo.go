@@ -7,87 +7,45 @@ package audio
 
 import (
 	"fmt"
-	"os"
 
-	oggopus "github.com/altager/oggopus"
 	"layeh.com/gopus"
 )
 
-// OpusFormat implements the Format interface for Opus audio files.
-type OpusFormat struct{}
-
-// GetMetadata extracts metadata from an Opus file.
-func (f *OpusFormat) GetMetadata(filename string, fileSize int64) (AudioMetadata, error) {
-	// Standard Opus parameters
-	sampleRate := 48000  // Opus default
-	channels := 1        // We decode as mono
-	frameSize := 960     // Standard Opus frame size for 20ms
-	bytesPerFrame := 120 // Typical Opus frame size in bytes
+// libopus decodes every Opus mode.
+const (
+	opusDecodable = true
+	opusNote      = ""
+)
 
-	// Calculate approximate duration
-	frameCount := fileSize / int64(bytesPerFrame)
-	duration := float64(frameCount) * float64(frameSize) / float64(sampleRate)
+// newOpusDecoder returns a libopus decoder.
+func newOpusDecoder(channels int) (opusDecoder, error) {
+	return newGopusDecoder(channels)
+}
 
-	return AudioMetadata{
-		Format:       "OPUS",
-		Codec:        "Opus",
-		SampleRate:   sampleRate,
-		Channels:     channels,
-		BitDepth:     16, // Opus uses 16-bit samples internally
-		Duration:     duration,
-		OriginalSize: fileSize,
-		Bitrate:      int(float64(fileSize*8) / duration / 1000),
-	}, nil
+// gopusDecoder decodes packets with libopus, which supports every Opus mode.
+type gopusDecoder struct {
+	decoder  *gopus.Decoder
+	channels int
 }
 
-// ConvertToSamples converts an Opus file to a slice of float32 samples.
-func (f *OpusFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
-	file, err := os.Open(filename)
+func newGopusDecoder(channels int) (*gopusDecoder, error) {
+	decoder, err := gopus.NewDecoder(OpusSampleRate, channels)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create Opus decoder: %v", err)
 	}
-	defer file.Close()
+	return &gopusDecoder{decoder: decoder, channels: channels}, nil
+}
 
-	dec, err := oggopus.NewOpusReader(file)
+func (d *gopusDecoder) decode(packet []byte) ([]float32, error) {
+	// 120ms, the longest packet Opus allows
+	output, err := d.decoder.Decode(packet, OpusSampleRate*120/1000, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create oggopus OpusReader: %v", err)
+		return nil, fmt.Errorf("failed to decode Opus packet: %v", err)
 	}
 
-	var pcm []float32
-	frameSize := 960 // 20ms at 48kHz
-	var decoder *gopus.Decoder
-
-	for {
-		packet, err := dec.NextPacket()
-		if err != nil {
-			break
-		}
-
-		// Initialize decoder after headers are read (on first packet)
-		if decoder == nil {
-			decoder, err = gopus.NewDecoder(int(dec.InputSampleRate), int(dec.ChannelCount))
-			if err != nil {
-				return nil, fmt.Errorf("failed to create Opus decoder: %v", err)
-			}
-		}
-
-		// Decode Opus packet to PCM samples
-		output, err := decoder.Decode(packet.PacketData, frameSize, false)
-		if err != nil {
-			continue // Skip invalid packets
-		}
-
-		// Convert int16 to float32 and handle mono conversion
-		for i := 0; i < len(output); i += int(dec.ChannelCount) {
-			sample := float32(output[i]) / 32768.0
-			pcm = append(pcm, sample)
-		}
+	samples := make([]float32, len(output))
+	for i, v := range output {
+		samples[i] = float32(v) / 32768.0
 	}
-
-	if len(pcm) == 0 {
-		return nil, fmt.Errorf("no valid Opus frames decoded")
-	}
-
-	// Resample from decoded sample rate to target rate
-	return ResampleAudio(pcm, int(dec.InputSampleRate), targetSampleRate), nil
+	return samples, nil
 }
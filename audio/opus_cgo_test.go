@@ -0,0 +1,100 @@
+//go:build cgo
+
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeOpusWith decodes an Ogg Opus file with the given decoder.
+func decodeOpusWith(t *testing.T, filename string, dec opusDecoder) []float32 {
+	file, err := os.Open(filename)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer file.Close()
+
+	ogg := newOggReader(file)
+	packet, err := ogg.nextPacket()
+	assert.NoError(t, err)
+	head, err := parseOpusHead(packet.data)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	ogg.nextPacket()
+
+	samples, err := ReadAll(newOpusStream(ogg, head, dec))
+	assert.NoError(t, err)
+	return samples
+}
+
+// firstAudioPacket returns the first packet after the Opus headers.
+func firstAudioPacket(t *testing.T, filename string) []byte {
+	file, err := os.Open(filename)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer file.Close()
+
+	ogg := newOggReader(file)
+	for i := 0; i < 2; i++ {
+		ogg.nextPacket()
+	}
+	packet, err := ogg.nextPacket()
+	if !assert.NoError(t, err) || !assert.NotEmpty(t, packet.data) {
+		t.FailNow()
+	}
+	return packet.data
+}
+
+// envelope returns the RMS level of each 20ms block.
+func envelope(samples []float32) []float64 {
+	var levels []float64
+	for i := 0; i+960 <= len(samples); i += 960 {
+		var sum float64
+		for _, v := range samples[i : i+960] {
+			sum += float64(v) * float64(v)
+		}
+		levels = append(levels, math.Sqrt(sum/960))
+	}
+	return levels
+}
+
+func TestOpusDecoders_Agree(t *testing.T) {
+	// The fixture is SILK wideband, the only mode the pure-Go decoder handles
+	testFile := filepath.Join("..", "test_fixtures", "test.opus")
+	if toc := firstAudioPacket(t, testFile)[0]; !assert.Equal(t, byte(pureOpusConfig), toc>>3, "TOC %#02x", toc) {
+		return
+	}
+
+	gopusDec, err := newGopusDecoder(1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	libopus := decodeOpusWith(t, testFile, gopusDec)
+	pure := decodeOpusWith(t, testFile, newPureOpusDecoder(1))
+
+	// Both trim to the same length
+	assert.Equal(t, len(libopus), len(pure))
+
+	// libopus resamples SILK's 16 kHz output with a filter where pion/opus
+	// repeats samples, so compare loudness over time rather than samples
+	a, b := envelope(libopus), envelope(pure)
+	n := min(len(a), len(b))
+	var diff, total float64
+	for i := 0; i < n; i++ {
+		diff += math.Abs(a[i] - b[i])
+		total += a[i]
+	}
+	if assert.NotZero(t, total) {
+		assert.Less(t, diff/total, 0.1)
+	}
+}
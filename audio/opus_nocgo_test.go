@@ -0,0 +1,36 @@
+//go:build !cgo
+
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpusFormat_CELTWithoutCgo(t *testing.T) {
+	// Two 20ms CELT fullband packets, as browsers send
+	packet := []byte{0xfc, 0xff, 0xfe}
+	var data []byte
+	data = append(data, buildOggPage(0x02, 0, 1, false, opusHeadPacket(1, 0, 0, 0, 0, 0))...)
+	data = append(data, buildOggPage(0, 0, 1, false, []byte("OpusTags"))...)
+	data = append(data, buildOggPage(0x04, 1920, 1, false, packet, packet)...)
+
+	// The packets fail to decode instead of becoming silence
+	stream, _, err := (&OpusFormat{}).Decode(bytes.NewReader(data))
+	if assert.NoError(t, err) {
+		samples, err := ReadAll(stream)
+		assert.ErrorContains(t, err, "unsupported Opus packet")
+		assert.Empty(t, samples)
+	}
+
+	// Uploads are turned away as the format is not decodable in this build
+	opus, _ := Lookup("opus")
+	assert.False(t, opus.Decodable)
+	_, _, err = Decode(bytes.NewReader(data), 16000)
+	assert.ErrorContains(t, err, "requires cgo")
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"fmt"
+
+	"github.com/pion/opus"
+)
+
+// pureOpusConfig is the only TOC configuration the pure-Go decoder handles:
+// SILK wideband with 20ms frames.
+const pureOpusConfig = 9
+
+// pureOpusDecoder decodes packets with pion/opus, which needs no cgo but only
+// implements SILK and upsamples its 16 kHz wideband output to 48 kHz.
+type pureOpusDecoder struct {
+	decoder  opus.Decoder
+	channels int
+}
+
+// newPureOpusDecoder creates a pure-Go decoder. Coupled streams are decoded
+// as their mid channel, which is output as both channels.
+func newPureOpusDecoder(channels int) *pureOpusDecoder {
+	return &pureOpusDecoder{decoder: opus.NewDecoder(), channels: channels}
+}
+
+func (d *pureOpusDecoder) decode(packet []byte) ([]float32, error) {
+	if len(packet) == 0 {
+		return nil, fmt.Errorf("empty Opus packet")
+	}
+	// Check the TOC first, as pion/opus assumes a single 20ms wideband frame
+	// when sizing its output
+	if packet[0]>>3 != pureOpusConfig || packet[0]&3 != 0 {
+		return nil, fmt.Errorf("unsupported Opus packet (TOC %#02x): only single-frame 20ms SILK wideband packets can be decoded without cgo", packet[0])
+	}
+
+	n := opusPacketSamples(packet)
+	output := make([]byte, 2*n) // 16-bit little-endian PCM
+	if _, _, err := d.decoder.Decode(packet, output); err != nil {
+		return nil, fmt.Errorf("failed to decode Opus packet: %v", err)
+	}
+
+	samples := make([]float32, n*d.channels)
+	for i := range samples {
+		j := i / d.channels
+		samples[i] = float32(int16(uint16(output[2*j])|uint16(output[2*j+1])<<8)) / 32768.0
+	}
+	return samples, nil
+}
@@ -4,6 +4,9 @@
 package audio
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -55,3 +58,254 @@ func TestOpusFormat_ConvertToSamples(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, samples)
 }
+
+// opusHeadPacket builds an OpusHead packet. The mapping table is only written
+// for mapping family 1.
+func opusHeadPacket(channels, preSkip int, gain int16, family, streams, coupled int, mapping ...byte) []byte {
+	p := []byte("OpusHead\x01")
+	p = append(p, byte(channels))
+	p = binary.LittleEndian.AppendUint16(p, uint16(preSkip))
+	p = binary.LittleEndian.AppendUint32(p, 16000)
+	p = binary.LittleEndian.AppendUint16(p, uint16(gain))
+	p = append(p, byte(family))
+	if family != 0 {
+		p = append(p, byte(streams), byte(coupled))
+		p = append(p, mapping...)
+	}
+	return p
+}
+
+func TestParseOpusHead(t *testing.T) {
+	head, err := parseOpusHead(opusHeadPacket(2, 312, 6*256, 0, 0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, head.channels)
+	assert.Equal(t, 312, head.preSkip)
+	assert.Equal(t, 16000, head.inputRate)
+	assert.InDelta(t, 1.995, head.gain, 1e-3)
+	assert.Equal(t, 1, head.streams)
+	assert.Equal(t, 1, head.coupledStreams)
+	assert.Equal(t, []byte{0, 1}, head.mapping)
+
+	// 5.1 surround: 4 streams, 2 of them coupled
+	head, err = parseOpusHead(opusHeadPacket(6, 312, 0, 1, 4, 2, 0, 4, 1, 2, 3, 5))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, head.streams)
+	assert.Equal(t, 2, head.coupledStreams)
+	assert.Equal(t, []byte{0, 4, 1, 2, 3, 5}, head.mapping)
+	assert.Equal(t, float32(1), head.gain)
+
+	for name, data := range map[string][]byte{
+		"bad magic":         append([]byte("OpusTags"), make([]byte, 11)...),
+		"short":             opusHeadPacket(1, 0, 0, 0, 0, 0)[:18],
+		"no channels":       opusHeadPacket(0, 0, 0, 0, 0, 0),
+		"family 0 surround": opusHeadPacket(3, 0, 0, 0, 0, 0),
+		"truncated table":   opusHeadPacket(3, 0, 0, 1, 2, 1, 0, 1),
+		"no streams":        opusHeadPacket(1, 0, 0, 1, 0, 0, 0),
+		"bad mapping":       opusHeadPacket(2, 0, 0, 1, 1, 0, 0, 1),
+	} {
+		_, err := parseOpusHead(data)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestSelfDelimited(t *testing.T) {
+	frame := func(n int, v byte) []byte { return bytes.Repeat([]byte{v}, n) }
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	tests := []struct {
+		name   string
+		data   []byte
+		packet []byte
+	}{
+		{"one frame", cat([]byte{0x48, 3}, frame(3, 1)), cat([]byte{0x48}, frame(3, 1))},
+		{"two equal frames", cat([]byte{0x49, 2}, frame(4, 1)), cat([]byte{0x49}, frame(4, 1))},
+		{"two frames", cat([]byte{0x4a, 2, 3}, frame(5, 1)), cat([]byte{0x4a, 2}, frame(5, 1))},
+		{"long frame", cat([]byte{0x48, 252, 1}, frame(256, 1)), cat([]byte{0x48}, frame(256, 1))},
+		{"cbr with padding", cat([]byte{0x4b, 0x42, 1, 2}, frame(4, 1), frame(1, 0)), cat([]byte{0x4b, 0x42, 1}, frame(4, 1), frame(1, 0))},
+		{"vbr", cat([]byte{0x4b, 0x82, 1, 2}, frame(3, 1)), cat([]byte{0x4b, 0x82, 1}, frame(3, 1))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The next stream's packet follows
+			packet, n, err := selfDelimited(append(tt.data, 0xff, 0xff))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.packet, packet)
+			assert.Equal(t, len(tt.data), n)
+
+			_, _, err = selfDelimited(tt.data[:len(tt.data)-1])
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestOpusPacketSamples(t *testing.T) {
+	tests := []struct {
+		packet []byte
+		want   int
+	}{
+		{[]byte{0x48}, 960},        // SILK WB 20ms
+		{[]byte{0x58}, 2880},       // SILK WB 60ms
+		{[]byte{0x60}, 480},        // Hybrid SWB 10ms
+		{[]byte{0xfc}, 960},        // CELT FB 20ms
+		{[]byte{0x80}, 120},        // CELT NB 2.5ms
+		{[]byte{0xfd}, 1920},       // Two CELT FB 20ms frames
+		{[]byte{0xfb, 0x03}, 2880}, // Three CELT 20ms frames
+		{[]byte{0xfb}, 0},
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, opusPacketSamples(tt.packet), "%x", tt.packet)
+	}
+}
+
+// countingDecoder returns 960 samples per channel per packet, numbered from 1
+// and the same in every channel, so tests can tell which samples were kept.
+type countingDecoder struct {
+	n        int
+	value    float32 // Constant output instead, if set
+	channels int     // 1 if unset
+	err      error
+	errAfter int // Packets decoded before failing with err
+	packets  int
+}
+
+func (d *countingDecoder) decode(packet []byte) ([]float32, error) {
+	if d.err != nil && d.packets >= d.errAfter {
+		return nil, d.err
+	}
+	d.packets++
+	channels := max(d.channels, 1)
+	samples := make([]float32, 960*channels)
+	for i := range samples {
+		if i%channels == 0 {
+			d.n++
+		}
+		samples[i] = float32(d.n)
+		if d.value != 0 {
+			samples[i] = d.value
+		}
+	}
+	return samples, nil
+}
+
+// opusFile builds an Ogg Opus stream with three 20ms packets whose last page
+// ends at granule.
+func opusFile(head []byte, granule int64) []byte {
+	packet := []byte{0x48, 0}
+	var data []byte
+	data = append(data, buildOggPage(0x02, 0, 1, false, head)...)
+	data = append(data, buildOggPage(0, 0, 1, false, []byte("OpusTags"))...)
+	data = append(data, buildOggPage(0, 960, 1, false, packet)...)
+	data = append(data, buildOggPage(0x04, granule, 1, false, packet, packet)...)
+	return data
+}
+
+func TestOpusStream_PreSkipAndTrim(t *testing.T) {
+	data := opusFile(opusHeadPacket(1, 312, 0, 0, 0, 0), 2380)
+	ogg := newOggReader(bytes.NewReader(data))
+	packet, _ := ogg.nextPacket()
+	head, err := parseOpusHead(packet.data)
+	assert.NoError(t, err)
+	ogg.nextPacket()
+
+	samples, err := ReadAll(newOpusStream(ogg, head, &countingDecoder{}))
+	assert.NoError(t, err)
+	// 2880 decoded, less 312 of pre-skip and 500 past the final granule
+	if assert.Len(t, samples, 2380-312) {
+		assert.Equal(t, float32(313), samples[0])
+		assert.Equal(t, float32(2380), samples[len(samples)-1])
+	}
+
+	// Trimming counts samples per channel in stereo streams
+	data = opusFile(opusHeadPacket(2, 312, 0, 0, 0, 0), 2380)
+	ogg = newOggReader(bytes.NewReader(data))
+	packet, _ = ogg.nextPacket()
+	head, _ = parseOpusHead(packet.data)
+	ogg.nextPacket()
+	samples, err = ReadAll(newOpusStream(ogg, head, &countingDecoder{channels: 2}))
+	assert.NoError(t, err)
+	if assert.Len(t, samples, 2*(2380-312)) {
+		assert.Equal(t, []float32{313, 313}, samples[:2])
+		assert.Equal(t, float32(2380), samples[len(samples)-1])
+	}
+
+	// Output gain scales the samples
+	data = opusFile(opusHeadPacket(1, 0, -6*256, 0, 0, 0), 2880)
+	ogg = newOggReader(bytes.NewReader(data))
+	packet, _ = ogg.nextPacket()
+	head, _ = parseOpusHead(packet.data)
+	ogg.nextPacket()
+	samples, err = ReadAll(newOpusStream(ogg, head, &countingDecoder{value: 1}))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.501, samples[0], 1e-3)
+
+	// A packet that cannot be decoded fails the stream, even after others
+	// have decoded
+	ogg = newOggReader(bytes.NewReader(data))
+	ogg.nextPacket()
+	ogg.nextPacket()
+	_, err = ReadAll(newOpusStream(ogg, head, &countingDecoder{err: assert.AnError}))
+	assert.ErrorContains(t, err, "Opus packet 1")
+
+	ogg = newOggReader(bytes.NewReader(data))
+	ogg.nextPacket()
+	ogg.nextPacket()
+	_, err = ReadAll(newOpusStream(ogg, head, &countingDecoder{errAfter: 2, err: assert.AnError}))
+	assert.ErrorContains(t, err, "Opus packet 3")
+}
+
+func TestOpusFormat_DecodeDuration(t *testing.T) {
+	data := opusFile(opusHeadPacket(1, 312, 0, 0, 0, 0), 2380)
+	stream, metadata, err := (&OpusFormat{}).Decode(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer stream.Close()
+	assert.Equal(t, OpusSampleRate, metadata.SampleRate)
+	assert.Equal(t, 1, metadata.Channels)
+	assert.InDelta(t, float64(2380-312)/OpusSampleRate, metadata.Duration, 1e-9)
+
+	// Without seeking the duration is unknown
+	stream, metadata, err = (&OpusFormat{}).Decode(io.MultiReader(bytes.NewReader(data)))
+	if assert.NoError(t, err) {
+		stream.Close()
+		assert.Zero(t, metadata.Duration)
+	}
+}
+
+func TestOpusMultistream_Mapping(t *testing.T) {
+	// Three channels: a coupled pair in stream 0, a mono stream 1, and a
+	// silent fourth channel
+	head, err := parseOpusHead(opusHeadPacket(4, 0, 0, 1, 2, 1, 0, 1, 2, 255))
+	if !assert.NoError(t, err) {
+		return
+	}
+	m := &opusMultistream{head: head, decoders: []opusDecoder{
+		&countingDecoder{value: 1, channels: 2},
+		&countingDecoder{value: 0.5},
+	}}
+
+	// Stream 0 self-delimited, then stream 1
+	samples, err := m.decode([]byte{0x48, 1, 0xaa, 0x48, 0xbb})
+	assert.NoError(t, err)
+	if assert.Len(t, samples, 4*960) {
+		assert.Equal(t, []float32{1, 1, 0.5, 0}, samples[:4])
+		assert.InDelta(t, (1+1+0.5)/4.0, ConvertToMono(samples, 4)[0], 1e-6)
+	}
+
+	_, err = m.decode([]byte{0x48, 5, 0xaa})
+	assert.Error(t, err)
+}
+
+func TestPureOpusDecoder_Unsupported(t *testing.T) {
+	d := newPureOpusDecoder(1)
+	for _, packet := range [][]byte{
+		{0xfc, 0x00}, // CELT
+		{0x08, 0x00}, // SILK NB 20ms
+		{0x49, 0x00}, // Two SILK WB frames
+		{},
+	} {
+		_, err := d.decode(packet)
+		assert.Error(t, err, "%x", packet)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Sample encodings for raw PCM streams.
+const (
+	PCMS16LE = "s16le" // Signed 16-bit little-endian
+	PCMF32LE = "f32le" // 32-bit float little-endian
+)
+
+// PCMDecoder converts raw interleaved little-endian PCM, received in chunks of
+// any size, to mono float32 samples. A partial frame at the end of a chunk is
+// kept until the rest of it arrives.
+type PCMDecoder struct {
+	encoding  string
+	channels  int
+	frameSize int // Bytes per interleaved frame
+	rest      []byte
+}
+
+// NewPCMDecoder creates a decoder for the given encoding and channel count.
+func NewPCMDecoder(encoding string, channels int) (*PCMDecoder, error) {
+	if channels < 1 {
+		return nil, fmt.Errorf("invalid channel count %d", channels)
+	}
+
+	var sampleSize int
+	switch encoding {
+	case PCMS16LE:
+		sampleSize = 2
+	case PCMF32LE:
+		sampleSize = 4
+	default:
+		return nil, fmt.Errorf("unsupported PCM encoding %q: expected %s or %s", encoding, PCMS16LE, PCMF32LE)
+	}
+
+	return &PCMDecoder{
+		encoding:  encoding,
+		channels:  channels,
+		frameSize: sampleSize * channels,
+	}, nil
+}
+
+// Decode returns the mono samples of the complete frames received so far.
+func (d *PCMDecoder) Decode(data []byte) []float32 {
+	if len(d.rest) > 0 {
+		data = append(d.rest, data...)
+	}
+	frames := len(data) / d.frameSize
+
+	samples := make([]float32, frames)
+	sampleSize := d.frameSize / d.channels
+	for i := range samples {
+		var sum float32
+		for ch := 0; ch < d.channels; ch++ {
+			b := data[i*d.frameSize+ch*sampleSize:]
+			if d.encoding == PCMS16LE {
+				sum += float32(int16(binary.LittleEndian.Uint16(b))) / 32768.0
+			} else {
+				sum += math.Float32frombits(binary.LittleEndian.Uint32(b))
+			}
+		}
+		samples[i] = sum / float32(d.channels)
+	}
+
+	// data may share its array with rest, so the samples are read first
+	d.rest = append(d.rest[:0], data[frames*d.frameSize:]...)
+	return samples
+}
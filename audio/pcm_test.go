@@ -0,0 +1,44 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPCMDecoder_S16LE(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []int16{16384, 0, -16384, -16384, 8192, 0})
+	data := buf.Bytes()
+
+	// Chunk boundaries inside a frame do not change the output
+	for _, n := range []int{1, 3, len(data)} {
+		d, err := NewPCMDecoder(PCMS16LE, 2)
+		assert.NoError(t, err)
+		var samples []float32
+		for i := 0; i < len(data); i += n {
+			samples = append(samples, d.Decode(data[i:min(i+n, len(data))])...)
+		}
+		assert.Equal(t, []float32{0.25, -0.5, 0.125}, samples, "chunk size %d", n)
+	}
+}
+
+func TestPCMDecoder_F32LE(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []float32{0.5, -0.25, 1})
+	d, err := NewPCMDecoder(PCMF32LE, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{0.5, -0.25, 1}, d.Decode(buf.Bytes()))
+}
+
+func TestNewPCMDecoder_Invalid(t *testing.T) {
+	_, err := NewPCMDecoder("u8", 1)
+	assert.Error(t, err)
+	_, err = NewPCMDecoder(PCMS16LE, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,380 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strings"
+)
+
+// Preprocessing stages, applied by Preprocess in the order given.
+const (
+	StageHighPass   = "highpass"   // Removes hum and CTCSS tones below the voice band
+	StageDeemphasis = "deemphasis" // Undoes the treble boost of FM pre-emphasis
+	StageDenoise    = "denoise"    // Spectral noise gate
+	StageLoudness   = "loudnorm"   // EBU R128 loudness normalization
+)
+
+// Stages lists the preprocessing stages in their usual order.
+var Stages = []string{StageHighPass, StageDeemphasis, StageDenoise, StageLoudness}
+
+// PreprocessOptions configures the preprocessing stages.
+type PreprocessOptions struct {
+	HighPassHz     float64 // Cutoff of the high-pass filter
+	Deemphasis     float64 // De-emphasis time constant in microseconds
+	NoiseReduction float64 // dB removed from parts of the spectrum at the noise floor
+	LoudnessTarget float64 // Integrated loudness to normalize to, in LUFS
+}
+
+// DefaultPreprocessOptions suits narrowband FM voice: CTCSS tones go up to
+// 254.1 Hz, and land mobile radio de-emphasizes at 6 dB per octave across the
+// voice band.
+var DefaultPreprocessOptions = PreprocessOptions{
+	HighPassHz:     300,
+	Deemphasis:     750,
+	NoiseReduction: 12,
+	LoudnessTarget: -23,
+}
+
+const (
+	highPassOrder   = 8    // Butterworth; 48 dB per octave
+	loudnessCeiling = -1.0 // dBFS the sample peak is kept below by normalization
+	gateFloorRank   = 0.1  // Fraction of frames assumed to be noise alone
+	gateThreshold   = 4.0  // Power over the noise floor, per bin, that opens the gate
+	gateRelease     = 0.7  // Fraction of the gain kept per frame as the gate closes
+	gateSpread      = 2    // Neighbouring bins either side averaged with each
+)
+
+// ParseStages checks a list of stage names, ignoring case and spaces. A
+// stage may appear only once.
+func ParseStages(names []string) ([]string, error) {
+	stages := make([]string, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		valid := false
+		for _, stage := range Stages {
+			valid = valid || name == stage
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown preprocessing stage %q: must be one of %s", name, strings.Join(Stages, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("preprocessing stage %q given twice", name)
+		}
+		seen[name] = true
+		stages = append(stages, name)
+	}
+	return stages, nil
+}
+
+// Preprocess applies stages, as returned by ParseStages, to mono samples in
+// place.
+func Preprocess(samples []float32, sampleRate int, stages []string, opts PreprocessOptions) {
+	for _, stage := range stages {
+		switch stage {
+		case StageHighPass:
+			HighPass(samples, sampleRate, opts.HighPassHz)
+		case StageDeemphasis:
+			Deemphasize(samples, sampleRate, opts.Deemphasis)
+		case StageDenoise:
+			Denoise(samples, sampleRate, opts.NoiseReduction)
+		case StageLoudness:
+			NormalizeLoudness(samples, sampleRate, opts.LoudnessTarget)
+		}
+	}
+}
+
+// biquad is a second-order IIR filter section in transposed direct form II.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// highPassBiquad returns a high-pass section with the given cutoff and Q,
+// from the RBJ audio EQ cookbook.
+func highPassBiquad(cutoff, q float64, sampleRate int) *biquad {
+	w := 2 * math.Pi * cutoff / float64(sampleRate)
+	alpha := math.Sin(w) / (2 * q)
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+	return &biquad{
+		b0: (1 + cos) / 2 / a0,
+		b1: -(1 + cos) / a0,
+		b2: (1 + cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+// HighPass removes content below cutoff Hz with an 8th-order Butterworth
+// filter, 12 dB down at the highest CTCSS tone with the default 300 Hz.
+func HighPass(samples []float32, sampleRate int, cutoff float64) {
+	if cutoff <= 0 || cutoff >= float64(sampleRate)/2 {
+		return
+	}
+	sections := make([]*biquad, highPassOrder/2)
+	for k := range sections {
+		q := 1 / (2 * math.Cos(math.Pi*float64(2*k+1)/(2*highPassOrder)))
+		sections[k] = highPassBiquad(cutoff, q, sampleRate)
+	}
+	for i, v := range samples {
+		x := float64(v)
+		for _, f := range sections {
+			x = f.process(x)
+		}
+		samples[i] = float32(x)
+	}
+}
+
+// Deemphasize applies a first-order low-pass with a time constant of micros
+// microseconds, the inverse of the pre-emphasis of an FM transmitter.
+func Deemphasize(samples []float32, sampleRate int, micros float64) {
+	if micros <= 0 {
+		return
+	}
+	alpha := 1 - math.Exp(-1/(micros*1e-6*float64(sampleRate)))
+	var y float64
+	for i, v := range samples {
+		y += alpha * (float64(v) - y)
+		samples[i] = float32(y)
+	}
+}
+
+// Denoise is a spectral noise gate. The noise spectrum is the average of the
+// quietest tenth of 32 ms frames; parts of the spectrum of each frame less
+// than 6 dB above it, averaged over neighbouring bins, are turned down by
+// reduction dB, closing gradually to avoid the warbling of gates that switch
+// abruptly.
+func Denoise(samples []float32, sampleRate int, reduction float64) {
+	n := 1
+	for n < int(0.032*float64(sampleRate)) {
+		n <<= 1
+	}
+	hop := n / 2
+	if reduction <= 0 || len(samples) < n {
+		return
+	}
+	frames := (len(samples)-n)/hop + 1
+
+	// Square-root Hann windows for analysis and synthesis overlap-add to a
+	// Hann window, which sums to one at half overlap
+	window := make([]float64, n)
+	for i := range window {
+		window[i] = math.Sqrt(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n)))
+	}
+	twiddle := make([]complex128, n/2)
+	for k := range twiddle {
+		twiddle[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(n)))
+	}
+	buf := make([]complex128, n)
+	spectrum := func(frame int) {
+		for i := range buf {
+			buf[i] = complex(float64(samples[frame*hop+i])*window[i], 0)
+		}
+		fft(buf, twiddle)
+	}
+
+	// The noise floor comes from the quietest frames
+	energies := make([]float64, frames)
+	for f := range energies {
+		for _, v := range samples[f*hop : f*hop+n] {
+			energies[f] += float64(v) * float64(v)
+		}
+	}
+	sorted := append([]float64(nil), energies...)
+	sort.Float64s(sorted)
+	quiet := sorted[int(gateFloorRank*float64(frames-1))]
+	noise := make([]float64, n/2+1)
+	var count float64
+	for f, e := range energies {
+		if e > quiet {
+			continue
+		}
+		spectrum(f)
+		for k := range noise {
+			noise[k] += real(buf[k])*real(buf[k]) + imag(buf[k])*imag(buf[k])
+		}
+		count++
+	}
+	for k := range noise {
+		noise[k] /= count
+	}
+	noise = smoothBins(noise)
+
+	floor := math.Pow(10, -reduction/20)
+	gains := make([]float64, n/2+1)
+	for k := range gains {
+		gains[k] = 1
+	}
+	// Frames are overlap-added from the current one on; the samples before
+	// the next frame are complete and written back, which is safe as no
+	// frame still to be analysed reads them
+	power := make([]float64, n/2+1)
+	out := make([]float64, n)
+	weight := make([]float64, n)
+	flush := func(start int) {
+		for i := 0; i < hop; i++ {
+			// Only the ends lack a full overlap
+			if weight[i] > 1e-3 {
+				samples[start+i] = float32(out[i] / weight[i])
+			}
+		}
+		copy(out, out[hop:])
+		copy(weight, weight[hop:])
+		clear(out[n-hop:])
+		clear(weight[n-hop:])
+	}
+	for f := 0; f < frames; f++ {
+		spectrum(f)
+		for k := range power {
+			power[k] = real(buf[k])*real(buf[k]) + imag(buf[k])*imag(buf[k])
+		}
+		power = smoothBins(power)
+		for k := range gains {
+			target := floor
+			if power[k] >= gateThreshold*noise[k] {
+				target = 1
+			}
+			gains[k] = max(target, gains[k]*gateRelease)
+		}
+
+		// Inverse FFT of the real spectrum by conjugation
+		for k := range buf {
+			g := gains[min(k, n-k)]
+			buf[k] = cmplx.Conj(buf[k] * complex(g, 0))
+		}
+		fft(buf, twiddle)
+		for i := range buf {
+			out[i] += real(buf[i]) / float64(n) * window[i]
+			weight[i] += window[i] * window[i]
+		}
+		flush(f * hop)
+	}
+	// Past the last frame nothing was analysed and the samples are left alone
+	flush(frames * hop)
+}
+
+// smoothBins averages each bin of a power spectrum with its neighbours, so
+// that the chance peaks of noise in single bins do not open the gate.
+func smoothBins(power []float64) []float64 {
+	smoothed := make([]float64, len(power))
+	for k := range power {
+		from, to := max(k-gateSpread, 0), min(k+gateSpread+1, len(power))
+		for _, p := range power[from:to] {
+			smoothed[k] += p
+		}
+		smoothed[k] /= float64(to - from)
+	}
+	return smoothed
+}
+
+// kWeighting returns the two-stage K-weighting filter of ITU-R BS.1770 at
+// sampleRate: a high shelf for the head and a high-pass for low frequencies.
+func kWeighting(sampleRate int) [2]*biquad {
+	// Coefficients from the analog prototypes, as libebur128 derives them
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / float64(sampleRate))
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := &biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / float64(sampleRate))
+	a0 = 1 + k/q + k*k
+	highPass := &biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return [2]*biquad{shelf, highPass}
+}
+
+// Loudness measures the integrated loudness of mono samples in LUFS, as in
+// EBU R128: the K-weighted power of 400 ms blocks every 100 ms, gated at -70
+// LUFS and then 10 LU below the loudness of the blocks that remain. Silence
+// measures -Inf.
+func Loudness(samples []float32, sampleRate int) float64 {
+	// The K-weighted energy of each 100 ms step; a block is four of them
+	filters := kWeighting(sampleRate)
+	step := max(int(0.1*float64(sampleRate)), 1)
+	var steps []float64
+	var energy, total float64
+	for i, v := range samples {
+		x := filters[1].process(filters[0].process(float64(v)))
+		energy += x * x
+		if (i+1)%step == 0 {
+			steps = append(steps, energy)
+			total += energy
+			energy = 0
+		}
+	}
+
+	var powers []float64
+	if len(steps) < 4 && len(samples) > 0 {
+		// Shorter than a block: measure all of it as one
+		powers = append(powers, (total+energy)/float64(len(samples)))
+	}
+	for i := 0; i+4 <= len(steps); i++ {
+		powers = append(powers, (steps[i]+steps[i+1]+steps[i+2]+steps[i+3])/float64(4*step))
+	}
+
+	loudness := func(power float64) float64 { return -0.691 + 10*math.Log10(power) }
+	gated := func(threshold float64) float64 {
+		var sum float64
+		var count int
+		for _, p := range powers {
+			if loudness(p) > threshold {
+				sum += p
+				count++
+			}
+		}
+		if count == 0 {
+			return 0
+		}
+		return sum / float64(count)
+	}
+	absolute := gated(-70)
+	if absolute == 0 {
+		return math.Inf(-1)
+	}
+	return loudness(gated(loudness(absolute) - 10))
+}
+
+// NormalizeLoudness scales mono samples to an integrated loudness of target
+// LUFS, or as near as keeping the peak below -1 dBFS allows. Silence is left
+// alone.
+func NormalizeLoudness(samples []float32, sampleRate int, target float64) {
+	measured := Loudness(samples, sampleRate)
+	if math.IsInf(measured, -1) {
+		return
+	}
+	var peak float64
+	for _, v := range samples {
+		peak = max(peak, math.Abs(float64(v)))
+	}
+	gain := min(math.Pow(10, (target-measured)/20), math.Pow(10, loudnessCeiling/20)/peak)
+	for i, v := range samples {
+		samples[i] = float32(float64(v) * gain)
+	}
+}
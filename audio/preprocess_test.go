@@ -0,0 +1,95 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sineWave returns seconds of a tone at the given peak amplitude.
+func sineWave(freq, amplitude, seconds float64, sampleRate int) []float32 {
+	samples := make([]float32, int(seconds*float64(sampleRate)))
+	for i := range samples {
+		samples[i] = float32(amplitude * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate)))
+	}
+	return samples
+}
+
+// rmsDB returns the RMS level of samples in dBFS.
+func rmsDB(samples []float32) float64 {
+	var sum float64
+	for _, v := range samples {
+		sum += float64(v) * float64(v)
+	}
+	return 10 * math.Log10(sum/float64(len(samples)))
+}
+
+func TestParseStages(t *testing.T) {
+	stages, err := ParseStages([]string{" HighPass", "loudnorm"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{StageHighPass, StageLoudness}, stages)
+
+	_, err = ParseStages([]string{"reverb"})
+	assert.ErrorContains(t, err, "unknown preprocessing stage")
+	_, err = ParseStages([]string{"denoise", "denoise"})
+	assert.ErrorContains(t, err, "twice")
+}
+
+func TestHighPass(t *testing.T) {
+	// A CTCSS tone is removed and the voice band kept; the first 100 ms
+	// lets the filter settle
+	ctcss := sineWave(100, 0.5, 1, 16000)
+	before := rmsDB(ctcss[1600:])
+	HighPass(ctcss, 16000, 300)
+	assert.Less(t, rmsDB(ctcss[1600:]), before-60)
+
+	voice := sineWave(1000, 0.5, 1, 16000)
+	before = rmsDB(voice[1600:])
+	HighPass(voice, 16000, 300)
+	assert.InDelta(t, before, rmsDB(voice[1600:]), 0.1)
+}
+
+func TestDeemphasize(t *testing.T) {
+	// 6 dB per octave above the 212 Hz corner of 750 µs
+	low, high := sineWave(300, 0.5, 1, 16000), sineWave(3000, 0.5, 1, 16000)
+	Deemphasize(low, 16000, 750)
+	Deemphasize(high, 16000, 750)
+	assert.InDelta(t, 18.2, rmsDB(low[1600:])-rmsDB(high[1600:]), 0.5)
+}
+
+func TestDenoise(t *testing.T) {
+	// Hiss between words is turned down and the words kept
+	samples := vadSignal(4, 0.01)
+	addTone(samples, 1, 3)
+	hiss, speech := rmsDB(samples[1600:12000]), rmsDB(samples[20000:44000])
+	Denoise(samples, 16000, 12)
+	assert.InDelta(t, hiss-12, rmsDB(samples[1600:12000]), 1.5)
+	assert.InDelta(t, speech, rmsDB(samples[20000:44000]), 0.5)
+}
+
+func TestLoudness(t *testing.T) {
+	// BS.1770: a 1 kHz sine at full scale measures -3.01 LUFS
+	for _, rate := range []int{16000, 48000} {
+		assert.InDelta(t, -23.01, Loudness(sineWave(997, 0.1, 2, rate), rate), 0.1, "%d Hz", rate)
+	}
+	assert.True(t, math.IsInf(Loudness(make([]float32, 16000), 16000), -1))
+
+	// Quiet speech is raised to the target
+	samples := sineWave(997, 0.01, 2, 16000)
+	NormalizeLoudness(samples, 16000, -23)
+	assert.InDelta(t, -23.0, Loudness(samples, 16000), 0.1)
+
+	// unless that would push the peaks past -1 dBFS
+	samples = sineWave(997, 0.01, 2, 16000)
+	samples[8000] = 0.5
+	NormalizeLoudness(samples, 16000, -10)
+	assert.InDelta(t, math.Pow(10, -1.0/20), samples[8000], 1e-6)
+
+	silence := make([]float32, 16000)
+	NormalizeLoudness(silence, 16000, -23)
+	assert.Equal(t, make([]float32, 16000), silence)
+}
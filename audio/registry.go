@@ -0,0 +1,120 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registration describes an audio format: how to recognize it and how to
+// create its handler. Formats register themselves from init functions, and
+// other packages may register their own formats the same way.
+type Registration struct {
+	Name       string   // Unique name, e.g. "wav" or "opus"
+	Container  string   // One of the Container constants, or a custom container name
+	Codec      string   // Codec inside the container
+	MIMETypes  []string // MIME types clients may send for this format
+	Extensions []string // Lowercase file extensions, including the dot
+
+	// Sniff reports whether the leading bytes of a file (after any ID3v2 tags)
+	// are this format.
+	Sniff func(header []byte) bool
+
+	// New creates the handler. It is nil for formats that are recognized but
+	// have no handler at all.
+	New func() Format
+
+	// Decodable reports whether the handler can convert audio to samples in
+	// this build; Note says why not when it cannot.
+	Decodable bool
+	Note      string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []*Registration
+)
+
+// Register adds a format to the registry. It panics if the name is empty,
+// already registered or the registration has no Sniff function.
+func Register(r Registration) {
+	if r.Name == "" || r.Sniff == nil {
+		panic("audio: Register requires a name and a Sniff function")
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, existing := range registry {
+		if existing.Name == r.Name {
+			panic(fmt.Sprintf("audio: format %q registered twice", r.Name))
+		}
+	}
+	registry = append(registry, &r)
+}
+
+// Formats returns all registered formats sorted by name.
+func Formats() []Registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	formats := make([]Registration, len(registry))
+	for i, r := range registry {
+		formats[i] = *r
+	}
+	sort.Slice(formats, func(i, j int) bool { return formats[i].Name < formats[j].Name })
+	return formats
+}
+
+// Lookup returns the registered format with the given name.
+func Lookup(name string) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, r := range registry {
+		if r.Name == name {
+			return *r, true
+		}
+	}
+	return Registration{}, false
+}
+
+// sniffRegistered returns the first registered format that recognizes header.
+func sniffRegistered(header []byte) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, r := range registry {
+		if r.Sniff(header) {
+			return *r, true
+		}
+	}
+	return Registration{}, false
+}
+
+// formatsForExtension returns the registered formats that use an extension.
+func formatsForExtension(ext string) []Registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var formats []Registration
+	for _, r := range registry {
+		if r.hasExtension(ext) {
+			formats = append(formats, *r)
+		}
+	}
+	return formats
+}
+
+func (r Registration) hasExtension(ext string) bool {
+	for _, e := range r.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Registration) detection() Detection {
+	return Detection{Format: r.Name, Container: r.Container, Codec: r.Codec}
+}
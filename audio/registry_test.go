@@ -0,0 +1,76 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rawFormat is a custom format as another package might register one.
+type rawFormat struct{}
+
+func (f *rawFormat) GetMetadata(filename string, fileSize int64) (AudioMetadata, error) {
+	return AudioMetadata{Format: "RAW", OriginalSize: fileSize}, nil
+}
+
+func (f *rawFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
+	return nil, nil
+}
+
+func TestRegistry_Builtins(t *testing.T) {
+	formats := Formats()
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = f.Name
+	}
+	assert.True(t, sort.StringsAreSorted(names))
+	for _, name := range []string{"wav", "flac", "mp3", "aac", "m4a", "vorbis", "opus", "mp2", "ogg-flac", "speex", "webm", "matroska"} {
+		assert.Contains(t, names, name)
+	}
+
+	wav, ok := Lookup("wav")
+	assert.True(t, ok)
+	assert.True(t, wav.Decodable)
+	assert.Contains(t, wav.MIMETypes, "audio/wav")
+	assert.Equal(t, []string{".wav"}, wav.Extensions)
+
+	// Recognized formats without a handler say why
+	speex, _ := Lookup("speex")
+	assert.Nil(t, speex.New)
+	assert.False(t, speex.Decodable)
+	assert.NotEmpty(t, speex.Note)
+
+	_, ok = Lookup("nonexistent")
+	assert.False(t, ok)
+}
+
+func TestRegister_CustomFormat(t *testing.T) {
+	Register(Registration{
+		Name:       "test-raw",
+		Container:  "RAW",
+		Codec:      "PCM",
+		Extensions: []string{".raw"},
+		Sniff:      func(h []byte) bool { return bytes.HasPrefix(h, []byte("RAW!")) },
+		New:        func() Format { return &rawFormat{} },
+		Decodable:  true,
+	})
+
+	path := filepath.Join(t.TempDir(), "capture.dat")
+	assert.NoError(t, os.WriteFile(path, []byte("RAW!\x00\x00"), 0644))
+
+	format, d, err := Detect(path)
+	assert.NoError(t, err)
+	assert.IsType(t, &rawFormat{}, format)
+	assert.Equal(t, "test-raw", d.Format)
+	assert.Equal(t, "RAW/PCM", d.String())
+
+	assert.Panics(t, func() { Register(Registration{Name: "test-raw", Sniff: func([]byte) bool { return false }}) })
+	assert.Panics(t, func() { Register(Registration{Name: "no-sniff"}) })
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ResampleQuality selects the trade-off between speed and fidelity of the
+// resampler's anti-aliasing filter.
+type ResampleQuality int
+
+const (
+	// ResampleLow uses a short filter with about 60 dB of stopband attenuation.
+	ResampleLow ResampleQuality = iota
+	// ResampleMedium uses a filter with about 80 dB of stopband attenuation.
+	ResampleMedium
+	// ResampleHigh uses a long filter with about 100 dB of stopband
+	// attenuation and the narrowest transition band.
+	ResampleHigh
+)
+
+// DefaultResampleQuality is the quality used by Resample, ResampleAudio and
+// every Format.
+var DefaultResampleQuality = ResampleMedium
+
+// resampleFilter describes the Kaiser-windowed sinc filter of a quality
+// level. The cutoff, as a fraction of the lower Nyquist frequency, places the
+// end of the transition band at that frequency, so nothing above it aliases
+// back below it by more than the stopband allows.
+type resampleFilter struct {
+	zeroCrossings int     // Zero crossings of the sinc on each side
+	beta          float64 // Kaiser window shape
+	cutoff        float64
+}
+
+var resampleFilters = map[ResampleQuality]resampleFilter{
+	ResampleLow:    {zeroCrossings: 8, beta: 5.65, cutoff: 0.81},
+	ResampleMedium: {zeroCrossings: 24, beta: 7.86, cutoff: 0.90},
+	ResampleHigh:   {zeroCrossings: 48, beta: 10.06, cutoff: 0.93},
+}
+
+// ParseResampleQuality parses a quality name: "low", "medium" or "high". An
+// empty name selects DefaultResampleQuality.
+func ParseResampleQuality(name string) (ResampleQuality, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return DefaultResampleQuality, nil
+	case "low":
+		return ResampleLow, nil
+	case "medium":
+		return ResampleMedium, nil
+	case "high":
+		return ResampleHigh, nil
+	}
+	return 0, fmt.Errorf("invalid resample quality %q: must be low, medium or high", name)
+}
+
+func (q ResampleQuality) String() string {
+	switch q {
+	case ResampleLow:
+		return "low"
+	case ResampleMedium:
+		return "medium"
+	case ResampleHigh:
+		return "high"
+	}
+	return fmt.Sprintf("ResampleQuality(%d)", int(q))
+}
+
+// resampleMaxPhases bounds the filter table. Rate pairs whose reduced ratio
+// needs more phases, such as 44100 to 16001, interpolate between the nearest
+// two.
+const resampleMaxPhases = 1024
+
+// Resampler converts audio between sample rates with a polyphase
+// windowed-sinc filter. Input may be passed in chunks of any size: the
+// output does not depend on how it is split, and after Flush its length is
+// exactly ceil(n*dstRate/srcRate) for n input samples.
+type Resampler struct {
+	up, down int       // Output samples for every down input samples, in lowest terms
+	half     int       // Taps on each side of the output position
+	phases   int       // Rows in the filter table, less one
+	table    []float32 // phases+1 rows of 2*half taps
+
+	in     []float32 // Input from index base on, with zeros before the start
+	base   int64
+	total  int64 // Input samples received
+	out    int64 // Output samples produced
+	closed bool
+}
+
+// NewResampler returns a Resampler from srcRate to dstRate. If the rates are
+// equal or either is unknown, samples pass through unchanged.
+func NewResampler(srcRate, dstRate int, quality ResampleQuality) *Resampler {
+	if srcRate == dstRate || srcRate <= 0 || dstRate <= 0 {
+		return &Resampler{}
+	}
+	g := gcd(srcRate, dstRate)
+	r := &Resampler{up: dstRate / g, down: srcRate / g}
+
+	filter, ok := resampleFilters[quality]
+	if !ok {
+		filter = resampleFilters[DefaultResampleQuality]
+	}
+	// In input samples: the cutoff frequency and the filter's half length
+	cutoff := filter.cutoff * min(1, float64(r.up)/float64(r.down))
+	width := float64(filter.zeroCrossings) / cutoff
+	r.half = int(math.Ceil(width))
+	r.phases = min(r.up, resampleMaxPhases)
+
+	taps := 2 * r.half
+	r.table = make([]float32, (r.phases+1)*taps)
+	norm := besselI0(filter.beta)
+	for p := 0; p <= r.phases; p++ {
+		frac := float64(p) / float64(r.phases)
+		row := r.table[p*taps : (p+1)*taps]
+		var sum float64
+		coefs := make([]float64, taps)
+		for t := range coefs {
+			// Distance from the output position to input sample idx-half+1+t
+			x := float64(t-r.half+1) - frac
+			if math.Abs(x) >= width {
+				continue
+			}
+			w := besselI0(filter.beta*math.Sqrt(1-(x/width)*(x/width))) / norm
+			coefs[t] = cutoff * sinc(cutoff*x) * w
+			sum += coefs[t]
+		}
+		// Each phase passes DC unchanged
+		for t, c := range coefs {
+			row[t] = float32(c / sum)
+		}
+	}
+
+	r.in = make([]float32, r.half-1)
+	r.base = -int64(r.half - 1)
+	return r
+}
+
+// sinc is the normalized sinc function.
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Process resamples the next chunk of input, returning the output samples
+// that can be computed so far. The filter looks ahead, so output lags the
+// input by a few milliseconds until Flush.
+func (r *Resampler) Process(samples []float32) []float32 {
+	if r.table == nil {
+		r.total += int64(len(samples))
+		return append([]float32(nil), samples...)
+	}
+	r.in = append(r.in, samples...)
+	r.total += int64(len(samples))
+	return r.emit(false)
+}
+
+// Flush returns the rest of the output, treating the input as ending with
+// the samples passed so far. The Resampler must not be used afterwards.
+func (r *Resampler) Flush() []float32 {
+	if r.table == nil || r.closed {
+		return nil
+	}
+	r.closed = true
+	r.in = append(r.in, make([]float32, r.half+1)...)
+	return r.emit(true)
+}
+
+func (r *Resampler) emit(flush bool) []float32 {
+	taps := 2 * r.half
+	// Output samples due by the end of the input
+	end := (r.total*int64(r.up) + int64(r.down) - 1) / int64(r.down)
+
+	var out []float32
+	for r.out < end {
+		pos := r.out * int64(r.down)
+		idx := pos / int64(r.up)
+		if !flush && idx+int64(r.half) >= r.total {
+			break
+		}
+
+		window := r.in[idx-int64(r.half-1)-r.base:][:taps]
+		var v float32
+		if r.phases == r.up {
+			v = dot(window, r.table[int(pos%int64(r.up))*taps:][:taps])
+		} else {
+			phase := float64(pos%int64(r.up)) * float64(r.phases) / float64(r.up)
+			p := int(phase)
+			v0 := dot(window, r.table[p*taps:][:taps])
+			v1 := dot(window, r.table[(p+1)*taps:][:taps])
+			v = v0 + float32(phase-float64(p))*(v1-v0)
+		}
+		out = append(out, v)
+		r.out++
+	}
+
+	// Drop input no longer needed by the next output sample
+	next := r.out * int64(r.down) / int64(r.up)
+	if drop := int(next - int64(r.half-1) - r.base); drop > len(r.in)/2 {
+		r.in = append(r.in[:0], r.in[drop:]...)
+		r.base += int64(drop)
+	}
+	return out
+}
+
+// dot returns the dot product of a and b, which have the same length.
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i, v := range a {
+		sum += v * b[i]
+	}
+	return sum
+}
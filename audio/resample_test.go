@@ -0,0 +1,128 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tone returns one second of a sine wave at freq with amplitude 0.5.
+func tone(freq float64, rate int) []float32 {
+	samples := make([]float32, rate)
+	for i := range samples {
+		samples[i] = float32(0.5 * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+	}
+	return samples
+}
+
+// levelDB returns the RMS level of samples relative to the tone amplitude,
+// ignoring the first and last tenth where the filter meets the edges.
+func levelDB(samples []float32) float64 {
+	edge := len(samples) / 10
+	var sum float64
+	for _, v := range samples[edge : len(samples)-edge] {
+		sum += float64(v) * float64(v)
+	}
+	rms := math.Sqrt(sum / float64(len(samples)-2*edge))
+	return 20 * math.Log10(rms/(0.5/math.Sqrt2))
+}
+
+func TestResampler_AliasingRejection(t *testing.T) {
+	tests := []struct {
+		quality     ResampleQuality
+		attenuation float64 // Minimum rejection of tones above the output Nyquist
+		passband    float64 // Highest frequency passed within 0.1 dB at 16 kHz
+	}{
+		{ResampleLow, 55, 4000},
+		{ResampleMedium, 75, 6000},
+		{ResampleHigh, 95, 7000},
+	}
+	for _, tt := range tests {
+		for _, rate := range []int{44100, 48000} {
+			// Tones above 8 kHz would alias into the speech band
+			for _, freq := range []float64{9000, 12000, 15000, 20000} {
+				r := NewResampler(rate, 16000, tt.quality)
+				out := append(r.Process(tone(freq, rate)), r.Flush()...)
+				assert.Less(t, levelDB(out), -tt.attenuation, "%s quality, %d Hz tone at %d Hz", tt.quality, int(freq), rate)
+			}
+			for _, freq := range []float64{1000, tt.passband} {
+				r := NewResampler(rate, 16000, tt.quality)
+				out := append(r.Process(tone(freq, rate)), r.Flush()...)
+				assert.InDelta(t, 0, levelDB(out), 0.1, "%s quality, %d Hz tone at %d Hz", tt.quality, int(freq), rate)
+			}
+		}
+	}
+
+	// Linear interpolation, for comparison, lets a 12 kHz tone through at
+	// close to full level
+	input := tone(12000, 48000)
+	linear := make([]float32, len(input)/3)
+	for i := range linear {
+		linear[i] = input[3*i]
+	}
+	assert.Greater(t, levelDB(linear), -20.0)
+}
+
+func TestResampler_Upsampling(t *testing.T) {
+	// Passband tones keep their level, and no images appear above the input
+	// Nyquist: a 1 kHz tone has nothing left once a 1 kHz tone is removed
+	out := ResampleAudio(tone(1000, 8000), 8000, 16000)
+	assert.Len(t, out, 16000)
+	assert.InDelta(t, 0, levelDB(out), 0.1)
+
+	residual := make([]float32, len(out))
+	reference := tone(1000, 16000)
+	for i := range out {
+		residual[i] = out[i] - reference[i]
+	}
+	assert.Less(t, levelDB(residual), -60.0)
+}
+
+func TestResampler_StreamingAndLength(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	input := make([]float32, 20000)
+	for i := range input {
+		input[i] = float32(rng.NormFloat64() * 0.1)
+	}
+
+	for _, rates := range [][2]int{{48000, 16000}, {44100, 16000}, {8000, 16000}, {44100, 16001}, {16000, 16000}} {
+		src, dst := rates[0], rates[1]
+		for _, n := range []int{0, 1, 999, len(input)} {
+			r := NewResampler(src, dst, ResampleMedium)
+			whole := append(r.Process(input[:n]), r.Flush()...)
+			// Exactly one output sample for each output period that starts
+			// within the input
+			assert.Len(t, whole, int((int64(n)*int64(dst)+int64(src)-1)/int64(src)), "%d to %d, %d samples", src, dst, n)
+		}
+
+		r := NewResampler(src, dst, ResampleMedium)
+		whole := append(r.Process(input), r.Flush()...)
+
+		// Any split of the input gives the same output
+		r = NewResampler(src, dst, ResampleMedium)
+		var chunked []float32
+		for rest := input; len(rest) > 0; {
+			n := min(rng.Intn(3000), len(rest))
+			chunked = append(chunked, r.Process(rest[:n])...)
+			rest = rest[n:]
+		}
+		chunked = append(chunked, r.Flush()...)
+		assert.Equal(t, whole, chunked, "%d to %d", src, dst)
+	}
+}
+
+func TestParseResampleQuality(t *testing.T) {
+	for name, want := range map[string]ResampleQuality{"low": ResampleLow, "Medium": ResampleMedium, "high": ResampleHigh, "": DefaultResampleQuality} {
+		q, err := ParseResampleQuality(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, want, q, name)
+	}
+	_, err := ParseResampleQuality("best")
+	assert.Error(t, err)
+	assert.Equal(t, "high", ResampleHigh.String())
+}
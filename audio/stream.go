@@ -0,0 +1,479 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// streamChunk is the number of samples decoders aim to produce per block.
+const streamChunk = 4096
+
+// SampleStream yields mono float32 samples in chunks as they are decoded.
+type SampleStream interface {
+	// Read fills p with up to len(p) samples and returns the number read. It
+	// returns io.EOF once the stream is exhausted.
+	Read(p []float32) (int, error)
+	Close() error
+}
+
+// StreamFormat is implemented by formats that can decode incrementally from a
+// reader instead of a file on disk.
+type StreamFormat interface {
+	Format
+	// Decode reads the stream header from r and returns the metadata known from
+	// it and a stream of mono samples at the native sample rate given in the
+	// metadata. Durations may be zero when r is not seekable and the header
+	// does not record the length.
+	Decode(r io.Reader) (SampleStream, AudioMetadata, error)
+}
+
+// ChannelFormat is implemented by stream formats that can keep the channels
+// of multi-channel audio apart.
+type ChannelFormat interface {
+	StreamFormat
+	// DecodeChannels is like Decode, but the stream yields the samples of all
+	// metadata.Channels channels, interleaved, instead of their mono mix.
+	DecodeChannels(r io.Reader) (SampleStream, AudioMetadata, error)
+}
+
+// Decode identifies the format of the audio in r from its content and returns
+// its metadata and a stream of mono samples resampled to targetSampleRate.
+// Samples are decoded as the stream is read, so r may be an upload or a
+// network stream that is still arriving.
+func Decode(r io.Reader, targetSampleRate int) (SampleStream, AudioMetadata, error) {
+	return DecodeNamed(r, "", targetSampleRate)
+}
+
+// DecodeNamed is Decode for audio uploaded under name. As with Detect, the
+// name's extension is a hint used only when the content is not recognized, and
+// a contradicting extension is reported in the metadata.
+func DecodeNamed(r io.Reader, name string, targetSampleRate int) (SampleStream, AudioMetadata, error) {
+	return decodeNamed(r, name, targetSampleRate, false)
+}
+
+// DecodeNamedChannels is DecodeNamed keeping the channels apart: the stream
+// yields interleaved samples of metadata.Channels channels, each resampled to
+// targetSampleRate. It fails for formats that do not implement ChannelFormat.
+func DecodeNamedChannels(r io.Reader, name string, targetSampleRate int) (SampleStream, AudioMetadata, error) {
+	return decodeNamed(r, name, targetSampleRate, true)
+}
+
+func decodeNamed(r io.Reader, name string, targetSampleRate int, channels bool) (SampleStream, AudioMetadata, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+
+	r, d, sniffed, size, err := openInput(r)
+	if err != nil {
+		return nil, AudioMetadata{}, fmt.Errorf("failed to read audio: %v", err)
+	}
+
+	reg, d, err := resolveFormat(d, sniffed, ext)
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+	if !reg.Decodable {
+		return nil, AudioMetadata{}, fmt.Errorf("unsupported audio format: %s: %s", d, reg.Note)
+	}
+
+	format := reg.New()
+	var stream SampleStream
+	var metadata AudioMetadata
+	rate := 0 // Sample rate of stream when it is not metadata.SampleRate
+	if channels {
+		cf, ok := format.(ChannelFormat)
+		if !ok {
+			return nil, AudioMetadata{}, fmt.Errorf("%s audio cannot be decoded channel by channel", reg.Name)
+		}
+		stream, metadata, err = cf.DecodeChannels(r)
+	} else if sf, ok := format.(StreamFormat); ok {
+		stream, metadata, err = sf.Decode(r)
+	} else {
+		stream, metadata, err = decodeViaFile(format, r, ext, targetSampleRate)
+		// The samples are already at the target rate
+		rate = targetSampleRate
+	}
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+
+	if size > 0 {
+		metadata.OriginalSize = size
+		if metadata.Bitrate == 0 && metadata.Duration > 0 {
+			metadata.Bitrate = int(float64(size*8) / metadata.Duration / 1000)
+		}
+	}
+	if d.Mismatch {
+		metadata.Extension = d.Extension
+		metadata.ExtensionMismatch = true
+	}
+	if channels {
+		return ResampleChannels(stream, metadata.Channels, metadata.SampleRate, targetSampleRate), metadata, nil
+	}
+	if rate == 0 {
+		rate = metadata.SampleRate
+	}
+	return Resample(stream, rate, targetSampleRate), metadata, nil
+}
+
+// openInput identifies the format of the audio in r and returns the reader the
+// decoder should consume, positioned at the start of the audio, along with the
+// input size if it is known. Files and other random-access inputs stay seekable
+// so decoders can find the length.
+func openInput(r io.Reader) (io.Reader, Detection, bool, int64, error) {
+	rs, seekable := r.(io.ReadSeeker)
+	ra, random := r.(io.ReaderAt)
+	if !seekable || !random {
+		br, d, sniffed, err := peekFormat(r)
+		return br, d, sniffed, 0, err
+	}
+
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, Detection{}, false, 0, err
+	}
+	d, sniffed, offset, err := detectAt(rs)
+	if err != nil {
+		return nil, Detection{}, false, 0, err
+	}
+	// A section hides leading ID3v2 tags from decoders that seek to the start
+	return io.NewSectionReader(ra, offset, size-offset), d, sniffed, size, nil
+}
+
+// peekFormat identifies the format of a non-seekable stream by peeking at its
+// first bytes, discarding any ID3v2 tags. The returned reader continues from
+// the start of the audio.
+func peekFormat(r io.Reader) (*bufio.Reader, Detection, bool, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	for {
+		header, err := br.Peek(sniffLen)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, Detection{}, false, err
+		}
+		size := id3Size(header)
+		if size == 0 {
+			d, ok := Sniff(header)
+			return br, d, ok, nil
+		}
+		if _, err := br.Discard(int(size)); err != nil {
+			return br, Detection{}, false, nil
+		}
+	}
+}
+
+// decodeViaFile decodes a format that only works on files by spooling r to a
+// temporary file. The stream is at targetSampleRate; the metadata reports the
+// rate of the source.
+func decodeViaFile(format Format, r io.Reader, ext string, targetSampleRate int) (SampleStream, AudioMetadata, error) {
+	tmp, err := os.CreateTemp("", "audio-*"+ext)
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return nil, AudioMetadata{}, fmt.Errorf("failed to read audio: %v", err)
+	}
+
+	metadata, err := format.GetMetadata(tmp.Name(), size)
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+	samples, err := format.ConvertToSamples(tmp.Name(), targetSampleRate)
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+	return NewSliceStream(samples), metadata, nil
+}
+
+// ReadAll reads a stream to the end and closes it.
+func ReadAll(s SampleStream) ([]float32, error) {
+	defer s.Close()
+
+	var samples []float32
+	buf := make([]float32, streamChunk)
+	for {
+		n, err := s.Read(buf)
+		samples = append(samples, buf[:n]...)
+		if err == io.EOF {
+			return samples, nil
+		}
+		if err != nil {
+			return samples, err
+		}
+	}
+}
+
+// ReadAtMost reads up to n samples from s and closes it, reporting whether the
+// stream held more. Decoding stops as soon as the limit is passed.
+func ReadAtMost(s SampleStream, n int) ([]float32, bool, error) {
+	defer s.Close()
+
+	var samples []float32
+	buf := make([]float32, streamChunk)
+	for {
+		m, err := s.Read(buf)
+		samples = append(samples, buf[:m]...)
+		if len(samples) > n {
+			return samples[:n], true, nil
+		}
+		if err == io.EOF {
+			return samples, false, nil
+		}
+		if err != nil {
+			return samples, false, err
+		}
+	}
+}
+
+// Deinterleave splits interleaved samples into one slice per channel,
+// dropping a partial frame at the end.
+func Deinterleave(samples []float32, channels int) [][]float32 {
+	frames := len(samples) / channels
+	out := make([][]float32, channels)
+	for c := range out {
+		out[c] = make([]float32, frames)
+		for i := range out[c] {
+			out[c][i] = samples[i*channels+c]
+		}
+	}
+	return out
+}
+
+// Downmix returns a stream that mixes the interleaved channels of s to mono.
+func Downmix(s SampleStream, channels int) SampleStream {
+	if channels <= 1 {
+		return s
+	}
+	buffer := make([]float32, streamChunk*channels)
+	var pending int // Samples of a partial frame left at the start of buffer
+	return &blockStream{
+		next: func() ([]float32, error) {
+			n, err := s.Read(buffer[pending:])
+			n += pending
+			frames := n / channels
+			block := ConvertToMono(buffer[:frames*channels], channels)
+			pending = copy(buffer, buffer[frames*channels:n])
+			return block, err
+		},
+		close: s.Close,
+	}
+}
+
+// downmixed mixes the stream returned by a DecodeChannels method to mono, for
+// the Decode method of the same format.
+func downmixed(s SampleStream, metadata AudioMetadata, err error) (SampleStream, AudioMetadata, error) {
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+	return Downmix(s, metadata.Channels), metadata, nil
+}
+
+// sliceStream is a SampleStream over samples already in memory.
+type sliceStream struct {
+	samples []float32
+}
+
+// NewSliceStream returns a SampleStream that yields samples.
+func NewSliceStream(samples []float32) SampleStream {
+	return &sliceStream{samples: samples}
+}
+
+func (s *sliceStream) Read(p []float32) (int, error) {
+	if len(s.samples) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, s.samples)
+	s.samples = s.samples[n:]
+	return n, nil
+}
+
+func (s *sliceStream) Close() error {
+	return nil
+}
+
+// blockStream adapts a decoder that produces a block of samples at a time to
+// a SampleStream.
+type blockStream struct {
+	next  func() ([]float32, error) // Returns the next block, or io.EOF
+	close func() error
+	buf   []float32
+	err   error
+}
+
+func (s *blockStream) Read(p []float32) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.buf, s.err = s.next()
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *blockStream) Close() error {
+	if s.close != nil {
+		return s.close()
+	}
+	return nil
+}
+
+// resampleStream converts a stream of interleaved channels between sample
+// rates with a Resampler per channel, which carries its state across reads
+// so chunk boundaries do not affect the output.
+type resampleStream struct {
+	src        SampleStream
+	channels   int
+	resamplers []*Resampler
+
+	in  []float32 // Input not yet resampled, at most a partial frame between reads
+	out []float32 // Resampled samples not yet returned
+	buf []float32
+	eof bool
+}
+
+// Resample returns a stream that converts s from srcRate to dstRate with
+// DefaultResampleQuality. It returns s unchanged if the rates are equal or
+// either is unknown.
+func Resample(s SampleStream, srcRate, dstRate int) SampleStream {
+	return ResampleWithQuality(s, srcRate, dstRate, DefaultResampleQuality)
+}
+
+// ResampleWithQuality is like Resample with the given filter quality.
+func ResampleWithQuality(s SampleStream, srcRate, dstRate int, quality ResampleQuality) SampleStream {
+	return newResampleStream(s, 1, srcRate, dstRate, quality)
+}
+
+// ResampleChannels is like Resample for a stream of interleaved samples of
+// the given number of channels, each of which is resampled separately.
+func ResampleChannels(s SampleStream, channels, srcRate, dstRate int) SampleStream {
+	return newResampleStream(s, max(channels, 1), srcRate, dstRate, DefaultResampleQuality)
+}
+
+func newResampleStream(s SampleStream, channels, srcRate, dstRate int, quality ResampleQuality) SampleStream {
+	if srcRate == dstRate || srcRate <= 0 || dstRate <= 0 {
+		return s
+	}
+	rs := &resampleStream{src: s, channels: channels, buf: make([]float32, streamChunk*channels)}
+	for c := 0; c < channels; c++ {
+		rs.resamplers = append(rs.resamplers, NewResampler(srcRate, dstRate, quality))
+	}
+	return rs
+}
+
+func (s *resampleStream) Read(p []float32) (int, error) {
+	for len(s.out) == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+		m, err := s.src.Read(s.buf)
+		s.in = append(s.in, s.buf[:m]...)
+		if err == io.EOF {
+			s.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+		s.resample()
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// resample passes the complete frames of input to the resamplers, flushing
+// them at the end of the stream, and queues their interleaved output.
+func (s *resampleStream) resample() {
+	if s.channels == 1 {
+		s.out = append(s.out, s.resamplers[0].Process(s.in)...)
+		s.in = s.in[:0]
+		if s.eof {
+			s.out = append(s.out, s.resamplers[0].Flush()...)
+		}
+		return
+	}
+
+	frames := len(s.in) / s.channels
+	outputs := make([][]float32, s.channels)
+	channel := make([]float32, frames)
+	for c, r := range s.resamplers {
+		for i := range channel {
+			channel[i] = s.in[i*s.channels+c]
+		}
+		outputs[c] = r.Process(channel)
+		if s.eof {
+			outputs[c] = append(outputs[c], r.Flush()...)
+		}
+	}
+	s.in = append(s.in[:0], s.in[frames*s.channels:]...)
+
+	// Every channel has had the same input, so has the same output length
+	for i := range outputs[0] {
+		for _, output := range outputs {
+			s.out = append(s.out, output[i])
+		}
+	}
+}
+
+func (s *resampleStream) Close() error {
+	return s.src.Close()
+}
+
+// openStream decodes a file with a stream format, for the file-based Format methods.
+func openStream(f StreamFormat, filename string) (SampleStream, AudioMetadata, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, AudioMetadata{}, err
+	}
+	stream, metadata, err := f.Decode(file)
+	if err != nil {
+		file.Close()
+		return nil, AudioMetadata{}, err
+	}
+	return &fileStream{SampleStream: stream, file: file}, metadata, nil
+}
+
+// fileStream closes the underlying file along with the stream.
+type fileStream struct {
+	SampleStream
+	file *os.File
+}
+
+func (s *fileStream) Close() error {
+	err := s.SampleStream.Close()
+	s.file.Close()
+	return err
+}
+
+// streamMetadata implements Format.GetMetadata for a stream format.
+func streamMetadata(f StreamFormat, filename string, fileSize int64) (AudioMetadata, error) {
+	stream, metadata, err := openStream(f, filename)
+	if err != nil {
+		return AudioMetadata{}, err
+	}
+	stream.Close()
+
+	metadata.OriginalSize = fileSize
+	if metadata.Bitrate == 0 && metadata.Duration > 0 {
+		metadata.Bitrate = int(float64(fileSize*8) / metadata.Duration / 1000)
+	}
+	return metadata, nil
+}
+
+// streamSamples implements Format.ConvertToSamples for a stream format.
+func streamSamples(f StreamFormat, filename string, targetSampleRate int) ([]float32, error) {
+	stream, metadata, err := openStream(f, filename)
+	if err != nil {
+		return nil, err
+	}
+	return ReadAll(Resample(stream, metadata.SampleRate, targetSampleRate))
+}
@@ -0,0 +1,376 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pcmWAV builds a WAV file from interleaved integer samples. A negative size
+// writes the 0xFFFFFFFF placeholder used by streaming writers.
+func pcmWAV(sampleRate, channels, bitDepth int, samples []int32, size int) []byte {
+	var data bytes.Buffer
+	for _, v := range samples {
+		switch bitDepth {
+		case 8:
+			data.WriteByte(byte(v + 128))
+		case 16:
+			binary.Write(&data, binary.LittleEndian, int16(v))
+		case 24:
+			data.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16)})
+		case 32:
+			binary.Write(&data, binary.LittleEndian, v)
+		}
+	}
+
+	dataSize := uint32(data.Len())
+	if size < 0 {
+		dataSize = 0xFFFFFFFF
+	}
+	blockAlign := channels * bitDepth / 8
+
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	buf.WriteString("RIFF")
+	binary.Write(&buf, le, uint32(36+data.Len()))
+	buf.WriteString("WAVE")
+	// An unrelated chunk before fmt must be skipped
+	buf.WriteString("LIST")
+	binary.Write(&buf, le, uint32(3))
+	buf.Write([]byte{'a', 'b', 'c', 0})
+	buf.WriteString("fmt ")
+	binary.Write(&buf, le, uint32(16))
+	binary.Write(&buf, le, uint16(1))
+	binary.Write(&buf, le, uint16(channels))
+	binary.Write(&buf, le, uint32(sampleRate))
+	binary.Write(&buf, le, uint32(sampleRate*blockAlign))
+	binary.Write(&buf, le, uint16(blockAlign))
+	binary.Write(&buf, le, uint16(bitDepth))
+	buf.WriteString("data")
+	binary.Write(&buf, le, dataSize)
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
+
+// sine returns n samples of a full-scale-fraction sine at freq Hz.
+func sine(n, sampleRate int, freq float64, amplitude float64) []int32 {
+	samples := make([]int32, n)
+	for i := range samples {
+		samples[i] = int32(amplitude * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate)))
+	}
+	return samples
+}
+
+// streamOnly hides any Seek method, as for a network stream.
+type streamOnly struct{ io.Reader }
+
+func TestWAVFormat_Decode(t *testing.T) {
+	tests := []struct {
+		name     string
+		bitDepth int
+		peak     int32
+	}{
+		{"8-bit", 8, 100},
+		{"16-bit", 16, 16000},
+		{"24-bit", 24, 4000000},
+		{"32-bit", 32, 1000000000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames := 8000
+			samples := sine(frames, 8000, 440, float64(tt.peak))
+			wav := pcmWAV(8000, 1, tt.bitDepth, samples, 0)
+
+			stream, metadata, err := (&WAVFormat{}).Decode(bytes.NewReader(wav))
+			assert.NoError(t, err)
+			assert.Equal(t, 8000, metadata.SampleRate)
+			assert.Equal(t, tt.bitDepth, metadata.BitDepth)
+			assert.InDelta(t, 1.0, metadata.Duration, 1e-9)
+
+			decoded, err := ReadAll(stream)
+			assert.NoError(t, err)
+			if assert.Len(t, decoded, frames) {
+				scale := float64(int64(1) << (tt.bitDepth - 1))
+				for _, i := range []int{0, 5, 123, 7999} {
+					assert.InDelta(t, float64(samples[i])/scale, decoded[i], 1e-6)
+				}
+			}
+		})
+	}
+}
+
+func TestWAVFormat_DecodeStereoUnknownSize(t *testing.T) {
+	// Left and right cancel out except for the last frame
+	samples := []int32{1000, -1000, 2000, -2000, 16384, 16384}
+	stream, metadata, err := (&WAVFormat{}).Decode(iotest.OneByteReader(bytes.NewReader(pcmWAV(16000, 2, 16, samples, -1))))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, metadata.Channels)
+	assert.Zero(t, metadata.Duration)
+
+	decoded, err := ReadAll(stream)
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{0, 0, 0.5}, decoded)
+}
+
+func TestWAVFormat_DecodeInvalid(t *testing.T) {
+	_, _, err := (&WAVFormat{}).Decode(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WAVE")))
+	assert.Error(t, err)
+	_, _, err = (&WAVFormat{}).Decode(bytes.NewReader([]byte("not a wav file")))
+	assert.Error(t, err)
+}
+
+func TestDecode_SeekableAndStreamingMatch(t *testing.T) {
+	wav := pcmWAV(44100, 2, 16, sine(2*44100, 44100, 300, 12000), 0)
+
+	stream, metadata, err := Decode(bytes.NewReader(wav), 16000)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(wav)), metadata.OriginalSize)
+	assert.InDelta(t, 1.0, metadata.Duration, 1e-9)
+	assert.NotZero(t, metadata.Bitrate)
+	seekable, err := ReadAll(stream)
+	assert.NoError(t, err)
+
+	stream, metadata, err = Decode(streamOnly{iotest.HalfReader(bytes.NewReader(wav))}, 16000)
+	assert.NoError(t, err)
+	assert.Zero(t, metadata.OriginalSize)
+	assert.Equal(t, 44100, metadata.SampleRate)
+	streamed, err := ReadAll(stream)
+	assert.NoError(t, err)
+
+	assert.InDelta(t, 16000, len(seekable), 2)
+	assert.Equal(t, seekable, streamed)
+}
+
+func TestDecode_SkipsID3OnStream(t *testing.T) {
+	wav := pcmWAV(16000, 1, 16, sine(1600, 16000, 200, 8000), 0)
+	stream, _, err := Decode(streamOnly{bytes.NewReader(append(id3Tag(6000), wav...))}, 16000)
+	assert.NoError(t, err)
+	samples, err := ReadAll(stream)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 1600)
+}
+
+func TestDecodeNamed_Mismatch(t *testing.T) {
+	wav := pcmWAV(16000, 1, 16, sine(160, 16000, 200, 8000), 0)
+	_, metadata, err := DecodeNamed(streamOnly{bytes.NewReader(wav)}, "clip.mp3", 16000)
+	assert.NoError(t, err)
+	assert.Equal(t, "WAV", metadata.Format)
+	assert.True(t, metadata.ExtensionMismatch)
+	assert.Equal(t, ".mp3", metadata.Extension)
+
+	_, _, err = DecodeNamed(streamOnly{bytes.NewReader([]byte("garbage"))}, "clip.bin", 16000)
+	assert.Error(t, err)
+}
+
+// fileOnlyFormat only implements the file-based Format methods.
+type fileOnlyFormat struct{}
+
+func (f *fileOnlyFormat) GetMetadata(filename string, fileSize int64) (AudioMetadata, error) {
+	return AudioMetadata{Format: "FILEONLY", SampleRate: 8000, OriginalSize: fileSize}, nil
+}
+
+func (f *fileOnlyFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]float32, len(data)-4)
+	for i := range samples {
+		samples[i] = float32(data[i+4]) / 255
+	}
+	return samples, nil
+}
+
+func TestDecode_FileOnlyFormat(t *testing.T) {
+	Register(Registration{
+		Name:      "test-file-only",
+		Container: "FILEONLY",
+		Sniff:     func(h []byte) bool { return bytes.HasPrefix(h, []byte("FOF!")) },
+		New:       func() Format { return &fileOnlyFormat{} },
+		Decodable: true,
+	})
+
+	stream, metadata, err := Decode(streamOnly{bytes.NewReader([]byte("FOF!\x00\xff"))}, 16000)
+	assert.NoError(t, err)
+	assert.Equal(t, "FILEONLY", metadata.Format)
+	assert.Equal(t, 8000, metadata.SampleRate)
+	samples, err := ReadAll(stream)
+	assert.NoError(t, err)
+	// ConvertToSamples has resampled them already
+	assert.Equal(t, []float32{0, 1}, samples)
+
+	// It has no way to keep channels apart
+	_, _, err = DecodeNamedChannels(streamOnly{bytes.NewReader([]byte("FOF!\x00\xff"))}, "", 16000)
+	assert.ErrorContains(t, err, "cannot be decoded channel by channel")
+}
+
+// chunkReader reads a stream n samples at a time.
+func chunkReader(s SampleStream, n int) ([]float32, error) {
+	var out []float32
+	buf := make([]float32, n)
+	for {
+		m, err := s.Read(buf)
+		out = append(out, buf[:m]...)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+	}
+}
+
+func TestResample_ChunkingDoesNotChangeOutput(t *testing.T) {
+	input := make([]float32, 48000)
+	for i := range input {
+		input[i] = float32(math.Sin(float64(i) / 7))
+	}
+
+	whole := ResampleAudio(input, 48000, 16000)
+	for _, n := range []int{1, 7, 4096, 100000} {
+		out, err := chunkReader(Resample(NewSliceStream(input), 48000, 16000), n)
+		assert.NoError(t, err)
+		assert.Equal(t, whole, out, "chunk size %d", n)
+	}
+
+	// Equal rates pass the stream through
+	s := NewSliceStream(input)
+	assert.Equal(t, s, Resample(s, 16000, 16000))
+}
+
+func TestResampleChannels(t *testing.T) {
+	left, right := tone(440, 48000), tone(1000, 48000)
+	input := make([]float32, 0, 2*len(left))
+	for i := range left {
+		input = append(input, left[i], right[i])
+	}
+
+	// Each channel comes out as if resampled on its own, however the stream
+	// is split, including through the middle of a frame
+	for _, n := range []int{1, 7, 4096} {
+		out, err := chunkReader(ResampleChannels(NewSliceStream(input), 2, 48000, 16000), n)
+		assert.NoError(t, err)
+		channels := Deinterleave(out, 2)
+		assert.Equal(t, ResampleAudio(left, 48000, 16000), channels[0], "chunk size %d", n)
+		assert.Equal(t, ResampleAudio(right, 48000, 16000), channels[1], "chunk size %d", n)
+	}
+}
+
+func TestDownmix(t *testing.T) {
+	input := make([]float32, 3*5000)
+	for i := range input {
+		input[i] = float32(i % 7)
+	}
+	for _, n := range []int{1, 2, 4096} {
+		out, err := chunkReader(Downmix(NewSliceStream(input), 3), n)
+		assert.NoError(t, err)
+		assert.Equal(t, ConvertToMono(input, 3), out, "chunk size %d", n)
+	}
+
+	s := NewSliceStream(input)
+	assert.Equal(t, s, Downmix(s, 1))
+}
+
+func TestDecodeNamedChannels(t *testing.T) {
+	// A tone on the left and silence on the right
+	left := sine(8000, 8000, 440, 16000)
+	samples := make([]int32, 0, 2*len(left))
+	for _, v := range left {
+		samples = append(samples, v, 0)
+	}
+	wav := pcmWAV(8000, 2, 16, samples, 0)
+
+	stream, metadata, err := DecodeNamedChannels(streamOnly{bytes.NewReader(wav)}, "call.wav", 16000)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 2, metadata.Channels)
+	decoded, err := ReadAll(stream)
+	assert.NoError(t, err)
+	channels := Deinterleave(decoded, 2)
+	if assert.Len(t, channels[0], 16000) {
+		assert.Greater(t, levelDB(channels[0]), -10.0)
+		assert.Equal(t, make([]float32, 16000), channels[1])
+	}
+
+	// Decode mixes the same file to mono
+	stream, _, err = DecodeNamed(streamOnly{bytes.NewReader(wav)}, "call.wav", 16000)
+	assert.NoError(t, err)
+	mono, err := ReadAll(stream)
+	assert.NoError(t, err)
+	if assert.Len(t, mono, 16000) {
+		assert.InDelta(t, channels[0][4000]/2, mono[4000], 1e-6)
+	}
+}
+
+func TestReadAtMost(t *testing.T) {
+	input := make([]float32, 10000)
+	for i := range input {
+		input[i] = float32(i)
+	}
+
+	samples, more, err := ReadAtMost(NewSliceStream(input), 6000)
+	assert.NoError(t, err)
+	assert.True(t, more)
+	assert.Equal(t, input[:6000], samples)
+
+	samples, more, err = ReadAtMost(NewSliceStream(input), len(input))
+	assert.NoError(t, err)
+	assert.False(t, more)
+	assert.Equal(t, input, samples)
+}
+
+func TestDecodeNamed_Metadata(t *testing.T) {
+	var adts []byte
+	for i := 0; i < 5; i++ {
+		adts = append(adts, adtsFrame(silentBlock(true), 4, 2)...)
+	}
+	var units [][]byte
+	for i := 0; i < 10; i++ {
+		units = append(units, silentBlock(true))
+	}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		format     string
+		sampleRate int
+		channels   int
+		bitDepth   int
+		duration   float64
+	}{
+		{"wav 8-bit mono", pcmWAV(8000, 1, 8, make([]int32, 4000), 0), "WAV", 8000, 1, 8, 0.5},
+		{"wav 16-bit stereo", pcmWAV(44100, 2, 16, make([]int32, 2*44100), 0), "WAV", 44100, 2, 16, 1},
+		{"wav 24-bit stereo", pcmWAV(48000, 2, 24, make([]int32, 2*12000), 0), "WAV", 48000, 2, 24, 0.25},
+		{"wav 32-bit mono", pcmWAV(16000, 1, 32, make([]int32, 16000), 0), "WAV", 16000, 1, 32, 1},
+		{"adts", adts, "AAC", 44100, 2, 16, 5 * 1024 / 44100.0},
+		{"m4a", buildMP4(mp4Options{codec: "mp4a", config: []byte{0x15, 0x90}, samples: units, timescale: 8000, edit: []uint32{1000, 2112}}),
+			"M4A", 8000, 2, 16, 1},
+		{"opus stereo", opusFile(opusHeadPacket(2, 312, 0, 0, 0, 0), 2380), "OPUS", OpusSampleRate, 2, 16, float64(2380-312) / OpusSampleRate},
+		{"matroska", buildMatroska(matroskaOptions{docType: "matroska", codec: "A_AAC", private: []byte{0x11, 0x88},
+			duration: 2500, clusters: [][][]byte{{simpleBlock(0, 0, silentBlock(false))}}}), "MATROSKA", 48000, 1, 16, 2.5},
+	}
+	for _, tt := range tests {
+		stream, metadata, err := DecodeNamed(bytes.NewReader(tt.data), "", 16000)
+		if !assert.NoError(t, err, tt.name) {
+			continue
+		}
+		stream.Close()
+		assert.Equal(t, tt.format, metadata.Format, tt.name)
+		assert.Equal(t, tt.sampleRate, metadata.SampleRate, tt.name)
+		assert.Equal(t, tt.channels, metadata.Channels, tt.name)
+		assert.Equal(t, tt.bitDepth, metadata.BitDepth, tt.name)
+		assert.InDelta(t, tt.duration, metadata.Duration, 1e-9, tt.name)
+		assert.Equal(t, int64(len(tt.data)), metadata.OriginalSize, tt.name)
+	}
+}
@@ -19,6 +19,11 @@ type AudioMetadata struct {
 	Duration     float64 `json:"duration_seconds"`
 	OriginalSize int64   `json:"original_size_bytes"`
 	Bitrate      int     `json:"bitrate_kbps,omitempty"`
+
+	// Set when the content was identified as a different format than the
+	// upload's file extension suggests; Format and Codec describe the content.
+	Extension         string `json:"extension,omitempty"`
+	ExtensionMismatch bool   `json:"extension_mismatch,omitempty"`
 }
 
 // Format defines the interface for audio format handlers.
@@ -40,22 +45,9 @@ func ConvertToMono(samples []float32, channels int) []float32 {
 	return monoSamples
 }
 
-// ResampleAudio resamples audio samples from one sample rate to another using linear interpolation.
-// For production, consider using a better resampling algorithm.
+// ResampleAudio resamples audio samples from one sample rate to another with
+// a band-limited filter of DefaultResampleQuality.
 func ResampleAudio(samples []float32, srcRate, dstRate int) []float32 {
-	ratio := float64(srcRate) / float64(dstRate)
-	outLen := int(float64(len(samples)) / ratio)
-	resampled := make([]float32, outLen)
-
-	for i := range resampled {
-		pos := float64(i) * ratio
-		idx := int(pos)
-		if idx >= len(samples)-1 {
-			break
-		}
-		frac := float32(pos - float64(idx))
-		resampled[i] = samples[idx]*(1-frac) + samples[idx+1]*frac
-	}
-
-	return resampled
+	r := NewResampler(srcRate, dstRate, DefaultResampleQuality)
+	return append(r.Process(samples), r.Flush()...)
 }
@@ -0,0 +1,220 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+// VADOptions configures DetectSpeech.
+type VADOptions struct {
+	Threshold   float64 // Frame energy above the noise floor, in dB, that can be speech
+	MaxFlatness float64 // Spectral flatness (0-1) above which a frame is noise, however loud
+	MinSpeech   float64 // Seconds; shorter bursts, such as clicks, are dropped
+	MinSilence  float64 // Seconds; shorter pauses do not split speech
+	Padding     float64 // Seconds of audio kept either side of speech
+}
+
+// DefaultVADOptions suits speech recorded with steady background noise, such
+// as radio hiss or squelch tails.
+var DefaultVADOptions = VADOptions{
+	Threshold:   10,
+	MaxFlatness: 0.45,
+	MinSpeech:   0.25,
+	MinSilence:  0.5,
+	Padding:     0.2,
+}
+
+const (
+	vadHop       = 0.02  // Seconds between frame decisions
+	vadWindow    = 0.032 // Seconds of audio analysed per frame
+	vadSilenceDB = -60   // Frames quieter than this, in dBFS, are never speech
+	vadFloorRank = 0.1   // Fraction of frames assumed quieter than any speech
+
+	// Band in which spectral flatness is measured, where speech has its
+	// harmonics and formants and hum or hiss is flat
+	vadLowHz  = 100
+	vadHighHz = 4000
+)
+
+// SpeechRegion is the span of samples [Start, End) that holds speech.
+type SpeechRegion struct {
+	Start int
+	End   int
+}
+
+// DetectSpeech finds the regions of samples that hold speech. Each frame is
+// speech if its energy is Threshold dB above the noise floor, estimated from
+// the quietest frames, and its spectrum is not flat like noise. Pauses
+// shorter than MinSilence are bridged, bursts shorter than MinSpeech dropped,
+// and the regions that remain are widened by Padding.
+func DetectSpeech(samples []float32, sampleRate int, opts VADOptions) []SpeechRegion {
+	hop := max(int(vadHop*float64(sampleRate)), 1)
+	window := max(int(vadWindow*float64(sampleRate)), hop)
+	frames := (len(samples) + hop - 1) / hop
+	if frames == 0 {
+		return nil
+	}
+
+	energies := make([]float64, frames)
+	for i := range energies {
+		frame := samples[i*hop : min(i*hop+window, len(samples))]
+		var sum float64
+		for _, v := range frame {
+			sum += float64(v) * float64(v)
+		}
+		energies[i] = 10 * math.Log10(sum/float64(len(frame))+1e-12)
+	}
+	sorted := append([]float64(nil), energies...)
+	sort.Float64s(sorted)
+	floor := sorted[int(vadFloorRank*float64(frames-1))]
+
+	flatness := newFlatnessMeter(window, sampleRate)
+	speech := make([]bool, frames)
+	for i, e := range energies {
+		// Flatness is only worth measuring for frames loud enough to count
+		if e > vadSilenceDB && e >= floor+opts.Threshold {
+			frame := samples[i*hop : min(i*hop+window, len(samples))]
+			speech[i] = flatness.measure(frame) <= opts.MaxFlatness
+		}
+	}
+
+	var regions []SpeechRegion
+	for i := 0; i < frames; {
+		if !speech[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < frames && speech[i] {
+			i++
+		}
+		regions = append(regions, SpeechRegion{Start: start * hop, End: min(i*hop, len(samples))})
+	}
+
+	minSilence := int(opts.MinSilence * float64(sampleRate))
+	minSpeech := int(opts.MinSpeech * float64(sampleRate))
+	padding := int(opts.Padding * float64(sampleRate))
+	regions = mergeRegions(regions, minSilence)
+	kept := regions[:0]
+	for _, r := range regions {
+		if r.End-r.Start >= minSpeech {
+			kept = append(kept, SpeechRegion{Start: max(r.Start-padding, 0), End: min(r.End+padding, len(samples))})
+		}
+	}
+	return mergeRegions(kept, 0)
+}
+
+// mergeRegions joins regions, in order, separated by fewer than gap samples.
+// Regions that overlap are always joined.
+func mergeRegions(regions []SpeechRegion, gap int) []SpeechRegion {
+	var merged []SpeechRegion
+	for _, r := range regions {
+		if n := len(merged); n > 0 && (r.Start <= merged[n-1].End || r.Start-merged[n-1].End < gap) {
+			merged[n-1].End = max(merged[n-1].End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// flatnessMeter measures the spectral flatness of frames: the geometric mean
+// of the power spectrum over its arithmetic mean, near 1 for white noise and
+// near 0 for tonal sounds such as voiced speech.
+type flatnessMeter struct {
+	window  []float64 // Hann window
+	twiddle []complex128
+	buf     []complex128
+	low, hi int // Bins of the measured band
+}
+
+func newFlatnessMeter(window, sampleRate int) *flatnessMeter {
+	n := 1
+	for n < window {
+		n <<= 1
+	}
+	m := &flatnessMeter{
+		window:  make([]float64, window),
+		twiddle: make([]complex128, n/2),
+		buf:     make([]complex128, n),
+	}
+	for i := range m.window {
+		m.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(window))
+	}
+	for k := range m.twiddle {
+		m.twiddle[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(n)))
+	}
+	m.low = max(vadLowHz*n/sampleRate, 1)
+	m.hi = max(min(vadHighHz*n/sampleRate, n/2), m.low+1)
+	return m
+}
+
+func (m *flatnessMeter) measure(frame []float32) float64 {
+	for i := range m.buf {
+		m.buf[i] = 0
+		if i < len(frame) {
+			m.buf[i] = complex(float64(frame[i])*m.window[i], 0)
+		}
+	}
+	fft(m.buf, m.twiddle)
+
+	var logSum, sum float64
+	for k := m.low; k < m.hi; k++ {
+		p := real(m.buf[k])*real(m.buf[k]) + imag(m.buf[k])*imag(m.buf[k]) + 1e-12
+		logSum += math.Log(p)
+		sum += p
+	}
+	n := float64(m.hi - m.low)
+	return math.Exp(logSum/n) / (sum / n)
+}
+
+// SpeechTimeline maps times in audio joined from speech regions by JoinSpeech
+// back to times in the audio the regions were found in.
+type SpeechTimeline struct {
+	regions    []SpeechRegion
+	offsets    []int // Start of each region in the joined audio
+	sampleRate int
+}
+
+// JoinSpeech returns the samples of regions placed end to end, and the
+// timeline that maps times in them back to samples.
+func JoinSpeech(samples []float32, regions []SpeechRegion, sampleRate int) ([]float32, SpeechTimeline) {
+	timeline := SpeechTimeline{regions: regions, sampleRate: sampleRate}
+	var joined []float32
+	for _, r := range regions {
+		timeline.offsets = append(timeline.offsets, len(joined))
+		joined = append(joined, samples[r.Start:r.End]...)
+	}
+	return joined, timeline
+}
+
+// Start maps the time at which something starts in the joined audio to the
+// original. A time where two regions meet maps to the start of the later one.
+func (t SpeechTimeline) Start(seconds float64) float64 {
+	return t.original(seconds, false)
+}
+
+// End maps the time at which something ends in the joined audio to the
+// original. A time where two regions meet maps to the end of the earlier one.
+func (t SpeechTimeline) End(seconds float64) float64 {
+	return t.original(seconds, true)
+}
+
+func (t SpeechTimeline) original(seconds float64, end bool) float64 {
+	if len(t.regions) == 0 {
+		return seconds
+	}
+	pos := seconds * float64(t.sampleRate)
+	i := sort.Search(len(t.offsets), func(i int) bool {
+		if end {
+			return float64(t.offsets[i]) >= pos
+		}
+		return float64(t.offsets[i]) > pos
+	}) - 1
+	i = max(i, 0)
+	return (float64(t.regions[i].Start) + pos - float64(t.offsets[i])) / float64(t.sampleRate)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package audio
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// vadSignal returns seconds of white noise at the given RMS level, to which
+// tones and noise bursts can be added.
+func vadSignal(seconds, rms float64) []float32 {
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]float32, int(seconds*16000))
+	for i := range samples {
+		samples[i] = float32(rng.NormFloat64() * rms)
+	}
+	return samples
+}
+
+// addTone adds a 220 Hz tone with harmonics, like a voiced vowel, from start
+// to end seconds.
+func addTone(samples []float32, start, end float64) {
+	for i := int(start * 16000); i < int(end*16000); i++ {
+		t := float64(i) / 16000
+		samples[i] += float32(0.2*math.Sin(2*math.Pi*220*t) + 0.1*math.Sin(2*math.Pi*440*t) + 0.05*math.Sin(2*math.Pi*660*t))
+	}
+}
+
+// seconds returns regions in seconds at 16 kHz.
+func seconds(regions []SpeechRegion) [][2]float64 {
+	var out [][2]float64
+	for _, r := range regions {
+		out = append(out, [2]float64{float64(r.Start) / 16000, float64(r.End) / 16000})
+	}
+	return out
+}
+
+func TestDetectSpeech(t *testing.T) {
+	// Speech in quiet hiss is found and padded
+	samples := vadSignal(3, 0.002)
+	addTone(samples, 1, 2)
+	regions := seconds(DetectSpeech(samples, 16000, DefaultVADOptions))
+	if assert.Len(t, regions, 1) {
+		assert.InDelta(t, 0.8, regions[0][0], 0.03)
+		assert.InDelta(t, 2.2, regions[0][1], 0.03)
+	}
+
+	// A loud burst of noise, like a squelch tail, is not speech
+	samples = vadSignal(3, 0.002)
+	burst := vadSignal(0.5, 0.3)
+	copy(samples[8000:], burst)
+	addTone(samples, 1.5, 2.5)
+	regions = seconds(DetectSpeech(samples, 16000, DefaultVADOptions))
+	if assert.Len(t, regions, 1) {
+		assert.InDelta(t, 1.3, regions[0][0], 0.03)
+		assert.InDelta(t, 2.7, regions[0][1], 0.03)
+	}
+
+	// Short pauses are bridged, long ones split, and clicks dropped
+	samples = vadSignal(6, 0.002)
+	addTone(samples, 0.5, 1.0)
+	addTone(samples, 1.3, 2.0)
+	addTone(samples, 3.5, 4.0)
+	addTone(samples, 5.0, 5.1)
+	regions = seconds(DetectSpeech(samples, 16000, DefaultVADOptions))
+	if assert.Len(t, regions, 2) {
+		assert.InDelta(t, 0.3, regions[0][0], 0.03)
+		assert.InDelta(t, 2.2, regions[0][1], 0.03)
+		assert.InDelta(t, 3.3, regions[1][0], 0.03)
+	}
+
+	// A higher threshold rejects quieter speech
+	samples = vadSignal(2, 0.002)
+	addTone(samples, 0.5, 1.5)
+	opts := DefaultVADOptions
+	opts.Threshold = 60
+	assert.Empty(t, DetectSpeech(samples, 16000, opts))
+
+	// Silence has no speech
+	assert.Empty(t, DetectSpeech(make([]float32, 16000), 16000, DefaultVADOptions))
+	assert.Empty(t, DetectSpeech(nil, 16000, DefaultVADOptions))
+}
+
+func TestJoinSpeech(t *testing.T) {
+	samples := make([]float32, 1000)
+	for i := range samples {
+		samples[i] = float32(i)
+	}
+	joined, timeline := JoinSpeech(samples, []SpeechRegion{{100, 200}, {500, 600}}, 100)
+	if assert.Len(t, joined, 200) {
+		assert.Equal(t, float32(100), joined[0])
+		assert.Equal(t, float32(500), joined[100])
+	}
+
+	assert.Equal(t, 1.0, timeline.Start(0))
+	assert.Equal(t, 1.5, timeline.Start(0.5))
+	assert.Equal(t, 5.0, timeline.Start(1.0))
+	assert.Equal(t, 2.0, timeline.End(1.0))
+	assert.Equal(t, 5.5, timeline.End(1.5))
+	assert.Equal(t, 6.0, timeline.End(2.0))
+
+	// Without regions times are unchanged
+	_, timeline = JoinSpeech(samples, nil, 100)
+	assert.Equal(t, 1.5, timeline.Start(1.5))
+}
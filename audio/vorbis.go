@@ -6,93 +6,121 @@ package audio
 import (
 	"fmt"
 	"io"
-	"os"
 
 	"github.com/jfreymuth/oggvorbis"
+	"github.com/jfreymuth/vorbis"
 )
 
 // VorbisFormat implements the Format interface for OGG Vorbis audio files.
 type VorbisFormat struct{}
 
+func init() {
+	Register(Registration{
+		Name:       "vorbis",
+		Container:  ContainerOgg,
+		Codec:      "Vorbis",
+		MIMETypes:  []string{"audio/ogg", "audio/vorbis"},
+		Extensions: []string{".ogg", ".oga"},
+		Sniff:      func(h []byte) bool { return oggCodec(h) == "Vorbis" },
+		New:        func() Format { return &VorbisFormat{} },
+		Decodable:  true,
+	})
+}
+
 // GetMetadata extracts metadata from an OGG Vorbis file.
 func (f *VorbisFormat) GetMetadata(filename string, fileSize int64) (AudioMetadata, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return AudioMetadata{}, err
-	}
-	defer file.Close()
+	return streamMetadata(f, filename, fileSize)
+}
 
-	decoder, err := oggvorbis.NewReader(file)
-	if err != nil {
-		return AudioMetadata{}, fmt.Errorf("failed to create Vorbis decoder: %v", err)
-	}
+// ConvertToSamples converts an OGG Vorbis file to a slice of float32 samples.
+func (f *VorbisFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
+	return streamSamples(f, filename, targetSampleRate)
+}
 
-	// Get duration by seeking to end
-	currentPos, err := file.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return AudioMetadata{}, err
-	}
+// Decode reads the Vorbis headers and streams packets as they are decoded,
+// mixed to mono.
+func (f *VorbisFormat) Decode(r io.Reader) (SampleStream, AudioMetadata, error) {
+	return downmixed(f.DecodeChannels(r))
+}
 
-	maxPos, err := file.Seek(0, io.SeekEnd)
-	if err != nil {
-		return AudioMetadata{}, err
+// DecodeChannels is like Decode but keeps the channels interleaved. The
+// duration is only known when r is seekable.
+func (f *VorbisFormat) DecodeChannels(r io.Reader) (SampleStream, AudioMetadata, error) {
+	// The decoder takes the length from the last page of whichever bitstream
+	// ends the file, so find the last page of this one
+	granule := int64(-1)
+	if rs, ok := r.(io.ReadSeeker); ok {
+		if g, err := oggLength(rs); err == nil {
+			granule = g
+		}
 	}
 
-	_, err = file.Seek(currentPos, io.SeekStart)
+	decoder, err := oggvorbis.NewReader(r)
 	if err != nil {
-		return AudioMetadata{}, err
+		return nil, AudioMetadata{}, fmt.Errorf("failed to create Vorbis decoder: %v", err)
 	}
 
-	duration := float64(maxPos) / float64(decoder.SampleRate()*decoder.Channels()*2)
-
-	return AudioMetadata{
-		Format:       "OGG",
-		Codec:        "Vorbis",
-		SampleRate:   decoder.SampleRate(),
-		Channels:     decoder.Channels(),
-		BitDepth:     16, // Vorbis typically uses 16-bit samples
-		Duration:     duration,
-		OriginalSize: fileSize,
-		Bitrate:      int(float64(fileSize*8) / duration / 1000),
-	}, nil
-}
-
-// ConvertToSamples converts an OGG Vorbis file to a slice of float32 samples.
-func (f *VorbisFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+	channels := decoder.Channels()
+	metadata := AudioMetadata{
+		Format:     "OGG",
+		Codec:      "Vorbis",
+		SampleRate: decoder.SampleRate(),
+		Channels:   channels,
+		BitDepth:   16, // Vorbis typically uses 16-bit samples
+	}
+	// A stream cut from a longer one starts at a later position
+	if start := decoder.Position(); granule > start {
+		metadata.Duration = float64(granule-start) / float64(decoder.SampleRate())
 	}
-	defer file.Close()
 
-	decoder, err := oggvorbis.NewReader(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Vorbis decoder: %v", err)
+	buffer := make([]float32, streamChunk*channels)
+	stream := &blockStream{
+		next: func() ([]float32, error) {
+			n, err := decoder.Read(buffer)
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("failed to read OGG data: %v", err)
+			}
+			block := make([]float32, n)
+			copy(block, buffer[:n])
+			return block, err
+		},
 	}
+	return stream, metadata, nil
+}
 
-	// Read all samples
-	var samples []float32
-	buffer := make([]float32, 16384) // Read in chunks
-	for {
-		n, err := decoder.Read(buffer)
-		if err == io.EOF {
-			break
+// newVorbisPacketStream decodes Vorbis packets stored outside Ogg, as in
+// Matroska, after reading the identification, comment and setup headers. It
+// returns the stream of interleaved channels with its sample rate and channel
+// count.
+func newVorbisPacketStream(packets packetReader, headers [][]byte) (SampleStream, int, int, error) {
+	var decoder vorbis.Decoder
+	for _, header := range headers {
+		if err := decoder.ReadHeader(header); err != nil {
+			return nil, 0, 0, fmt.Errorf("invalid Vorbis header: %v", err)
 		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read OGG data: %v", err)
-		}
-		samples = append(samples, buffer[:n]...)
 	}
-
-	// Convert to mono if stereo
-	if decoder.Channels() > 1 {
-		samples = ConvertToMono(samples, decoder.Channels())
+	if !decoder.HeadersRead() {
+		return nil, 0, 0, fmt.Errorf("missing Vorbis headers")
 	}
 
-	// Resample if needed
-	if decoder.SampleRate() != targetSampleRate {
-		samples = ResampleAudio(samples, decoder.SampleRate(), targetSampleRate)
+	channels := decoder.Channels()
+	stream := &blockStream{
+		next: func() ([]float32, error) {
+			for {
+				packet, err := packets.nextPacket()
+				if err != nil {
+					return nil, err
+				}
+				block, err := decoder.Decode(packet.data)
+				if err != nil {
+					return nil, fmt.Errorf("failed to decode Vorbis packet: %v", err)
+				}
+				if len(block) == 0 {
+					continue
+				}
+				return block, nil
+			}
+		},
 	}
-
-	return samples, nil
+	return stream, decoder.SampleRate(), channels, nil
 }
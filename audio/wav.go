@@ -4,88 +4,329 @@
 package audio
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
-	"os"
-
-	"github.com/go-audio/wav"
+	"io"
+	"math"
+	"strings"
 )
 
 // WAVFormat implements the Format interface for WAV audio files.
 type WAVFormat struct{}
 
+func init() {
+	Register(Registration{
+		Name:       "wav",
+		Container:  ContainerWAV,
+		Codec:      "PCM",
+		MIMETypes:  []string{"audio/wav", "audio/x-wav", "audio/wave", "audio/vnd.wave"},
+		Extensions: []string{".wav"},
+		Sniff:      isRIFFWave,
+		New:        func() Format { return &WAVFormat{} },
+		Decodable:  true,
+	})
+}
+
 // GetMetadata extracts metadata from a WAV file.
 func (f *WAVFormat) GetMetadata(filename string, fileSize int64) (AudioMetadata, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return AudioMetadata{}, err
+	return streamMetadata(f, filename, fileSize)
+}
+
+// ConvertToSamples converts a WAV file to a slice of float32 samples.
+func (f *WAVFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
+	return streamSamples(f, filename, targetSampleRate)
+}
+
+// WAVE format tags, from the fmt chunk or the sub-format of
+// WAVE_FORMAT_EXTENSIBLE.
+const (
+	wavPCM        = 0x0001
+	wavFloat      = 0x0003
+	wavALaw       = 0x0006
+	wavMuLaw      = 0x0007
+	wavExtensible = 0xFFFE
+)
+
+// wavMaxChannels bounds the channels of a WAV file. The fmt chunk allows
+// 65535, far beyond any recording, and each channel costs buffer space.
+const wavMaxChannels = 32
+
+// wavReadSize is the number of bytes of the data chunk read at a time,
+// whatever the frame size.
+const wavReadSize = streamChunk * 4
+
+// wavGUIDSuffix ends the sub-format GUID of every WAVE_FORMAT_EXTENSIBLE
+// encoding that has a format tag, which is stored in the first two bytes.
+var wavGUIDSuffix = []byte("\x00\x00\x00\x00\x10\x00\x80\x00\x00\xAA\x00\x38\x9B\x71")
+
+// wavFormat describes the samples of a WAV file.
+type wavFormat struct {
+	tag        int // wavPCM, wavFloat, wavALaw or wavMuLaw
+	channels   int
+	sampleRate int
+	bitDepth   int // Bits per sample in the data, a multiple of 8
+	validBits  int // Significant bits per sample, at most bitDepth
+}
+
+// parseWAVFormat parses a fmt chunk, resolving WAVE_FORMAT_EXTENSIBLE to the
+// encoding it wraps.
+func parseWAVFormat(chunk []byte) (wavFormat, error) {
+	if len(chunk) < 16 {
+		return wavFormat{}, fmt.Errorf("invalid WAV fmt chunk")
+	}
+	f := wavFormat{
+		tag:        int(binary.LittleEndian.Uint16(chunk[0:])),
+		channels:   int(binary.LittleEndian.Uint16(chunk[2:])),
+		sampleRate: int(binary.LittleEndian.Uint32(chunk[4:])),
+		bitDepth:   int(binary.LittleEndian.Uint16(chunk[14:])),
 	}
-	defer file.Close()
+	f.validBits = f.bitDepth
+	// Samples narrower than a byte multiple are stored left-justified in whole bytes
+	f.bitDepth = (f.bitDepth + 7) / 8 * 8
 
-	decoder := wav.NewDecoder(file)
-	if !decoder.IsValidFile() {
-		return AudioMetadata{}, fmt.Errorf("invalid WAV file")
+	if f.tag == wavExtensible {
+		if len(chunk) < 40 || !bytes.Equal(chunk[26:40], wavGUIDSuffix) {
+			return wavFormat{}, fmt.Errorf("unsupported WAV encoding: unknown WAVE_FORMAT_EXTENSIBLE sub-format")
+		}
+		if valid := int(binary.LittleEndian.Uint16(chunk[18:])); valid > 0 && valid <= f.bitDepth {
+			f.validBits = valid
+		}
+		f.tag = int(binary.LittleEndian.Uint16(chunk[24:]))
 	}
 
-	format := decoder.Format()
-	dur, err := decoder.Duration()
-	if err != nil {
-		return AudioMetadata{}, fmt.Errorf("failed to get duration: %v", err)
+	if f.channels < 1 || f.channels > wavMaxChannels || f.sampleRate < 1 {
+		return wavFormat{}, fmt.Errorf("unsupported WAV format: %d channels, %d Hz", f.channels, f.sampleRate)
 	}
+	switch f.tag {
+	case wavPCM:
+		if f.bitDepth < 8 || f.bitDepth > 32 {
+			return wavFormat{}, fmt.Errorf("unsupported WAV format: %d-bit PCM", f.validBits)
+		}
+	case wavFloat:
+		if f.bitDepth != 32 && f.bitDepth != 64 {
+			return wavFormat{}, fmt.Errorf("unsupported WAV format: %d-bit float", f.validBits)
+		}
+	case wavALaw, wavMuLaw:
+		if f.bitDepth != 8 {
+			return wavFormat{}, fmt.Errorf("unsupported WAV format: %d-bit G.711", f.validBits)
+		}
+	default:
+		return wavFormat{}, fmt.Errorf("unsupported WAV encoding (format tag %d)", f.tag)
+	}
+	return f, nil
+}
 
-	duration := dur.Seconds()
-	// Calculate bitrate from file size and duration
-	bitrate := int(float64(fileSize*8) / duration / 1000)
+// codec names the encoding for AudioMetadata.
+func (f wavFormat) codec() string {
+	switch f.tag {
+	case wavFloat:
+		return "IEEE float"
+	case wavALaw:
+		return "G.711 A-law"
+	case wavMuLaw:
+		return "G.711 mu-law"
+	}
+	return "PCM"
+}
 
-	return AudioMetadata{
-		Format:       "WAV",
-		Codec:        "PCM",
-		SampleRate:   format.SampleRate,
-		Channels:     format.NumChannels,
-		BitDepth:     16, // WAV files are typically 16-bit PCM
-		Duration:     duration,
-		OriginalSize: fileSize,
-		Bitrate:      bitrate,
-	}, nil
+// Decode parses the RIFF header and streams the data chunk as it is read,
+// mixed to mono.
+func (f *WAVFormat) Decode(r io.Reader) (SampleStream, AudioMetadata, error) {
+	return downmixed(f.DecodeChannels(r))
 }
 
-// ConvertToSamples converts a WAV file to a slice of float32 samples.
-func (f *WAVFormat) ConvertToSamples(filename string, targetSampleRate int) ([]float32, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+// DecodeChannels is like Decode but keeps the channels of the data chunk
+// interleaved. RF64 and BW64 files, which hold over 4 GB, take the data size
+// from their ds64 chunk.
+func (f *WAVFormat) DecodeChannels(r io.Reader) (SampleStream, AudioMetadata, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil || !isRIFFWave(riff[:]) {
+		return nil, AudioMetadata{}, fmt.Errorf("invalid WAV file")
 	}
-	defer file.Close()
+	rf64 := string(riff[:4]) != "RIFF"
+
+	var (
+		format     wavFormat
+		haveFormat bool
+		dataSize   int64 = -1 // From the ds64 chunk
+	)
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, AudioMetadata{}, fmt.Errorf("invalid WAV file: no data chunk")
+		}
+		id := string(header[:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:]))
 
-	decoder := wav.NewDecoder(file)
-	if !decoder.IsValidFile() {
-		return nil, fmt.Errorf("invalid WAV file")
+		switch id {
+		case "fmt ", "ds64":
+			if size > 1<<16 {
+				return nil, AudioMetadata{}, fmt.Errorf("invalid WAV %s chunk", strings.TrimSpace(id))
+			}
+			chunk := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, AudioMetadata{}, fmt.Errorf("invalid WAV %s chunk: %v", strings.TrimSpace(id), err)
+			}
+			if id == "ds64" {
+				// RIFF size, then data size, as 64-bit values
+				if len(chunk) < 16 {
+					return nil, AudioMetadata{}, fmt.Errorf("invalid WAV ds64 chunk")
+				}
+				dataSize = int64(binary.LittleEndian.Uint64(chunk[8:]))
+				continue
+			}
+			var err error
+			if format, err = parseWAVFormat(chunk); err != nil {
+				return nil, AudioMetadata{}, err
+			}
+			haveFormat = true
+
+		case "data":
+			if !haveFormat {
+				return nil, AudioMetadata{}, fmt.Errorf("invalid WAV file: data before fmt chunk")
+			}
+			if rf64 && size == 0xFFFFFFFF && dataSize >= 0 {
+				size = dataSize
+			} else if size == 0 || size == 0xFFFFFFFF {
+				// Streaming writers leave the size unset; read to the end then
+				size = -1
+			}
+
+			var duration float64
+			frameSize := int64(format.channels * format.bitDepth / 8)
+			if size >= 0 {
+				duration = float64(size/frameSize) / float64(format.sampleRate)
+			}
+
+			metadata := AudioMetadata{
+				Format:     "WAV",
+				Codec:      format.codec(),
+				SampleRate: format.sampleRate,
+				Channels:   format.channels,
+				BitDepth:   format.validBits,
+				Duration:   duration,
+			}
+			return newWAVStream(r, size, format), metadata, nil
+
+		default:
+			// Skip other chunks, which are padded to an even size
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return nil, AudioMetadata{}, fmt.Errorf("invalid WAV file: no data chunk")
+			}
+		}
 	}
+}
 
-	buf, err := decoder.FullPCMBuffer()
-	if err != nil {
-		return nil, fmt.Errorf("failed to read PCM buffer: %v", err)
+// newWAVStream streams the interleaved frames of a WAV data chunk from r,
+// normalized to [-1, 1]. size is the data length in bytes,
+// or -1 to read until EOF.
+func newWAVStream(r io.Reader, size int64, format wavFormat) SampleStream {
+	if size >= 0 {
+		r = io.LimitReader(r, size)
 	}
+	channels := format.channels
+	bytesPerSample := format.bitDepth / 8
+	frameSize := channels * bytesPerSample
+	decode := wavSampleDecoder(format)
+	raw := make([]byte, max(wavReadSize/frameSize, 1)*frameSize)
 
-	// Convert int buffer to float32 samples
-	numSamples := len(buf.Data)
-	samples := make([]float32, numSamples)
+	return &blockStream{
+		next: func() ([]float32, error) {
+			n, err := io.ReadFull(r, raw)
+			if err == io.ErrUnexpectedEOF {
+				err = nil
+			}
+			frames := n / frameSize
+			if frames == 0 {
+				if err == nil {
+					err = io.EOF
+				}
+				return nil, err
+			}
 
-	// Scale factor for audio format
-	scale := float32(1.0 / 32768.0)
+			block := make([]float32, frames*channels)
+			for i := range block {
+				block[i] = decode(raw[i*bytesPerSample:])
+			}
+			return block, err
+		},
+	}
+}
 
-	for i, sample := range buf.Data {
-		samples[i] = float32(sample) * scale
+// wavSampleDecoder returns a function that decodes one little-endian sample in
+// the given format to [-1, 1]. Integer PCM is scaled by its container size, so
+// samples with fewer valid bits, which are left-justified, scale the same.
+func wavSampleDecoder(format wavFormat) func([]byte) float32 {
+	switch format.tag {
+	case wavFloat:
+		if format.bitDepth == 64 {
+			return func(b []byte) float32 {
+				return clampSample(float32(math.Float64frombits(binary.LittleEndian.Uint64(b))))
+			}
+		}
+		return func(b []byte) float32 {
+			return clampSample(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		}
+	case wavALaw:
+		return func(b []byte) float32 { return float32(aLawToLinear(b[0])) / 32768 }
+	case wavMuLaw:
+		return func(b []byte) float32 { return float32(muLawToLinear(b[0])) / 32768 }
 	}
 
-	format := decoder.Format()
-	if format.NumChannels > 1 {
-		samples = ConvertToMono(samples, format.NumChannels)
+	scale := 1 / float32(int64(1)<<(format.bitDepth-1))
+	switch format.bitDepth {
+	case 8:
+		// 8-bit PCM is unsigned
+		return func(b []byte) float32 { return float32(int(b[0])-128) * scale }
+	case 16:
+		return func(b []byte) float32 { return float32(int16(binary.LittleEndian.Uint16(b))) * scale }
+	case 24:
+		return func(b []byte) float32 {
+			return float32(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) * scale
+		}
+	default:
+		return func(b []byte) float32 { return float32(int32(binary.LittleEndian.Uint32(b))) * scale }
 	}
+}
 
-	// Resample if needed
-	if format.SampleRate != targetSampleRate {
-		samples = ResampleAudio(samples, format.SampleRate, targetSampleRate)
+// clampSample limits a float sample to [-1, 1], replacing NaN with silence.
+func clampSample(v float32) float32 {
+	switch {
+	case v != v:
+		return 0
+	case v > 1:
+		return 1
+	case v < -1:
+		return -1
 	}
+	return v
+}
 
-	return samples, nil
+// muLawToLinear expands a G.711 mu-law byte to a 16-bit linear sample.
+func muLawToLinear(u byte) int16 {
+	u = ^u
+	t := (int16(u&0x0F)<<3 + 0x84) << ((u & 0x70) >> 4)
+	if u&0x80 != 0 {
+		return 0x84 - t
+	}
+	return t - 0x84
+}
+
+// aLawToLinear expands a G.711 A-law byte to a 16-bit linear sample.
+func aLawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int16(a&0x0F) << 4
+	switch seg := (a & 0x70) >> 4; seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t = (t + 0x108) << (seg - 1)
+	}
+	if a&0x80 != 0 {
+		return t
+	}
+	return -t
 }
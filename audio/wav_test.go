@@ -4,6 +4,9 @@
 package audio
 
 import (
+	"bytes"
+	"encoding/binary"
+	"math"
 	"os"
 	"path/filepath"
 	"testing"
@@ -43,3 +46,140 @@ func TestWAVFormat_ConvertToSamples(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, samples)
 }
+
+// wavFmt builds a fmt chunk body. A non-zero subFormat writes
+// WAVE_FORMAT_EXTENSIBLE wrapping that format tag, with validBits.
+func wavFmt(tag, channels, sampleRate, bitDepth, subFormat, validBits int) []byte {
+	le := binary.LittleEndian
+	blockAlign := channels * (bitDepth + 7) / 8
+	b := le.AppendUint16(nil, uint16(tag))
+	b = le.AppendUint16(b, uint16(channels))
+	b = le.AppendUint32(b, uint32(sampleRate))
+	b = le.AppendUint32(b, uint32(sampleRate*blockAlign))
+	b = le.AppendUint16(b, uint16(blockAlign))
+	b = le.AppendUint16(b, uint16(bitDepth))
+	if subFormat == 0 {
+		return b
+	}
+	b = le.AppendUint16(b, 22)
+	b = le.AppendUint16(b, uint16(validBits))
+	b = le.AppendUint32(b, 0x3) // Front left and right
+	b = le.AppendUint16(b, uint16(subFormat))
+	return append(b, wavGUIDSuffix...)
+}
+
+// buildWAV builds a WAV file from a fmt chunk body and sample data. An RF64
+// file records the data size only in its ds64 chunk, and an unrelated chunk
+// follows the data.
+func buildWAV(fmtChunk, data []byte, rf64 bool) []byte {
+	le := binary.LittleEndian
+	chunk := func(id string, body []byte) []byte {
+		b := le.AppendUint32([]byte(id), uint32(len(body)))
+		b = append(b, body...)
+		if len(body)%2 == 1 {
+			b = append(b, 0)
+		}
+		return b
+	}
+
+	if !rf64 {
+		body := bytes.Join([][]byte{[]byte("WAVE"), chunk("fmt ", fmtChunk), chunk("data", data)}, nil)
+		return append(le.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+	}
+	ds64 := le.AppendUint64(nil, 0) // RIFF size, unused here
+	ds64 = le.AppendUint64(ds64, uint64(len(data)))
+	ds64 = append(ds64, make([]byte, 12)...) // Sample count, table length
+	dataChunk := le.AppendUint32([]byte("data"), 0xFFFFFFFF)
+	return bytes.Join([][]byte{[]byte("RF64\xFF\xFF\xFF\xFFWAVE"), chunk("ds64", ds64), chunk("fmt ", fmtChunk),
+		dataChunk, data, chunk("LIST", []byte("INFOjunk"))}, nil)
+}
+
+// float32s encodes values as little-endian 32-bit floats.
+func float32s(values ...float32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+	}
+	return b
+}
+
+func TestWAVFormat_Encodings(t *testing.T) {
+	var float64s []byte
+	for _, v := range []float64{0.25, -1, 0.125} {
+		float64s = binary.LittleEndian.AppendUint64(float64s, math.Float64bits(v))
+	}
+	// 20 valid bits left-justified in 24: full scale positive and negative
+	pcm20 := []byte{0xF0, 0xFF, 0x7F, 0x00, 0x00, 0x80}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		codec    string
+		channels int
+		bitDepth int
+		want     []float32
+	}{
+		{"float32 stereo", buildWAV(wavFmt(wavFloat, 2, 8000, 32, 0, 0), float32s(0.5, -0.5, 0.5, 0.25, 2, 2), false),
+			"IEEE float", 2, 32, []float32{0, 0.375, 1}},
+		{"float64", buildWAV(wavFmt(wavFloat, 1, 8000, 64, 0, 0), float64s, false), "IEEE float", 1, 64, []float32{0.25, -1, 0.125}},
+		{"mu-law", buildWAV(wavFmt(wavMuLaw, 1, 8000, 8, 0, 0), []byte{0xFF, 0x80, 0x00}, false),
+			"G.711 mu-law", 1, 8, []float32{0, 32124.0 / 32768, -32124.0 / 32768}},
+		{"a-law", buildWAV(wavFmt(wavALaw, 1, 8000, 8, 0, 0), []byte{0xD5, 0x55, 0xAA, 0x2A}, false),
+			"G.711 A-law", 1, 8, []float32{8.0 / 32768, -8.0 / 32768, 32256.0 / 32768, -32256.0 / 32768}},
+		{"extensible 20-bit", buildWAV(wavFmt(wavExtensible, 1, 8000, 24, wavPCM, 20), pcm20, false),
+			"PCM", 1, 20, []float32{float32(0x7FFFF0) / (1 << 23), -1}},
+		{"extensible float", buildWAV(wavFmt(wavExtensible, 1, 8000, 32, wavFloat, 32), float32s(-0.75), false),
+			"IEEE float", 1, 32, []float32{-0.75}},
+		{"12-bit pcm", buildWAV(wavFmt(wavPCM, 1, 8000, 12, 0, 0), []byte{0x00, 0x40}, false), "PCM", 1, 12, []float32{0.5}},
+		{"rf64", buildWAV(wavFmt(wavPCM, 1, 8000, 16, 0, 0), []byte{0x00, 0x40, 0x00, 0xC0}, true),
+			"PCM", 1, 16, []float32{0.5, -0.5}},
+	}
+	for _, tt := range tests {
+		stream, metadata, err := (&WAVFormat{}).Decode(bytes.NewReader(tt.data))
+		if !assert.NoError(t, err, tt.name) {
+			continue
+		}
+		assert.Equal(t, tt.codec, metadata.Codec, tt.name)
+		assert.Equal(t, tt.channels, metadata.Channels, tt.name)
+		assert.Equal(t, tt.bitDepth, metadata.BitDepth, tt.name)
+		assert.InDelta(t, float64(len(tt.want))/8000, metadata.Duration, 1e-9, tt.name)
+
+		samples, err := ReadAll(stream)
+		assert.NoError(t, err, tt.name)
+		assert.InDeltaSlice(t, tt.want, samples, 1e-6, tt.name)
+	}
+}
+
+func TestWAVFormat_UnsupportedEncodings(t *testing.T) {
+	unknownGUID := wavFmt(wavExtensible, 1, 8000, 16, wavPCM, 16)
+	unknownGUID[len(unknownGUID)-1] ^= 0xFF
+
+	tests := map[string][]byte{
+		"adpcm":              wavFmt(0x0002, 1, 8000, 4, 0, 0),
+		"float16":            wavFmt(wavFloat, 1, 8000, 16, 0, 0),
+		"16-bit mu-law":      wavFmt(wavMuLaw, 1, 8000, 16, 0, 0),
+		"no channels":        wavFmt(wavPCM, 0, 8000, 16, 0, 0),
+		"65535 channels":     wavFmt(wavFloat, 65535, 8000, 64, 0, 0),
+		"unknown sub-format": unknownGUID,
+		"short extensible":   wavFmt(wavExtensible, 1, 8000, 16, 0, 0),
+	}
+	for name, fmtChunk := range tests {
+		_, _, err := (&WAVFormat{}).Decode(bytes.NewReader(buildWAV(fmtChunk, make([]byte, 4), false)))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestG711(t *testing.T) {
+	// Every code expands to a distinct value and the sign bit mirrors it
+	for _, expand := range []func(byte) int16{muLawToLinear, aLawToLinear} {
+		seen := map[int16]bool{}
+		for c := 0; c < 256; c++ {
+			v := expand(byte(c))
+			assert.Equal(t, -v, expand(byte(c)^0x80))
+			assert.LessOrEqual(t, max(v, -v), int16(32256))
+			seen[v] = true
+		}
+		// Mu-law has both a positive and a negative zero
+		assert.GreaterOrEqual(t, len(seen), 255)
+	}
+}
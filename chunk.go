@@ -0,0 +1,329 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/VA7DBI/whisperAPI/audio"
+)
+
+// transcribeTrack runs engine over one track of samples. Tracks longer than
+// chunking.min_duration_seconds are split into chunks that are decoded on
+// engine and on up to chunking.max_parallel-1 other slots while they are
+// free, and stitched back together in order, so the result does not depend on
+// how many ran at once. Cancelling ctx stops it between chunks: whisper.cpp
+// cannot be interrupted, so chunks already being decoded run to the end.
+// It returns the seconds of audio engine decoded and the number of chunks.
+func (s *TranscriptionService) transcribeTrack(ctx context.Context, engine Engine, samples []float32, opts TranscriptionOptions, onSegment func(SegmentInfo), progress ProgressFunc) (float64, int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	cfg := s.config.Chunking
+	rate := s.config.Audio.SampleRate
+	duration := float64(len(samples)) / float64(rate)
+	if cfg.MinDuration <= 0 || duration <= cfg.MinDuration {
+		return duration, 1, engine.Transcribe(samples, opts, onSegment, progress)
+	}
+	chunks := audio.SplitChunks(samples, rate, cfg.WindowSeconds, cfg.OverlapSeconds, opts.vadOptions())
+	if len(chunks) == 1 {
+		return duration, 1, engine.Transcribe(samples, opts, onSegment, progress)
+	}
+
+	st := &chunkStitcher{
+		ctx:        ctx,
+		chunks:     chunks,
+		rate:       float64(rate),
+		results:    make([][]SegmentInfo, len(chunks)),
+		done:       make([]bool, len(chunks)),
+		percent:    make([]int, len(chunks)),
+		onSegment:  onSegment,
+		onProgress: progress,
+	}
+	decode := func(engine Engine, i int) (seconds float64) {
+		chunk := chunks[i]
+		var segments []SegmentInfo
+		err := engine.Transcribe(samples[chunk.Start:chunk.End], opts,
+			func(seg SegmentInfo) { segments = append(segments, seg) },
+			func(percent int) { st.progress(i, percent) })
+		st.finish(i, segments, err)
+		return float64(chunk.End-chunk.Start) / float64(rate)
+	}
+
+	// Helpers never wait for a slot: each takes a free one for a chunk and
+	// gives it back after, so requests queued behind this one get the next
+	// slot that frees up and the request only ever counts as one in the queue
+	parallel := min(len(chunks), len(s.pool.engines))
+	if cfg.MaxParallel > 0 {
+		parallel = min(parallel, cfg.MaxParallel)
+	}
+	var wg sync.WaitGroup
+	for w := 1; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				helper, ok := s.pool.TryAcquire()
+				if !ok {
+					return
+				}
+				i, ok := st.take()
+				if !ok {
+					s.pool.Release(helper, 0, 0)
+					return
+				}
+				start := time.Now()
+				seconds := decode(helper, i)
+				s.pool.Release(helper, seconds, time.Since(start).Seconds())
+			}
+		}()
+	}
+
+	// The slot the request already holds decodes until the chunks run out
+	var decoded float64
+	for {
+		i, ok := st.take()
+		if !ok {
+			break
+		}
+		decoded += decode(engine, i)
+	}
+	wg.Wait()
+	return decoded, len(chunks), st.err
+}
+
+// chunkStitcher collects the segments of chunks decoded in any order and
+// passes them on in order, once every chunk before them is done. Each chunk
+// keeps the words in its kept span, and words repeated either side of a cut
+// are dropped.
+type chunkStitcher struct {
+	ctx        context.Context // Stops chunks from being taken once cancelled
+	chunks     []audio.Chunk
+	rate       float64
+	onSegment  func(SegmentInfo)
+	onProgress ProgressFunc
+
+	mu       sync.Mutex
+	next     int // Next chunk to decode
+	results  [][]SegmentInfo
+	done     []bool
+	percent  []int
+	reported int
+	emitted  int // Chunks passed on
+	last     *SegmentInfo
+	err      error
+}
+
+// take returns the next chunk to decode, or false when there are none left,
+// a chunk has failed or the context is cancelled.
+func (st *chunkStitcher) take() (int, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.err == nil {
+		st.err = st.ctx.Err()
+	}
+	if st.err != nil || st.next == len(st.chunks) {
+		return 0, false
+	}
+	st.next++
+	return st.next - 1, true
+}
+
+// progress records the progress of chunk i and reports the share of the
+// track done, weighted by chunk length.
+func (st *chunkStitcher) progress(i, percent int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.percent[i] = percent
+	st.report()
+}
+
+func (st *chunkStitcher) report() {
+	if st.onProgress == nil {
+		return
+	}
+	var done, total int
+	for i, c := range st.chunks {
+		done += st.percent[i] * (c.End - c.Start)
+		total += c.End - c.Start
+	}
+	if percent := done / total; percent > st.reported {
+		st.reported = percent
+		st.onProgress(percent)
+	}
+}
+
+// finish records the segments of chunk i, with times relative to the chunk,
+// and passes on those of every chunk now done in order.
+func (st *chunkStitcher) finish(i int, segments []SegmentInfo, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err != nil {
+		if st.err == nil {
+			st.err = err
+		}
+		return
+	}
+	st.results[i], st.done[i] = segments, true
+	st.percent[i] = 100
+	st.report()
+
+	for st.emitted < len(st.chunks) && st.done[st.emitted] {
+		st.emit(st.emitted)
+		st.results[st.emitted] = nil
+		st.emitted++
+	}
+}
+
+// emit passes on the segments chunk i keeps.
+func (st *chunkStitcher) emit(i int) {
+	chunk := st.chunks[i]
+	from, to := float64(chunk.KeepStart)/st.rate, float64(chunk.KeepEnd)/st.rate
+	if i == len(st.chunks)-1 {
+		to = math.Inf(1)
+	}
+	first := i > 0 // The first segment after a cut may repeat words before it
+	for _, seg := range shiftSegments(st.results[i], float64(chunk.Start)/st.rate) {
+		seg, ok := trimSegment(seg, from, to)
+		if ok && first && st.last != nil {
+			seg, ok = dropRepeatedWords(*st.last, seg)
+		}
+		if !ok {
+			continue
+		}
+		first = false
+		st.last = &seg
+		st.onSegment(seg)
+	}
+}
+
+// spokenWord is a word of a segment and the tokens that spell it.
+type spokenWord struct {
+	text   string
+	tokens []TokenInfo
+}
+
+// splitWords splits the tokens of seg into words, each starting with a
+// token that begins with a space, leaving out special tokens such as
+// timestamps. Without tokens the words come from the text.
+func splitWords(seg SegmentInfo) []spokenWord {
+	var words []spokenWord
+	for _, token := range seg.Tokens {
+		if isSpecialToken(token.Text) || token.Text == "" {
+			continue
+		}
+		if len(words) == 0 || strings.HasPrefix(token.Text, " ") {
+			words = append(words, spokenWord{})
+		}
+		w := &words[len(words)-1]
+		w.text += token.Text
+		w.tokens = append(w.tokens, token)
+	}
+	if len(words) == 0 {
+		for _, field := range strings.Fields(seg.Text) {
+			words = append(words, spokenWord{text: " " + field})
+		}
+	}
+	return words
+}
+
+// timedWords reports whether the engine timed the tokens of words, which
+// whisper only does with token timestamps enabled.
+func timedWords(words []spokenWord) bool {
+	timed := false
+	for _, w := range words {
+		if len(w.tokens) == 0 {
+			return false
+		}
+		for _, token := range w.tokens {
+			timed = timed || token.EndTime > token.StartTime
+		}
+	}
+	return timed
+}
+
+// withWords returns seg holding only words, with its times narrowed to
+// theirs when they are timed.
+func withWords(seg SegmentInfo, words []spokenWord, timed bool) SegmentInfo {
+	var text strings.Builder
+	seg.Tokens = make([]TokenInfo, 0, len(seg.Tokens))
+	for _, w := range words {
+		text.WriteString(w.text)
+		seg.Tokens = append(seg.Tokens, w.tokens...)
+	}
+	seg.Text = text.String()
+	if timed {
+		seg.StartTime = max(seg.StartTime, seg.Tokens[0].StartTime)
+		seg.EndTime = min(seg.EndTime, seg.Tokens[len(seg.Tokens)-1].EndTime)
+	}
+	return seg
+}
+
+// trimSegment keeps the words of seg whose middle is between from and to
+// seconds. When the tokens are not timed, all of seg is kept if its middle
+// is. It returns false if nothing is kept.
+func trimSegment(seg SegmentInfo, from, to float64) (SegmentInfo, bool) {
+	inside := func(start, end float64) bool {
+		mid := (start + end) / 2
+		return mid >= from && mid < to
+	}
+	words := splitWords(seg)
+	if !timedWords(words) {
+		return seg, inside(seg.StartTime, seg.EndTime)
+	}
+
+	var kept []spokenWord
+	for _, w := range words {
+		if inside(w.tokens[0].StartTime, w.tokens[len(w.tokens)-1].EndTime) {
+			kept = append(kept, w)
+		}
+	}
+	switch len(kept) {
+	case 0:
+		return seg, false
+	case len(words):
+		return seg, true
+	}
+	return withWords(seg, kept, true), true
+}
+
+// dropRepeatedWords removes from the start of seg the longest run of words
+// that also ends prev. Both chunks either side of a cut hear the words in
+// their overlap, and misplaced times can leave them in both. It returns false
+// if no words are left.
+func dropRepeatedWords(prev, seg SegmentInfo) (SegmentInfo, bool) {
+	before, words := splitWords(prev), splitWords(seg)
+	n := 0
+	for m := min(len(before), len(words)); m > 0 && n == 0; m-- {
+		n = m
+		for j := 0; j < m; j++ {
+			if normalizeWord(before[len(before)-m+j].text) != normalizeWord(words[j].text) {
+				n = 0
+				break
+			}
+		}
+	}
+	switch n {
+	case 0:
+		return seg, true
+	case len(words):
+		return seg, false
+	}
+
+	seg = withWords(seg, words[n:], timedWords(words))
+	seg.StartTime = min(max(seg.StartTime, prev.EndTime), seg.EndTime)
+	return seg, true
+}
+
+// normalizeWord ignores case, spacing and punctuation when comparing words.
+func normalizeWord(word string) string {
+	return strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}))
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// wordEngine hears a word wherever the samples hold a run of one value,
+// named after the value, and reports the words four to a segment with timed
+// tokens. Runs cut off at either end of the audio are words too, as a model
+// would guess at a word cut in half.
+type wordEngine struct {
+	mu     sync.Mutex
+	calls  int
+	onCall func(calls int) // Called at the start of each call, if set
+}
+
+func (e *wordEngine) Transcribe(samples []float32, opts TranscriptionOptions, onSegment func(SegmentInfo), onProgress ProgressFunc) error {
+	e.mu.Lock()
+	e.calls++
+	calls := e.calls
+	e.mu.Unlock()
+	if e.onCall != nil {
+		e.onCall(calls)
+	}
+
+	var seg SegmentInfo
+	for start := 0; start < len(samples); {
+		end := start
+		for end < len(samples) && samples[end] == samples[start] {
+			end++
+		}
+		token := TokenInfo{
+			Text:      fmt.Sprintf(" w%d", int(samples[start]*1024)),
+			StartTime: float64(start) / EngineSampleRate,
+			EndTime:   float64(end) / EngineSampleRate,
+		}
+		if len(seg.Tokens) == 0 {
+			seg.StartTime = token.StartTime
+		}
+		seg.Text += token.Text
+		seg.Tokens = append(seg.Tokens, token)
+		seg.EndTime = token.EndTime
+		if len(seg.Tokens) == 4 || end == len(samples) {
+			onSegment(seg)
+			seg = SegmentInfo{}
+		}
+		start = end
+	}
+	if onProgress != nil {
+		onProgress(100)
+	}
+	return nil
+}
+
+func (e *wordEngine) Close() error { return nil }
+
+// wordAudio returns seconds of audio with a word every half second.
+func wordAudio(seconds float64) ([]float32, string) {
+	samples := make([]float32, int(seconds*EngineSampleRate))
+	var text strings.Builder
+	for i := range samples {
+		word := i/(EngineSampleRate/2) + 1
+		samples[i] = float32(word) / 1024
+		if i%(EngineSampleRate/2) == 0 {
+			fmt.Fprintf(&text, " w%d", word)
+		}
+	}
+	return samples, text.String()
+}
+
+func TestTranscribeTrack_Chunks(t *testing.T) {
+	samples, want := wordAudio(60)
+
+	transcribe := func(slots, maxParallel int) ([]SegmentInfo, []int, int) {
+		cfg := &config.Config{}
+		cfg.Audio.SampleRate = EngineSampleRate
+		cfg.Chunking.MinDuration = 20
+		cfg.Chunking.WindowSeconds = 10
+		cfg.Chunking.OverlapSeconds = 2
+		cfg.Chunking.MaxParallel = maxParallel
+		engines := make([]Engine, slots)
+		for i := range engines {
+			engines[i] = &wordEngine{}
+		}
+		service := NewTranscriptionServiceWithEngines(cfg, engines)
+		engine, err := service.pool.Acquire(context.Background())
+		assert.NoError(t, err)
+		defer service.pool.Release(engine, 0, 0)
+
+		var segments []SegmentInfo
+		var progress []int
+		_, chunks, err := service.transcribeTrack(context.Background(), engine, samples, TranscriptionOptions{},
+			func(seg SegmentInfo) { segments = append(segments, seg) },
+			func(percent int) { progress = append(progress, percent) })
+		assert.NoError(t, err)
+		return segments, progress, chunks
+	}
+
+	// Every word is heard once, in order, with times in the whole track
+	segments, progress, chunks := transcribe(1, 0)
+	assert.GreaterOrEqual(t, chunks, 8) // Each keeps at most the window less the overlap
+	var text strings.Builder
+	for i, seg := range segments {
+		text.WriteString(seg.Text)
+		if i > 0 {
+			assert.GreaterOrEqual(t, seg.StartTime, segments[i-1].EndTime)
+		}
+	}
+	assert.Equal(t, want, text.String())
+	assert.InDelta(t, 60.0, segments[len(segments)-1].EndTime, 1e-9)
+	assert.Equal(t, 100, progress[len(progress)-1])
+
+	// The result is the same however many slots decode the chunks
+	for _, slots := range []int{2, 4} {
+		parallel, _, _ := transcribe(slots, 0)
+		assert.Equal(t, segments, parallel, "%d slots", slots)
+	}
+	limited, _, _ := transcribe(4, 2)
+	assert.Equal(t, segments, limited)
+
+	// Short audio is decoded in one piece
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = EngineSampleRate
+	cfg.Chunking.MinDuration = 120
+	engine := &wordEngine{}
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{engine})
+	_, chunks, err := service.transcribeTrack(context.Background(), engine, samples, TranscriptionOptions{}, func(SegmentInfo) {}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, chunks)
+	assert.Equal(t, 1, engine.calls)
+}
+
+func TestTranscribeTrack_HelpersYieldSlots(t *testing.T) {
+	samples, _ := wordAudio(60)
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = EngineSampleRate
+	cfg.Chunking.MinDuration = 20
+	cfg.Chunking.WindowSeconds = 10
+	cfg.Chunking.OverlapSeconds = 2
+	cfg.Pool.QueueSize = 1
+	own, spare := &wordEngine{}, &wordEngine{}
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{own, spare})
+	pool := service.pool
+
+	engine, err := pool.Acquire(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Engine(own), engine)
+	defer pool.Release(engine, 0, 0)
+
+	// Another request queues while a helper decodes the first chunk on the
+	// spare slot. The request's own slot holds off its first chunk until the
+	// helper has started, and its second until the queued request has a
+	// slot, which only the helper giving the spare back can bring about.
+	helping, served := make(chan struct{}), make(chan struct{})
+	own.onCall = func(calls int) {
+		switch calls {
+		case 1:
+			<-helping
+		case 2:
+			select {
+			case <-served:
+			case <-time.After(5 * time.Second):
+				t.Error("the queued request did not get the spare slot")
+			}
+		}
+	}
+	spare.onCall = func(calls int) {
+		if calls > 1 {
+			return
+		}
+		go func() {
+			other, err := pool.Acquire(context.Background())
+			if assert.NoError(t, err) {
+				close(served)
+				pool.Release(other, 0, 0)
+			}
+		}()
+		waitForWaiting(t, pool, 1)
+		close(helping)
+	}
+
+	_, _, err = service.transcribeTrack(context.Background(), engine, samples, TranscriptionOptions{}, func(SegmentInfo) {}, nil)
+	assert.NoError(t, err)
+}
+
+func TestDropRepeatedWords(t *testing.T) {
+	prev := SegmentInfo{Text: " the quick brown fox", StartTime: 8, EndTime: 10.4}
+
+	// Words both chunks heard are dropped, ignoring case and punctuation
+	seg, ok := dropRepeatedWords(prev, SegmentInfo{Text: " Brown fox, jumps over", StartTime: 9.6, EndTime: 12})
+	assert.True(t, ok)
+	assert.Equal(t, " jumps over", seg.Text)
+	assert.Equal(t, 10.4, seg.StartTime)
+
+	// Tokens go with their words
+	seg, ok = dropRepeatedWords(prev, SegmentInfo{
+		Text: " fox jumps.",
+		Tokens: []TokenInfo{
+			{Text: "[_BEG_]"}, {Text: " f", StartTime: 10, EndTime: 10.2}, {Text: "ox", StartTime: 10.2, EndTime: 10.4},
+			{Text: " jumps", StartTime: 10.5, EndTime: 10.9}, {Text: ".", StartTime: 10.9, EndTime: 11},
+		},
+		StartTime: 10, EndTime: 11,
+	})
+	assert.True(t, ok)
+	assert.Equal(t, " jumps.", seg.Text)
+	assert.Len(t, seg.Tokens, 2)
+	assert.Equal(t, 10.5, seg.StartTime)
+
+	// Nothing is dropped without a repeat, and nothing is left of a segment
+	// that only repeats
+	seg, ok = dropRepeatedWords(prev, SegmentInfo{Text: " jumps over", StartTime: 10.5, EndTime: 12})
+	assert.True(t, ok)
+	assert.Equal(t, " jumps over", seg.Text)
+	_, ok = dropRepeatedWords(prev, SegmentInfo{Text: " fox.", StartTime: 10, EndTime: 10.4})
+	assert.False(t, ok)
+}
+
+func TestTrimSegment(t *testing.T) {
+	seg := SegmentInfo{
+		Text: " one two three",
+		Tokens: []TokenInfo{
+			{Text: " one", StartTime: 9, EndTime: 9.5}, {Text: " two", StartTime: 9.6, EndTime: 10.2}, {Text: " three", StartTime: 10.3, EndTime: 11},
+		},
+		StartTime: 9, EndTime: 11,
+	}
+
+	// Words go to the side of the cut their middle is on
+	before, ok := trimSegment(seg, 0, 10)
+	assert.True(t, ok)
+	assert.Equal(t, " one two", before.Text)
+	assert.Equal(t, 10.2, before.EndTime)
+	after, ok := trimSegment(seg, 10, 20)
+	assert.True(t, ok)
+	assert.Equal(t, " three", after.Text)
+	assert.Equal(t, 10.3, after.StartTime)
+	_, ok = trimSegment(seg, 11, 20)
+	assert.False(t, ok)
+
+	// Without token times the whole segment goes by its middle
+	untimed := SegmentInfo{Text: " one two three", StartTime: 9, EndTime: 11}
+	_, ok = trimSegment(untimed, 0, 10)
+	assert.False(t, ok)
+	kept, ok := trimSegment(untimed, 10, 20)
+	assert.True(t, ok)
+	assert.Equal(t, untimed, kept)
+}
+
+func TestTranscribeTrack_CancelStopsBetweenChunks(t *testing.T) {
+	samples, _ := wordAudio(60)
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = EngineSampleRate
+	cfg.Chunking.MinDuration = 20
+	cfg.Chunking.WindowSeconds = 10
+	cfg.Chunking.OverlapSeconds = 2
+	engine := &wordEngine{}
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{engine})
+
+	// The chunk being decoded when the request is cancelled is the last
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	engine.onCall = func(calls int) {
+		if calls == 2 {
+			cancel()
+		}
+	}
+	var segments []SegmentInfo
+	_, _, err := service.transcribeTrack(ctx, engine, samples, TranscriptionOptions{},
+		func(seg SegmentInfo) { segments = append(segments, seg) }, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 2, engine.calls)
+	assert.NotEmpty(t, segments)
+
+	// A cancelled request decodes nothing more
+	_, _, err = service.transcribeTrack(ctx, engine, samples[:EngineSampleRate], TranscriptionOptions{}, func(SegmentInfo) {}, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 2, engine.calls)
+}
@@ -5,7 +5,10 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"runtime"
 
 	"gopkg.in/yaml.v3"
 )
@@ -22,16 +25,112 @@ type Config struct {
 	} `yaml:"api"`
 
 	Whisper struct {
-		ModelPath string `yaml:"model_path"`
-		Language  string `yaml:"language"`
+		Engine           string  `yaml:"engine"` // "whisper" or "fake"
+		ModelPath        string  `yaml:"model_path"`
+		Language         string  `yaml:"language"`
+		Translate        bool    `yaml:"translate"`
+		InitialPrompt    string  `yaml:"initial_prompt"`
+		Temperature      float32 `yaml:"temperature"`
+		Threads          int     `yaml:"threads"`
+		MaxSegmentLength int     `yaml:"max_segment_length"`
+		TokenTimestamps  bool    `yaml:"token_timestamps"`
+
+		// Language identification, for language "auto" and /detect-language
+		Languages     []string `yaml:"languages"`      // Candidate languages; empty allows any the model knows
+		DetectSeconds float64  `yaml:"detect_seconds"` // Audio listened to, from the start
+
+		// Upper bounds that per-request options cannot exceed
+		MaxThreads int `yaml:"max_threads"`
 	} `yaml:"whisper"`
 
+	Pool struct {
+		Size      int `yaml:"size"`       // Concurrent inference slots, each loads its own model
+		QueueSize int `yaml:"queue_size"` // Requests allowed to wait for a slot
+		MaxWait   int `yaml:"max_wait_seconds"`
+	} `yaml:"pool"`
+
+	// Long audio is split into chunks decoded on several pool slots at once
+	Chunking struct {
+		MinDuration    float64 `yaml:"min_duration_seconds"` // Audio longer than this is chunked; 0 = never
+		WindowSeconds  float64 `yaml:"window_seconds"`       // Longest chunk
+		OverlapSeconds float64 `yaml:"overlap_seconds"`      // Audio shared by neighbouring chunks
+		MaxParallel    int     `yaml:"max_parallel"`         // Slots one request uses at once; 0 = the pool size
+	} `yaml:"chunking"`
+
+	// Speaker labels for the segments of each channel
+	Diarization struct {
+		Enabled     bool    `yaml:"enabled"`
+		MaxSpeakers int     `yaml:"max_speakers"` // Most speakers to find; 0 = no limit
+		Threshold   float64 `yaml:"threshold"`    // Voice distance, in standard deviations, below which turns are one speaker
+	} `yaml:"diarization"`
+
 	Audio struct {
-		SampleRate  int   `yaml:"sample_rate"`
-		MaxDuration int   `yaml:"max_duration_seconds"`
-		MaxFileSize int64 `yaml:"max_file_size_mb"`
+		SampleRate       int            `yaml:"sample_rate"`
+		MaxDuration      int            `yaml:"max_duration_seconds"`       // 0 = no limit
+		MaxDurationMode  string         `yaml:"max_duration_mode"`          // "reject" or "truncate" longer audio
+		TokenMaxDuration map[string]int `yaml:"token_max_duration_seconds"` // Limit per API token, overriding max_duration_seconds
+		MaxFileSize      int64          `yaml:"max_file_size_mb"`
+		ResampleQuality  string         `yaml:"resample_quality"` // "low", "medium" or "high"
+
+		// Voice activity detection, so only speech is transcribed
+		VAD struct {
+			Enabled     bool    `yaml:"enabled"`
+			Threshold   float64 `yaml:"threshold_db"`        // Energy above the noise floor that can be speech
+			MaxFlatness float64 `yaml:"max_flatness"`        // Spectral flatness (0-1) above which a frame is noise
+			MinSpeech   float64 `yaml:"min_speech_seconds"`  // Shorter bursts are dropped
+			MinSilence  float64 `yaml:"min_silence_seconds"` // Shorter pauses do not split speech
+			Padding     float64 `yaml:"padding_seconds"`     // Audio kept either side of speech
+		} `yaml:"vad"`
+
+		// Filters applied to decoded audio before transcription
+		Preprocess struct {
+			Profile        string              `yaml:"profile"`              // Chain applied unless a request names one; "" for none
+			Profiles       map[string][]string `yaml:"profiles"`             // Named chains of stages
+			HighPassHz     float64             `yaml:"highpass_hz"`          // Cutoff below which hum and CTCSS tones are removed
+			Deemphasis     float64             `yaml:"deemphasis_us"`        // FM de-emphasis time constant
+			NoiseReduction float64             `yaml:"noise_reduction_db"`   // Attenuation of the noise gate
+			LoudnessTarget float64             `yaml:"loudness_target_lufs"` // EBU R128 integrated loudness
+		} `yaml:"preprocess"`
 	} `yaml:"audio"`
 
+	Subtitles struct {
+		MaxLineLength int     `yaml:"max_line_length"`
+		MaxLines      int     `yaml:"max_lines"`
+		MinDuration   float64 `yaml:"min_duration_seconds"`
+	} `yaml:"subtitles"`
+
+	Stream struct {
+		WindowSeconds   float64 `yaml:"window_seconds"`  // Longest audio decoded in one pass
+		StepSeconds     float64 `yaml:"step_seconds"`    // New audio that triggers the next pass
+		OverlapSeconds  float64 `yaml:"overlap_seconds"` // Trailing audio whose text stays partial
+		MaxMessageBytes int     `yaml:"max_message_bytes"`
+	} `yaml:"stream"`
+
+	Jobs struct {
+		Store      string `yaml:"store"` // "memory" or "postgres"
+		Workers    int    `yaml:"workers"`
+		QueueSize  int    `yaml:"queue_size"`
+		StorageDir string `yaml:"storage_dir"`     // Where uploads wait for processing
+		Retention  int    `yaml:"retention_hours"` // Finished jobs are deleted after this long; negative keeps them
+		Postgres   struct {
+			Host     string `yaml:"host"`
+			Port     int    `yaml:"port"`
+			User     string `yaml:"user"`
+			Password string `yaml:"password"`
+			DBName   string `yaml:"dbname"`
+		} `yaml:"postgres"`
+	} `yaml:"jobs"`
+
+	Webhooks struct {
+		Secret          string            `yaml:"secret"`        // Default signing secret
+		TokenSecrets    map[string]string `yaml:"token_secrets"` // Signing secret per API token
+		MaxAttempts     int               `yaml:"max_attempts"`
+		InitialBackoff  int               `yaml:"initial_backoff_seconds"`
+		MaxBackoff      int               `yaml:"max_backoff_seconds"`
+		Timeout         int               `yaml:"timeout_seconds"`
+		AllowedNetworks []string          `yaml:"allowed_networks"` // Private CIDRs callbacks may reach
+	} `yaml:"webhooks"`
+
 	Metrics struct {
 		Enabled bool   `yaml:"enabled"`
 		Path    string `yaml:"path"`
@@ -85,9 +184,131 @@ func LoadConfig(filename string) (*Config, error) {
 	if config.Audio.SampleRate == 0 {
 		config.Audio.SampleRate = 16000
 	}
+	if config.Audio.ResampleQuality == "" {
+		config.Audio.ResampleQuality = "medium"
+	}
+	if config.Audio.VAD.Threshold == 0 {
+		config.Audio.VAD.Threshold = 10
+	}
+	if config.Audio.VAD.MaxFlatness == 0 {
+		config.Audio.VAD.MaxFlatness = 0.45
+	}
+	if config.Audio.VAD.MinSpeech == 0 {
+		config.Audio.VAD.MinSpeech = 0.25
+	}
+	if config.Audio.VAD.MinSilence == 0 {
+		config.Audio.VAD.MinSilence = 0.5
+	}
+	if config.Audio.VAD.Padding == 0 {
+		config.Audio.VAD.Padding = 0.2
+	}
+	if config.Audio.Preprocess.HighPassHz == 0 {
+		config.Audio.Preprocess.HighPassHz = 300
+	}
+	if config.Audio.Preprocess.Deemphasis == 0 {
+		config.Audio.Preprocess.Deemphasis = 750
+	}
+	if config.Audio.Preprocess.NoiseReduction == 0 {
+		config.Audio.Preprocess.NoiseReduction = 12
+	}
+	if config.Audio.Preprocess.LoudnessTarget == 0 {
+		config.Audio.Preprocess.LoudnessTarget = -23
+	}
+	if profile := config.Audio.Preprocess.Profile; profile != "" {
+		if _, ok := config.Audio.Preprocess.Profiles[profile]; !ok {
+			return nil, fmt.Errorf("invalid audio.preprocess.profile %q: not in audio.preprocess.profiles", profile)
+		}
+	}
+	switch config.Audio.MaxDurationMode {
+	case "":
+		config.Audio.MaxDurationMode = "reject"
+	case "reject", "truncate":
+	default:
+		return nil, fmt.Errorf("invalid audio.max_duration_mode %q: must be reject or truncate", config.Audio.MaxDurationMode)
+	}
+	if config.Whisper.Engine == "" {
+		config.Whisper.Engine = "whisper"
+	}
 	if config.Whisper.ModelPath == "" {
 		config.Whisper.ModelPath = "models/ggml-base.bin"
 	}
+	if config.Whisper.MaxThreads == 0 {
+		config.Whisper.MaxThreads = runtime.NumCPU()
+	}
+	if config.Whisper.DetectSeconds == 0 {
+		config.Whisper.DetectSeconds = 30
+	}
+	if config.Pool.Size == 0 {
+		config.Pool.Size = 1
+	}
+	if config.Pool.QueueSize == 0 {
+		config.Pool.QueueSize = 10
+	}
+	if config.Pool.MaxWait == 0 {
+		config.Pool.MaxWait = 60
+	}
+	if config.Diarization.Threshold == 0 {
+		config.Diarization.Threshold = 0.5
+	}
+	if config.Chunking.WindowSeconds == 0 {
+		config.Chunking.WindowSeconds = 30
+	}
+	if config.Chunking.OverlapSeconds == 0 {
+		config.Chunking.OverlapSeconds = 2
+	}
+	if config.Chunking.OverlapSeconds*2 >= config.Chunking.WindowSeconds {
+		return nil, fmt.Errorf("invalid chunking.overlap_seconds %g: must be less than half of window_seconds", config.Chunking.OverlapSeconds)
+	}
+	if config.Subtitles.MaxLineLength == 0 {
+		config.Subtitles.MaxLineLength = 42
+	}
+	if config.Subtitles.MaxLines == 0 {
+		config.Subtitles.MaxLines = 2
+	}
+	if config.Stream.WindowSeconds == 0 {
+		config.Stream.WindowSeconds = 10
+	}
+	if config.Stream.StepSeconds == 0 {
+		config.Stream.StepSeconds = 1
+	}
+	if config.Stream.OverlapSeconds == 0 {
+		config.Stream.OverlapSeconds = 2
+	}
+	if config.Stream.MaxMessageBytes == 0 {
+		config.Stream.MaxMessageBytes = 1 << 20
+	}
+	if config.Jobs.Store == "" {
+		config.Jobs.Store = "memory"
+	}
+	if config.Jobs.Workers == 0 {
+		config.Jobs.Workers = 1
+	}
+	if config.Jobs.QueueSize == 0 {
+		config.Jobs.QueueSize = 100
+	}
+	if config.Jobs.Retention == 0 {
+		config.Jobs.Retention = 24
+	}
+	if config.Jobs.StorageDir == "" {
+		config.Jobs.StorageDir = filepath.Join(os.TempDir(), "whisperapi-jobs")
+	}
+	if config.Webhooks.MaxAttempts == 0 {
+		config.Webhooks.MaxAttempts = 5
+	}
+	if config.Webhooks.InitialBackoff == 0 {
+		config.Webhooks.InitialBackoff = 2
+	}
+	if config.Webhooks.MaxBackoff == 0 {
+		config.Webhooks.MaxBackoff = 300
+	}
+	if config.Webhooks.Timeout == 0 {
+		config.Webhooks.Timeout = 10
+	}
+	for _, network := range config.Webhooks.AllowedNetworks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return nil, fmt.Errorf("invalid webhooks.allowed_networks entry %q: must be a CIDR", network)
+		}
+	}
 	if config.Metrics.Path == "" {
 		config.Metrics.Path = "/metrics"
 	}
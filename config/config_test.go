@@ -5,6 +5,7 @@ package config
 
 import (
 	"os"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,13 +23,51 @@ api:
   swagger_host: test.api.com
 
 whisper:
+  engine: fake
   model_path: /path/to/model
   language: en
+  translate: true
+  temperature: 0.2
+  threads: 2
+  max_threads: 4
+
+pool:
+  size: 2
+  queue_size: 4
+  max_wait_seconds: 30
 
 audio:
   sample_rate: 16000
   max_duration_seconds: 120
+  max_duration_mode: truncate
+  token_max_duration_seconds:
+    token-a: 3600
   max_file_size_mb: 10
+  resample_quality: high
+
+subtitles:
+  max_line_length: 32
+  max_lines: 1
+  min_duration_seconds: 0.8
+
+stream:
+  window_seconds: 8
+  step_seconds: 0.5
+  overlap_seconds: 1.5
+
+jobs:
+  store: postgres
+  workers: 2
+  postgres:
+    host: db
+    port: 5432
+
+webhooks:
+  secret: shared
+  token_secrets:
+    token-a: secret-a
+  max_attempts: 3
+  allowed_networks: ["10.1.0.0/16"]
 
 metrics:
   enabled: true
@@ -53,6 +92,33 @@ metrics:
 	assert.Equal(t, 9090, cfg.Server.Port)
 	assert.Equal(t, "/api/v1", cfg.API.BasePath)
 	assert.Equal(t, 16000, cfg.Audio.SampleRate)
+	assert.Equal(t, "high", cfg.Audio.ResampleQuality)
+	assert.Equal(t, 120, cfg.Audio.MaxDuration)
+	assert.Equal(t, "truncate", cfg.Audio.MaxDurationMode)
+	assert.Equal(t, 3600, cfg.Audio.TokenMaxDuration["token-a"])
+	assert.Equal(t, "fake", cfg.Whisper.Engine)
+	assert.Equal(t, "en", cfg.Whisper.Language)
+	assert.True(t, cfg.Whisper.Translate)
+	assert.InDelta(t, 0.2, cfg.Whisper.Temperature, 1e-6)
+	assert.Equal(t, 2, cfg.Whisper.Threads)
+	assert.Equal(t, 4, cfg.Whisper.MaxThreads)
+	assert.Equal(t, 2, cfg.Pool.Size)
+	assert.Equal(t, 4, cfg.Pool.QueueSize)
+	assert.Equal(t, 30, cfg.Pool.MaxWait)
+	assert.Equal(t, 32, cfg.Subtitles.MaxLineLength)
+	assert.Equal(t, 1, cfg.Subtitles.MaxLines)
+	assert.InDelta(t, 0.8, cfg.Subtitles.MinDuration, 1e-9)
+	assert.Equal(t, 8.0, cfg.Stream.WindowSeconds)
+	assert.Equal(t, 0.5, cfg.Stream.StepSeconds)
+	assert.Equal(t, 1.5, cfg.Stream.OverlapSeconds)
+	assert.Equal(t, 1<<20, cfg.Stream.MaxMessageBytes)
+	assert.Equal(t, "postgres", cfg.Jobs.Store)
+	assert.Equal(t, 2, cfg.Jobs.Workers)
+	assert.Equal(t, "db", cfg.Jobs.Postgres.Host)
+	assert.Equal(t, "shared", cfg.Webhooks.Secret)
+	assert.Equal(t, "secret-a", cfg.Webhooks.TokenSecrets["token-a"])
+	assert.Equal(t, 3, cfg.Webhooks.MaxAttempts)
+	assert.Equal(t, []string{"10.1.0.0/16"}, cfg.Webhooks.AllowedNetworks)
 	assert.Equal(t, true, cfg.Metrics.Enabled)
 }
 
@@ -77,6 +143,96 @@ func TestDefaultValues(t *testing.T) {
 	assert.Equal(t, 8080, cfg.Server.Port)
 	assert.Equal(t, "/", cfg.API.BasePath)
 	assert.Equal(t, 16000, cfg.Audio.SampleRate)
+	assert.Equal(t, "medium", cfg.Audio.ResampleQuality)
+	assert.Equal(t, "reject", cfg.Audio.MaxDurationMode)
+	assert.False(t, cfg.Audio.VAD.Enabled)
+	assert.Equal(t, 10.0, cfg.Audio.VAD.Threshold)
+	assert.Equal(t, 0.45, cfg.Audio.VAD.MaxFlatness)
+	assert.Equal(t, 0.25, cfg.Audio.VAD.MinSpeech)
+	assert.Equal(t, 0.5, cfg.Audio.VAD.MinSilence)
+	assert.Equal(t, 0.2, cfg.Audio.VAD.Padding)
+	assert.Empty(t, cfg.Audio.Preprocess.Profile)
+	assert.Equal(t, 300.0, cfg.Audio.Preprocess.HighPassHz)
+	assert.Equal(t, 750.0, cfg.Audio.Preprocess.Deemphasis)
+	assert.Equal(t, 12.0, cfg.Audio.Preprocess.NoiseReduction)
+	assert.Equal(t, -23.0, cfg.Audio.Preprocess.LoudnessTarget)
+	assert.Equal(t, "whisper", cfg.Whisper.Engine)
 	assert.Equal(t, "models/ggml-base.bin", cfg.Whisper.ModelPath)
+	assert.Equal(t, runtime.NumCPU(), cfg.Whisper.MaxThreads)
+	assert.Equal(t, 30.0, cfg.Whisper.DetectSeconds)
+	assert.False(t, cfg.Diarization.Enabled)
+	assert.Equal(t, 0.5, cfg.Diarization.Threshold)
+	assert.Equal(t, 1, cfg.Pool.Size)
+	assert.Equal(t, 10, cfg.Pool.QueueSize)
+	assert.Equal(t, 60, cfg.Pool.MaxWait)
+	assert.Zero(t, cfg.Chunking.MinDuration)
+	assert.Equal(t, 30.0, cfg.Chunking.WindowSeconds)
+	assert.Equal(t, 2.0, cfg.Chunking.OverlapSeconds)
+	assert.Equal(t, 42, cfg.Subtitles.MaxLineLength)
+	assert.Equal(t, 2, cfg.Subtitles.MaxLines)
+	assert.Equal(t, 10.0, cfg.Stream.WindowSeconds)
+	assert.Equal(t, 1.0, cfg.Stream.StepSeconds)
+	assert.Equal(t, 2.0, cfg.Stream.OverlapSeconds)
+	assert.Equal(t, "memory", cfg.Jobs.Store)
+	assert.Equal(t, 1, cfg.Jobs.Workers)
+	assert.Equal(t, 100, cfg.Jobs.QueueSize)
+	assert.Equal(t, 24, cfg.Jobs.Retention)
+	assert.NotEmpty(t, cfg.Jobs.StorageDir)
+	assert.Equal(t, 5, cfg.Webhooks.MaxAttempts)
+	assert.Equal(t, 2, cfg.Webhooks.InitialBackoff)
+	assert.Equal(t, 300, cfg.Webhooks.MaxBackoff)
+	assert.Equal(t, 10, cfg.Webhooks.Timeout)
 	assert.Equal(t, "/metrics", cfg.Metrics.Path)
 }
+
+func TestInvalidMaxDurationMode(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "config.*.yaml")
+	assert.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+
+	_, err = tmpfile.Write([]byte("audio:\n  max_duration_mode: split\n"))
+	assert.NoError(t, err)
+	tmpfile.Close()
+
+	_, err = LoadConfig(tmpfile.Name())
+	assert.ErrorContains(t, err, "max_duration_mode")
+}
+
+func TestInvalidChunkOverlap(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "config.*.yaml")
+	assert.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+
+	_, err = tmpfile.Write([]byte("chunking:\n  window_seconds: 10\n  overlap_seconds: 5\n"))
+	assert.NoError(t, err)
+	tmpfile.Close()
+
+	_, err = LoadConfig(tmpfile.Name())
+	assert.ErrorContains(t, err, "overlap_seconds")
+}
+
+func TestInvalidWebhookNetwork(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "config.*.yaml")
+	assert.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+
+	_, err = tmpfile.Write([]byte("webhooks:\n  allowed_networks: [\"10.0.0.1\"]\n"))
+	assert.NoError(t, err)
+	tmpfile.Close()
+
+	_, err = LoadConfig(tmpfile.Name())
+	assert.ErrorContains(t, err, "allowed_networks")
+}
+
+func TestUnknownPreprocessProfile(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "config.*.yaml")
+	assert.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+
+	_, err = tmpfile.Write([]byte("audio:\n  preprocess:\n    profile: fm\n    profiles:\n      am: [loudnorm]\n"))
+	assert.NoError(t, err)
+	tmpfile.Close()
+
+	_, err = LoadConfig(tmpfile.Name())
+	assert.ErrorContains(t, err, "audio.preprocess.profile")
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/VA7DBI/whisperAPI/audio"
+	"github.com/gin-gonic/gin"
+)
+
+// MaxDetectSeconds is the most audio whisper listens to when identifying the
+// language: one window of the encoder.
+const MaxDetectSeconds = 30
+
+// detectSeconds returns the configured audio to listen to when identifying
+// the language, in seconds.
+func (s *TranscriptionService) detectSeconds() float64 {
+	if s.config.Whisper.DetectSeconds > 0 {
+		return min(s.config.Whisper.DetectSeconds, MaxDetectSeconds)
+	}
+	return MaxDetectSeconds
+}
+
+// LanguageProbability is a candidate language and how likely it is to be the
+// one spoken.
+type LanguageProbability struct {
+	Code        string  `json:"code"`
+	Name        string  `json:"name"`
+	Probability float64 `json:"probability"`
+}
+
+// LanguageDetectionResponse represents the result of language identification.
+type LanguageDetectionResponse struct {
+	Language       string                `json:"language"`         // Most likely language code
+	Probability    float64               `json:"probability"`      // Its probability
+	Languages      []LanguageProbability `json:"languages"`        // Every candidate, most likely first
+	Duration       float64               `json:"duration_seconds"` // Audio listened to
+	ProcessingTime float64               `json:"processing_time_seconds"`
+	Preprocess     []string              `json:"preprocess,omitempty"`
+}
+
+// DetectLanguageHandler handles the language identification request.
+// @Summary     Identify the spoken language
+// @Description Runs whisper's language identification on the start of an audio file and returns the candidate languages ranked by probability.
+// @Tags        transcription
+// @Accept      multipart/form-data
+// @Produce     json
+// @Param       audio formData file true "Audio file (WAV, MP3, OGG Vorbis, or Opus format)"
+// @Param       duration formData number false "Seconds from the start to listen to, up to 30 (default whisper.detect_seconds)"
+// @Param       languages formData string false "Comma-separated candidate language codes; probabilities are renormalized over them (default whisper.languages)"
+// @Param       preprocess formData string false "Preprocessing profile, comma-separated stages (highpass, deemphasis, denoise, loudnorm) or none"
+// @Success     200 {object} LanguageDetectionResponse "Candidate languages, most likely first"
+// @Failure     400 {object} ErrorResponse "Invalid request (missing file, file too large, invalid option, no audio)"
+// @Failure     401 {object} ErrorResponse "Unauthorized (invalid or missing API key)"
+// @Failure     429 {object} ErrorResponse "Transcription queue is full, retry after the Retry-After header"
+// @Failure     500 {object} ErrorResponse "Server error during processing"
+// @Failure     501 {object} ErrorResponse "The engine cannot identify languages"
+// @Failure     503 {object} ErrorResponse "Timed out waiting for a transcription slot"
+// @Security    ApiKeyAuth
+// @Router      /detect-language [post]
+func (s *TranscriptionService) DetectLanguageHandler(c *gin.Context) {
+	file, err := c.FormFile("audio")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "No audio file provided"})
+		return
+	}
+
+	seconds := s.detectSeconds()
+	if v, ok := c.GetPostForm("duration"); ok && v != "" {
+		d, err := strconv.ParseFloat(v, 64)
+		if err != nil || d <= 0 || d > MaxDetectSeconds {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("invalid duration %q: must be more than 0 and at most %d seconds", v, MaxDetectSeconds)})
+			return
+		}
+		seconds = d
+	}
+
+	candidates := s.config.Whisper.Languages
+	if v, ok := c.GetPostForm("languages"); ok && v != "" {
+		candidates, err = parseLanguages(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	preprocess := s.config.Audio.Preprocess.Profile
+	if v, ok := c.GetPostForm("preprocess"); ok && v != "" {
+		preprocess = v
+	}
+	stages, err := s.preprocessStages(preprocess)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	response, err := s.detectUpload(c.Request.Context(), file, seconds, candidates, stages)
+	if err != nil {
+		setRetryAfter(c, err)
+		c.JSON(errorStatus(err), errorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// detectUpload identifies the language spoken in the first seconds of an
+// uploaded file, decoding no more of it than that.
+func (s *TranscriptionService) detectUpload(ctx context.Context, file *multipart.FileHeader, seconds float64, candidates, stages []string) (*LanguageDetectionResponse, error) {
+	startTime := time.Now()
+	if file.Size > s.config.Audio.MaxFileSize*1024*1024 {
+		return nil, &requestError{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("File too large. Maximum size is %dMB", s.config.Audio.MaxFileSize),
+		}
+	}
+	src, err := file.Open()
+	if err != nil {
+		return nil, &requestError{Status: http.StatusInternalServerError, Message: "Failed to read audio file"}
+	}
+	defer src.Close()
+
+	rate := s.config.Audio.SampleRate
+	stream, _, err := audio.DecodeNamed(src, file.Filename, rate)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get audio metadata: %v", err)
+	}
+	samples, _, err := audio.ReadAtMost(stream, int(seconds*float64(rate)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to convert audio: %v", err)
+	}
+	if len(samples) == 0 {
+		return nil, &requestError{Status: http.StatusBadRequest, Message: "The audio file holds no samples"}
+	}
+	audio.Preprocess(samples, rate, stages, s.preprocessOptions())
+
+	engine, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, s.poolError(err)
+	}
+	processStart := time.Now()
+	languages, err := detectLanguage(engine, samples, candidates, s.defaultOptions().Threads)
+	duration := float64(len(samples)) / float64(rate)
+	s.pool.Release(engine, duration, time.Since(processStart).Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	return &LanguageDetectionResponse{
+		Language:       languages[0].Code,
+		Probability:    languages[0].Probability,
+		Languages:      languages,
+		Duration:       duration,
+		ProcessingTime: time.Since(startTime).Seconds(),
+		Preprocess:     stages,
+	}, nil
+}
+
+// detectLanguage runs engine's language identification over samples and ranks
+// the candidates, or every language the model knows when there are none.
+func detectLanguage(engine Engine, samples []float32, candidates []string, threads uint) ([]LanguageProbability, error) {
+	detector, ok := engine.(LanguageDetector)
+	if !ok {
+		return nil, &requestError{Status: http.StatusNotImplemented, Message: "The engine cannot identify languages"}
+	}
+	probabilities, err := detector.DetectLanguage(samples, threads)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to identify the language: %v", err)
+	}
+	languages := rankLanguages(probabilities, candidates)
+	if len(languages) == 0 {
+		return nil, fmt.Errorf("Failed to identify the language: the model reported no languages")
+	}
+	return languages, nil
+}
+
+// rankLanguages orders languages by probability, most likely first. Given
+// candidates, only they are kept and their probabilities scaled to sum to 1.
+func rankLanguages(probabilities map[string]float64, candidates []string) []LanguageProbability {
+	var languages []LanguageProbability
+	var total float64
+	for _, lang := range whisperLanguages {
+		p, known := probabilities[lang.Code]
+		if len(candidates) > 0 && !slices.Contains(candidates, lang.Code) || len(candidates) == 0 && !known {
+			continue
+		}
+		languages = append(languages, LanguageProbability{Code: lang.Code, Name: lang.Name, Probability: p})
+		total += p
+	}
+	if len(candidates) > 0 && total > 0 {
+		for i := range languages {
+			languages[i].Probability /= total
+		}
+	}
+	sort.SliceStable(languages, func(a, b int) bool { return languages[a].Probability > languages[b].Probability })
+	return languages
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// spokenFrench is what a fake engine hears as French, with some doubt.
+var spokenFrench = map[string]float64{"fr": 0.6, "en": 0.3, "de": 0.1}
+
+func newDetectTestService(engine Engine) *TranscriptionService {
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = 16000
+	cfg.Audio.MaxFileSize = 25
+	return NewTranscriptionServiceWithEngines(cfg, []Engine{engine})
+}
+
+// postDetect uploads the WAV file at path to /detect-language with fields.
+func postDetect(r *gin.Engine, path string, fields map[string]string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, _ := writer.CreateFormFile("audio", "clip.wav")
+	data, _ := os.ReadFile(path)
+	part.Write(data)
+	for k, v := range fields {
+		writer.WriteField(k, v)
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/detect-language", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRankLanguages(t *testing.T) {
+	ranked := rankLanguages(spokenFrench, nil)
+	assert.Equal(t, []LanguageProbability{
+		{Code: "fr", Name: "french", Probability: 0.6},
+		{Code: "en", Name: "english", Probability: 0.3},
+		{Code: "de", Name: "german", Probability: 0.1},
+	}, ranked)
+
+	// Candidates share all the probability, even ones the model did not report
+	ranked = rankLanguages(spokenFrench, []string{"de", "en", "nl"})
+	if assert.Len(t, ranked, 3) {
+		assert.Equal(t, "en", ranked[0].Code)
+		assert.InDelta(t, 0.75, ranked[0].Probability, 1e-9)
+		assert.Equal(t, "de", ranked[1].Code)
+		assert.InDelta(t, 0.25, ranked[1].Probability, 1e-9)
+		assert.Equal(t, LanguageProbability{Code: "nl", Name: "dutch"}, ranked[2])
+	}
+}
+
+func TestDetectLanguageHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := NewFakeEngine(nil)
+	engine.Languages = spokenFrench
+	service := newDetectTestService(engine)
+	r := gin.New()
+	r.POST("/detect-language", service.DetectLanguageHandler)
+	wav := writeTestWAV(t, 45, 1)
+
+	// Only the first detect_seconds are listened to
+	w := postDetect(r, wav, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response LanguageDetectionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "fr", response.Language)
+	assert.Equal(t, 0.6, response.Probability)
+	assert.Len(t, response.Languages, 3)
+	assert.Equal(t, 30.0, response.Duration)
+
+	w = postDetect(r, wav, map[string]string{"duration": "5", "languages": "en,de"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "en", response.Language)
+	assert.InDelta(t, 0.75, response.Probability, 1e-9)
+	assert.Len(t, response.Languages, 2)
+	assert.Equal(t, 5.0, response.Duration)
+
+	for _, fields := range []map[string]string{
+		{"duration": "31"},
+		{"duration": "0"},
+		{"languages": "en,klingon"},
+		{"preprocess": "am"},
+	} {
+		w = postDetect(r, wav, fields)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "%v", fields)
+	}
+
+	// Engines without language identification say so
+	service = newDetectTestService(&wordEngine{})
+	r = gin.New()
+	r.POST("/detect-language", service.DetectLanguageHandler)
+	w = postDetect(r, wav, nil)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+func TestTranscribeAudio_LanguageAuto(t *testing.T) {
+	engine := NewFakeEngine(nil)
+	engine.Languages = spokenFrench
+	service := newDetectTestService(engine)
+	wav, err := os.ReadFile(writeTestWAV(t, 2, 1))
+	assert.NoError(t, err)
+
+	// The most likely language is transcribed and reported
+	response, err := service.transcribeAudio(context.Background(), bytes.NewReader(wav), "clip.wav", TranscriptionOptions{Language: "auto"}, nil, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "fr", response.DetectedLanguage)
+		assert.Equal(t, 0.6, response.LanguageProbability)
+		assert.Equal(t, "fr", response.Options.Language)
+	}
+	_, opts := engine.Calls()
+	assert.Equal(t, "fr", opts.Language)
+
+	// Detection picks among the candidates
+	response, err = service.transcribeAudio(context.Background(), bytes.NewReader(wav), "clip.wav", TranscriptionOptions{Language: "auto", Languages: []string{"de", "en"}}, nil, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "en", response.DetectedLanguage)
+		assert.InDelta(t, 0.75, response.LanguageProbability, 1e-9)
+	}
+
+	// A language given is not second-guessed
+	response, err = service.transcribeAudio(context.Background(), bytes.NewReader(wav), "clip.wav", TranscriptionOptions{Language: "de"}, nil, nil)
+	if assert.NoError(t, err) {
+		assert.Empty(t, response.DetectedLanguage)
+		assert.Equal(t, "de", response.Options.Language)
+	}
+}
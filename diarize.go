@@ -0,0 +1,79 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/VA7DBI/whisperAPI/audio"
+)
+
+// Diarization methods reported in DiarizationReport
+const (
+	DiarizeTurns      = "tinydiarize" // Turns the model marked, told apart by voice
+	DiarizeClustering = "clustering"  // Each segment told apart by voice
+)
+
+// speakerTurnMark is how a tinydiarize model writes out the end of a speaker's
+// turn.
+const speakerTurnMark = "[_SPEAKER_TURN_]"
+
+// takeSpeakerTurns removes the speaker turn marks from the text and tokens of
+// seg, and marks the segment as ending a turn if it had any.
+func takeSpeakerTurns(seg SegmentInfo) SegmentInfo {
+	if !strings.Contains(seg.Text, speakerTurnMark) && !slices.ContainsFunc(seg.Tokens, isSpeakerTurn) {
+		return seg
+	}
+	seg.SpeakerTurn = true
+	seg.Text = strings.ReplaceAll(strings.ReplaceAll(seg.Text, " "+speakerTurnMark, ""), speakerTurnMark, "")
+	tokens := make([]TokenInfo, 0, len(seg.Tokens))
+	for _, token := range seg.Tokens {
+		if !isSpeakerTurn(token) {
+			tokens = append(tokens, token)
+		}
+	}
+	seg.Tokens = tokens
+	return seg
+}
+
+func isSpeakerTurn(token TokenInfo) bool {
+	return strings.TrimSpace(token.Text) == speakerTurnMark
+}
+
+// diarizeTrack labels the segments of one track with speakers, numbered on
+// from first. When the model marked speaker turns, the segments of a turn
+// are one speaker's; otherwise each segment is told apart on its own.
+// samples is the whole track the segment times refer to. It returns the
+// number of speakers found and whether the model marked turns.
+func (s *TranscriptionService) diarizeTrack(segments []SegmentInfo, samples []float32, opts TranscriptionOptions, first int) (int, bool) {
+	if len(segments) == 0 {
+		return 0, false
+	}
+	turns := slices.ContainsFunc(segments, func(seg SegmentInfo) bool { return seg.SpeakerTurn })
+	rate := float64(s.config.Audio.SampleRate)
+	var spans []audio.Span
+	span := make([]int, len(segments)) // Span of each segment
+	for i, seg := range segments {
+		if i == 0 || !turns || segments[i-1].SpeakerTurn {
+			spans = append(spans, audio.Span{Start: int(seg.StartTime * rate)})
+		}
+		spans[len(spans)-1].End = int(seg.EndTime * rate)
+		span[i] = len(spans) - 1
+	}
+
+	labels := audio.Diarize(samples, s.config.Audio.SampleRate, spans, s.diarizeOptions(opts))
+	speakers := 0
+	for i := range segments {
+		segments[i].Speaker = speakerLabel(first + labels[span[i]])
+		speakers = max(speakers, labels[span[i]]+1)
+	}
+	return speakers, turns
+}
+
+// speakerLabel names the speaker numbered n from 0.
+func speakerLabel(n int) string {
+	return fmt.Sprintf("SPEAKER_%02d", n)
+}
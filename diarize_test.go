@@ -0,0 +1,128 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// vowel returns seconds of the harmonics of f0 shaped by resonances at the
+// formants, a steady stand-in for a voice.
+func vowel(f0 float64, formants []float64, seconds float64) []float32 {
+	samples := make([]float32, int(seconds*EngineSampleRate))
+	for h := 1; float64(h)*f0 < EngineSampleRate/2; h++ {
+		f := float64(h) * f0
+		var amplitude float64
+		for _, formant := range formants {
+			amplitude += 1 / (1 + math.Pow((f-formant)/100, 2))
+		}
+		for i := range samples {
+			samples[i] += float32(0.05 * amplitude * math.Sin(2*math.Pi*f*float64(i)/EngineSampleRate+float64(h)))
+		}
+	}
+	return samples
+}
+
+// radioCall returns four two-second turns, dispatcher and unit in turn, and a
+// segment for each second of them.
+func radioCall() ([]float32, []SegmentInfo) {
+	var samples []float32
+	var segments []SegmentInfo
+	for turn := 0; turn < 4; turn++ {
+		voice := vowel(120, []float64{700, 1200, 2600}, 2)
+		if turn%2 == 1 {
+			voice = vowel(200, []float64{350, 2100, 3000}, 2)
+		}
+		start := float64(len(samples)) / EngineSampleRate
+		samples = append(samples, voice...)
+		segments = append(segments,
+			SegmentInfo{Text: " Go ahead.", StartTime: start, EndTime: start + 1},
+			SegmentInfo{Text: " Over.", StartTime: start + 1, EndTime: start + 2})
+	}
+	return samples, segments
+}
+
+func speakersOf(segments []SegmentInfo) []string {
+	speakers := make([]string, len(segments))
+	for i, seg := range segments {
+		speakers[i] = seg.Speaker
+	}
+	return speakers
+}
+
+func TestDiarizeTrack(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = EngineSampleRate
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{NewFakeEngine(nil)})
+	samples, segments := radioCall()
+
+	// Without turn tokens each segment is told apart by voice
+	speakers, turns := service.diarizeTrack(segments, samples, TranscriptionOptions{Diarize: true}, 0)
+	assert.Equal(t, 2, speakers)
+	assert.False(t, turns)
+	assert.Equal(t, []string{
+		"SPEAKER_00", "SPEAKER_00", "SPEAKER_01", "SPEAKER_01",
+		"SPEAKER_00", "SPEAKER_00", "SPEAKER_01", "SPEAKER_01",
+	}, speakersOf(segments))
+
+	// Turns the model marked keep their segments together, and numbering
+	// carries on from earlier channels
+	_, segments = radioCall()
+	for i := 1; i < len(segments); i += 2 {
+		segments[i].SpeakerTurn = true
+	}
+	speakers, turns = service.diarizeTrack(segments, samples, TranscriptionOptions{Diarize: true}, 2)
+	assert.Equal(t, 2, speakers)
+	assert.True(t, turns)
+	assert.Equal(t, "SPEAKER_02", segments[1].Speaker)
+	assert.Equal(t, "SPEAKER_03", segments[2].Speaker)
+
+	// The hint caps the speakers
+	speakers, _ = service.diarizeTrack(segments, samples, TranscriptionOptions{Diarize: true, MaxSpeakers: 1}, 0)
+	assert.Equal(t, 1, speakers)
+	assert.Equal(t, "SPEAKER_00", segments[7].Speaker)
+}
+
+func TestTakeSpeakerTurns(t *testing.T) {
+	seg := takeSpeakerTurns(SegmentInfo{
+		Text: " Go ahead, 12. [_SPEAKER_TURN_]",
+		Tokens: []TokenInfo{
+			{Text: " Go"}, {Text: " ahead"}, {Text: ","}, {Text: " 12"}, {Text: "."}, {Text: " [_SPEAKER_TURN_]"},
+		},
+	})
+	assert.True(t, seg.SpeakerTurn)
+	assert.Equal(t, " Go ahead, 12.", seg.Text)
+	assert.Len(t, seg.Tokens, 5)
+
+	// Segments without the mark are left as they are
+	plain := SegmentInfo{Text: " Dispatch, unit 12.", Tokens: []TokenInfo{{Text: " Dispatch"}}}
+	assert.Equal(t, plain, takeSpeakerTurns(plain))
+}
+
+func TestTranscribeAudio_Diarize(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = EngineSampleRate
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{NewFakeEngine(nil)})
+	wav, err := os.ReadFile(writeTestWAV(t, 2, 1))
+	assert.NoError(t, err)
+
+	response, err := service.transcribeAudio(context.Background(), bytes.NewReader(wav), "tone.wav", TranscriptionOptions{Diarize: true}, nil, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &DiarizationReport{Method: DiarizeClustering, Speakers: 1}, response.Diarization)
+		assert.Equal(t, "SPEAKER_00", response.Segments[0].Speaker)
+	}
+
+	response, err = service.transcribeAudio(context.Background(), bytes.NewReader(wav), "tone.wav", TranscriptionOptions{}, nil, nil)
+	if assert.NoError(t, err) {
+		assert.Nil(t, response.Diarization)
+		assert.Empty(t, response.Segments[0].Speaker)
+	}
+}
@@ -23,6 +23,117 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/detect-language": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Runs whisper's language identification on the start of an audio file and returns the candidate languages ranked by probability.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transcription"
+                ],
+                "summary": "Identify the spoken language",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Audio file (WAV, MP3, OGG Vorbis, or Opus format)",
+                        "name": "audio",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Seconds from the start to listen to, up to 30 (default whisper.detect_seconds)",
+                        "name": "duration",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated candidate language codes; probabilities are renormalized over them (default whisper.languages)",
+                        "name": "languages",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Preprocessing profile, comma-separated stages (highpass, deemphasis, denoise, loudnorm) or none",
+                        "name": "preprocess",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Candidate languages, most likely first",
+                        "schema": {
+                            "$ref": "#/definitions/main.LanguageDetectionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request (missing file, file too large, invalid option, no audio)",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized (invalid or missing API key)",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Transcription queue is full, retry after the Retry-After header",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server error during processing",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "The engine cannot identify languages",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Timed out waiting for a transcription slot",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/formats": {
+            "get": {
+                "description": "List the audio formats this build recognizes and whether each can be transcribed. Formats are detected from file content; availability can depend on build options, e.g. Opus decoding requires cgo.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "formats"
+                ],
+                "summary": "List supported audio formats",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.FormatsResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/health": {
             "get": {
                 "description": "Get API health status",
@@ -43,14 +154,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/transcribe": {
+        "/jobs": {
             "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Converts audio file to text using Whisper AI model. Supports WAV, MP3, OGG (Vorbis), and Opus formats.",
+                "description": "Accepts the same upload and decoding options as /transcribe and returns immediately with a job ID. Poll GET /jobs/{id} for progress and the result.",
                 "consumes": [
                     "multipart/form-data"
                 ],
@@ -58,9 +169,9 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "transcription"
+                    "jobs"
                 ],
-                "summary": "Transcribe audio to text",
+                "summary": "Submit an asynchronous transcription job",
                 "parameters": [
                     {
                         "type": "file",
@@ -68,17 +179,143 @@ const docTemplate = `{
                         "name": "audio",
                         "in": "formData",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Spoken language (ISO 639-1 code) or auto to detect",
+                        "name": "language",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated candidate language codes for language=auto (default whisper.languages)",
+                        "name": "languages",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Translate the transcription to English",
+                        "name": "translate",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Initial prompt to guide the decoder",
+                        "name": "initial_prompt",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Sampling temperature (0.0-1.0)",
+                        "name": "temperature",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of decoding threads (up to the configured max_threads)",
+                        "name": "threads",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum segment length in characters (0 = no limit)",
+                        "name": "max_segment_length",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Compute per-token timestamps",
+                        "name": "token_timestamps",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Transcribe only up to the maximum duration instead of rejecting longer audio",
+                        "name": "truncate",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "mix (default) to downmix, or separate to transcribe each channel and merge the segments by time",
+                        "name": "channels",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Transcribe only this channel, numbered from 0",
+                        "name": "channel",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Transcribe only the speech found by voice activity detection",
+                        "name": "vad",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Energy above the noise floor, in dB, that can be speech (default 10)",
+                        "name": "vad_threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Spectral flatness, 0-1, above which loud audio is noise (default 0.45)",
+                        "name": "vad_flatness",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Seconds; shorter bursts are dropped (default 0.25)",
+                        "name": "vad_min_speech",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Seconds; shorter pauses do not split speech (default 0.5)",
+                        "name": "vad_min_silence",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Seconds kept either side of speech (default 0.2)",
+                        "name": "vad_padding",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Preprocessing profile, comma-separated stages (highpass, deemphasis, denoise, loudnorm) or none",
+                        "name": "preprocess",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Label each segment with its speaker (default diarization.enabled)",
+                        "name": "diarize",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Most speakers to tell apart, 1-20, with diarize=true (default diarization.max_speakers)",
+                        "name": "max_speakers",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "URL notified with the signed job state when the job finishes",
+                        "name": "callback_url",
+                        "in": "formData"
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "Successful transcription with metadata",
+                    "202": {
+                        "description": "Job accepted",
                         "schema": {
-                            "$ref": "#/definitions/main.TranscriptionResponse"
+                            "$ref": "#/definitions/main.JobResponse"
                         }
                     },
                     "400": {
-                        "description": "Invalid request (missing file, file too large)",
+                        "description": "Invalid request (missing file, file too large, invalid option or callback_url)",
                         "schema": {
                             "$ref": "#/definitions/main.ErrorResponse"
                         }
@@ -89,59 +326,847 @@ const docTemplate = `{
                             "$ref": "#/definitions/main.ErrorResponse"
                         }
                     },
+                    "413": {
+                        "description": "Audio longer than the maximum duration (code audio_too_long)",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
                     "500": {
-                        "description": "Server error during processing",
+                        "description": "Server error while storing the job",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Job queue is full",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/jobs/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Returns the job status (queued, running, done, failed or cancelled), progress in percent, and the transcription result when done.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jobs"
+                ],
+                "summary": "Get an asynchronous transcription job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.JobResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized (invalid or missing API key)",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found, or submitted with another API token",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server error while loading the job",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Cancels a queued or running job. A running job waiting for a model slot stops waiting; one already decoding cannot be interrupted, so its worker finishes and the result is discarded.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jobs"
+                ],
+                "summary": "Cancel an asynchronous transcription job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Job cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/main.JobResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized (invalid or missing API key)",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found, or submitted with another API token",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Job already finished",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server error while updating the job",
                         "schema": {
                             "$ref": "#/definitions/main.ErrorResponse"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "audio.AudioMetadata": {
+        },
+        "/stream": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Upgrades to a WebSocket. The client sends audio as binary messages (raw PCM in any chunk size, or one Opus packet per message) and a {\"type\":\"end\"} text message to flush. The server sends JSON StreamMessages: \"partial\" with the current hypothesis for recent audio, \"final\" with segments that will not change, then \"done\". Segment times are relative to the start of the stream. A stream that runs past the maximum audio duration for the API key ends with an \"error\" message.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transcription"
+                ],
+                "summary": "Real-time transcription over WebSocket",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Audio encoding: s16le (default), f32le or opus",
+                        "name": "encoding",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "PCM sample rate in Hz (default 16000; Opus is decoded at 48000)",
+                        "name": "sample_rate",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Interleaved channels, mixed to mono (default 1)",
+                        "name": "channels",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Spoken language (ISO 639-1 code) or auto to detect",
+                        "name": "language",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Translate the transcription to English",
+                        "name": "translate",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Initial prompt to guide the decoder",
+                        "name": "initial_prompt",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Sampling temperature (0.0-1.0)",
+                        "name": "temperature",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of decoding threads (up to the configured max_threads)",
+                        "name": "threads",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "101": {
+                        "description": "Switching protocols; messages follow over the WebSocket",
+                        "schema": {
+                            "$ref": "#/definitions/main.StreamMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid audio parameters or decoding options",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized (invalid or missing API key)",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/transcribe": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Converts audio file to text using Whisper AI model. Supports WAV, MP3, OGG (Vorbis), and Opus formats.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/x-subrip",
+                    "text/vtt",
+                    "application/ttml+xml",
+                    "text/x-ass",
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "transcription"
+                ],
+                "summary": "Transcribe audio to text",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Audio file to transcribe (WAV, MP3, OGG Vorbis, or Opus format)",
+                        "name": "audio",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Spoken language (ISO 639-1 code) or auto to detect",
+                        "name": "language",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated candidate language codes for language=auto (default whisper.languages)",
+                        "name": "languages",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Translate the transcription to English",
+                        "name": "translate",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Initial prompt to guide the decoder",
+                        "name": "initial_prompt",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Sampling temperature (0.0-1.0)",
+                        "name": "temperature",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of decoding threads (up to the configured max_threads)",
+                        "name": "threads",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum segment length in characters (0 = no limit)",
+                        "name": "max_segment_length",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Compute per-token timestamps",
+                        "name": "token_timestamps",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Transcribe only up to the maximum duration instead of rejecting longer audio",
+                        "name": "truncate",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "mix (default) to downmix, or separate to transcribe each channel and merge the segments by time",
+                        "name": "channels",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Transcribe only this channel, numbered from 0",
+                        "name": "channel",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Transcribe only the speech found by voice activity detection",
+                        "name": "vad",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Energy above the noise floor, in dB, that can be speech (default 10)",
+                        "name": "vad_threshold",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Spectral flatness, 0-1, above which loud audio is noise (default 0.45)",
+                        "name": "vad_flatness",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Seconds; shorter bursts are dropped (default 0.25)",
+                        "name": "vad_min_speech",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Seconds; shorter pauses do not split speech (default 0.5)",
+                        "name": "vad_min_silence",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Seconds kept either side of speech (default 0.2)",
+                        "name": "vad_padding",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Preprocessing profile, comma-separated stages (highpass, deemphasis, denoise, loudnorm) or none",
+                        "name": "preprocess",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Label each segment with its speaker (default diarization.enabled)",
+                        "name": "diarize",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Most speakers to tell apart, 1-20, with diarize=true (default diarization.max_speakers)",
+                        "name": "max_speakers",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Response format: json, srt, vtt, ttml, ass or sse (alias: format, or use the Accept header)",
+                        "name": "output",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Subtitle characters per line (0 = one line per segment)",
+                        "name": "max_line_length",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Subtitle lines per cue (0 = no limit)",
+                        "name": "max_lines",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Minimum subtitle cue duration in seconds",
+                        "name": "min_duration",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Emit word-level \u003cc\u003e timing tags in WebVTT output",
+                        "name": "word_timestamps",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successful transcription with metadata",
+                        "schema": {
+                            "$ref": "#/definitions/main.TranscriptionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request (missing file, file too large, invalid option)",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized (invalid or missing API key)",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Audio longer than the maximum duration (code audio_too_long)",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Transcription queue is full, retry after the Retry-After header",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server error during processing",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Timed out waiting for a transcription slot",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/audio/transcriptions": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Accepts the OpenAI audio transcription request shape and returns OpenAI-compatible responses.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/plain"
+                ],
+                "tags": [
+                    "openai"
+                ],
+                "summary": "Transcribe audio (OpenAI compatible)",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Audio file to transcribe",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Model name (accepted for compatibility, ignored)",
+                        "name": "model",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Spoken language (ISO 639-1 code)",
+                        "name": "language",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Prompt to guide the decoder",
+                        "name": "prompt",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "default": "json",
+                        "description": "json, text, srt, verbose_json or vtt",
+                        "name": "response_format",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Sampling temperature (0.0-1.0)",
+                        "name": "temperature",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "word and/or segment (verbose_json only)",
+                        "name": "timestamp_granularities[]",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Transcription in the requested format",
+                        "schema": {
+                            "$ref": "#/definitions/main.OpenAIVerboseTranscription"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.OpenAIErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized (invalid or missing API key)",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Audio longer than the maximum duration (code audio_too_long)",
+                        "schema": {
+                            "$ref": "#/definitions/main.OpenAIErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server error during processing",
+                        "schema": {
+                            "$ref": "#/definitions/main.OpenAIErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/audio/translations": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Accepts the OpenAI audio translation request shape and returns OpenAI-compatible responses.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/plain"
+                ],
+                "tags": [
+                    "openai"
+                ],
+                "summary": "Translate audio to English (OpenAI compatible)",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Audio file to translate",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Model name (accepted for compatibility, ignored)",
+                        "name": "model",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Prompt to guide the decoder",
+                        "name": "prompt",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "default": "json",
+                        "description": "json, text, srt, verbose_json or vtt",
+                        "name": "response_format",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Sampling temperature (0.0-1.0)",
+                        "name": "temperature",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Translation in the requested format",
+                        "schema": {
+                            "$ref": "#/definitions/main.OpenAIVerboseTranscription"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.OpenAIErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized (invalid or missing API key)",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Audio longer than the maximum duration (code audio_too_long)",
+                        "schema": {
+                            "$ref": "#/definitions/main.OpenAIErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server error during processing",
+                        "schema": {
+                            "$ref": "#/definitions/main.OpenAIErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "audio.AudioMetadata": {
+            "type": "object",
+            "properties": {
+                "bit_depth": {
+                    "type": "integer"
+                },
+                "bitrate_kbps": {
+                    "type": "integer"
+                },
+                "channels": {
+                    "type": "integer"
+                },
+                "codec": {
+                    "type": "string"
+                },
+                "duration_seconds": {
+                    "type": "number"
+                },
+                "extension": {
+                    "description": "Set when the content was identified as a different format than the\nupload's file extension suggests; Format and Codec describe the content.",
+                    "type": "string"
+                },
+                "extension_mismatch": {
+                    "type": "boolean"
+                },
+                "format": {
+                    "type": "string"
+                },
+                "original_size_bytes": {
+                    "type": "integer"
+                },
+                "sample_rate": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.DiarizationReport": {
+            "type": "object",
+            "properties": {
+                "method": {
+                    "description": "DiarizeTurns or DiarizeClustering",
+                    "type": "string"
+                },
+                "speakers": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Machine-readable reason, such as \"audio_too_long\"",
+                    "type": "string"
+                },
+                "duration_seconds": {
+                    "description": "Set with code audio_too_long; the duration only when the header records it",
+                    "type": "number"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "max_duration_seconds": {
+                    "type": "number"
+                }
+            }
+        },
+        "main.FormatInfo": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "description": "Uploads in this format can be transcribed",
+                    "type": "boolean"
+                },
+                "codec": {
+                    "type": "string",
+                    "example": "Opus"
+                },
+                "container": {
+                    "type": "string",
+                    "example": "OGG"
+                },
+                "extensions": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mime_types": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string",
+                    "example": "opus"
+                },
+                "note": {
+                    "description": "Why the format is unavailable or limited in this build",
+                    "type": "string"
+                }
+            }
+        },
+        "main.FormatsResponse": {
             "type": "object",
             "properties": {
-                "bit_depth": {
-                    "type": "integer"
+                "formats": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.FormatInfo"
+                    }
+                }
+            }
+        },
+        "main.HealthResponse": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.JobResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
                 },
-                "bitrate_kbps": {
-                    "type": "integer"
+                "error": {
+                    "type": "string"
                 },
-                "channels": {
-                    "type": "integer"
+                "filename": {
+                    "type": "string"
                 },
-                "codec": {
+                "finished_at": {
                     "type": "string"
                 },
-                "duration_seconds": {
-                    "type": "number"
+                "id": {
+                    "type": "string"
                 },
-                "format": {
+                "progress": {
+                    "type": "integer",
+                    "example": 45
+                },
+                "result": {
+                    "$ref": "#/definitions/main.TranscriptionResponse"
+                },
+                "started_at": {
                     "type": "string"
                 },
-                "original_size_bytes": {
-                    "type": "integer"
+                "status": {
+                    "type": "string",
+                    "example": "running"
                 },
-                "sample_rate": {
-                    "type": "integer"
+                "webhook_status": {
+                    "type": "string",
+                    "example": "delivered"
                 }
             }
         },
-        "main.ErrorResponse": {
+        "main.LanguageDetectionResponse": {
             "type": "object",
             "properties": {
-                "error": {
+                "duration_seconds": {
+                    "description": "Audio listened to",
+                    "type": "number"
+                },
+                "language": {
+                    "description": "Most likely language code",
                     "type": "string"
+                },
+                "languages": {
+                    "description": "Every candidate, most likely first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.LanguageProbability"
+                    }
+                },
+                "preprocess": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "probability": {
+                    "description": "Its probability",
+                    "type": "number"
+                },
+                "processing_time_seconds": {
+                    "type": "number"
                 }
             }
         },
-        "main.HealthResponse": {
+        "main.LanguageProbability": {
             "type": "object",
             "properties": {
-                "status": {
+                "code": {
+                    "type": "string"
+                },
+                "name": {
                     "type": "string"
+                },
+                "probability": {
+                    "type": "number"
                 }
             }
         },
@@ -171,12 +1196,134 @@ const docTemplate = `{
                 }
             }
         },
+        "main.OpenAIErrorDetail": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "param": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.OpenAIErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "$ref": "#/definitions/main.OpenAIErrorDetail"
+                }
+            }
+        },
+        "main.OpenAISegment": {
+            "type": "object",
+            "properties": {
+                "avg_logprob": {
+                    "type": "number"
+                },
+                "compression_ratio": {
+                    "type": "number"
+                },
+                "end": {
+                    "type": "number"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "no_speech_prob": {
+                    "type": "number"
+                },
+                "seek": {
+                    "type": "integer"
+                },
+                "start": {
+                    "type": "number"
+                },
+                "temperature": {
+                    "type": "number"
+                },
+                "text": {
+                    "type": "string"
+                },
+                "tokens": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "main.OpenAIVerboseTranscription": {
+            "type": "object",
+            "properties": {
+                "duration": {
+                    "type": "number"
+                },
+                "language": {
+                    "type": "string"
+                },
+                "segments": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.OpenAISegment"
+                    }
+                },
+                "task": {
+                    "type": "string"
+                },
+                "text": {
+                    "type": "string"
+                },
+                "words": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.OpenAIWord"
+                    }
+                }
+            }
+        },
+        "main.OpenAIWord": {
+            "type": "object",
+            "properties": {
+                "end": {
+                    "type": "number"
+                },
+                "start": {
+                    "type": "number"
+                },
+                "word": {
+                    "type": "string"
+                }
+            }
+        },
         "main.SegmentInfo": {
             "type": "object",
             "properties": {
+                "channel": {
+                    "description": "Zero-based audio channel, when transcribed by channel",
+                    "type": "integer"
+                },
+                "channel_label": {
+                    "description": "\"left\", \"right\" or \"channel N\"",
+                    "type": "string"
+                },
                 "end_time": {
                     "type": "number"
                 },
+                "speaker": {
+                    "description": "\"SPEAKER_00\", \"SPEAKER_01\"... when diarized",
+                    "type": "string"
+                },
+                "speaker_turn": {
+                    "description": "The model heard the speaker change after this segment",
+                    "type": "boolean"
+                },
                 "start_time": {
                     "type": "number"
                 },
@@ -191,12 +1338,36 @@ const docTemplate = `{
                 }
             }
         },
+        "main.StreamMessage": {
+            "type": "object",
+            "properties": {
+                "audio_time_seconds": {
+                    "description": "Audio received so far",
+                    "type": "number"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "segments": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.SegmentInfo"
+                    }
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
         "main.TokenInfo": {
             "type": "object",
             "properties": {
                 "end_time": {
                     "type": "number"
                 },
+                "id": {
+                    "type": "integer"
+                },
                 "probability": {
                     "type": "number"
                 },
@@ -208,6 +1379,91 @@ const docTemplate = `{
                 }
             }
         },
+        "main.TranscriptionOptions": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "description": "Transcribe only this zero-based channel",
+                    "type": "integer"
+                },
+                "channels": {
+                    "description": "Channel selection; applied while decoding, not passed to the engine",
+                    "type": "string"
+                },
+                "diarize": {
+                    "description": "Speaker labels; applied to the segments the engine returns",
+                    "type": "boolean"
+                },
+                "initial_prompt": {
+                    "type": "string"
+                },
+                "language": {
+                    "type": "string"
+                },
+                "languages": {
+                    "description": "Candidate languages for language=auto; empty allows any the model knows",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "max_duration_seconds": {
+                    "description": "Audio length limit; applied while decoding, not passed to the engine",
+                    "type": "number"
+                },
+                "max_segment_length": {
+                    "type": "integer"
+                },
+                "max_speakers": {
+                    "description": "0 = no limit",
+                    "type": "integer"
+                },
+                "preprocess": {
+                    "description": "Profile, comma-separated stages or \"none\"",
+                    "type": "string"
+                },
+                "temperature": {
+                    "type": "number"
+                },
+                "threads": {
+                    "type": "integer"
+                },
+                "token_timestamps": {
+                    "type": "boolean"
+                },
+                "translate": {
+                    "type": "boolean"
+                },
+                "truncate": {
+                    "description": "Cut longer audio at the limit instead of rejecting it",
+                    "type": "boolean"
+                },
+                "vad": {
+                    "description": "Voice activity detection; applied before the engine, not passed to it",
+                    "type": "boolean"
+                },
+                "vad_flatness": {
+                    "description": "Spectral flatness above which loud audio is noise",
+                    "type": "number"
+                },
+                "vad_min_silence": {
+                    "description": "Seconds",
+                    "type": "number"
+                },
+                "vad_min_speech": {
+                    "description": "Seconds",
+                    "type": "number"
+                },
+                "vad_padding": {
+                    "description": "Seconds",
+                    "type": "number"
+                },
+                "vad_threshold": {
+                    "description": "Energy above the noise floor, in dB, that can be speech",
+                    "type": "number"
+                }
+            }
+        },
         "main.TranscriptionResponse": {
             "type": "object",
             "properties": {
@@ -219,6 +1475,10 @@ const docTemplate = `{
                         }
                     ]
                 },
+                "chunks": {
+                    "description": "Pieces long audio was split into and decoded in parallel",
+                    "type": "integer"
+                },
                 "compute_time": {
                     "type": "object",
                     "properties": {
@@ -233,12 +1493,38 @@ const docTemplate = `{
                 "confidence": {
                     "type": "number"
                 },
+                "detected_language": {
+                    "description": "Language identified with language=auto, and transcribed",
+                    "type": "string"
+                },
+                "diarization": {
+                    "description": "Present when segments were labelled with speakers",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.DiarizationReport"
+                        }
+                    ]
+                },
                 "duration_seconds": {
                     "type": "number"
                 },
+                "language_probability": {
+                    "description": "How likely the detected language is",
+                    "type": "number"
+                },
                 "memory_usage": {
                     "$ref": "#/definitions/main.MemStats"
                 },
+                "options": {
+                    "$ref": "#/definitions/main.TranscriptionOptions"
+                },
+                "preprocess": {
+                    "description": "Stages applied to the audio before transcription, in order",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "processing_time_seconds": {
                     "type": "number"
                 },
@@ -253,6 +1539,32 @@ const docTemplate = `{
                 },
                 "timestamp": {
                     "type": "string"
+                },
+                "truncated": {
+                    "description": "Audio past the maximum duration was not transcribed",
+                    "type": "boolean"
+                },
+                "vad": {
+                    "description": "Present when voice activity detection ran",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.VADReport"
+                        }
+                    ]
+                }
+            }
+        },
+        "main.VADReport": {
+            "type": "object",
+            "properties": {
+                "regions": {
+                    "type": "integer"
+                },
+                "speech_duration_seconds": {
+                    "type": "number"
+                },
+                "total_duration_seconds": {
+                    "type": "number"
                 }
             }
         }
@@ -0,0 +1,49 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/VA7DBI/whisperAPI/config"
+)
+
+// EngineSampleRate is the sample rate engines expect their input at.
+const EngineSampleRate = 16000
+
+// ProgressFunc receives the percentage of audio processed.
+type ProgressFunc func(percent int)
+
+// Engine runs speech recognition over 16 kHz mono samples. An engine handles one
+// request at a time; the model pool hands each one to a single caller.
+type Engine interface {
+	// Transcribe decodes samples with the given options, calling onSegment for
+	// each segment in order and onProgress, if not nil, as decoding advances.
+	Transcribe(samples []float32, opts TranscriptionOptions, onSegment func(SegmentInfo), onProgress ProgressFunc) error
+	Close() error
+}
+
+// NewEngine creates the engine selected in the configuration.
+func NewEngine(cfg *config.Config) (Engine, error) {
+	switch cfg.Whisper.Engine {
+	case "", "whisper":
+		engine, err := NewWhisperEngine(cfg.Whisper.ModelPath)
+		if err != nil {
+			return nil, err
+		}
+		return engine, nil
+	case "fake":
+		return NewFakeEngine(nil), nil
+	default:
+		return nil, fmt.Errorf("unknown engine %q", cfg.Whisper.Engine)
+	}
+}
+
+// LanguageDetector is implemented by engines that can identify the language
+// spoken in audio.
+type LanguageDetector interface {
+	// DetectLanguage returns the probability of each language the model knows,
+	// by code, being spoken in samples.
+	DetectLanguage(samples []float32, threads uint) (map[string]float64, error)
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// fakeScript is the transcription the fake engine produces without scripted segments.
+const fakeScript = "This is a test transcription."
+
+// FakeEngine is a deterministic Engine that replays scripted segments instead of
+// running a model, for tests and for exercising the service without a model file.
+type FakeEngine struct {
+	Segments []SegmentInfo // Scripted output; nil spreads fakeScript over the audio
+	Err      error         // Returned by Transcribe when set
+
+	Languages map[string]float64 // Scripted language probabilities; nil detects English
+
+	mu          sync.Mutex
+	calls       int
+	lastOptions TranscriptionOptions
+	closed      bool
+}
+
+// NewFakeEngine creates a fake engine that replays the given segments.
+func NewFakeEngine(segments []SegmentInfo) *FakeEngine {
+	return &FakeEngine{Segments: segments}
+}
+
+func (e *FakeEngine) Transcribe(samples []float32, opts TranscriptionOptions, onSegment func(SegmentInfo), onProgress ProgressFunc) error {
+	e.mu.Lock()
+	e.calls++
+	e.lastOptions = opts
+	e.mu.Unlock()
+
+	if e.Err != nil {
+		return e.Err
+	}
+
+	segments := e.Segments
+	if segments == nil {
+		segments = []SegmentInfo{scriptedSegment(fakeScript, float64(len(samples))/EngineSampleRate)}
+	}
+
+	for i, seg := range segments {
+		onSegment(seg)
+		if onProgress != nil {
+			onProgress(100 * (i + 1) / len(segments))
+		}
+	}
+	return nil
+}
+
+// DetectLanguage returns the scripted language probabilities.
+func (e *FakeEngine) DetectLanguage(samples []float32, threads uint) (map[string]float64, error) {
+	if e.Err != nil {
+		return nil, e.Err
+	}
+	if e.Languages == nil {
+		return map[string]float64{"en": 1}, nil
+	}
+	return e.Languages, nil
+}
+
+// Calls returns how many times Transcribe was called and the options of the last call.
+func (e *FakeEngine) Calls() (int, TranscriptionOptions) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calls, e.lastOptions
+}
+
+func (e *FakeEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	return nil
+}
+
+// scriptedSegment returns a segment with the words of text spaced evenly over duration.
+func scriptedSegment(text string, duration float64) SegmentInfo {
+	words := strings.Fields(text)
+	seg := SegmentInfo{Text: text, StartTime: 0, EndTime: duration}
+	for i, word := range words {
+		start := duration * float64(i) / float64(len(words))
+		end := duration * float64(i+1) / float64(len(words))
+		seg.Tokens = append(seg.Tokens, TokenInfo{
+			ID:          i,
+			Text:        " " + word,
+			Probability: 0.9,
+			StartTime:   start,
+			EndTime:     end,
+		})
+	}
+	return seg
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeEngine_Script(t *testing.T) {
+	engine := NewFakeEngine(nil)
+
+	var segments []SegmentInfo
+	var progress []int
+	err := engine.Transcribe(make([]float32, 4*EngineSampleRate), TranscriptionOptions{Language: "de"},
+		func(seg SegmentInfo) { segments = append(segments, seg) },
+		func(percent int) { progress = append(progress, percent) })
+	assert.NoError(t, err)
+	assert.Equal(t, []int{100}, progress)
+
+	if assert.Len(t, segments, 1) {
+		seg := segments[0]
+		assert.Equal(t, fakeScript, seg.Text)
+		assert.Equal(t, 4.0, seg.EndTime)
+		if assert.Len(t, seg.Tokens, 5) {
+			assert.Equal(t, " This", seg.Tokens[0].Text)
+			assert.Equal(t, 0.8, seg.Tokens[1].StartTime)
+			assert.Equal(t, 4.0, seg.Tokens[4].EndTime)
+		}
+	}
+
+	calls, opts := engine.Calls()
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "de", opts.Language)
+}
+
+func TestFakeEngine_Segments(t *testing.T) {
+	scripted := []SegmentInfo{
+		{Text: "First.", StartTime: 0, EndTime: 1},
+		{Text: " Second.", StartTime: 1, EndTime: 2},
+	}
+	engine := NewFakeEngine(scripted)
+
+	var segments []SegmentInfo
+	var progress []int
+	err := engine.Transcribe(nil, TranscriptionOptions{},
+		func(seg SegmentInfo) { segments = append(segments, seg) },
+		func(percent int) { progress = append(progress, percent) })
+	assert.NoError(t, err)
+	assert.Equal(t, scripted, segments)
+	assert.Equal(t, []int{50, 100}, progress)
+
+	// A nil progress callback is allowed
+	assert.NoError(t, engine.Transcribe(nil, TranscriptionOptions{}, func(SegmentInfo) {}, nil))
+
+	engine.Err = errors.New("boom")
+	assert.EqualError(t, engine.Transcribe(nil, TranscriptionOptions{}, func(SegmentInfo) {}, nil), "boom")
+	calls, _ := engine.Calls()
+	assert.Equal(t, 3, calls)
+}
+
+func TestNewEngine(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Whisper.Engine = "fake"
+	engine, err := NewEngine(cfg)
+	assert.NoError(t, err)
+	assert.IsType(t, &FakeEngine{}, engine)
+
+	cfg.Whisper.Engine = "sphinx"
+	_, err = NewEngine(cfg)
+	assert.Error(t, err)
+
+	// A missing model fails instead of returning a nil engine
+	cfg.Whisper.Engine = "whisper"
+	cfg.Whisper.ModelPath = "/nonexistent/ggml-base.bin"
+	engine, err = NewEngine(cfg)
+	assert.Error(t, err)
+	assert.Nil(t, engine)
+}
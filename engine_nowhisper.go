@@ -0,0 +1,28 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build nowhisper
+
+package main
+
+import "errors"
+
+// WhisperEngine is unavailable in builds without whisper.cpp.
+type WhisperEngine struct{}
+
+// NewWhisperEngine always fails; the binary was built with the nowhisper tag.
+func NewWhisperEngine(modelPath string) (*WhisperEngine, error) {
+	return nil, errors.New("built without whisper.cpp support (nowhisper tag); use the fake engine")
+}
+
+func (e *WhisperEngine) Transcribe(samples []float32, opts TranscriptionOptions, onSegment func(SegmentInfo), onProgress ProgressFunc) error {
+	return errors.New("built without whisper.cpp support")
+}
+
+func (e *WhisperEngine) DetectLanguage(samples []float32, threads uint) (map[string]float64, error) {
+	return nil, errors.New("built without whisper.cpp support")
+}
+
+func (e *WhisperEngine) Close() error {
+	return nil
+}
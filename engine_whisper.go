@@ -0,0 +1,144 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !nowhisper
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// WhisperEngine runs a whisper.cpp model through the Go bindings.
+type WhisperEngine struct {
+	model whisper.Model
+}
+
+// NewWhisperEngine loads a GGML model from disk.
+func NewWhisperEngine(modelPath string) (*WhisperEngine, error) {
+	model, err := whisper.New(modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load whisper model: %v", err)
+	}
+	return &WhisperEngine{model: model}, nil
+}
+
+func (e *WhisperEngine) Transcribe(samples []float32, opts TranscriptionOptions, onSegment func(SegmentInfo), onProgress ProgressFunc) error {
+	ctx, err := e.model.NewContext()
+	if err != nil {
+		return fmt.Errorf("Failed to create whisper context")
+	}
+	if err := opts.apply(ctx); err != nil {
+		return &requestError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	var progress whisper.ProgressCallback
+	if onProgress != nil {
+		progress = func(percent int) { onProgress(percent) }
+	}
+
+	segmentCallback := func(seg whisper.Segment) {
+		segInfo := SegmentInfo{
+			Text:      seg.Text,
+			StartTime: durationToSeconds(seg.Start),
+			EndTime:   durationToSeconds(seg.End),
+			Tokens:    make([]TokenInfo, 0, len(seg.Tokens)),
+		}
+		for _, token := range seg.Tokens {
+			// tinydiarize models end a turn with the start-of-LM token, which
+			// whisper.cpp only keeps with tdrz_enable set; otherwise the turn
+			// comes through as text, taken out below
+			segInfo.SpeakerTurn = segInfo.SpeakerTurn || ctx.IsSOLM(token)
+			segInfo.Tokens = append(segInfo.Tokens, TokenInfo{
+				ID:          token.Id,
+				Text:        token.Text,
+				Probability: float64(token.P),
+				StartTime:   durationToSeconds(token.Start),
+				EndTime:     durationToSeconds(token.End),
+			})
+		}
+		onSegment(takeSpeakerTurns(segInfo))
+	}
+
+	if err := ctx.Process(samples, segmentCallback, progress); err != nil {
+		return fmt.Errorf("Failed to process audio: %v", err)
+	}
+	return nil
+}
+
+// DetectLanguage runs whisper.cpp's language identification over samples. An
+// English-only model knows no other language.
+func (e *WhisperEngine) DetectLanguage(samples []float32, threads uint) (map[string]float64, error) {
+	if !e.model.IsMultilingual() {
+		return map[string]float64{"en": 1}, nil
+	}
+	ctx, err := e.model.NewContext()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create whisper context")
+	}
+	detector, ok := ctx.(interface {
+		WhisperLangAutoDetect(offsetMs, threads int) ([]float32, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("whisper bindings cannot identify languages")
+	}
+
+	// The detector reads the spectrogram whisper.cpp computes as it decodes,
+	// which needs no more than a token to get to
+	if err := ctx.SetLanguage("auto"); err != nil {
+		return nil, err
+	}
+	ctx.SetThreads(threads)
+	ctx.SetMaxTokensPerSegment(1)
+	if err := ctx.Process(samples, nil, nil); err != nil {
+		return nil, fmt.Errorf("Failed to process audio: %v", err)
+	}
+	probs, err := detector.WhisperLangAutoDetect(0, int(threads))
+	if err != nil {
+		return nil, err
+	}
+
+	// The probabilities are indexed by language token, in the order of
+	// whisperLanguages
+	languages := make(map[string]float64, len(probs))
+	for i, p := range probs {
+		if i < len(whisperLanguages) {
+			languages[whisperLanguages[i].Code] = float64(p)
+		}
+	}
+	return languages, nil
+}
+
+func (e *WhisperEngine) Close() error {
+	return e.model.Close()
+}
+
+// apply configures a whisper context with the decoding options.
+func (o TranscriptionOptions) apply(ctx whisper.Context) error {
+	if o.Language != "" {
+		if ctx.IsMultilingual() {
+			if err := ctx.SetLanguage(o.Language); err != nil {
+				return fmt.Errorf("unsupported language %q: %v", o.Language, err)
+			}
+		} else if o.Language != "en" {
+			return fmt.Errorf("model is English-only and cannot transcribe language %q", o.Language)
+		}
+	}
+	if o.Translate && !ctx.IsMultilingual() {
+		return fmt.Errorf("model is English-only and cannot translate")
+	}
+
+	ctx.SetTranslate(o.Translate)
+	ctx.SetTemperature(o.Temperature)
+	ctx.SetThreads(o.Threads)
+	ctx.SetMaxSegmentLength(o.MaxSegmentLength)
+	ctx.SetTokenTimestamps(o.TokenTimestamps)
+	if o.InitialPrompt != "" {
+		ctx.SetInitialPrompt(o.InitialPrompt)
+	}
+
+	return nil
+}
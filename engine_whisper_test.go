@@ -0,0 +1,61 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !nowhisper
+
+package main
+
+import (
+	"testing"
+
+	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingContext captures the setters called on a whisper context.
+type recordingContext struct {
+	whisper.Context
+	multilingual bool
+	language     string
+	translate    bool
+	temperature  float32
+	threads      uint
+	prompt       string
+}
+
+func (r *recordingContext) IsMultilingual() bool { return r.multilingual }
+func (r *recordingContext) SetLanguage(lang string) error {
+	r.language = lang
+	return nil
+}
+func (r *recordingContext) SetTranslate(v bool)        { r.translate = v }
+func (r *recordingContext) SetTemperature(t float32)   { r.temperature = t }
+func (r *recordingContext) SetThreads(n uint)          { r.threads = n }
+func (r *recordingContext) SetMaxSegmentLength(n uint) {}
+func (r *recordingContext) SetTokenTimestamps(b bool)  {}
+func (r *recordingContext) SetInitialPrompt(p string)  { r.prompt = p }
+
+func TestTranscriptionOptions_Apply(t *testing.T) {
+	opts := TranscriptionOptions{
+		Language:      "de",
+		Translate:     true,
+		Temperature:   0.2,
+		Threads:       3,
+		InitialPrompt: "Dispatch",
+	}
+
+	ctx := &recordingContext{multilingual: true}
+	assert.NoError(t, opts.apply(ctx))
+	assert.Equal(t, "de", ctx.language)
+	assert.True(t, ctx.translate)
+	assert.InDelta(t, 0.2, ctx.temperature, 1e-6)
+	assert.Equal(t, uint(3), ctx.threads)
+	assert.Equal(t, "Dispatch", ctx.prompt)
+
+	// English-only models accept "en" but nothing else
+	englishOnly := &recordingContext{}
+	assert.NoError(t, TranscriptionOptions{Language: "en"}.apply(englishOnly))
+	assert.Empty(t, englishOnly.language)
+	assert.Error(t, TranscriptionOptions{Language: "de"}.apply(englishOnly))
+	assert.Error(t, TranscriptionOptions{Translate: true}.apply(englishOnly))
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"mime/multipart"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Server-Sent Event names for /transcribe with Accept: text/event-stream
+const (
+	EventSegment  = "segment"  // A SegmentInfo, as soon as it is decoded
+	EventProgress = "progress" // A ProgressEvent
+	EventResult   = "result"   // The complete TranscriptionResponse
+	EventError    = "error"    // An ErrorResponse if transcription fails after events were sent
+)
+
+// ProgressEvent reports how much of the audio has been processed.
+type ProgressEvent struct {
+	Percent int `json:"percent"`
+}
+
+// transcriptionEvent is an event waiting to be written to the client.
+type transcriptionEvent struct {
+	name string
+	data interface{}
+}
+
+// eventQueue holds the events waiting to be written to the client. Adding to
+// it never blocks the engine: segments queue up however slowly the client
+// reads, and progress still waiting to be written is replaced by newer
+// progress.
+type eventQueue struct {
+	mu     sync.Mutex
+	events []transcriptionEvent
+	closed bool
+	ready  chan struct{} // Signalled when events are added or the queue closes
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{ready: make(chan struct{}, 1)}
+}
+
+// add queues an event, coalescing it with progress queued just before it.
+func (q *eventQueue) add(name string, data interface{}) {
+	q.mu.Lock()
+	if n := len(q.events); name == EventProgress && n > 0 && q.events[n-1].name == EventProgress {
+		q.events[n-1].data = data
+	} else {
+		q.events = append(q.events, transcriptionEvent{name, data})
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+// close marks the end of the events.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take waits for events and returns all those queued, in order. It returns
+// false once the queue is closed and every event has been taken.
+func (q *eventQueue) take() ([]transcriptionEvent, bool) {
+	for {
+		q.mu.Lock()
+		events, closed := q.events, q.closed
+		q.events = nil
+		q.mu.Unlock()
+		if len(events) > 0 {
+			return events, true
+		}
+		if closed {
+			return nil, false
+		}
+		<-q.ready
+	}
+}
+
+// transcribeEvents transcribes an upload and streams the result as Server-Sent
+// Events: each segment as it is decoded, progress as it increases, and then the
+// complete response. Errors before the first event are sent as a normal error
+// response, so clients still see 429 and 503 from the pool.
+func (s *TranscriptionService) transcribeEvents(c *gin.Context, file *multipart.FileHeader, opts TranscriptionOptions) {
+	events := newEventQueue()
+	var response *TranscriptionResponse
+	var err error
+	go func() {
+		defer events.close()
+		lastPercent := -1
+		response, err = s.transcribeUpload(c.Request.Context(), file, opts,
+			func(seg SegmentInfo) { events.add(EventSegment, seg) },
+			func(percent int) {
+				// Whisper reports the same percentage repeatedly
+				if percent > lastPercent {
+					lastPercent = percent
+					events.add(EventProgress, ProgressEvent{Percent: percent})
+				}
+			})
+	}()
+
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			c.Header("Cache-Control", "no-cache")
+			c.Header("X-Accel-Buffering", "no") // Stop nginx buffering the stream
+		}
+	}
+
+	for {
+		queued, ok := events.take()
+		if !ok {
+			break
+		}
+		start()
+		for _, event := range queued {
+			c.SSEvent(event.name, event.data)
+		}
+		c.Writer.Flush()
+	}
+
+	// The queue is closed, so response and err are set
+	if err != nil && !started {
+		setRetryAfter(c, err)
+		c.JSON(errorStatus(err), errorResponse(err))
+		return
+	}
+
+	start()
+	if err != nil {
+		c.SSEvent(EventError, errorResponse(err))
+	} else {
+		c.SSEvent(EventResult, response)
+	}
+	c.Writer.Flush()
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// sseEvent is a parsed Server-Sent Event.
+type sseEvent struct {
+	Name string
+	Data string
+}
+
+// parseEvents splits an event stream into its events.
+func parseEvents(body string) []sseEvent {
+	var events []sseEvent
+	for _, block := range strings.Split(body, "\n\n") {
+		var event sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			if v, ok := strings.CutPrefix(line, "event:"); ok {
+				event.Name = strings.TrimSpace(v)
+			} else if v, ok := strings.CutPrefix(line, "data:"); ok {
+				event.Data += v
+			}
+		}
+		if event.Name != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// postEvents uploads a WAV file to /transcribe asking for an event stream.
+func postEvents(r *gin.Engine, path string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, _ := writer.CreateFormFile("audio", "tone.wav")
+	data, _ := os.ReadFile(path)
+	part.Write(data)
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/transcribe", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestTranscribeHandler_Events(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = 16000
+	cfg.Audio.MaxFileSize = 25
+	engine := NewFakeEngine([]SegmentInfo{
+		{Text: " First.", StartTime: 0, EndTime: 1},
+		{Text: " Second.", StartTime: 1, EndTime: 2},
+	})
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{engine})
+
+	r := gin.New()
+	r.POST("/transcribe", service.TranscribeHandler)
+	wavPath := writeTestWAV(t, 2, 1)
+
+	w := postEvents(r, wavPath)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+
+	events := parseEvents(w.Body.String())
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = event.Name
+	}
+	assert.Equal(t, []string{EventSegment, EventProgress, EventSegment, EventProgress, EventResult}, names)
+
+	var seg SegmentInfo
+	assert.NoError(t, json.Unmarshal([]byte(events[0].Data), &seg))
+	assert.Equal(t, " First.", seg.Text)
+
+	var progress ProgressEvent
+	assert.NoError(t, json.Unmarshal([]byte(events[3].Data), &progress))
+	assert.Equal(t, 100, progress.Percent)
+
+	var response TranscriptionResponse
+	assert.NoError(t, json.Unmarshal([]byte(events[4].Data), &response))
+	assert.Equal(t, " First. Second.", response.Text)
+	assert.Len(t, response.Segments, 2)
+
+	// The output parameter selects events too
+	w = postAudio(r, wavPath, "tone.wav", "", "?output=sse")
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Len(t, parseEvents(w.Body.String()), 5)
+
+	// Failures before any event keep their status code
+	engine.Err = assert.AnError
+	w = postEvents(r, wavPath)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), assert.AnError.Error())
+}
+
+func TestEventQueue(t *testing.T) {
+	q := newEventQueue()
+
+	// Nothing reads yet, and adding never waits
+	for i := 0; i < 100; i++ {
+		q.add(EventSegment, i)
+	}
+	q.add(EventProgress, 10)
+	q.add(EventProgress, 20)
+	q.add(EventSegment, 100)
+	q.add(EventProgress, 30)
+	q.close()
+
+	events, ok := q.take()
+	assert.True(t, ok)
+	assert.Len(t, events, 103)
+	assert.Equal(t, transcriptionEvent{EventProgress, 20}, events[100])
+	assert.Equal(t, transcriptionEvent{EventSegment, 100}, events[101])
+	assert.Equal(t, transcriptionEvent{EventProgress, 30}, events[102])
+
+	_, ok = q.take()
+	assert.False(t, ok)
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/VA7DBI/whisperAPI/audio"
+	"github.com/gin-gonic/gin"
+)
+
+// FormatInfo describes an audio format the service recognizes.
+type FormatInfo struct {
+	Name       string   `json:"name" example:"opus"`
+	Container  string   `json:"container" example:"OGG"`
+	Codec      string   `json:"codec,omitempty" example:"Opus"`
+	MIMETypes  []string `json:"mime_types"`
+	Extensions []string `json:"extensions"`
+	Available  bool     `json:"available"`      // Uploads in this format can be transcribed
+	Note       string   `json:"note,omitempty"` // Why the format is unavailable or limited in this build
+}
+
+// FormatsResponse lists the audio formats known to this build.
+type FormatsResponse struct {
+	Formats []FormatInfo `json:"formats"`
+}
+
+// FormatsHandler lists the registered audio formats.
+// @Summary     List supported audio formats
+// @Description List the audio formats this build recognizes and whether each can be transcribed. Formats are detected from file content; availability can depend on build options, e.g. Opus decoding requires cgo.
+// @Tags        formats
+// @Produce     json
+// @Success     200 {object} FormatsResponse
+// @Router      /formats [get]
+func FormatsHandler(c *gin.Context) {
+	registered := audio.Formats()
+	response := FormatsResponse{Formats: make([]FormatInfo, 0, len(registered))}
+	for _, f := range registered {
+		response.Formats = append(response.Formats, FormatInfo{
+			Name:       f.Name,
+			Container:  f.Container,
+			Codec:      f.Codec,
+			MIMETypes:  f.MIMETypes,
+			Extensions: f.Extensions,
+			Available:  f.New != nil && f.Decodable,
+			Note:       f.Note,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/formats", FormatsHandler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/formats", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response FormatsResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	formats := make(map[string]FormatInfo)
+	for _, f := range response.Formats {
+		formats[f.Name] = f
+	}
+
+	wav := formats["wav"]
+	assert.True(t, wav.Available)
+	assert.Equal(t, "WAV", wav.Container)
+	assert.Contains(t, wav.MIMETypes, "audio/wav")
+	assert.Equal(t, []string{".wav"}, wav.Extensions)
+
+	// Recognized but undecodable formats are listed with the reason
+	speex := formats["speex"]
+	assert.False(t, speex.Available)
+	assert.NotEmpty(t, speex.Note)
+
+	// Opus is listed in every build, but decodes only with cgo
+	opus, ok := formats["opus"]
+	assert.True(t, ok)
+	assert.Equal(t, opus.Note == "", opus.Available)
+	if !opus.Available {
+		assert.Contains(t, opus.Note, "cgo")
+	}
+}
@@ -0,0 +1,643 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/VA7DBI/whisperAPI/jobs"
+	"github.com/VA7DBI/whisperAPI/metrics"
+	"github.com/VA7DBI/whisperAPI/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// JobResponse represents the state of an asynchronous transcription job.
+type JobResponse struct {
+	ID         string                 `json:"id"`
+	Status     string                 `json:"status" example:"running"`
+	Progress   int                    `json:"progress" example:"45"`
+	Filename   string                 `json:"filename"`
+	Error      string                 `json:"error,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+	StartedAt  *time.Time             `json:"started_at,omitempty"`
+	FinishedAt *time.Time             `json:"finished_at,omitempty"`
+	Result     *TranscriptionResponse `json:"result,omitempty"`
+
+	WebhookStatus string `json:"webhook_status,omitempty" example:"delivered"`
+}
+
+// transcribeFunc transcribes an audio file on disk, reporting progress as it goes.
+type transcribeFunc func(ctx context.Context, filename string, opts TranscriptionOptions, progress ProgressFunc) (*TranscriptionResponse, error)
+
+// JobManager queues transcription jobs and runs them on background workers.
+type JobManager struct {
+	service    *TranscriptionService
+	store      jobs.JobStore
+	transcribe transcribeFunc
+	webhooks   *WebhookSender
+	dir        string
+	workers    int
+	queue      chan string
+	retention  time.Duration // How long finished jobs are kept; 0 keeps them
+
+	mu            sync.Mutex                    // Serializes job status transitions
+	running       map[string]context.CancelFunc // Stops each running job, guarded by mu
+	wg            sync.WaitGroup
+	quit          chan struct{}
+	notifications sync.WaitGroup
+	ctx           context.Context // Cancelled once the manager has stopped
+	cancel        context.CancelFunc
+}
+
+// NewJobStore creates the job store selected in the configuration.
+func NewJobStore(cfg *config.Config) (jobs.JobStore, error) {
+	switch cfg.Jobs.Store {
+	case "", "memory":
+		return jobs.NewMemoryJobStore(), nil
+	case "postgres":
+		return jobs.NewPostgresJobStore(cfg)
+	default:
+		return nil, fmt.Errorf("unknown job store %q", cfg.Jobs.Store)
+	}
+}
+
+// NewJobManager creates a job manager that transcribes with the given service.
+func NewJobManager(cfg *config.Config, service *TranscriptionService, store jobs.JobStore) (*JobManager, error) {
+	if err := os.MkdirAll(cfg.Jobs.StorageDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create job storage directory: %v", err)
+	}
+
+	workers := cfg.Jobs.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	queueSize := cfg.Jobs.QueueSize
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	var retention time.Duration
+	if cfg.Jobs.Retention > 0 {
+		retention = time.Duration(cfg.Jobs.Retention) * time.Hour
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JobManager{
+		service:    service,
+		store:      store,
+		transcribe: service.transcribeFile,
+		webhooks:   NewWebhookSender(cfg),
+		dir:        cfg.Jobs.StorageDir,
+		workers:    workers,
+		queue:      make(chan string, queueSize),
+		retention:  retention,
+		running:    make(map[string]context.CancelFunc),
+		quit:       make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+	}, nil
+}
+
+// Start requeues jobs left unfinished by a previous run and starts the workers.
+func (m *JobManager) Start() error {
+	pending, err := m.store.ListJobs(jobs.StatusQueued, jobs.StatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to load pending jobs: %v", err)
+	}
+
+	var requeue []string
+	for _, job := range pending {
+		if _, err := os.Stat(job.AudioPath); err != nil {
+			m.finish(job.ID, nil, errors.New("audio file lost during restart"))
+			continue
+		}
+		if job.Status == jobs.StatusRunning {
+			// Interrupted mid-transcription; start over
+			job.Status = jobs.StatusQueued
+			job.Progress = 0
+			job.StartedAt = nil
+			if err := m.store.UpdateJob(job); err != nil {
+				log.Printf("Failed to requeue job %s: %v", job.ID, err)
+				continue
+			}
+		}
+		requeue = append(requeue, job.ID)
+	}
+
+	for i := 0; i < m.workers; i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+	if m.retention > 0 {
+		m.wg.Add(1)
+		go m.pruner()
+	}
+
+	// The backlog may exceed the queue, so feed it in the background
+	if len(requeue) > 0 {
+		log.Printf("Requeueing %d unfinished jobs", len(requeue))
+		go func() {
+			for _, id := range requeue {
+				select {
+				case m.queue <- id:
+				case <-m.quit:
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// Stop cancels running jobs and stops the workers. A running job stops after
+// the chunk it is decoding; it stays in the store with the queued jobs, and
+// both are picked up again on the next Start. Webhook deliveries still waiting
+// to be retried are abandoned.
+func (m *JobManager) Stop() {
+	close(m.quit)
+	m.cancel()
+	m.wg.Wait()
+	m.notifications.Wait()
+}
+
+// CreateJobHandler queues an audio file for asynchronous transcription.
+// @Summary     Submit an asynchronous transcription job
+// @Description Accepts the same upload and decoding options as /transcribe and returns immediately with a job ID. Poll GET /jobs/{id} for progress and the result.
+// @Tags        jobs
+// @Accept      multipart/form-data
+// @Produce     json
+// @Param       audio formData file true "Audio file to transcribe (WAV, MP3, OGG Vorbis, or Opus format)"
+// @Param       language formData string false "Spoken language (ISO 639-1 code) or auto to detect"
+// @Param       languages formData string false "Comma-separated candidate language codes for language=auto (default whisper.languages)"
+// @Param       translate formData boolean false "Translate the transcription to English"
+// @Param       initial_prompt formData string false "Initial prompt to guide the decoder"
+// @Param       temperature formData number false "Sampling temperature (0.0-1.0)"
+// @Param       threads formData integer false "Number of decoding threads (up to the configured max_threads)"
+// @Param       max_segment_length formData integer false "Maximum segment length in characters (0 = no limit)"
+// @Param       token_timestamps formData boolean false "Compute per-token timestamps"
+// @Param       truncate formData boolean false "Transcribe only up to the maximum duration instead of rejecting longer audio"
+// @Param       channels formData string false "mix (default) to downmix, or separate to transcribe each channel and merge the segments by time"
+// @Param       channel formData integer false "Transcribe only this channel, numbered from 0"
+// @Param       vad formData boolean false "Transcribe only the speech found by voice activity detection"
+// @Param       vad_threshold formData number false "Energy above the noise floor, in dB, that can be speech (default 10)"
+// @Param       vad_flatness formData number false "Spectral flatness, 0-1, above which loud audio is noise (default 0.45)"
+// @Param       vad_min_speech formData number false "Seconds; shorter bursts are dropped (default 0.25)"
+// @Param       vad_min_silence formData number false "Seconds; shorter pauses do not split speech (default 0.5)"
+// @Param       vad_padding formData number false "Seconds kept either side of speech (default 0.2)"
+// @Param       preprocess formData string false "Preprocessing profile, comma-separated stages (highpass, deemphasis, denoise, loudnorm) or none"
+// @Param       diarize formData boolean false "Label each segment with its speaker (default diarization.enabled)"
+// @Param       max_speakers formData integer false "Most speakers to tell apart, 1-20, with diarize=true (default diarization.max_speakers)"
+// @Param       callback_url formData string false "URL notified with the signed job state when the job finishes"
+// @Success     202 {object} JobResponse "Job accepted"
+// @Failure     400 {object} ErrorResponse "Invalid request (missing file, file too large, invalid option or callback_url)"
+// @Failure     413 {object} ErrorResponse "Audio longer than the maximum duration (code audio_too_long)"
+// @Failure     401 {object} ErrorResponse "Unauthorized (invalid or missing API key)"
+// @Failure     500 {object} ErrorResponse "Server error while storing the job"
+// @Failure     503 {object} ErrorResponse "Job queue is full"
+// @Security    ApiKeyAuth
+// @Router      /jobs [post]
+func (m *JobManager) CreateJobHandler(c *gin.Context) {
+	file, err := c.FormFile("audio")
+	if err != nil {
+		metrics.TranscriptionRequests.WithLabelValues("error", "unknown").Inc()
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "No audio file provided"})
+		return
+	}
+	format := uploadFormat(file)
+
+	opts, err := m.service.parseOptions(c)
+	if err != nil {
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if file.Size > m.service.config.Audio.MaxFileSize*1024*1024 {
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("File too large. Maximum size is %dMB", m.service.config.Audio.MaxFileSize),
+		})
+		return
+	}
+
+	// Callbacks are always signed, so a secret must be available for the caller
+	callbackURL := c.PostForm("callback_url")
+	tokenHash := hashToken(middleware.TokenFromContext(c))
+	if callbackURL != "" {
+		if err := validateCallbackURL(callbackURL); err != nil {
+			metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if m.webhooks.secretFor(tokenHash) == "" {
+			metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "callback_url requires a webhook signing secret to be configured"})
+			return
+		}
+	}
+
+	// Turn the upload away before storing it if there is no room to queue it
+	if len(m.queue) == cap(m.queue) {
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{Error: "Job queue is full, try again later"})
+		return
+	}
+
+	id, err := jobs.NewJobID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create job"})
+		return
+	}
+	optionsJSON, err := json.Marshal(opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create job"})
+		return
+	}
+
+	// Keep the upload until a worker gets to it; the extension selects the decoder
+	audioPath := filepath.Join(m.dir, id+filepath.Ext(filepath.Base(file.Filename)))
+	if err := saveUploadAs(file, audioPath); err != nil {
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save audio file"})
+		return
+	}
+	if err := m.service.checkDuration(audioPath, opts); err != nil {
+		os.Remove(audioPath)
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		c.JSON(errorStatus(err), errorResponse(err))
+		return
+	}
+
+	job := &jobs.Job{
+		ID:        id,
+		Status:    jobs.StatusQueued,
+		Filename:  filepath.Base(file.Filename),
+		AudioPath: audioPath,
+		Options:   optionsJSON,
+		CreatedAt: time.Now().UTC(),
+
+		CallbackURL: callbackURL,
+		TokenHash:   tokenHash,
+	}
+	if callbackURL != "" {
+		job.WebhookStatus = jobs.WebhookPending
+	}
+	if err := m.store.CreateJob(job); err != nil {
+		os.Remove(audioPath)
+		log.Printf("Failed to store job: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create job"})
+		return
+	}
+
+	select {
+	case m.queue <- id:
+	default:
+		// The queue filled up meanwhile; the client is told the job was not
+		// accepted, so it leaves no trace
+		if err := m.store.DeleteJob(id); err != nil {
+			log.Printf("Failed to delete rejected job %s: %v", id, err)
+		}
+		os.Remove(audioPath)
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{Error: "Job queue is full, try again later"})
+		return
+	}
+
+	c.Header("Location", "/jobs/"+id)
+	c.JSON(http.StatusAccepted, toJobResponse(job))
+}
+
+// GetJobHandler reports the status of a job and its result once done.
+// @Summary     Get an asynchronous transcription job
+// @Description Returns the job status (queued, running, done, failed or cancelled), progress in percent, and the transcription result when done.
+// @Tags        jobs
+// @Produce     json
+// @Param       id path string true "Job ID"
+// @Success     200 {object} JobResponse
+// @Failure     401 {object} ErrorResponse "Unauthorized (invalid or missing API key)"
+// @Failure     404 {object} ErrorResponse "Job not found, or submitted with another API token"
+// @Failure     500 {object} ErrorResponse "Server error while loading the job"
+// @Security    ApiKeyAuth
+// @Router      /jobs/{id} [get]
+func (m *JobManager) GetJobHandler(c *gin.Context) {
+	job, err := m.store.GetJob(c.Param("id"))
+	if err == nil && !ownsJob(c, job) {
+		err = jobs.ErrJobNotFound
+	}
+	if err != nil {
+		m.jobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, toJobResponse(job))
+}
+
+// CancelJobHandler cancels a queued or running job.
+// @Summary     Cancel an asynchronous transcription job
+// @Description Cancels a queued or running job. A running job waiting for a model slot stops waiting; one already decoding cannot be interrupted, so its worker finishes and the result is discarded.
+// @Tags        jobs
+// @Produce     json
+// @Param       id path string true "Job ID"
+// @Success     200 {object} JobResponse "Job cancelled"
+// @Failure     401 {object} ErrorResponse "Unauthorized (invalid or missing API key)"
+// @Failure     404 {object} ErrorResponse "Job not found, or submitted with another API token"
+// @Failure     409 {object} ErrorResponse "Job already finished"
+// @Failure     500 {object} ErrorResponse "Server error while updating the job"
+// @Security    ApiKeyAuth
+// @Router      /jobs/{id} [delete]
+func (m *JobManager) CancelJobHandler(c *gin.Context) {
+	var wasQueued, foreign bool
+	job, updated, err := m.update(c.Param("id"), func(job *jobs.Job) bool {
+		if foreign = !ownsJob(c, job); foreign || job.Finished() {
+			return false
+		}
+		wasQueued = job.Status == jobs.StatusQueued
+		if cancel, ok := m.running[job.ID]; ok {
+			cancel()
+		}
+		now := time.Now().UTC()
+		job.Status = jobs.StatusCancelled
+		job.FinishedAt = &now
+		return true
+	})
+	if err == nil && foreign {
+		err = jobs.ErrJobNotFound
+	}
+	if err != nil {
+		m.jobError(c, err)
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: fmt.Sprintf("Job already %s", job.Status)})
+		return
+	}
+
+	// A running job removes its own audio once the worker is done with it
+	if wasQueued {
+		os.Remove(job.AudioPath)
+	}
+	m.notify(job)
+	c.JSON(http.StatusOK, toJobResponse(job))
+}
+
+// ownsJob reports whether job was submitted with the API token of the request.
+// Other tenants' jobs are reported as not found, so their IDs are not confirmed.
+func ownsJob(c *gin.Context, job *jobs.Job) bool {
+	return job.TokenHash == hashToken(middleware.TokenFromContext(c))
+}
+
+// jobError reports a job store error to the client.
+func (m *JobManager) jobError(c *gin.Context, err error) {
+	if errors.Is(err, jobs.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Job not found"})
+		return
+	}
+	log.Printf("Job store error: %v", err)
+	c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to load job"})
+}
+
+// pruner deletes expired jobs now and then until the manager stops.
+func (m *JobManager) pruner() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(min(m.retention, time.Hour))
+	defer ticker.Stop()
+	for {
+		m.prune()
+		select {
+		case <-m.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune deletes the jobs that finished more than the retention period ago,
+// with their results.
+func (m *JobManager) prune() {
+	deleted, err := m.store.DeleteFinishedJobs(time.Now().UTC().Add(-m.retention))
+	if err != nil {
+		log.Printf("Failed to delete expired jobs: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d expired jobs", deleted)
+	}
+}
+
+func (m *JobManager) worker() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.quit:
+			return
+		case id := <-m.queue:
+			m.run(id)
+		}
+	}
+}
+
+// run transcribes a single job, unless it was cancelled while queued. The
+// transcription runs under a context of its own, which cancelling the job
+// cancels.
+func (m *JobManager) run(id string) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+	job, started, err := m.update(id, func(job *jobs.Job) bool {
+		if job.Status != jobs.StatusQueued {
+			return false
+		}
+		m.running[id] = cancel
+		now := time.Now().UTC()
+		job.Status = jobs.StatusRunning
+		job.Progress = 0
+		job.StartedAt = &now
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to start job %s: %v", id, err)
+		return
+	}
+	if !started {
+		return
+	}
+	defer func() {
+		m.mu.Lock()
+		delete(m.running, id)
+		m.mu.Unlock()
+	}()
+	stopped := false
+	defer func() {
+		// The next Start runs a stopped job again from its audio
+		if !stopped {
+			os.Remove(job.AudioPath)
+		}
+	}()
+
+	var opts TranscriptionOptions
+	if err := json.Unmarshal(job.Options, &opts); err != nil {
+		m.finish(id, nil, fmt.Errorf("invalid job options: %v", err))
+		return
+	}
+
+	progress := func(percent int) {
+		m.update(id, func(job *jobs.Job) bool {
+			if job.Status != jobs.StatusRunning || percent <= job.Progress {
+				return false
+			}
+			job.Progress = percent
+			return true
+		})
+	}
+
+	// Jobs already wait in the job queue, so they are not subject to the pool's limits
+	response, err := m.transcribe(withoutQueueLimit(ctx), job.AudioPath, opts, progress)
+	if err != nil && m.ctx.Err() != nil {
+		// Shutting down; a cancelled job needs its audio no more
+		m.update(id, func(job *jobs.Job) bool {
+			stopped = !job.Finished()
+			return false
+		})
+		return
+	}
+	m.finish(id, response, err)
+}
+
+// finish records the outcome of a job. Jobs cancelled in the meantime keep their
+// cancelled status and the result is discarded.
+func (m *JobManager) finish(id string, response *TranscriptionResponse, jobErr error) {
+	var result []byte
+	if jobErr == nil && response != nil {
+		var err error
+		if result, err = json.Marshal(response); err != nil {
+			jobErr = fmt.Errorf("failed to encode result: %v", err)
+		}
+	}
+
+	job, updated, err := m.update(id, func(job *jobs.Job) bool {
+		if job.Finished() {
+			return false
+		}
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+		if jobErr != nil {
+			job.Status = jobs.StatusFailed
+			job.Error = jobErr.Error()
+			return true
+		}
+		job.Status = jobs.StatusDone
+		job.Progress = 100
+		job.Result = result
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to record result of job %s: %v", id, err)
+		return
+	}
+	if updated {
+		m.notify(job)
+	}
+}
+
+// notify delivers the final state of a job to its callback URL in the background.
+// Notifications that exhaust their attempts are recorded as dead letters.
+func (m *JobManager) notify(job *jobs.Job) {
+	if job.CallbackURL == "" {
+		return
+	}
+
+	payload := toJobResponse(job)
+	payload.WebhookStatus = ""
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode webhook for job %s: %v", job.ID, err)
+		return
+	}
+	event := "job." + string(job.Status)
+
+	m.notifications.Add(1)
+	go func() {
+		defer m.notifications.Done()
+
+		attempts, err := m.webhooks.Send(m.ctx, job.CallbackURL, job.TokenHash, event, body)
+		if err != nil && m.ctx.Err() != nil {
+			log.Printf("Webhook for job %s abandoned at shutdown after %d attempts", job.ID, attempts)
+			return
+		}
+
+		status := jobs.WebhookDelivered
+		if err != nil {
+			status = jobs.WebhookFailed
+			log.Printf("Webhook for job %s failed after %d attempts: %v", job.ID, attempts, err)
+			letter := &jobs.DeadLetter{
+				JobID:     job.ID,
+				URL:       job.CallbackURL,
+				Payload:   body,
+				Attempts:  attempts,
+				LastError: err.Error(),
+				CreatedAt: time.Now().UTC(),
+			}
+			if err := m.store.AddDeadLetter(letter); err != nil {
+				log.Printf("Failed to record dead letter for job %s: %v", job.ID, err)
+			}
+		}
+
+		if _, _, err := m.update(job.ID, func(job *jobs.Job) bool {
+			job.WebhookStatus = status
+			return true
+		}); err != nil {
+			log.Printf("Failed to record webhook status of job %s: %v", job.ID, err)
+		}
+	}()
+}
+
+// update loads a job, applies fn and saves the job if fn reports a change. It
+// returns the job as it is after the update and whether it was changed.
+func (m *JobManager) update(id string, fn func(job *jobs.Job) bool) (*jobs.Job, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, err := m.store.GetJob(id)
+	if err != nil {
+		return nil, false, err
+	}
+	if !fn(job) {
+		return job, false, nil
+	}
+	if err := m.store.UpdateJob(job); err != nil {
+		return nil, false, err
+	}
+	return job, true, nil
+}
+
+// toJobResponse converts a stored job to its API representation.
+func toJobResponse(job *jobs.Job) JobResponse {
+	response := JobResponse{
+		ID:         job.ID,
+		Status:     string(job.Status),
+		Progress:   job.Progress,
+		Filename:   job.Filename,
+		Error:      job.Error,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+
+		WebhookStatus: job.WebhookStatus,
+	}
+	if len(job.Result) > 0 {
+		var result TranscriptionResponse
+		if err := json.Unmarshal(job.Result, &result); err == nil {
+			response.Result = &result
+		}
+	}
+	return response
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jobs
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryJobStore implements JobStore in memory. Jobs are lost on restart.
+type MemoryJobStore struct {
+	mu          sync.RWMutex
+	jobs        map[string]*Job
+	deadLetters []*DeadLetter
+}
+
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{
+		jobs: make(map[string]*Job),
+	}
+}
+
+func (s *MemoryJobStore) CreateJob(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.jobs[job.ID]; exists {
+		return fmt.Errorf("job %s already exists", job.ID)
+	}
+	copied := *job
+	s.jobs[job.ID] = &copied
+	return nil
+}
+
+func (s *MemoryJobStore) GetJob(id string) (*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return nil, ErrJobNotFound
+	}
+	copied := *job
+	return &copied, nil
+}
+
+func (s *MemoryJobStore) UpdateJob(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.jobs[job.ID]; !exists {
+		return ErrJobNotFound
+	}
+	copied := *job
+	s.jobs[job.ID] = &copied
+	return nil
+}
+
+func (s *MemoryJobStore) DeleteJob(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.jobs[id]; !exists {
+		return ErrJobNotFound
+	}
+	delete(s.jobs, id)
+	return nil
+}
+
+// DeleteFinishedJobs deletes the jobs that finished before the given time and
+// returns how many there were.
+func (s *MemoryJobStore) DeleteFinishedJobs(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for id, job := range s.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(before) {
+			delete(s.jobs, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// ListJobs returns the jobs in any of the given statuses, oldest first. With no
+// statuses all jobs are returned.
+func (s *MemoryJobStore) ListJobs(statuses ...Status) ([]*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*Job
+	for _, job := range s.jobs {
+		if len(statuses) > 0 && !containsStatus(statuses, job.Status) {
+			continue
+		}
+		copied := *job
+		result = append(result, &copied)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+func (s *MemoryJobStore) AddDeadLetter(letter *DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *letter
+	s.deadLetters = append(s.deadLetters, &copied)
+	return nil
+}
+
+func (s *MemoryJobStore) ListDeadLetters() ([]*DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*DeadLetter, len(s.deadLetters))
+	for i, letter := range s.deadLetters {
+		copied := *letter
+		result[i] = &copied
+	}
+	return result, nil
+}
+
+func containsStatus(statuses []Status, status Status) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
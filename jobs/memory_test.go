@@ -0,0 +1,98 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryJobStore(t *testing.T) {
+	store := NewMemoryJobStore()
+	now := time.Now()
+
+	first := &Job{ID: "first", Status: StatusQueued, CreatedAt: now}
+	second := &Job{ID: "second", Status: StatusRunning, CreatedAt: now.Add(time.Second)}
+	assert.NoError(t, store.CreateJob(second))
+	assert.NoError(t, store.CreateJob(first))
+	assert.Error(t, store.CreateJob(first), "duplicate IDs are rejected")
+
+	job, err := store.GetJob("first")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusQueued, job.Status)
+
+	// Returned jobs are copies
+	job.Status = StatusDone
+	stored, _ := store.GetJob("first")
+	assert.Equal(t, StatusQueued, stored.Status)
+
+	assert.NoError(t, store.UpdateJob(job))
+	stored, _ = store.GetJob("first")
+	assert.Equal(t, StatusDone, stored.Status)
+	assert.True(t, stored.Finished())
+
+	_, err = store.GetJob("missing")
+	assert.Equal(t, ErrJobNotFound, err)
+	assert.Equal(t, ErrJobNotFound, store.UpdateJob(&Job{ID: "missing"}))
+
+	all, err := store.ListJobs()
+	assert.NoError(t, err)
+	if assert.Len(t, all, 2) {
+		assert.Equal(t, "first", all[0].ID, "oldest first")
+	}
+
+	running, err := store.ListJobs(StatusQueued, StatusRunning)
+	assert.NoError(t, err)
+	if assert.Len(t, running, 1) {
+		assert.Equal(t, "second", running[0].ID)
+	}
+
+	assert.NoError(t, store.DeleteJob("first"))
+	_, err = store.GetJob("first")
+	assert.Equal(t, ErrJobNotFound, err)
+	assert.Equal(t, ErrJobNotFound, store.DeleteJob("first"))
+}
+
+func TestMemoryJobStore_DeleteFinishedJobs(t *testing.T) {
+	store := NewMemoryJobStore()
+	now := time.Now()
+	old, recent := now.Add(-time.Hour), now.Add(-time.Second)
+	store.CreateJob(&Job{ID: "old", Status: StatusDone, FinishedAt: &old})
+	store.CreateJob(&Job{ID: "recent", Status: StatusFailed, FinishedAt: &recent})
+	store.CreateJob(&Job{ID: "queued", Status: StatusQueued, CreatedAt: old})
+
+	deleted, err := store.DeleteFinishedJobs(now.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = store.GetJob("old")
+	assert.Equal(t, ErrJobNotFound, err)
+	all, _ := store.ListJobs()
+	assert.Len(t, all, 2)
+}
+
+func TestMemoryJobStore_DeadLetters(t *testing.T) {
+	store := NewMemoryJobStore()
+
+	letters, err := store.ListDeadLetters()
+	assert.NoError(t, err)
+	assert.Empty(t, letters)
+
+	assert.NoError(t, store.AddDeadLetter(&DeadLetter{JobID: "a", Attempts: 3, LastError: "timeout"}))
+	letters, err = store.ListDeadLetters()
+	assert.NoError(t, err)
+	if assert.Len(t, letters, 1) {
+		assert.Equal(t, "a", letters[0].JobID)
+		assert.Equal(t, 3, letters[0].Attempts)
+	}
+}
+
+func TestNewJobID(t *testing.T) {
+	a, err := NewJobID()
+	assert.NoError(t, err)
+	b, _ := NewJobID()
+	assert.Len(t, a, 32)
+	assert.NotEqual(t, a, b)
+}
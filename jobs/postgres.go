@@ -0,0 +1,238 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jobs
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	_ "github.com/lib/pq"
+)
+
+const jobColumns = "id, status, progress, filename, audio_path, options, result, error, created_at, started_at, finished_at, " +
+	"callback_url, token_hash, webhook_status"
+
+// PostgresJobStore implements JobStore for PostgreSQL, using the
+// transcription_jobs table from scripts/schema.sql.
+type PostgresJobStore struct {
+	db *sql.DB
+}
+
+func NewPostgresJobStore(cfg *config.Config) (*PostgresJobStore, error) {
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		cfg.Jobs.Postgres.Host,
+		cfg.Jobs.Postgres.Port,
+		cfg.Jobs.Postgres.User,
+		cfg.Jobs.Postgres.Password,
+		cfg.Jobs.Postgres.DBName,
+	)
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("postgres connection failed: %v", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("postgres ping failed: %v", err)
+	}
+
+	return &PostgresJobStore{db: db}, nil
+}
+
+func (s *PostgresJobStore) CreateJob(job *Job) error {
+	_, err := s.db.Exec(
+		"INSERT INTO transcription_jobs ("+jobColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)",
+		job.ID, string(job.Status), job.Progress, job.Filename, job.AudioPath,
+		nullJSON(job.Options), nullJSON(job.Result), job.Error,
+		job.CreatedAt, job.StartedAt, job.FinishedAt,
+		job.CallbackURL, job.TokenHash, job.WebhookStatus,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create job: %v", err)
+	}
+	return nil
+}
+
+func (s *PostgresJobStore) GetJob(id string) (*Job, error) {
+	row := s.db.QueryRow("SELECT "+jobColumns+" FROM transcription_jobs WHERE id = $1", id)
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %v", err)
+	}
+	return job, nil
+}
+
+func (s *PostgresJobStore) UpdateJob(job *Job) error {
+	result, err := s.db.Exec(
+		`UPDATE transcription_jobs SET status = $2, progress = $3, result = $4, error = $5,
+			started_at = $6, finished_at = $7, webhook_status = $8 WHERE id = $1`,
+		job.ID, string(job.Status), job.Progress, nullJSON(job.Result), job.Error,
+		job.StartedAt, job.FinishedAt, job.WebhookStatus,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %v", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+func (s *PostgresJobStore) DeleteJob(id string) error {
+	result, err := s.db.Exec("DELETE FROM transcription_jobs WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete job: %v", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// DeleteFinishedJobs deletes the jobs that finished before the given time and
+// returns how many there were. Jobs with dead letters are kept, for the
+// letters refer to them.
+func (s *PostgresJobStore) DeleteFinishedJobs(before time.Time) (int, error) {
+	result, err := s.db.Exec(
+		`DELETE FROM transcription_jobs WHERE finished_at < $1
+			AND id NOT IN (SELECT job_id FROM webhook_dead_letters)`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete finished jobs: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete finished jobs: %v", err)
+	}
+	return int(rows), nil
+}
+
+// ListJobs returns the jobs in any of the given statuses, oldest first. With no
+// statuses all jobs are returned.
+func (s *PostgresJobStore) ListJobs(statuses ...Status) ([]*Job, error) {
+	query := "SELECT " + jobColumns + " FROM transcription_jobs"
+	args := make([]interface{}, len(statuses))
+	if len(statuses) > 0 {
+		placeholders := make([]string, len(statuses))
+		for i, status := range statuses {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+			args[i] = string(status)
+		}
+		query += " WHERE status IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	query += " ORDER BY created_at"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+	defer rows.Close()
+
+	var result []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list jobs: %v", err)
+		}
+		result = append(result, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+	return result, nil
+}
+
+func (s *PostgresJobStore) AddDeadLetter(letter *DeadLetter) error {
+	_, err := s.db.Exec(
+		`INSERT INTO webhook_dead_letters (job_id, url, payload, attempts, last_error, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+		letter.JobID, letter.URL, nullJSON(letter.Payload), letter.Attempts, letter.LastError, letter.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record dead letter: %v", err)
+	}
+	return nil
+}
+
+func (s *PostgresJobStore) ListDeadLetters() ([]*DeadLetter, error) {
+	rows, err := s.db.Query(
+		"SELECT job_id, url, payload, attempts, last_error, created_at FROM webhook_dead_letters ORDER BY created_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %v", err)
+	}
+	defer rows.Close()
+
+	var result []*DeadLetter
+	for rows.Next() {
+		var letter DeadLetter
+		var payload []byte
+		if err := rows.Scan(&letter.JobID, &letter.URL, &payload, &letter.Attempts,
+			&letter.LastError, &letter.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to list dead letters: %v", err)
+		}
+		letter.Payload = payload
+		result = append(result, &letter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %v", err)
+	}
+	return result, nil
+}
+
+func (s *PostgresJobStore) Close() error {
+	return s.db.Close()
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row scanner) (*Job, error) {
+	var (
+		job                   Job
+		status                string
+		options, result       []byte
+		errMsg                sql.NullString
+		startedAt, finishedAt sql.NullTime
+		callbackURL           sql.NullString
+		tokenHash             sql.NullString
+		webhookStatus         sql.NullString
+	)
+
+	err := row.Scan(&job.ID, &status, &job.Progress, &job.Filename, &job.AudioPath,
+		&options, &result, &errMsg, &job.CreatedAt, &startedAt, &finishedAt,
+		&callbackURL, &tokenHash, &webhookStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Status = Status(status)
+	job.Options = options
+	job.Result = result
+	job.Error = errMsg.String
+	job.CallbackURL = callbackURL.String
+	job.TokenHash = tokenHash.String
+	job.WebhookStatus = webhookStatus.String
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return &job, nil
+}
+
+// nullJSON maps an empty JSON document to SQL NULL.
+func nullJSON(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
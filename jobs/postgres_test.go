@@ -0,0 +1,168 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jobs
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupPostgresTest(t *testing.T) (*PostgresJobStore, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	return &PostgresJobStore{db: db}, mock
+}
+
+func jobRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "status", "progress", "filename", "audio_path",
+		"options", "result", "error", "created_at", "started_at", "finished_at",
+		"callback_url", "token_hash", "webhook_status"})
+}
+
+func TestPostgresJobStore(t *testing.T) {
+	store, mock := setupPostgresTest(t)
+	defer store.db.Close()
+
+	created := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	finished := created.Add(time.Minute)
+
+	t.Run("CreateJob", func(t *testing.T) {
+		mock.ExpectExec(`INSERT INTO transcription_jobs`).
+			WithArgs("job-1", "queued", 0, "clip.wav", "/tmp/job-1.wav", `{"language":"en"}`,
+				nil, "", created, nil, nil, "https://example.com/hook", "abc", "").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		err := store.CreateJob(&Job{
+			ID:        "job-1",
+			Status:    StatusQueued,
+			Filename:  "clip.wav",
+			AudioPath: "/tmp/job-1.wav",
+			Options:   []byte(`{"language":"en"}`),
+			CreatedAt: created,
+
+			CallbackURL: "https://example.com/hook",
+			TokenHash:   "abc",
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("GetJob", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT .* FROM transcription_jobs WHERE id = \$1`).
+			WithArgs("job-1").
+			WillReturnRows(jobRows().AddRow("job-1", "done", 100, "clip.wav", "/tmp/job-1.wav",
+				[]byte(`{}`), []byte(`{"text":"hi"}`), nil, created, created, finished,
+				"https://example.com/hook", "abc", "delivered"))
+
+		job, err := store.GetJob("job-1")
+		assert.NoError(t, err)
+		assert.Equal(t, StatusDone, job.Status)
+		assert.Equal(t, 100, job.Progress)
+		assert.JSONEq(t, `{"text":"hi"}`, string(job.Result))
+		assert.Empty(t, job.Error)
+		assert.Equal(t, "https://example.com/hook", job.CallbackURL)
+		assert.Equal(t, WebhookDelivered, job.WebhookStatus)
+		if assert.NotNil(t, job.FinishedAt) {
+			assert.Equal(t, finished, *job.FinishedAt)
+		}
+	})
+
+	t.Run("GetMissingJob", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT .* FROM transcription_jobs`).
+			WithArgs("missing").
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := store.GetJob("missing")
+		assert.Equal(t, ErrJobNotFound, err)
+	})
+
+	t.Run("UpdateJob", func(t *testing.T) {
+		mock.ExpectExec(`UPDATE transcription_jobs SET`).
+			WithArgs("job-1", "failed", 40, nil, "decode error", nil, &finished, "").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := store.UpdateJob(&Job{ID: "job-1", Status: StatusFailed, Progress: 40,
+			Error: "decode error", FinishedAt: &finished})
+		assert.NoError(t, err)
+
+		mock.ExpectExec(`UPDATE transcription_jobs SET`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		assert.Equal(t, ErrJobNotFound, store.UpdateJob(&Job{ID: "missing"}))
+	})
+
+	t.Run("ListJobs", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT .* FROM transcription_jobs WHERE status IN \(\$1, \$2\) ORDER BY created_at`).
+			WithArgs("queued", "running").
+			WillReturnRows(jobRows().
+				AddRow("a", "queued", 0, "a.wav", "/tmp/a.wav", nil, nil, nil, created, nil, nil, nil, nil, nil).
+				AddRow("b", "running", 10, "b.mp3", "/tmp/b.mp3", nil, nil, nil, finished, finished, nil, nil, nil, nil))
+
+		pending, err := store.ListJobs(StatusQueued, StatusRunning)
+		assert.NoError(t, err)
+		if assert.Len(t, pending, 2) {
+			assert.Equal(t, "a", pending[0].ID)
+			assert.Nil(t, pending[0].StartedAt)
+			assert.Equal(t, StatusRunning, pending[1].Status)
+			assert.NotNil(t, pending[1].StartedAt)
+		}
+	})
+
+	t.Run("DeleteJob", func(t *testing.T) {
+		mock.ExpectExec(`DELETE FROM transcription_jobs WHERE id = \$1`).
+			WithArgs("job-2").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		assert.NoError(t, store.DeleteJob("job-2"))
+
+		mock.ExpectExec(`DELETE FROM transcription_jobs`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		assert.Equal(t, ErrJobNotFound, store.DeleteJob("missing"))
+	})
+
+	t.Run("DeleteFinishedJobs", func(t *testing.T) {
+		mock.ExpectExec(`DELETE FROM transcription_jobs WHERE finished_at < \$1\s+AND id NOT IN \(SELECT job_id FROM webhook_dead_letters\)`).
+			WithArgs(created).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		deleted, err := store.DeleteFinishedJobs(created)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, deleted)
+	})
+
+	t.Run("DeadLetters", func(t *testing.T) {
+		mock.ExpectExec(`INSERT INTO webhook_dead_letters`).
+			WithArgs("job-1", "https://example.com/hook", `{"id":"job-1"}`, 5, "status 500", created).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		err := store.AddDeadLetter(&DeadLetter{JobID: "job-1", URL: "https://example.com/hook",
+			Payload: []byte(`{"id":"job-1"}`), Attempts: 5, LastError: "status 500", CreatedAt: created})
+		assert.NoError(t, err)
+
+		mock.ExpectQuery(`SELECT .* FROM webhook_dead_letters ORDER BY created_at`).
+			WillReturnRows(sqlmock.NewRows([]string{"job_id", "url", "payload", "attempts", "last_error", "created_at"}).
+				AddRow("job-1", "https://example.com/hook", []byte(`{"id":"job-1"}`), 5, "status 500", created))
+
+		letters, err := store.ListDeadLetters()
+		assert.NoError(t, err)
+		if assert.Len(t, letters, 1) {
+			assert.Equal(t, 5, letters[0].Attempts)
+			assert.Equal(t, "status 500", letters[0].LastError)
+		}
+	})
+
+	t.Run("DatabaseError", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT .* FROM transcription_jobs`).
+			WithArgs("job-1").
+			WillReturnError(sqlmock.ErrCancelled)
+
+		_, err := store.GetJob("job-1")
+		assert.Error(t, err)
+		assert.NotEqual(t, ErrJobNotFound, err)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
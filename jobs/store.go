@@ -0,0 +1,88 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Status is the lifecycle state of a job.
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusDone      Status = "done"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Webhook delivery states for jobs with a callback URL.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// ErrJobNotFound is returned when a job does not exist in the store.
+var ErrJobNotFound = errors.New("job not found")
+
+// Job represents an asynchronous transcription job.
+type Job struct {
+	ID         string
+	Status     Status
+	Progress   int
+	Filename   string          // Original upload filename
+	AudioPath  string          // Location of the uploaded audio on disk
+	Options    json.RawMessage // Decoding options for the job
+	Result     json.RawMessage // Transcription result once done
+	Error      string
+	CreatedAt  time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+
+	CallbackURL   string // Notified when the job finishes
+	TokenHash     string // SHA-256 of the submitting API token, selects the signing secret
+	WebhookStatus string
+}
+
+// DeadLetter records a webhook notification that could not be delivered.
+type DeadLetter struct {
+	JobID     string
+	URL       string
+	Payload   json.RawMessage
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+}
+
+// Finished reports whether the job has reached a terminal state.
+func (j *Job) Finished() bool {
+	return j.Status == StatusDone || j.Status == StatusFailed || j.Status == StatusCancelled
+}
+
+// JobStore defines the job persistence operations
+type JobStore interface {
+	CreateJob(job *Job) error
+	GetJob(id string) (*Job, error)
+	UpdateJob(job *Job) error
+	ListJobs(statuses ...Status) ([]*Job, error)
+	DeleteJob(id string) error
+	DeleteFinishedJobs(before time.Time) (int, error)
+	AddDeadLetter(letter *DeadLetter) error
+	ListDeadLetters() ([]*DeadLetter, error)
+}
+
+// NewJobID returns a random job identifier.
+func NewJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
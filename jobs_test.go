@@ -0,0 +1,347 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/VA7DBI/whisperAPI/jobs"
+	"github.com/VA7DBI/whisperAPI/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupJobTest(t *testing.T, transcribe transcribeFunc) (*JobManager, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{}
+	cfg.Audio.MaxFileSize = 25
+	cfg.Jobs.Workers = 1
+	cfg.Jobs.QueueSize = 10
+	cfg.Jobs.StorageDir = t.TempDir()
+	service := &TranscriptionService{config: cfg}
+
+	manager, err := NewJobManager(cfg, service, jobs.NewMemoryJobStore())
+	assert.NoError(t, err)
+	manager.transcribe = transcribe
+
+	r := gin.New()
+	r.POST("/jobs", manager.CreateJobHandler)
+	r.GET("/jobs/:id", manager.GetJobHandler)
+	r.DELETE("/jobs/:id", manager.CancelJobHandler)
+	return manager, r
+}
+
+func postJob(r *gin.Engine, fields map[string]string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		writer.WriteField(k, v)
+	}
+	part, _ := writer.CreateFormFile("audio", "clip.wav")
+	part.Write([]byte("RIFF"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/jobs", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func submitJob(t *testing.T, r *gin.Engine, fields map[string]string) JobResponse {
+	w := postJob(r, fields)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var job JobResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+	assert.Equal(t, "/jobs/"+job.ID, w.Header().Get("Location"))
+	return job
+}
+
+func jobRequest(r *gin.Engine, method, id string) (int, JobResponse) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, "/jobs/"+id, nil))
+	var job JobResponse
+	json.Unmarshal(w.Body.Bytes(), &job)
+	return w.Code, job
+}
+
+func waitForJob(t *testing.T, r *gin.Engine, id string, status string) JobResponse {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, job := jobRequest(r, "GET", id)
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, job.Status, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	var audioPath string
+	manager, r := setupJobTest(t, func(ctx context.Context, filename string, opts TranscriptionOptions, progress ProgressFunc) (*TranscriptionResponse, error) {
+		audioPath = filename
+		progress(50)
+		return &TranscriptionResponse{Text: "hello", Options: opts}, nil
+	})
+	assert.NoError(t, manager.Start())
+	defer manager.Stop()
+
+	job := submitJob(t, r, map[string]string{"language": "fr"})
+	assert.Equal(t, "queued", job.Status)
+	assert.Equal(t, "clip.wav", job.Filename)
+
+	done := waitForJob(t, r, job.ID, "done")
+	assert.Equal(t, 100, done.Progress)
+	assert.NotNil(t, done.StartedAt)
+	assert.NotNil(t, done.FinishedAt)
+	if assert.NotNil(t, done.Result) {
+		assert.Equal(t, "hello", done.Result.Text)
+		assert.Equal(t, "fr", done.Result.Options.Language)
+	}
+
+	// The upload is removed once processed
+	assert.Equal(t, ".wav", filepath.Ext(audioPath))
+	_, err := os.Stat(audioPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// Finished jobs cannot be cancelled
+	code, _ := jobRequest(r, "DELETE", job.ID)
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, _ = jobRequest(r, "GET", "missing")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestJobFailure(t *testing.T) {
+	manager, r := setupJobTest(t, func(context.Context, string, TranscriptionOptions, ProgressFunc) (*TranscriptionResponse, error) {
+		return nil, errors.New("Failed to convert audio: bad header")
+	})
+	assert.NoError(t, manager.Start())
+	defer manager.Stop()
+
+	job := waitForJob(t, r, submitJob(t, r, nil).ID, "failed")
+	assert.Equal(t, "Failed to convert audio: bad header", job.Error)
+	assert.Nil(t, job.Result)
+}
+
+func TestJobMaxDuration(t *testing.T) {
+	manager, r := setupJobTest(t, nil)
+	manager.service.config.Audio.MaxDuration = 1
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, _ := writer.CreateFormFile("audio", "tone.wav")
+	data, err := os.ReadFile(writeTestWAV(t, 2, 1))
+	assert.NoError(t, err)
+	part.Write(data)
+	writer.Close()
+
+	// Audio over the limit is rejected before it is queued
+	req := httptest.NewRequest("POST", "/jobs", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), CodeAudioTooLong)
+
+	entries, err := os.ReadDir(manager.dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestJobQueueFull(t *testing.T) {
+	manager, r := setupJobTest(t, nil)
+	manager.queue = make(chan string, 1)
+
+	// Without workers the first job fills the queue
+	queued := submitJob(t, r, nil)
+	w := postJob(r, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// The rejected upload is neither stored nor kept on disk
+	all, err := manager.store.ListJobs()
+	assert.NoError(t, err)
+	if assert.Len(t, all, 1) {
+		assert.Equal(t, queued.ID, all[0].ID)
+	}
+	entries, err := os.ReadDir(manager.dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestJobOwnership(t *testing.T) {
+	manager, _ := setupJobTest(t, nil)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set(middleware.TokenKey, c.GetHeader("Authorization")) })
+	r.GET("/jobs/:id", manager.GetJobHandler)
+	r.DELETE("/jobs/:id", manager.CancelJobHandler)
+	manager.store.CreateJob(&jobs.Job{ID: "mine", Status: jobs.StatusQueued, TokenHash: hashToken("alice")})
+
+	request := func(method, token string) int {
+		req := httptest.NewRequest(method, "/jobs/mine", nil)
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Other tokens can neither see nor cancel the job
+	assert.Equal(t, http.StatusNotFound, request("GET", "bob"))
+	assert.Equal(t, http.StatusNotFound, request("DELETE", "bob"))
+	job, _ := manager.store.GetJob("mine")
+	assert.Equal(t, jobs.StatusQueued, job.Status)
+
+	assert.Equal(t, http.StatusOK, request("GET", "alice"))
+	assert.Equal(t, http.StatusOK, request("DELETE", "alice"))
+}
+
+func TestJobCancel(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	manager, r := setupJobTest(t, func(context.Context, string, TranscriptionOptions, ProgressFunc) (*TranscriptionResponse, error) {
+		started <- struct{}{}
+		<-release
+		return &TranscriptionResponse{Text: "discarded"}, nil
+	})
+	assert.NoError(t, manager.Start())
+
+	running := submitJob(t, r, nil)
+	<-started
+	queued := submitJob(t, r, nil)
+
+	// A queued job is cancelled before it starts and never runs
+	code, job := jobRequest(r, "DELETE", queued.ID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "cancelled", job.Status)
+
+	// A running job keeps cancelled once the worker finishes
+	code, job = jobRequest(r, "DELETE", running.ID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "cancelled", job.Status)
+	close(release)
+
+	// The running job ignores its context, so Stop waits for it to finish
+	manager.Stop()
+	_, job = jobRequest(r, "GET", running.ID)
+	assert.Equal(t, "cancelled", job.Status)
+	assert.Nil(t, job.Result)
+	_, job = jobRequest(r, "GET", queued.ID)
+	assert.Equal(t, "cancelled", job.Status)
+}
+
+func TestJobCancel_WaitingForSlot(t *testing.T) {
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	manager, r := setupJobTest(t, func(ctx context.Context, _ string, _ TranscriptionOptions, _ ProgressFunc) (*TranscriptionResponse, error) {
+		// Stands in for waiting on a busy pool
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	assert.NoError(t, manager.Start())
+	defer manager.Stop()
+
+	job := submitJob(t, r, nil)
+	<-started
+	code, _ := jobRequest(r, "DELETE", job.ID)
+	assert.Equal(t, http.StatusOK, code)
+
+	select {
+	case err := <-stopped:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled job kept waiting")
+	}
+	_, done := jobRequest(r, "GET", job.ID)
+	assert.Equal(t, "cancelled", done.Status)
+}
+
+func TestJobManager_StopWithJobRunning(t *testing.T) {
+	started := make(chan struct{})
+	manager, r := setupJobTest(t, func(ctx context.Context, _ string, _ TranscriptionOptions, _ ProgressFunc) (*TranscriptionResponse, error) {
+		// Stands in for decoding, which stops at the next chunk
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	assert.NoError(t, manager.Start())
+
+	submitted := submitJob(t, r, nil)
+	<-started
+	stopped := make(chan struct{})
+	go func() {
+		manager.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop waited for the running job")
+	}
+
+	// The job is left to run again on the next Start
+	job, err := manager.store.GetJob(submitted.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, jobs.StatusRunning, job.Status)
+	assert.FileExists(t, job.AudioPath)
+}
+
+func TestJobManager_Prune(t *testing.T) {
+	manager, r := setupJobTest(t, nil)
+	manager.retention = time.Hour
+	old := time.Now().UTC().Add(-2 * time.Hour)
+	recent := time.Now().UTC()
+	manager.store.CreateJob(&jobs.Job{ID: "expired", Status: jobs.StatusDone, Result: []byte(`{}`), FinishedAt: &old})
+	manager.store.CreateJob(&jobs.Job{ID: "recent", Status: jobs.StatusFailed, FinishedAt: &recent})
+	manager.store.CreateJob(&jobs.Job{ID: "queued", Status: jobs.StatusQueued, CreatedAt: old})
+
+	manager.prune()
+	code, _ := jobRequest(r, "GET", "expired")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = jobRequest(r, "GET", "recent")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = jobRequest(r, "GET", "queued")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestJobManager_ResumesPendingJobs(t *testing.T) {
+	manager, r := setupJobTest(t, func(context.Context, string, TranscriptionOptions, ProgressFunc) (*TranscriptionResponse, error) {
+		return &TranscriptionResponse{Text: "resumed"}, nil
+	})
+
+	// Jobs left behind by a previous run
+	audioPath := filepath.Join(manager.dir, "interrupted.wav")
+	assert.NoError(t, os.WriteFile(audioPath, []byte("RIFF"), 0644))
+	started := time.Now()
+	manager.store.CreateJob(&jobs.Job{ID: "interrupted", Status: jobs.StatusRunning, Progress: 30,
+		AudioPath: audioPath, Options: []byte(`{}`), StartedAt: &started})
+	manager.store.CreateJob(&jobs.Job{ID: "lost", Status: jobs.StatusQueued,
+		AudioPath: filepath.Join(manager.dir, "lost.wav"), Options: []byte(`{}`)})
+
+	assert.NoError(t, manager.Start())
+	defer manager.Stop()
+
+	job := waitForJob(t, r, "interrupted", "done")
+	assert.Equal(t, "resumed", job.Result.Text)
+
+	_, job = jobRequest(r, "GET", "lost")
+	assert.Equal(t, "failed", job.Status)
+	assert.NotEmpty(t, job.Error)
+}
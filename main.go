@@ -7,7 +7,9 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strings"
 
+	"github.com/VA7DBI/whisperAPI/audio"
 	"github.com/VA7DBI/whisperAPI/config"
 	_ "github.com/VA7DBI/whisperAPI/docs"
 	"github.com/VA7DBI/whisperAPI/middleware"
@@ -42,6 +44,20 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	audio.DefaultResampleQuality, err = audio.ParseResampleQuality(cfg.Audio.ResampleQuality)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	for name, stages := range cfg.Audio.Preprocess.Profiles {
+		if _, err := audio.ParseStages(stages); err != nil {
+			log.Fatalf("Failed to load configuration: preprocessing profile %q: %v", name, err)
+		}
+	}
+	if len(cfg.Whisper.Languages) > 0 {
+		if _, err := parseLanguages(strings.Join(cfg.Whisper.Languages, ",")); err != nil {
+			log.Fatalf("Failed to load configuration: whisper.languages: %v", err)
+		}
+	}
 
 	r := gin.Default()
 
@@ -58,9 +74,34 @@ func main() {
 		log.Fatalf("Failed to initialize auth middleware: %v", err)
 	}
 	r.POST("/transcribe", authMiddleware.Handler(), service.TranscribeHandler)
+	r.GET("/stream", authMiddleware.Handler(), service.StreamHandler)
+	r.POST("/detect-language", authMiddleware.Handler(), service.DetectLanguageHandler)
+
+	// Asynchronous jobs
+	jobStore, err := NewJobStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize job store: %v", err)
+	}
+	jobManager, err := NewJobManager(cfg, service, jobStore)
+	if err != nil {
+		log.Fatalf("Failed to initialize job manager: %v", err)
+	}
+	if err := jobManager.Start(); err != nil {
+		log.Fatalf("Failed to start job workers: %v", err)
+	}
+	defer jobManager.Stop()
+	r.POST("/jobs", authMiddleware.Handler(), jobManager.CreateJobHandler)
+	r.GET("/jobs/:id", authMiddleware.Handler(), jobManager.GetJobHandler)
+	r.DELETE("/jobs/:id", authMiddleware.Handler(), jobManager.CancelJobHandler)
+
+	// OpenAI-compatible audio endpoints
+	v1 := r.Group("/v1", authMiddleware.HandlerWithErrors(openAIAuthError))
+	v1.POST("/audio/transcriptions", service.OpenAITranscriptionsHandler)
+	v1.POST("/audio/translations", service.OpenAITranslationsHandler)
 
 	// These endpoints remain public
 	r.GET("/health", healthCheck)
+	r.GET("/formats", FormatsHandler)
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	// Add Prometheus metrics endpoint if enabled
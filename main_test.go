@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/VA7DBI/whisperAPI/jobs"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/stretchr/testify/assert"
@@ -25,9 +26,11 @@ func TestMainSetup(t *testing.T) {
 	cfg.Server.Host = "localhost"
 	cfg.Audio.SampleRate = 16000
 	cfg.Audio.MaxFileSize = 25
+	cfg.Whisper.Engine = "fake"
 	cfg.Whisper.ModelPath = "models/ggml-base.bin"
 	cfg.Metrics.Enabled = true
 	cfg.Metrics.Path = "/metrics"
+	cfg.Jobs.StorageDir = t.TempDir()
 
 	// Initialize transcription service
 	service, err := NewTranscriptionService(cfg)
@@ -35,9 +38,20 @@ func TestMainSetup(t *testing.T) {
 	assert.NotNil(t, service)
 	defer service.Close()
 
+	jobManager, err := NewJobManager(cfg, service, jobs.NewMemoryJobStore())
+	assert.NoError(t, err)
+
 	// Register all routes
 	r.POST("/transcribe", service.TranscribeHandler)
+	r.GET("/stream", service.StreamHandler)
+	r.POST("/detect-language", service.DetectLanguageHandler)
+	r.POST("/jobs", jobManager.CreateJobHandler)
+	r.GET("/jobs/:id", jobManager.GetJobHandler)
+	r.DELETE("/jobs/:id", jobManager.CancelJobHandler)
+	r.POST("/v1/audio/transcriptions", service.OpenAITranscriptionsHandler)
+	r.POST("/v1/audio/translations", service.OpenAITranslationsHandler)
 	r.GET("/health", healthCheck)
+	r.GET("/formats", FormatsHandler)
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
@@ -50,7 +64,14 @@ func TestMainSetup(t *testing.T) {
 
 	// Verify required endpoints are registered
 	assert.True(t, routeMap["/transcribe"], "Missing /transcribe endpoint")
+	assert.True(t, routeMap["/stream"], "Missing /stream endpoint")
+	assert.True(t, routeMap["/detect-language"], "Missing /detect-language endpoint")
+	assert.True(t, routeMap["/jobs"], "Missing /jobs endpoint")
+	assert.True(t, routeMap["/jobs/:id"], "Missing /jobs/:id endpoint")
+	assert.True(t, routeMap["/v1/audio/transcriptions"], "Missing /v1/audio/transcriptions endpoint")
+	assert.True(t, routeMap["/v1/audio/translations"], "Missing /v1/audio/translations endpoint")
 	assert.True(t, routeMap["/health"], "Missing /health endpoint")
+	assert.True(t, routeMap["/formats"], "Missing /formats endpoint")
 	assert.True(t, routeMap["/swagger/*any"], "Missing /swagger endpoint")
 	assert.True(t, routeMap["/metrics"], "Missing /metrics endpoint")
 }
@@ -14,6 +14,11 @@ var (
 		Help: "Total number of transcription requests",
 	}, []string{"status", "format"})
 
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "whisperapi_webhook_deliveries_total",
+		Help: "Total number of job webhook delivery attempts",
+	}, []string{"status"})
+
 	TranscriptionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "whisperapi_transcription_duration_seconds",
 		Help:    "Time spent processing transcription requests",
@@ -26,6 +31,27 @@ var (
 		Buckets: prometheus.ExponentialBuckets(1, 2.0, 10), // 1s to ~512s
 	}, []string{"format"})
 
+	QueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "whisperapi_queue_depth",
+		Help: "Requests waiting for an inference slot",
+	})
+
+	InferenceInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "whisperapi_inference_in_flight",
+		Help: "Transcriptions currently running on an inference slot",
+	})
+
+	QueueWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "whisperapi_queue_wait_seconds",
+		Help:    "Time spent waiting for an inference slot",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2.0, 14), // 10ms to ~82s
+	})
+
+	QueueRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "whisperapi_queue_rejections_total",
+		Help: "Requests rejected because no inference slot was available",
+	}, []string{"reason"})
+
 	MemoryUsage = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "whisperapi_memory_usage_bytes",
 		Help: "Memory usage during transcription",
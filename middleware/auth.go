@@ -14,6 +14,9 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// TokenKey is the gin context key holding the validated bearer token
+const TokenKey = "auth_token"
+
 // Update constructor type definitions to match TokenStore interface
 type storeConstructor func(*config.Config) (auth.TokenStore, error)
 
@@ -87,8 +90,22 @@ func BearerAuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 	return middleware.Handler()
 }
 
+// ErrorWriter writes an authentication failure to the client.
+type ErrorWriter func(c *gin.Context, status int, message string)
+
+// writeJSONError reports a failure as {"error": message}.
+func writeJSONError(c *gin.Context, status int, message string) {
+	c.JSON(status, gin.H{"error": message})
+}
+
 // Handler returns the gin middleware handler function
 func (m *AuthMiddleware) Handler() gin.HandlerFunc {
+	return m.HandlerWithErrors(writeJSONError)
+}
+
+// HandlerWithErrors is like Handler but reports failures with writeError, for
+// APIs that have their own error format.
+func (m *AuthMiddleware) HandlerWithErrors(writeError ErrorWriter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Fast path: if auth is disabled, allow all requests
 		if !m.cfg.Auth.Enabled {
@@ -98,7 +115,7 @@ func (m *AuthMiddleware) Handler() gin.HandlerFunc {
 
 		token := extractToken(c)
 		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			writeError(c, http.StatusUnauthorized, "Authorization header required")
 			c.Abort()
 			return
 		}
@@ -107,6 +124,7 @@ func (m *AuthMiddleware) Handler() gin.HandlerFunc {
 		if m.redisStore != nil {
 			valid, err := m.redisStore.ValidateToken(token)
 			if err == nil && valid {
+				c.Set(TokenKey, token)
 				c.Next()
 				return
 			}
@@ -120,6 +138,7 @@ func (m *AuthMiddleware) Handler() gin.HandlerFunc {
 				if m.redisStore != nil {
 					_ = m.redisStore.CacheToken(token)
 				}
+				c.Set(TokenKey, token)
 				c.Next()
 				return
 			}
@@ -132,16 +151,23 @@ func (m *AuthMiddleware) Handler() gin.HandlerFunc {
 				if m.redisStore != nil {
 					_ = m.redisStore.CacheToken(token)
 				}
+				c.Set(TokenKey, token)
 				c.Next()
 				return
 			}
 		}
 
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		writeError(c, http.StatusUnauthorized, "Invalid token")
 		c.Abort()
 	}
 }
 
+// TokenFromContext returns the bearer token validated for the request, or an
+// empty string if authentication is disabled.
+func TokenFromContext(c *gin.Context) string {
+	return c.GetString(TokenKey)
+}
+
 func extractToken(c *gin.Context) string {
 	authHeader := c.GetHeader("Authorization")
 	if authHeader == "" {
@@ -146,6 +146,26 @@ func TestAuthMiddleware(t *testing.T) {
 
 		assert.Equal(t, http.StatusUnauthorized, w.Code)
 	})
+
+	t.Run("CustomErrorFormat", func(t *testing.T) {
+		r := gin.New()
+		cfg.Auth.Enabled = true
+		middleware := &AuthMiddleware{cfg: cfg}
+
+		r.GET("/test", middleware.HandlerWithErrors(func(c *gin.Context, status int, message string) {
+			c.JSON(status, gin.H{"error": gin.H{"message": message}})
+		}), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "Bearer invalid-token")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.JSONEq(t, `{"error": {"message": "Invalid token"}}`, w.Body.String())
+	})
 }
 
 func TestTokenValidationFlow(t *testing.T) {
@@ -189,6 +209,37 @@ func TestTokenValidationFlow(t *testing.T) {
 	})
 }
 
+func TestTokenFromContext(t *testing.T) {
+	cfg, mockRedis, mockPg := setupAuthTest()
+	r := gin.New()
+
+	middleware := &AuthMiddleware{
+		cfg:        cfg,
+		redisStore: mockRedis,
+		pgStore:    mockPg,
+	}
+
+	var token string
+	r.GET("/test", middleware.Handler(), func(c *gin.Context) {
+		token = TokenFromContext(c)
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer static-token")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "static-token", token)
+
+	// No token is recorded when authentication is disabled
+	cfg.Auth.Enabled = false
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "", token)
+}
+
 func TestAuthConfigurationBehavior(t *testing.T) {
 	t.Run("AuthDisabledOverridesStores", func(t *testing.T) {
 		cfg := &config.Config{}
@@ -0,0 +1,363 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/VA7DBI/whisperAPI/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAI response formats
+const (
+	OpenAIFormatJSON        = "json"
+	OpenAIFormatText        = "text"
+	OpenAIFormatSRT         = "srt"
+	OpenAIFormatVTT         = "vtt"
+	OpenAIFormatVerboseJSON = "verbose_json"
+)
+
+// OpenAIErrorResponse is the error envelope used by the OpenAI API.
+type OpenAIErrorResponse struct {
+	Error OpenAIErrorDetail `json:"error"`
+}
+
+// OpenAIErrorDetail describes an error in the OpenAI error envelope.
+type OpenAIErrorDetail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// OpenAITranscription is the OpenAI "json" response format.
+type OpenAITranscription struct {
+	Text string `json:"text"`
+}
+
+// OpenAIVerboseTranscription is the OpenAI "verbose_json" response format.
+type OpenAIVerboseTranscription struct {
+	Task     string          `json:"task"`
+	Language string          `json:"language,omitempty"`
+	Duration float64         `json:"duration"`
+	Text     string          `json:"text"`
+	Words    []OpenAIWord    `json:"words,omitempty"`
+	Segments []OpenAISegment `json:"segments,omitempty"`
+}
+
+// OpenAISegment is a segment in the OpenAI "verbose_json" response format.
+type OpenAISegment struct {
+	ID               int     `json:"id"`
+	Seek             int     `json:"seek"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+}
+
+// OpenAIWord is a word timestamp in the OpenAI "verbose_json" response format.
+type OpenAIWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// OpenAITranscriptionsHandler handles OpenAI-compatible transcription requests.
+// @Summary     Transcribe audio (OpenAI compatible)
+// @Description Accepts the OpenAI audio transcription request shape and returns OpenAI-compatible responses.
+// @Tags        openai
+// @Accept      multipart/form-data
+// @Produce     json,plain
+// @Param       file formData file true "Audio file to transcribe"
+// @Param       model formData string false "Model name (accepted for compatibility, ignored)"
+// @Param       language formData string false "Spoken language (ISO 639-1 code)"
+// @Param       prompt formData string false "Prompt to guide the decoder"
+// @Param       response_format formData string false "json, text, srt, verbose_json or vtt" default(json)
+// @Param       temperature formData number false "Sampling temperature (0.0-1.0)"
+// @Param       timestamp_granularities[] formData []string false "word and/or segment (verbose_json only)" collectionFormat(multi)
+// @Success     200 {object} OpenAIVerboseTranscription "Transcription in the requested format"
+// @Failure     400 {object} OpenAIErrorResponse "Invalid request"
+// @Failure     413 {object} OpenAIErrorResponse "Audio longer than the maximum duration (code audio_too_long)"
+// @Failure     401 {object} ErrorResponse "Unauthorized (invalid or missing API key)"
+// @Failure     500 {object} OpenAIErrorResponse "Server error during processing"
+// @Security    ApiKeyAuth
+// @Router      /v1/audio/transcriptions [post]
+func (s *TranscriptionService) OpenAITranscriptionsHandler(c *gin.Context) {
+	s.handleOpenAIRequest(c, "transcribe")
+}
+
+// OpenAITranslationsHandler handles OpenAI-compatible translation requests.
+// @Summary     Translate audio to English (OpenAI compatible)
+// @Description Accepts the OpenAI audio translation request shape and returns OpenAI-compatible responses.
+// @Tags        openai
+// @Accept      multipart/form-data
+// @Produce     json,plain
+// @Param       file formData file true "Audio file to translate"
+// @Param       model formData string false "Model name (accepted for compatibility, ignored)"
+// @Param       prompt formData string false "Prompt to guide the decoder"
+// @Param       response_format formData string false "json, text, srt, verbose_json or vtt" default(json)
+// @Param       temperature formData number false "Sampling temperature (0.0-1.0)"
+// @Success     200 {object} OpenAIVerboseTranscription "Translation in the requested format"
+// @Failure     400 {object} OpenAIErrorResponse "Invalid request"
+// @Failure     413 {object} OpenAIErrorResponse "Audio longer than the maximum duration (code audio_too_long)"
+// @Failure     401 {object} ErrorResponse "Unauthorized (invalid or missing API key)"
+// @Failure     500 {object} OpenAIErrorResponse "Server error during processing"
+// @Security    ApiKeyAuth
+// @Router      /v1/audio/translations [post]
+func (s *TranscriptionService) OpenAITranslationsHandler(c *gin.Context) {
+	s.handleOpenAIRequest(c, "translate")
+}
+
+// handleOpenAIRequest parses an OpenAI audio request, runs the transcription and
+// writes the response in the requested format.
+func (s *TranscriptionService) handleOpenAIRequest(c *gin.Context, task string) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		metrics.TranscriptionRequests.WithLabelValues("error", "unknown").Inc()
+		openAIError(c, http.StatusBadRequest, "file", "No audio file provided")
+		return
+	}
+
+	responseFormat := c.DefaultPostForm("response_format", OpenAIFormatJSON)
+	switch responseFormat {
+	case OpenAIFormatJSON, OpenAIFormatText, OpenAIFormatSRT, OpenAIFormatVTT, OpenAIFormatVerboseJSON:
+	default:
+		openAIError(c, http.StatusBadRequest, "response_format",
+			fmt.Sprintf("Invalid response_format %q: must be one of json, text, srt, verbose_json or vtt", responseFormat))
+		return
+	}
+
+	opts := s.defaultOptions()
+	opts.Translate = task == "translate"
+	opts.MaxDuration = s.maxDuration(c)
+
+	if lang := c.PostForm("language"); lang != "" && task == "transcribe" {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if len(lang) < 2 || len(lang) > 3 {
+			openAIError(c, http.StatusBadRequest, "language", fmt.Sprintf("Invalid language %q: expected an ISO 639-1 code", lang))
+			return
+		}
+		opts.Language = lang
+	}
+
+	if prompt, ok := c.GetPostForm("prompt"); ok {
+		if utf8.RuneCountInString(prompt) > MaxInitialPromptLen {
+			openAIError(c, http.StatusBadRequest, "prompt", fmt.Sprintf("prompt too long: maximum is %d characters", MaxInitialPromptLen))
+			return
+		}
+		opts.InitialPrompt = prompt
+	}
+
+	if v := c.PostForm("temperature"); v != "" {
+		t, err := strconv.ParseFloat(v, 32)
+		if err != nil || t < MinTemperature || t > MaxTemperature {
+			openAIError(c, http.StatusBadRequest, "temperature",
+				fmt.Sprintf("Invalid temperature %q: must be between %.1f and %.1f", v, MinTemperature, MaxTemperature))
+			return
+		}
+		opts.Temperature = float32(t)
+	}
+
+	var wantWords, wantSegments bool
+	granularities := c.PostFormArray("timestamp_granularities[]")
+	for _, g := range granularities {
+		switch g {
+		case "word":
+			wantWords = true
+		case "segment":
+			wantSegments = true
+		default:
+			openAIError(c, http.StatusBadRequest, "timestamp_granularities",
+				fmt.Sprintf("Invalid timestamp granularity %q: must be word or segment", g))
+			return
+		}
+	}
+	if len(granularities) > 0 && responseFormat != OpenAIFormatVerboseJSON {
+		openAIError(c, http.StatusBadRequest, "timestamp_granularities",
+			"timestamp_granularities requires response_format to be verbose_json")
+		return
+	}
+	if len(granularities) == 0 {
+		wantSegments = true
+	}
+	if wantWords {
+		opts.TokenTimestamps = true
+	}
+
+	response, err := s.transcribeUpload(c.Request.Context(), file, opts, nil, nil)
+	if err != nil {
+		setRetryAfter(c, err)
+		openAIRequestError(c, err)
+		return
+	}
+
+	switch responseFormat {
+	case OpenAIFormatJSON:
+		c.JSON(http.StatusOK, OpenAITranscription{Text: openAIText(response.Segments)})
+	case OpenAIFormatText:
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(openAIText(response.Segments)+"\n"))
+	case OpenAIFormatSRT:
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(renderSRT(buildCues(response.Segments, SubtitleOptions{}))))
+	case OpenAIFormatVTT:
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(renderVTT(buildCues(response.Segments, SubtitleOptions{}), false)))
+	case OpenAIFormatVerboseJSON:
+		c.JSON(http.StatusOK, toOpenAIVerbose(task, response, wantSegments, wantWords))
+	}
+}
+
+// openAIError writes an error using the OpenAI error envelope.
+func openAIError(c *gin.Context, status int, param, message string) {
+	detail := OpenAIErrorDetail{Message: message, Type: openAIErrorType(status)}
+	if param != "" {
+		detail.Param = &param
+	}
+	c.JSON(status, OpenAIErrorResponse{Error: detail})
+}
+
+// openAIAuthError reports an authentication failure as the OpenAI API does.
+func openAIAuthError(c *gin.Context, status int, message string) {
+	code := "invalid_api_key"
+	c.JSON(status, OpenAIErrorResponse{Error: OpenAIErrorDetail{
+		Message: message,
+		Type:    openAIErrorType(status),
+		Code:    &code,
+	}})
+}
+
+// openAIRequestError writes an error from the transcription pipeline, with its
+// code if it has one.
+func openAIRequestError(c *gin.Context, err error) {
+	status := errorStatus(err)
+	detail := OpenAIErrorDetail{Message: err.Error(), Type: openAIErrorType(status)}
+	if code := errorResponse(err).Code; code != "" {
+		detail.Code = &code
+	}
+	c.JSON(status, OpenAIErrorResponse{Error: detail})
+}
+
+// openAIErrorType returns the OpenAI error type for an HTTP status.
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= http.StatusInternalServerError:
+		return "server_error"
+	}
+	return "invalid_request_error"
+}
+
+// openAIText joins segment text the way the OpenAI API returns it.
+func openAIText(segments []SegmentInfo) string {
+	parts := make([]string, 0, len(segments))
+	for _, seg := range segments {
+		if text := strings.TrimSpace(seg.Text); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// openAILanguage returns the language name reported in verbose responses, or
+// "" when the language was to be detected and the engine could not.
+func openAILanguage(task string, opts TranscriptionOptions) string {
+	switch {
+	case task == "translate" || opts.Language == "":
+		// whisper.cpp defaults to English when no language is set
+		return "english"
+	case opts.Language == "auto":
+		return ""
+	}
+	return languageName(opts.Language)
+}
+
+// toOpenAIVerbose converts a transcription response to the OpenAI verbose_json format.
+func toOpenAIVerbose(task string, response *TranscriptionResponse, withSegments, withWords bool) OpenAIVerboseTranscription {
+	verbose := OpenAIVerboseTranscription{
+		Task:     task,
+		Language: openAILanguage(task, response.Options),
+		Duration: response.Duration,
+		Text:     openAIText(response.Segments),
+	}
+
+	if withSegments {
+		verbose.Segments = make([]OpenAISegment, 0, len(response.Segments))
+		for i, seg := range response.Segments {
+			verbose.Segments = append(verbose.Segments, toOpenAISegment(i, seg, response.Options.Temperature))
+		}
+	}
+
+	if withWords {
+		verbose.Words = make([]OpenAIWord, 0)
+		for _, seg := range response.Segments {
+			for _, w := range segmentWords(seg) {
+				verbose.Words = append(verbose.Words, OpenAIWord{Word: w.Text, Start: w.Start, End: w.End})
+			}
+		}
+	}
+
+	return verbose
+}
+
+// toOpenAISegment converts a segment to the OpenAI verbose segment shape.
+func toOpenAISegment(id int, seg SegmentInfo, temperature float32) OpenAISegment {
+	tokens := make([]int, 0, len(seg.Tokens))
+	var logprob float64
+	var textTokens int
+	for _, token := range seg.Tokens {
+		tokens = append(tokens, token.ID)
+		if isSpecialToken(token.Text) {
+			continue
+		}
+		logprob += math.Log(math.Max(token.Probability, 1e-10))
+		textTokens++
+	}
+	if textTokens > 0 {
+		logprob /= float64(textTokens)
+	}
+
+	return OpenAISegment{
+		ID:               id,
+		Seek:             int(math.Round(seg.StartTime * 100)),
+		Start:            seg.StartTime,
+		End:              seg.EndTime,
+		Text:             " " + strings.TrimSpace(seg.Text),
+		Tokens:           tokens,
+		Temperature:      float64(temperature),
+		AvgLogprob:       logprob,
+		CompressionRatio: compressionRatio(seg.Text),
+		// whisper.cpp does not report a per-segment no-speech probability
+		NoSpeechProb: 0,
+	}
+}
+
+// isSpecialToken reports whether a token is a whisper control token such as
+// [_BEG_], [_TT_150] or <|endoftext|>.
+func isSpecialToken(text string) bool {
+	return (strings.HasPrefix(text, "[_") && strings.HasSuffix(text, "]")) ||
+		(strings.HasPrefix(text, "<|") && strings.HasSuffix(text, "|>"))
+}
+
+// compressionRatio returns the zlib compression ratio of text, as reported by OpenAI.
+func compressionRatio(text string) float64 {
+	if text == "" {
+		return 0
+	}
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte(text))
+	w.Close()
+	return float64(len(text)) / float64(buf.Len())
+}
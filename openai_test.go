@@ -0,0 +1,165 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/VA7DBI/whisperAPI/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSegments() []SegmentInfo {
+	return []SegmentInfo{
+		{
+			Text:      "Hello world.",
+			StartTime: 0,
+			EndTime:   1.5,
+			Tokens: []TokenInfo{
+				{ID: 50364, Text: "[_BEG_]", Probability: 1},
+				{ID: 15947, Text: " Hello", Probability: 0.9, StartTime: 0, EndTime: 0.5},
+				{ID: 1002, Text: " wor", Probability: 0.8, StartTime: 0.5, EndTime: 0.9},
+				{ID: 75, Text: "ld", Probability: 0.8, StartTime: 0.9, EndTime: 1.2},
+				{ID: 13, Text: ".", Probability: 0.95, StartTime: 1.2, EndTime: 1.3},
+				{ID: 50439, Text: "[_TT_75]", Probability: 1},
+			},
+		},
+		{
+			Text:      "Over.",
+			StartTime: 61.25,
+			EndTime:   3723.5,
+			Tokens: []TokenInfo{
+				{ID: 4886, Text: " Over", Probability: 0.7, StartTime: 61.25, EndTime: 62},
+				{ID: 13, Text: ".", Probability: 0.9, StartTime: 62, EndTime: 62.1},
+			},
+		},
+	}
+}
+
+func TestOpenAIText(t *testing.T) {
+	assert.Equal(t, "Hello world. Over.", openAIText(testSegments()))
+}
+
+func TestToOpenAIVerbose(t *testing.T) {
+	response := &TranscriptionResponse{
+		Segments: testSegments(),
+		Duration: 3724,
+		Options:  TranscriptionOptions{Language: "fr", Temperature: 0.2},
+	}
+
+	verbose := toOpenAIVerbose("transcribe", response, true, true)
+	assert.Equal(t, "transcribe", verbose.Task)
+	assert.Equal(t, "french", verbose.Language)
+	assert.Equal(t, "Hello world. Over.", verbose.Text)
+	assert.Len(t, verbose.Segments, 2)
+	assert.Len(t, verbose.Words, 3)
+
+	seg := verbose.Segments[0]
+	assert.Equal(t, " Hello world.", seg.Text)
+	assert.Equal(t, []int{50364, 15947, 1002, 75, 13, 50439}, seg.Tokens)
+	assert.Less(t, seg.AvgLogprob, 0.0)
+	assert.Greater(t, seg.CompressionRatio, 0.0)
+	assert.InDelta(t, 0.2, seg.Temperature, 1e-6)
+	assert.Equal(t, 6125, verbose.Segments[1].Seek)
+
+	// Translations always report English and omit words unless requested
+	verbose = toOpenAIVerbose("translate", response, true, false)
+	assert.Equal(t, "english", verbose.Language)
+	assert.Nil(t, verbose.Words)
+
+	// A language left undetected is not reported
+	response.Options.Language = "auto"
+	verbose = toOpenAIVerbose("transcribe", response, false, false)
+	assert.Empty(t, verbose.Language)
+}
+
+func TestOpenAIAuthError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.Auth.Enabled = true
+	cfg.Auth.Tokens = []string{"secret"}
+	auth, err := middleware.NewAuthMiddleware(cfg)
+	assert.NoError(t, err)
+
+	r := gin.New()
+	r.POST("/v1/audio/transcriptions", auth.HandlerWithErrors(openAIAuthError), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	for _, header := range []string{"", "Bearer wrong"} {
+		req := httptest.NewRequest("POST", "/v1/audio/transcriptions", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		var envelope OpenAIErrorResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+		assert.Equal(t, "invalid_request_error", envelope.Error.Type)
+		assert.NotEmpty(t, envelope.Error.Message)
+		if assert.NotNil(t, envelope.Error.Code) {
+			assert.Equal(t, "invalid_api_key", *envelope.Error.Code)
+		}
+	}
+}
+
+func TestOpenAIHandler_ValidationErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.Audio.MaxFileSize = 25
+	service := &TranscriptionService{config: cfg}
+
+	r := gin.New()
+	r.POST("/v1/audio/transcriptions", service.OpenAITranscriptionsHandler)
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		file   bool
+		param  string
+	}{
+		{"missing file", map[string]string{"model": "whisper-1"}, false, "file"},
+		{"bad response_format", map[string]string{"response_format": "xml"}, true, "response_format"},
+		{"bad temperature", map[string]string{"temperature": "2"}, true, "temperature"},
+		{"granularities without verbose_json", map[string]string{"timestamp_granularities[]": "word"}, true, "timestamp_granularities"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writer := multipart.NewWriter(&buf)
+			for k, v := range tt.fields {
+				writer.WriteField(k, v)
+			}
+			if tt.file {
+				part, _ := writer.CreateFormFile("file", "clip.wav")
+				part.Write([]byte("RIFF"))
+			}
+			writer.Close()
+
+			req := httptest.NewRequest("POST", "/v1/audio/transcriptions", &buf)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			var envelope OpenAIErrorResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+			assert.Equal(t, "invalid_request_error", envelope.Error.Type)
+			assert.NotEmpty(t, envelope.Error.Message)
+			if assert.NotNil(t, envelope.Error.Param) {
+				assert.Equal(t, tt.param, *envelope.Error.Param)
+			}
+		})
+	}
+}
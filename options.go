@@ -0,0 +1,372 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/VA7DBI/whisperAPI/audio"
+	"github.com/VA7DBI/whisperAPI/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Allowed ranges for per-request decoding options
+	MinTemperature      = 0.0
+	MaxTemperature      = 1.0
+	MaxSegmentLength    = 1000
+	MaxInitialPromptLen = 1024 // Characters
+	MaxVADThreshold     = 60   // dB
+	MaxVADSeconds       = 10
+	MaxSpeakers         = 20
+)
+
+// TranscriptionOptions represents the whisper decoding parameters used for a request.
+type TranscriptionOptions struct {
+	Language         string  `json:"language,omitempty"`
+	Translate        bool    `json:"translate"`
+	InitialPrompt    string  `json:"initial_prompt,omitempty"`
+	Temperature      float32 `json:"temperature"`
+	Threads          uint    `json:"threads"`
+	MaxSegmentLength uint    `json:"max_segment_length,omitempty"`
+	TokenTimestamps  bool    `json:"token_timestamps"`
+
+	// Audio length limit; applied while decoding, not passed to the engine
+	MaxDuration float64 `json:"max_duration_seconds,omitempty"` // 0 = no limit
+	Truncate    bool    `json:"truncate,omitempty"`             // Cut longer audio at the limit instead of rejecting it
+
+	// Channel selection; applied while decoding, not passed to the engine
+	Channels string `json:"channels,omitempty"` // ChannelsSeparate, or empty to mix the channels to mono
+	Channel  *int   `json:"channel,omitempty"`  // Transcribe only this zero-based channel
+
+	// Voice activity detection; applied before the engine, not passed to it
+	VAD           bool    `json:"vad"`
+	VADThreshold  float64 `json:"vad_threshold,omitempty"`   // Energy above the noise floor, in dB, that can be speech
+	VADFlatness   float64 `json:"vad_flatness,omitempty"`    // Spectral flatness above which loud audio is noise
+	VADMinSpeech  float64 `json:"vad_min_speech,omitempty"`  // Seconds
+	VADMinSilence float64 `json:"vad_min_silence,omitempty"` // Seconds
+	VADPadding    float64 `json:"vad_padding,omitempty"`     // Seconds
+
+	Preprocess string `json:"preprocess,omitempty"` // Profile, comma-separated stages or "none"
+
+	// Candidate languages for language=auto; empty allows any the model knows
+	Languages []string `json:"languages,omitempty"`
+
+	// Speaker labels; applied to the segments the engine returns
+	Diarize     bool `json:"diarize"`
+	MaxSpeakers int  `json:"max_speakers,omitempty"` // 0 = no limit
+}
+
+// vadOptions returns the detector settings, with defaults for any not set.
+func (o TranscriptionOptions) vadOptions() audio.VADOptions {
+	vad := audio.DefaultVADOptions
+	for _, v := range []struct {
+		value float64
+		field *float64
+	}{
+		{o.VADThreshold, &vad.Threshold},
+		{o.VADFlatness, &vad.MaxFlatness},
+		{o.VADMinSpeech, &vad.MinSpeech},
+		{o.VADMinSilence, &vad.MinSilence},
+		{o.VADPadding, &vad.Padding},
+	} {
+		if v.value > 0 {
+			*v.field = v.value
+		}
+	}
+	return vad
+}
+
+// diarizeOptions returns the speaker clustering settings for opts.
+func (s *TranscriptionService) diarizeOptions(opts TranscriptionOptions) audio.DiarizeOptions {
+	diarize := audio.DefaultDiarizeOptions
+	if s.config.Diarization.Threshold > 0 {
+		diarize.Threshold = s.config.Diarization.Threshold
+	}
+	diarize.MaxSpeakers = opts.MaxSpeakers
+	return diarize
+}
+
+// PreprocessNone turns off the configured preprocessing profile.
+const PreprocessNone = "none"
+
+// preprocessStages resolves the preprocess option: a profile from the
+// configuration, a comma-separated list of stages or "none".
+func (s *TranscriptionService) preprocessStages(name string) ([]string, error) {
+	if name == "" || strings.EqualFold(name, PreprocessNone) {
+		return nil, nil
+	}
+	if stages, ok := s.config.Audio.Preprocess.Profiles[name]; ok {
+		return audio.ParseStages(stages)
+	}
+	stages, err := audio.ParseStages(strings.Split(name, ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid preprocess value %q: not a profile, and %v", name, err)
+	}
+	return stages, nil
+}
+
+// preprocessOptions returns the configured settings of the preprocessing
+// stages, with defaults for any not set.
+func (s *TranscriptionService) preprocessOptions() audio.PreprocessOptions {
+	opts := audio.DefaultPreprocessOptions
+	cfg := s.config.Audio.Preprocess
+	if cfg.HighPassHz > 0 {
+		opts.HighPassHz = cfg.HighPassHz
+	}
+	if cfg.Deemphasis > 0 {
+		opts.Deemphasis = cfg.Deemphasis
+	}
+	if cfg.NoiseReduction > 0 {
+		opts.NoiseReduction = cfg.NoiseReduction
+	}
+	if cfg.LoudnessTarget != 0 {
+		opts.LoudnessTarget = cfg.LoudnessTarget
+	}
+	return opts
+}
+
+// Channel modes for the channels form field
+const (
+	ChannelsMix      = "mix"
+	ChannelsSeparate = "separate" // Transcribe each channel on its own and merge the segments
+)
+
+// maxThreads returns the configured thread limit, falling back to the CPU count.
+func (s *TranscriptionService) maxThreads() int {
+	if s.config.Whisper.MaxThreads > 0 {
+		return s.config.Whisper.MaxThreads
+	}
+	return runtime.NumCPU()
+}
+
+// defaultOptions returns the server-wide decoding options from the configuration.
+func (s *TranscriptionService) defaultOptions() TranscriptionOptions {
+	threads := s.config.Whisper.Threads
+	if threads <= 0 || threads > s.maxThreads() {
+		threads = s.maxThreads()
+	}
+
+	return TranscriptionOptions{
+		Language:         s.config.Whisper.Language,
+		Translate:        s.config.Whisper.Translate,
+		InitialPrompt:    s.config.Whisper.InitialPrompt,
+		Temperature:      s.config.Whisper.Temperature,
+		Threads:          uint(threads),
+		MaxSegmentLength: uint(s.config.Whisper.MaxSegmentLength),
+		TokenTimestamps:  s.config.Whisper.TokenTimestamps,
+		MaxDuration:      float64(s.config.Audio.MaxDuration),
+		Truncate:         s.config.Audio.MaxDurationMode == "truncate",
+		VAD:              s.config.Audio.VAD.Enabled,
+		VADThreshold:     s.config.Audio.VAD.Threshold,
+		VADFlatness:      s.config.Audio.VAD.MaxFlatness,
+		VADMinSpeech:     s.config.Audio.VAD.MinSpeech,
+		VADMinSilence:    s.config.Audio.VAD.MinSilence,
+		VADPadding:       s.config.Audio.VAD.Padding,
+		Preprocess:       s.config.Audio.Preprocess.Profile,
+		Languages:        s.config.Whisper.Languages,
+		Diarize:          s.config.Diarization.Enabled,
+		MaxSpeakers:      s.config.Diarization.MaxSpeakers,
+	}
+}
+
+// maxDuration returns the longest audio the caller may submit, in seconds: the
+// limit configured for its API token if there is one, otherwise
+// max_duration_seconds. 0 means no limit.
+func (s *TranscriptionService) maxDuration(c *gin.Context) float64 {
+	if limit, ok := s.config.Audio.TokenMaxDuration[middleware.TokenFromContext(c)]; ok {
+		return float64(limit)
+	}
+	return float64(s.config.Audio.MaxDuration)
+}
+
+// parseOptions reads the optional decoding form fields of a request on top of the
+// configured defaults, rejecting values outside the allowed ranges.
+func (s *TranscriptionService) parseOptions(c *gin.Context) (TranscriptionOptions, error) {
+	opts, err := s.parseOptionValues(c.GetPostForm)
+	opts.MaxDuration = s.maxDuration(c)
+	if err != nil {
+		return opts, err
+	}
+	if err := parseVADOptions(c.GetPostForm, &opts); err != nil {
+		return opts, err
+	}
+	if v, ok := c.GetPostForm("preprocess"); ok && v != "" {
+		if _, err := s.preprocessStages(v); err != nil {
+			return opts, err
+		}
+		opts.Preprocess = v
+	}
+	if v, ok := c.GetPostForm("languages"); ok && v != "" {
+		if opts.Language != "auto" {
+			return opts, fmt.Errorf("languages only applies with language=auto")
+		}
+		languages, err := parseLanguages(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.Languages = languages
+	}
+	if v, ok := c.GetPostForm("diarize"); ok && v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid diarize value %q: expected true or false", v)
+		}
+		opts.Diarize = b
+	}
+	if v, ok := c.GetPostForm("max_speakers"); ok && v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxSpeakers {
+			return opts, fmt.Errorf("invalid max_speakers %q: must be between 1 and %d", v, MaxSpeakers)
+		}
+		if !opts.Diarize {
+			return opts, fmt.Errorf("max_speakers only applies with diarize=true")
+		}
+		opts.MaxSpeakers = n
+	}
+	return opts, parseChannelOptions(c.GetPostForm, &opts)
+}
+
+// parseVADOptions reads the voice activity detection fields, which only apply
+// to whole files: /stream decodes a sliding window instead.
+func parseVADOptions(get func(key string) (string, bool), opts *TranscriptionOptions) error {
+	if v, ok := get("vad"); ok && v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid vad value %q: expected true or false", v)
+		}
+		opts.VAD = b
+	}
+
+	for _, f := range []struct {
+		key      string
+		min, max float64
+		field    *float64
+	}{
+		{"vad_threshold", 0, MaxVADThreshold, &opts.VADThreshold},
+		{"vad_flatness", 0, 1, &opts.VADFlatness},
+		{"vad_min_speech", 0, MaxVADSeconds, &opts.VADMinSpeech},
+		{"vad_min_silence", 0, MaxVADSeconds, &opts.VADMinSilence},
+		{"vad_padding", 0, MaxVADSeconds, &opts.VADPadding},
+	} {
+		if v, ok := get(f.key); ok && v != "" {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil || n <= f.min || n > f.max {
+				return fmt.Errorf("invalid %s %q: must be above %g and at most %g", f.key, v, f.min, f.max)
+			}
+			*f.field = n
+		}
+	}
+	return nil
+}
+
+// parseChannelOptions reads the channels and channel fields, which only apply
+// to uploaded files: on /stream, channels is the channel count of the PCM.
+func parseChannelOptions(get func(key string) (string, bool), opts *TranscriptionOptions) error {
+	if v, ok := get("channels"); ok && v != "" {
+		switch mode := strings.ToLower(strings.TrimSpace(v)); mode {
+		case ChannelsMix:
+			opts.Channels = ""
+		case ChannelsSeparate:
+			opts.Channels = mode
+		default:
+			return fmt.Errorf("invalid channels value %q: expected %q or %q", v, ChannelsMix, ChannelsSeparate)
+		}
+	}
+
+	if v, ok := get("channel"); ok && v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid channel %q: expected a channel number starting at 0", v)
+		}
+		if opts.Channels == ChannelsSeparate {
+			return fmt.Errorf("channel and channels=separate cannot be combined")
+		}
+		opts.Channel = &n
+	}
+	return nil
+}
+
+// parseOptionValues is parseOptions for fields looked up with get, such as the
+// query parameters of a WebSocket handshake.
+func (s *TranscriptionService) parseOptionValues(get func(key string) (string, bool)) (TranscriptionOptions, error) {
+	opts := s.defaultOptions()
+
+	if v, ok := get("language"); ok && v != "" {
+		lang := strings.ToLower(strings.TrimSpace(v))
+		if lang != "auto" && !isWhisperLanguage(lang) {
+			return opts, fmt.Errorf("invalid language %q: expected an ISO 639-1 code or \"auto\"", v)
+		}
+		opts.Language = lang
+	}
+
+	if v, ok := get("translate"); ok && v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid translate value %q: expected true or false", v)
+		}
+		opts.Translate = b
+	}
+
+	if v, ok := get("initial_prompt"); ok {
+		if utf8.RuneCountInString(v) > MaxInitialPromptLen {
+			return opts, fmt.Errorf("initial_prompt too long: maximum is %d characters", MaxInitialPromptLen)
+		}
+		opts.InitialPrompt = v
+	}
+
+	if v, ok := get("temperature"); ok && v != "" {
+		t, err := strconv.ParseFloat(v, 32)
+		if err != nil || t < MinTemperature || t > MaxTemperature {
+			return opts, fmt.Errorf("invalid temperature %q: must be between %.1f and %.1f", v, MinTemperature, MaxTemperature)
+		}
+		opts.Temperature = float32(t)
+	}
+
+	// The Go bindings create greedy-sampling contexts and can select neither
+	// beam search nor the number of greedy candidates, so these would be ignored
+	for _, field := range []string{"beam_size", "best_of"} {
+		if v, ok := get(field); ok && v != "" {
+			return opts, fmt.Errorf("%s is not supported: the whisper.cpp Go bindings only decode greedily", field)
+		}
+	}
+
+	if v, ok := get("threads"); ok && v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > s.maxThreads() {
+			return opts, fmt.Errorf("invalid threads %q: must be between 1 and %d", v, s.maxThreads())
+		}
+		opts.Threads = uint(n)
+	}
+
+	if v, ok := get("max_segment_length"); ok && v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > MaxSegmentLength {
+			return opts, fmt.Errorf("invalid max_segment_length %q: must be between 0 and %d", v, MaxSegmentLength)
+		}
+		opts.MaxSegmentLength = uint(n)
+	}
+
+	if v, ok := get("token_timestamps"); ok && v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid token_timestamps value %q: expected true or false", v)
+		}
+		opts.TokenTimestamps = b
+	}
+
+	if v, ok := get("truncate"); ok && v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid truncate value %q: expected true or false", v)
+		}
+		opts.Truncate = b
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/VA7DBI/whisperAPI/audio"
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newOptionsTestService() *TranscriptionService {
+	cfg := &config.Config{}
+	cfg.Whisper.Language = "en"
+	cfg.Whisper.Threads = 2
+	cfg.Whisper.MaxThreads = 4
+	return &TranscriptionService{config: cfg}
+}
+
+func newFormContext(form url.Values) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	req := httptest.NewRequest("POST", "/transcribe", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.Request = req
+	return c
+}
+
+func TestParseOptions_Defaults(t *testing.T) {
+	s := newOptionsTestService()
+
+	opts, err := s.parseOptions(newFormContext(url.Values{}))
+	assert.NoError(t, err)
+	assert.Equal(t, "en", opts.Language)
+	assert.Equal(t, uint(2), opts.Threads)
+	assert.False(t, opts.Translate)
+}
+
+func TestParseOptions_Overrides(t *testing.T) {
+	s := newOptionsTestService()
+
+	opts, err := s.parseOptions(newFormContext(url.Values{
+		"language":           {"FR"},
+		"translate":          {"true"},
+		"initial_prompt":     {"Radio traffic"},
+		"temperature":        {"0.4"},
+		"threads":            {"4"},
+		"max_segment_length": {"42"},
+		"token_timestamps":   {"1"},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "fr", opts.Language)
+	assert.True(t, opts.Translate)
+	assert.Equal(t, "Radio traffic", opts.InitialPrompt)
+	assert.InDelta(t, 0.4, opts.Temperature, 1e-6)
+	assert.Equal(t, uint(4), opts.Threads)
+	assert.Equal(t, uint(42), opts.MaxSegmentLength)
+	assert.True(t, opts.TokenTimestamps)
+}
+
+func TestParseOptions_InitialPromptCharacters(t *testing.T) {
+	s := newOptionsTestService()
+
+	// The limit counts characters, not bytes
+	prompt := strings.Repeat("é", MaxInitialPromptLen)
+	opts, err := s.parseOptions(newFormContext(url.Values{"initial_prompt": {prompt}}))
+	assert.NoError(t, err)
+	assert.Equal(t, prompt, opts.InitialPrompt)
+}
+
+func TestParseOptions_RejectsOutOfRange(t *testing.T) {
+	s := newOptionsTestService()
+
+	tests := []struct {
+		field string
+		value string
+	}{
+		{"language", "english"},
+		{"language", "xx"},
+		{"language", `en"`},
+		{"translate", "maybe"},
+		{"temperature", "1.5"},
+		{"temperature", "-0.1"},
+		{"beam_size", "5"},
+		{"best_of", "5"},
+		{"threads", "5"},
+		{"threads", "abc"},
+		{"max_segment_length", "-1"},
+		{"token_timestamps", "yes please"},
+		{"initial_prompt", strings.Repeat("a", MaxInitialPromptLen+1)},
+		{"initial_prompt", strings.Repeat("é", MaxInitialPromptLen+1)},
+		{"channels", "2"},
+		{"channel", "-1"},
+		{"channel", "left"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field+"="+tt.value[:min(len(tt.value), 10)], func(t *testing.T) {
+			_, err := s.parseOptions(newFormContext(url.Values{tt.field: {tt.value}}))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseOptions_Channels(t *testing.T) {
+	s := newOptionsTestService()
+
+	opts, err := s.parseOptions(newFormContext(url.Values{"channels": {"Separate"}}))
+	assert.NoError(t, err)
+	assert.Equal(t, ChannelsSeparate, opts.Channels)
+	assert.Nil(t, opts.Channel)
+
+	opts, err = s.parseOptions(newFormContext(url.Values{"channels": {"mix"}, "channel": {"1"}}))
+	assert.NoError(t, err)
+	assert.Empty(t, opts.Channels)
+	if assert.NotNil(t, opts.Channel) {
+		assert.Equal(t, 1, *opts.Channel)
+	}
+
+	_, err = s.parseOptions(newFormContext(url.Values{"channels": {"separate"}, "channel": {"0"}}))
+	assert.ErrorContains(t, err, "cannot be combined")
+
+	// Only uploads take them: on /stream, channels is the PCM channel count
+	query := url.Values{"channels": {"2"}, "channel": {"1"}}
+	opts, err = s.parseOptionValues(func(key string) (string, bool) { return query.Get(key), query.Has(key) })
+	assert.NoError(t, err)
+	assert.Empty(t, opts.Channels)
+	assert.Nil(t, opts.Channel)
+}
+
+func TestParseOptions_Preprocess(t *testing.T) {
+	s := newOptionsTestService()
+	s.config.Audio.Preprocess.Profiles = map[string][]string{"fm": {"highpass", "deemphasis"}}
+
+	for _, value := range []string{"fm", "none", "Loudnorm, denoise"} {
+		opts, err := s.parseOptions(newFormContext(url.Values{"preprocess": {value}}))
+		assert.NoError(t, err)
+		assert.Equal(t, value, opts.Preprocess)
+	}
+	stages, err := s.preprocessStages("Loudnorm, denoise")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"loudnorm", "denoise"}, stages)
+	stages, err = s.preprocessStages("fm")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"highpass", "deemphasis"}, stages)
+
+	_, err = s.parseOptions(newFormContext(url.Values{"preprocess": {"am"}}))
+	assert.ErrorContains(t, err, "invalid preprocess")
+}
+
+func TestParseOptions_Languages(t *testing.T) {
+	s := newOptionsTestService()
+	s.config.Whisper.Languages = []string{"en", "fr"}
+
+	// The configured candidates apply unless a request names its own
+	opts, err := s.parseOptions(newFormContext(url.Values{"language": {"auto"}}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"en", "fr"}, opts.Languages)
+	opts, err = s.parseOptions(newFormContext(url.Values{"language": {"auto"}, "languages": {"DE, nl,de"}}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"de", "nl"}, opts.Languages)
+
+	_, err = s.parseOptions(newFormContext(url.Values{"language": {"auto"}, "languages": {"de,xx"}}))
+	assert.ErrorContains(t, err, "not a whisper language")
+	_, err = s.parseOptions(newFormContext(url.Values{"language": {"auto"}, "languages": {" , "}}))
+	assert.ErrorContains(t, err, "invalid languages")
+	_, err = s.parseOptions(newFormContext(url.Values{"languages": {"de"}}))
+	assert.ErrorContains(t, err, "language=auto")
+}
+
+func TestParseOptions_Diarize(t *testing.T) {
+	s := newOptionsTestService()
+	s.config.Diarization.Threshold = 0.7
+
+	opts, err := s.parseOptions(newFormContext(url.Values{"diarize": {"true"}, "max_speakers": {"2"}}))
+	assert.NoError(t, err)
+	assert.True(t, opts.Diarize)
+	assert.Equal(t, audio.DiarizeOptions{MaxSpeakers: 2, Threshold: 0.7}, s.diarizeOptions(opts))
+
+	_, err = s.parseOptions(newFormContext(url.Values{"diarize": {"true"}, "max_speakers": {"21"}}))
+	assert.ErrorContains(t, err, "invalid max_speakers")
+	_, err = s.parseOptions(newFormContext(url.Values{"max_speakers": {"2"}}))
+	assert.ErrorContains(t, err, "diarize=true")
+}
+
+func TestParseOptions_VAD(t *testing.T) {
+	s := newOptionsTestService()
+
+	opts, err := s.parseOptions(newFormContext(url.Values{"vad": {"true"}, "vad_threshold": {"15"}, "vad_padding": {"0.1"}}))
+	assert.NoError(t, err)
+	assert.True(t, opts.VAD)
+	vad := opts.vadOptions()
+	assert.Equal(t, 15.0, vad.Threshold)
+	assert.Equal(t, 0.1, vad.Padding)
+	assert.Equal(t, audio.DefaultVADOptions.MinSilence, vad.MinSilence)
+
+	for _, values := range []url.Values{
+		{"vad": {"sometimes"}},
+		{"vad_threshold": {"0"}},
+		{"vad_threshold": {"61"}},
+		{"vad_flatness": {"1.5"}},
+		{"vad_min_speech": {"-1"}},
+		{"vad_min_silence": {"11"}},
+		{"vad_padding": {"abc"}},
+	} {
+		_, err := s.parseOptions(newFormContext(values))
+		assert.Error(t, err, "%v", values)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/VA7DBI/whisperAPI/metrics"
+)
+
+var (
+	// ErrQueueFull is returned when every slot is busy and the wait queue is full.
+	ErrQueueFull = errors.New("transcription queue is full")
+	// ErrQueueTimeout is returned when no slot became free within the maximum wait.
+	ErrQueueTimeout = errors.New("timed out waiting for a transcription slot")
+)
+
+// Weight of the newest observation in the moving averages used for Retry-After.
+const rtfSmoothing = 0.2
+
+// ModelPool bounds concurrent inference to a fixed number of slots. Each slot
+// owns its own engine, since a whisper model cannot run two decodes at once.
+type ModelPool struct {
+	idle      chan Engine
+	engines   []Engine
+	queueSize int
+	maxWait   time.Duration
+
+	mu            sync.Mutex
+	waiting       int     // Callers waiting within the queue size
+	background    int     // Callers waiting without a queue limit
+	avgRTF        float64 // Processing time / audio duration
+	avgAudio      float64 // Audio duration in seconds
+	haveEstimates bool
+}
+
+type unboundedKey struct{}
+
+// withoutQueueLimit marks a context whose caller waits for a slot however long
+// it takes, without counting against the queue size. Job workers use it, since
+// jobs already wait in their own queue.
+func withoutQueueLimit(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unboundedKey{}, true)
+}
+
+// NewModelPool creates a pool with one slot per engine.
+func NewModelPool(engines []Engine, queueSize int, maxWait time.Duration) *ModelPool {
+	p := &ModelPool{
+		idle:      make(chan Engine, len(engines)),
+		engines:   engines,
+		queueSize: queueSize,
+		maxWait:   maxWait,
+	}
+	for _, engine := range engines {
+		p.idle <- engine
+	}
+	return p
+}
+
+// Acquire waits for a free slot and returns its engine. It fails immediately with
+// ErrQueueFull when the wait queue is full, and with ErrQueueTimeout once the
+// maximum wait has passed.
+func (p *ModelPool) Acquire(ctx context.Context) (Engine, error) {
+	// Fast path: a slot is free
+	if engine, ok := p.TryAcquire(); ok {
+		return engine, nil
+	}
+
+	unbounded, _ := ctx.Value(unboundedKey{}).(bool)
+
+	// Background callers are counted apart, so that they never take the
+	// places of interactive requests in the queue or lengthen Retry-After
+	counter := &p.waiting
+	if unbounded {
+		counter = &p.background
+	}
+	p.mu.Lock()
+	if !unbounded && p.waiting >= p.queueSize {
+		p.mu.Unlock()
+		metrics.QueueRejections.WithLabelValues("full").Inc()
+		return nil, ErrQueueFull
+	}
+	*counter++
+	metrics.QueueDepth.Set(float64(p.waiting))
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		*counter--
+		metrics.QueueDepth.Set(float64(p.waiting))
+		p.mu.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if !unbounded && p.maxWait > 0 {
+		timer := time.NewTimer(p.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	start := time.Now()
+	select {
+	case engine := <-p.idle:
+		metrics.QueueWait.Observe(time.Since(start).Seconds())
+		metrics.InferenceInFlight.Inc()
+		return engine, nil
+	case <-timeout:
+		metrics.QueueRejections.WithLabelValues("timeout").Inc()
+		return nil, ErrQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TryAcquire returns the engine of a free slot, or false at once if every
+// slot is busy.
+func (p *ModelPool) TryAcquire() (Engine, bool) {
+	select {
+	case engine := <-p.idle:
+		metrics.QueueWait.Observe(0)
+		metrics.InferenceInFlight.Inc()
+		return engine, true
+	default:
+		return nil, false
+	}
+}
+
+// Release returns a slot to the pool. audioSeconds and processingSeconds update
+// the real-time factor estimate; pass zero for either if the run did not complete.
+func (p *ModelPool) Release(engine Engine, audioSeconds, processingSeconds float64) {
+	if audioSeconds > 0 && processingSeconds > 0 {
+		p.mu.Lock()
+		rtf := processingSeconds / audioSeconds
+		if p.haveEstimates {
+			p.avgRTF += rtfSmoothing * (rtf - p.avgRTF)
+			p.avgAudio += rtfSmoothing * (audioSeconds - p.avgAudio)
+		} else {
+			p.avgRTF = rtf
+			p.avgAudio = audioSeconds
+			p.haveEstimates = true
+		}
+		p.mu.Unlock()
+	}
+
+	metrics.InferenceInFlight.Dec()
+	p.idle <- engine
+}
+
+// RetryAfter estimates how many seconds a rejected client should wait: the
+// queued requests spread over the slots, each taking the average audio duration
+// times the average real-time factor. Without history the maximum wait is used.
+func (p *ModelPool) RetryAfter() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.haveEstimates {
+		return int(math.Max(1, math.Ceil(p.maxWait.Seconds())))
+	}
+
+	perRequest := p.avgAudio * p.avgRTF
+	seconds := float64(p.waiting+1) * perRequest / float64(len(p.engines))
+	return int(math.Max(1, math.Ceil(seconds)))
+}
+
+// Close releases all engines in the pool.
+func (p *ModelPool) Close() {
+	for _, engine := range p.engines {
+		engine.Close()
+	}
+}
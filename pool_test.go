@@ -0,0 +1,184 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func waitForWaiting(t *testing.T, p *ModelPool, n int) {
+	waitForCount(t, p, &p.waiting, n)
+}
+
+// waitForCount waits until one of the pool's counters, read under its lock,
+// reaches n.
+func waitForCount(t *testing.T, p *ModelPool, count *int, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		p.mu.Lock()
+		waiting := *count
+		p.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests waiting, want %d", waiting, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestModelPool_QueueFull(t *testing.T) {
+	engine := NewFakeEngine(nil)
+	p := NewModelPool([]Engine{engine}, 1, time.Minute)
+
+	held, err := p.Acquire(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Engine(engine), held)
+
+	// One request may wait; the next is rejected immediately
+	acquired := make(chan Engine)
+	go func() {
+		m, _ := p.Acquire(context.Background())
+		acquired <- m
+	}()
+	waitForWaiting(t, p, 1)
+
+	_, err = p.Acquire(context.Background())
+	assert.Equal(t, ErrQueueFull, err)
+
+	p.Release(held, 0, 0)
+	assert.Equal(t, Engine(engine), <-acquired)
+	p.Release(engine, 0, 0)
+
+	p.Close()
+	assert.True(t, engine.closed)
+}
+
+func TestModelPool_Timeout(t *testing.T) {
+	p := NewModelPool([]Engine{NewFakeEngine(nil)}, 5, 20*time.Millisecond)
+	held, _ := p.Acquire(context.Background())
+	defer p.Release(held, 0, 0)
+
+	start := time.Now()
+	_, err := p.Acquire(context.Background())
+	assert.Equal(t, ErrQueueTimeout, err)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// A cancelled caller stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = p.Acquire(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestModelPool_WithoutQueueLimit(t *testing.T) {
+	p := NewModelPool([]Engine{NewFakeEngine(nil)}, 0, time.Millisecond)
+	held, _ := p.Acquire(context.Background())
+
+	_, err := p.Acquire(context.Background())
+	assert.Equal(t, ErrQueueFull, err)
+
+	// Background callers wait past the queue size and the maximum wait
+	acquired := make(chan error)
+	go func() {
+		_, err := p.Acquire(withoutQueueLimit(context.Background()))
+		acquired <- err
+	}()
+	waitForCount(t, p, &p.background, 1)
+	time.Sleep(5 * time.Millisecond)
+
+	p.Release(held, 0, 0)
+	assert.NoError(t, <-acquired)
+}
+
+func TestModelPool_BackgroundDoesNotFillQueue(t *testing.T) {
+	p := NewModelPool([]Engine{NewFakeEngine(nil)}, 1, time.Minute)
+	held, _ := p.Acquire(context.Background())
+	p.Release(held, 20, 10)
+	held, _ = p.Acquire(context.Background())
+
+	// More background callers than the queue holds, each done at once
+	background := make(chan error)
+	for i := 0; i < 3; i++ {
+		go func() {
+			engine, err := p.Acquire(withoutQueueLimit(context.Background()))
+			if err == nil {
+				p.Release(engine, 0, 0)
+			}
+			background <- err
+		}()
+	}
+	waitForCount(t, p, &p.background, 3)
+	// They are not counted as queued, nor in the wait estimate: 10s for the
+	// request in progress
+	assert.Zero(t, p.waiting)
+	assert.Equal(t, 10, p.RetryAfter())
+
+	// An interactive request still queues and gets a slot
+	acquired := make(chan error)
+	go func() {
+		engine, err := p.Acquire(context.Background())
+		if err == nil {
+			p.Release(engine, 0, 0)
+		}
+		acquired <- err
+	}()
+	waitForWaiting(t, p, 1)
+
+	p.Release(held, 0, 0)
+	assert.NoError(t, <-acquired)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, <-background)
+	}
+}
+
+func TestModelPool_RetryAfter(t *testing.T) {
+	engines := []Engine{NewFakeEngine(nil), NewFakeEngine(nil)}
+	p := NewModelPool(engines, 10, 30*time.Second)
+
+	// Without history the maximum wait is suggested
+	assert.Equal(t, 30, p.RetryAfter())
+
+	// 20s of audio took 10s: real-time factor 0.5, 10s per request over 2 slots
+	a, _ := p.Acquire(context.Background())
+	b, _ := p.Acquire(context.Background())
+	p.Release(a, 20, 10)
+	assert.Equal(t, 5, p.RetryAfter())
+
+	a, _ = p.Acquire(context.Background())
+	for i := 0; i < 3; i++ {
+		go p.Acquire(context.Background())
+	}
+	waitForWaiting(t, p, 3)
+	assert.Equal(t, 20, p.RetryAfter())
+
+	// Incomplete runs do not affect the estimate
+	p.Release(a, 0, 0)
+	p.Release(b, 20, 0)
+	waitForWaiting(t, p, 1)
+	assert.Equal(t, 10, p.RetryAfter())
+}
+
+func TestPoolError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &TranscriptionService{pool: NewModelPool([]Engine{NewFakeEngine(nil)}, 1, 45*time.Second)}
+
+	err := s.poolError(ErrQueueFull)
+	assert.Equal(t, http.StatusTooManyRequests, errorStatus(err))
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	setRetryAfter(c, err)
+	assert.Equal(t, "45", c.Writer.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusServiceUnavailable, errorStatus(s.poolError(ErrQueueTimeout)))
+	assert.Equal(t, http.StatusInternalServerError, errorStatus(s.poolError(context.Canceled)))
+}
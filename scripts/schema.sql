@@ -50,3 +50,36 @@ $$ LANGUAGE plpgsql;
 --     AFTER INSERT ON token_usage
 --     FOR EACH ROW
 --     EXECUTE FUNCTION update_token_last_used();
+
+-- Asynchronous transcription jobs
+CREATE TABLE transcription_jobs (
+    id VARCHAR(64) PRIMARY KEY,
+    status VARCHAR(16) NOT NULL,
+    progress INTEGER NOT NULL DEFAULT 0,
+    filename TEXT NOT NULL,
+    audio_path TEXT NOT NULL,
+    options JSONB,
+    result JSONB,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    callback_url TEXT,
+    token_hash VARCHAR(64),
+    webhook_status VARCHAR(16)
+);
+
+CREATE INDEX idx_transcription_jobs_status ON transcription_jobs(status);
+
+-- Webhook notifications that exhausted their delivery attempts
+CREATE TABLE webhook_dead_letters (
+    id SERIAL PRIMARY KEY,
+    job_id VARCHAR(64) NOT NULL REFERENCES transcription_jobs(id),
+    url TEXT NOT NULL,
+    payload JSONB,
+    attempts INTEGER NOT NULL,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhook_dead_letters_job_id ON webhook_dead_letters(job_id);
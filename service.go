@@ -4,13 +4,18 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,11 +24,7 @@ import (
 	"github.com/VA7DBI/whisperAPI/audio"
 	"github.com/VA7DBI/whisperAPI/config"
 	"github.com/VA7DBI/whisperAPI/metrics"
-	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
 	"github.com/gin-gonic/gin"
-	"github.com/go-audio/wav"
-	"github.com/jfreymuth/oggvorbis"
-	"github.com/pion/opus" // Replace hraban/opus with pion/opus
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -33,21 +34,15 @@ const (
 	NanosecondsPerSecond = 1_000_000_000
 )
 
-// OGG format detection patterns
-var (
-	oggCapturePattern = []byte("OggS")
-	vorbisHeader      = []byte("vorbis")
-	opusHeader        = []byte("OpusHead")
-)
-
-// TranscriptionService encapsulates the whisper model and configuration.
+// TranscriptionService encapsulates the engine pool and configuration.
 type TranscriptionService struct {
-	model  whisper.Model
+	pool   *ModelPool
 	config *config.Config
 }
 
 // TokenInfo represents token information.
 type TokenInfo struct {
+	ID          int     `json:"id"`
 	Text        string  `json:"text"`
 	Probability float64 `json:"probability"`
 	StartTime   float64 `json:"start_time"`
@@ -56,28 +51,63 @@ type TokenInfo struct {
 
 // SegmentInfo represents segment information.
 type SegmentInfo struct {
-	Text      string      `json:"text"`
-	Tokens    []TokenInfo `json:"tokens"`
-	StartTime float64     `json:"start_time"`
-	EndTime   float64     `json:"end_time"`
+	Text         string      `json:"text"`
+	Tokens       []TokenInfo `json:"tokens"`
+	StartTime    float64     `json:"start_time"`
+	EndTime      float64     `json:"end_time"`
+	Channel      *int        `json:"channel,omitempty"`       // Zero-based audio channel, when transcribed by channel
+	ChannelLabel string      `json:"channel_label,omitempty"` // "left", "right" or "channel N"
+	Speaker      string      `json:"speaker,omitempty"`       // "SPEAKER_00", "SPEAKER_01"... when diarized
+	SpeakerTurn  bool        `json:"speaker_turn,omitempty"`  // The model heard the speaker change after this segment
+}
+
+// channelLabel names a channel of audio with the given number of channels.
+func channelLabel(channel, channels int) string {
+	if channels == 2 {
+		return [2]string{"left", "right"}[channel]
+	}
+	return fmt.Sprintf("channel %d", channel)
 }
 
 // TranscriptionResponse represents the transcription response.
 type TranscriptionResponse struct {
-	Text           string              `json:"text"`
-	Segments       []SegmentInfo       `json:"segments"`
-	Duration       float64             `json:"duration_seconds"`
-	ProcessingTime float64             `json:"processing_time_seconds"`
-	Confidence     float64             `json:"confidence"`
-	MemoryUsage    MemStats            `json:"memory_usage"`
-	AudioInfo      audio.AudioMetadata `json:"audio_info"` // Updated to use audio package type
-	Timestamp      time.Time           `json:"timestamp"`
-	ComputeTime    struct {
+	Text                string               `json:"text"`
+	Segments            []SegmentInfo        `json:"segments"`
+	Duration            float64              `json:"duration_seconds"`
+	ProcessingTime      float64              `json:"processing_time_seconds"`
+	Confidence          float64              `json:"confidence"`
+	MemoryUsage         MemStats             `json:"memory_usage"`
+	AudioInfo           audio.AudioMetadata  `json:"audio_info"` // Updated to use audio package type
+	Options             TranscriptionOptions `json:"options"`
+	Truncated           bool                 `json:"truncated,omitempty"`            // Audio past the maximum duration was not transcribed
+	VAD                 *VADReport           `json:"vad,omitempty"`                  // Present when voice activity detection ran
+	Chunks              int                  `json:"chunks,omitempty"`               // Pieces long audio was split into and decoded in parallel
+	Preprocess          []string             `json:"preprocess,omitempty"`           // Stages applied to the audio before transcription, in order
+	DetectedLanguage    string               `json:"detected_language,omitempty"`    // Language identified with language=auto, and transcribed
+	LanguageProbability float64              `json:"language_probability,omitempty"` // How likely the detected language is
+	Diarization         *DiarizationReport   `json:"diarization,omitempty"`          // Present when segments were labelled with speakers
+	Timestamp           time.Time            `json:"timestamp"`
+	ComputeTime         struct {
 		CPUTime float64 `json:"cpu_time_seconds"`
 		GPUTime float64 `json:"gpu_time_seconds,omitempty"`
 	} `json:"compute_time"`
 }
 
+// VADReport describes the speech voice activity detection found, summed over
+// the channels transcribed.
+type VADReport struct {
+	SpeechDuration float64 `json:"speech_duration_seconds"`
+	TotalDuration  float64 `json:"total_duration_seconds"`
+	Regions        int     `json:"regions"`
+}
+
+// DiarizationReport describes the speakers diarization told apart, over all
+// the channels transcribed.
+type DiarizationReport struct {
+	Method   string `json:"method"` // DiarizeTurns or DiarizeClustering
+	Speakers int    `json:"speakers"`
+}
+
 // MemStats represents memory statistics.
 type MemStats struct {
 	AllocatedMB   float64 `json:"allocated_mb"`
@@ -92,24 +122,48 @@ type MemStats struct {
 // ErrorResponse represents an API error response.
 type ErrorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"` // Machine-readable reason, such as "audio_too_long"
+
+	// Set with code audio_too_long; the duration only when the header records it
+	DurationSeconds    float64 `json:"duration_seconds,omitempty"`
+	MaxDurationSeconds float64 `json:"max_duration_seconds,omitempty"`
 }
 
 // NewTranscriptionService creates a new transcription service.
+// Each pool slot gets its own engine, as selected by whisper.engine.
 func NewTranscriptionService(cfg *config.Config) (*TranscriptionService, error) {
-	model, err := whisper.New(cfg.Whisper.ModelPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load whisper model: %v", err)
+	size := cfg.Pool.Size
+	if size < 1 {
+		size = 1
+	}
+
+	engines := make([]Engine, 0, size)
+	for i := 0; i < size; i++ {
+		engine, err := NewEngine(cfg)
+		if err != nil {
+			for _, loaded := range engines {
+				loaded.Close()
+			}
+			return nil, err
+		}
+		engines = append(engines, engine)
 	}
 
+	return NewTranscriptionServiceWithEngines(cfg, engines), nil
+}
+
+// NewTranscriptionServiceWithEngines creates a transcription service with one pool
+// slot per engine.
+func NewTranscriptionServiceWithEngines(cfg *config.Config, engines []Engine) *TranscriptionService {
 	return &TranscriptionService{
-		model:  model,
+		pool:   NewModelPool(engines, cfg.Pool.QueueSize, time.Duration(cfg.Pool.MaxWait)*time.Second),
 		config: cfg,
-	}, nil
+	}
 }
 
 // Close closes the transcription service.
 func (s *TranscriptionService) Close() {
-	s.model.Close()
+	s.pool.Close()
 }
 
 // TranscribeHandler handles the transcription request.
@@ -117,12 +171,40 @@ func (s *TranscriptionService) Close() {
 // @Description Converts audio file to text using Whisper AI model. Supports WAV, MP3, OGG (Vorbis), and Opus formats.
 // @Tags        transcription
 // @Accept      multipart/form-data
-// @Produce     json
+// @Produce     json,application/x-subrip,text/vtt,application/ttml+xml,text/x-ass,text/event-stream
 // @Param       audio formData file true "Audio file to transcribe (WAV, MP3, OGG Vorbis, or Opus format)"
+// @Param       language formData string false "Spoken language (ISO 639-1 code) or auto to detect"
+// @Param       languages formData string false "Comma-separated candidate language codes for language=auto (default whisper.languages)"
+// @Param       translate formData boolean false "Translate the transcription to English"
+// @Param       initial_prompt formData string false "Initial prompt to guide the decoder"
+// @Param       temperature formData number false "Sampling temperature (0.0-1.0)"
+// @Param       threads formData integer false "Number of decoding threads (up to the configured max_threads)"
+// @Param       max_segment_length formData integer false "Maximum segment length in characters (0 = no limit)"
+// @Param       token_timestamps formData boolean false "Compute per-token timestamps"
+// @Param       truncate formData boolean false "Transcribe only up to the maximum duration instead of rejecting longer audio"
+// @Param       channels formData string false "mix (default) to downmix, or separate to transcribe each channel and merge the segments by time"
+// @Param       channel formData integer false "Transcribe only this channel, numbered from 0"
+// @Param       vad formData boolean false "Transcribe only the speech found by voice activity detection"
+// @Param       vad_threshold formData number false "Energy above the noise floor, in dB, that can be speech (default 10)"
+// @Param       vad_flatness formData number false "Spectral flatness, 0-1, above which loud audio is noise (default 0.45)"
+// @Param       vad_min_speech formData number false "Seconds; shorter bursts are dropped (default 0.25)"
+// @Param       vad_min_silence formData number false "Seconds; shorter pauses do not split speech (default 0.5)"
+// @Param       vad_padding formData number false "Seconds kept either side of speech (default 0.2)"
+// @Param       preprocess formData string false "Preprocessing profile, comma-separated stages (highpass, deemphasis, denoise, loudnorm) or none"
+// @Param       diarize formData boolean false "Label each segment with its speaker (default diarization.enabled)"
+// @Param       max_speakers formData integer false "Most speakers to tell apart, 1-20, with diarize=true (default diarization.max_speakers)"
+// @Param       output query string false "Response format: json, srt, vtt, ttml, ass or sse (alias: format, or use the Accept header)"
+// @Param       max_line_length query integer false "Subtitle characters per line (0 = one line per segment)"
+// @Param       max_lines query integer false "Subtitle lines per cue (0 = no limit)"
+// @Param       min_duration query number false "Minimum subtitle cue duration in seconds"
+// @Param       word_timestamps query boolean false "Emit word-level <c> timing tags in WebVTT output"
 // @Success     200 {object} TranscriptionResponse "Successful transcription with metadata"
-// @Failure     400 {object} ErrorResponse "Invalid request (missing file, file too large)"
+// @Failure     400 {object} ErrorResponse "Invalid request (missing file, file too large, invalid option)"
+// @Failure     413 {object} ErrorResponse "Audio longer than the maximum duration (code audio_too_long)"
 // @Failure     401 {object} ErrorResponse "Unauthorized (invalid or missing API key)"
+// @Failure     429 {object} ErrorResponse "Transcription queue is full, retry after the Retry-After header"
 // @Failure     500 {object} ErrorResponse "Server error during processing"
+// @Failure     503 {object} ErrorResponse "Timed out waiting for a transcription slot"
 // @Security    ApiKeyAuth
 // @Router      /transcribe [post]
 func (s *TranscriptionService) TranscribeHandler(c *gin.Context) {
@@ -134,574 +216,534 @@ func (s *TranscriptionService) TranscribeHandler(c *gin.Context) {
 		return
 	}
 
-	// Check file size
-	if file.Size > s.config.Audio.MaxFileSize*1024*1024 {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error: fmt.Sprintf("File too large. Maximum size is %dMB", s.config.Audio.MaxFileSize),
-		})
-		return
-	}
-
-	format := strings.ToLower(filepath.Ext(file.Filename))
-	timer := prometheus.NewTimer(metrics.TranscriptionDuration.WithLabelValues(format))
-	defer timer.ObserveDuration()
-
-	// Save uploaded file temporarily
-	tmpFile, err := os.CreateTemp("", "audio-*"+file.Filename)
+	// Parse decoding options on top of the configured defaults
+	opts, err := s.parseOptions(c)
 	if err != nil {
-		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save audio file"})
+		metrics.TranscriptionRequests.WithLabelValues("error", uploadFormat(file)).Inc()
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
-	defer os.Remove(tmpFile.Name())
 
-	if err := c.SaveUploadedFile(file, tmpFile.Name()); err != nil {
-		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save audio file"})
+	// Select the response format and subtitle layout
+	output, err := parseOutputFormat(c)
+	if err != nil {
+		metrics.TranscriptionRequests.WithLabelValues("error", uploadFormat(file)).Inc()
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
-
-	// Record start time and memory stats
-	startTime := time.Now()
-	var memStats runtime.MemStats
-	runtime.GC() // Run GC before measuring
-	runtime.ReadMemStats(&memStats)
-	startAlloc := memStats.Alloc
-	startGC := memStats.NumGC
-	startPause := memStats.PauseTotalNs
-
-	// Get audio metadata before processing
-	audioInfo, err := s.getAudioMetadata(tmpFile.Name())
+	subtitleOpts, err := s.parseSubtitleOptions(c)
 	if err != nil {
-		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to get audio metadata: %v", err)})
+		metrics.TranscriptionRequests.WithLabelValues("error", uploadFormat(file)).Inc()
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
-
-	// Process the audio file
-	context, err := s.model.NewContext()
-	if err != nil {
-		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create whisper context"})
+	if output == OutputSSE {
+		s.transcribeEvents(c, file, opts)
 		return
 	}
+	if output != OutputJSON {
+		// Subtitle cues are re-split on token timestamps
+		opts.TokenTimestamps = true
+	}
 
-	// Set up callbacks for collecting segments
-	text := ""
-	var totalProb float64
-	var tokenCount int
-	var segments []SegmentInfo
-
-	// Convert the audio file to samples (implementation needed)
-	samples, err := s.convertAudioToSamples(tmpFile.Name())
+	response, err := s.transcribeUpload(c.Request.Context(), file, opts, nil, nil)
 	if err != nil {
-		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to convert audio: %v", err)})
+		setRetryAfter(c, err)
+		c.JSON(errorStatus(err), errorResponse(err))
 		return
 	}
 
-	// Calculate actual duration from samples
-	duration := float64(len(samples)) / float64(s.config.Audio.SampleRate)
-
-	// Process using callbacks with correct types
-	segmentCallback := func(seg whisper.Segment) {
-		text += seg.Text
-
-		// Create segment info with tokens
-		segInfo := SegmentInfo{
-			Text:      seg.Text,
-			StartTime: durationToSeconds(seg.Start),
-			EndTime:   durationToSeconds(seg.End),
-			Tokens:    make([]TokenInfo, 0, len(seg.Tokens)),
-		}
-
-		// Collect token information
-		for _, token := range seg.Tokens {
-			tokenInfo := TokenInfo{
-				Text:        token.Text,
-				Probability: float64(token.P),
-				StartTime:   durationToSeconds(token.Start),
-				EndTime:     durationToSeconds(token.End),
-			}
-			segInfo.Tokens = append(segInfo.Tokens, tokenInfo)
-
-			totalProb += float64(token.P)
-			tokenCount++
-		}
-
-		segments = append(segments, segInfo)
+	if output == OutputJSON {
+		c.JSON(http.StatusOK, response)
+		return
 	}
 
-	// Track CPU time using rusage only
-	var rusageStart, rusageEnd syscall.Rusage
-	syscall.Getrusage(syscall.RUSAGE_SELF, &rusageStart)
-
-	// Process audio
-	if err := context.Process(samples, segmentCallback, nil); err != nil {
-		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to process audio: %v", err)})
+	body, err := renderSubtitles(output, response, subtitleOpts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to render subtitles: %v", err)})
 		return
 	}
+	c.Data(http.StatusOK, outputContentTypes[output], []byte(body))
+}
 
-	// Calculate CPU time
-	syscall.Getrusage(syscall.RUSAGE_SELF, &rusageEnd)
+// requestError is an error that carries the HTTP status to report to the client.
+type requestError struct {
+	Status     int
+	Message    string
+	RetryAfter int // Seconds, sent as the Retry-After header when set
 
-	cpuTimeUser := time.Duration(rusageEnd.Utime.Nano() - rusageStart.Utime.Nano())
-	cpuTimeSystem := time.Duration(rusageEnd.Stime.Nano() - rusageStart.Stime.Nano())
-	cpuTimeTotal := cpuTimeUser + cpuTimeSystem
+	Code        string  // Reported as ErrorResponse.Code
+	Duration    float64 // Length of audio rejected as too long, if known
+	MaxDuration float64
+}
 
-	// Record CPU time metrics
-	metrics.CPUTime.WithLabelValues("user").Observe(cpuTimeUser.Seconds())
-	metrics.CPUTime.WithLabelValues("system").Observe(cpuTimeSystem.Seconds())
-	metrics.CPUTime.WithLabelValues("total").Observe(cpuTimeTotal.Seconds())
+func (e *requestError) Error() string {
+	return e.Message
+}
 
-	// Calculate average confidence across all tokens
-	confidence := 0.0
-	if tokenCount > 0 {
-		confidence = totalProb / float64(tokenCount)
+// errorResponse returns the body reporting an error from the transcription pipeline.
+func errorResponse(err error) ErrorResponse {
+	response := ErrorResponse{Error: err.Error()}
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		response.Code = reqErr.Code
+		response.DurationSeconds = reqErr.Duration
+		response.MaxDurationSeconds = reqErr.MaxDuration
 	}
+	return response
+}
 
-	// Calculate final memory stats
-	runtime.GC() // Run GC after processing
-	runtime.ReadMemStats(&memStats)
-
-	const bytesToMB = 1024 * 1024
+// CodeAudioTooLong is the error code for audio longer than the maximum duration.
+const CodeAudioTooLong = "audio_too_long"
 
-	response := TranscriptionResponse{
-		Text:           text,
-		Segments:       segments,
-		Duration:       duration,
-		ProcessingTime: time.Since(startTime).Seconds(),
-		Confidence:     confidence,
-		AudioInfo:      audioInfo,
-		MemoryUsage: MemStats{
-			AllocatedMB:   float64(memStats.Alloc-startAlloc) / bytesToMB,
-			TotalAllocMB:  float64(memStats.TotalAlloc) / bytesToMB,
-			SystemMB:      float64(memStats.Sys) / bytesToMB,
-			HeapInUseMB:   float64(memStats.HeapInuse) / bytesToMB,
-			StackInUseMB:  float64(memStats.StackInuse) / bytesToMB,
-			GcCycles:      memStats.NumGC - startGC,
-			GcPauseMicros: (memStats.PauseTotalNs - startPause) / 1000,
-		},
-		Timestamp: time.Now(),
-		ComputeTime: struct {
-			CPUTime float64 `json:"cpu_time_seconds"`
-			GPUTime float64 `json:"gpu_time_seconds,omitempty"`
-		}{
-			CPUTime: cpuTimeTotal.Seconds(),
-			// GPU time would be added here if available from the model
-		},
+// durationError rejects audio longer than limit seconds. duration is 0 when
+// the length is only known to exceed the limit.
+func durationError(duration, limit float64) error {
+	message := fmt.Sprintf("Audio is longer than the maximum of %g seconds", limit)
+	if duration > 0 {
+		message = fmt.Sprintf("Audio is %.1f seconds long; the maximum is %g seconds", duration, limit)
+	}
+	return &requestError{
+		Status:      http.StatusRequestEntityTooLarge,
+		Message:     message,
+		Code:        CodeAudioTooLong,
+		Duration:    duration,
+		MaxDuration: limit,
 	}
-
-	// Record memory metrics
-	metrics.MemoryUsage.WithLabelValues("allocated").Set(float64(memStats.Alloc))
-	metrics.MemoryUsage.WithLabelValues("system").Set(float64(memStats.Sys))
-	metrics.MemoryUsage.WithLabelValues("heap").Set(float64(memStats.HeapInuse))
-
-	// Record audio duration
-	metrics.AudioDuration.WithLabelValues(format).Observe(duration)
-
-	// Record request success
-	metrics.TranscriptionRequests.WithLabelValues("success", format).Inc()
-
-	c.JSON(http.StatusOK, response)
 }
 
-// handleError adds error metrics in error handlers.
-func (s *TranscriptionService) handleError(c *gin.Context, format, status string, err error) {
-	metrics.TranscriptionRequests.WithLabelValues(status, format).Inc()
-	c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+// errorStatus returns the HTTP status for an error returned by the transcription pipeline.
+func errorStatus(err error) int {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return reqErr.Status
+	}
+	return http.StatusInternalServerError
 }
 
-// convertAudioToSamples converts the audio file to samples using the appropriate format handler.
-func (s *TranscriptionService) convertAudioToSamples(filename string) ([]float32, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open audio file: %v", err)
+// setRetryAfter adds the Retry-After header for errors that carry one.
+func setRetryAfter(c *gin.Context, err error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) && reqErr.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(reqErr.RetryAfter))
 	}
-	defer file.Close()
+}
 
-	ext := strings.ToLower(filepath.Ext(filename))
-	var format audio.Format
-
-	switch ext {
-	case ".wav":
-		format = &audio.WAVFormat{}
-	case ".mp3":
-		format = &audio.MP3Format{} // Add MP3 format
-	case ".flac":
-		format = &audio.FLACFormat{} // Add FLAC format
-	case ".aac", ".m4a":
-		format = &audio.AACFormat{} // Add AAC format
-	case ".ogg":
-		// Detect codec first
-		codec, err := detectOggCodec(file)
-		if err != nil {
-			return nil, fmt.Errorf("failed to detect OGG codec: %v", err)
-		}
+// uploadFormat returns the lowercase file extension used for metrics labeling.
+func uploadFormat(file *multipart.FileHeader) string {
+	return strings.ToLower(filepath.Ext(file.Filename))
+}
 
-		// Reset file position
-		if _, err := file.Seek(0, io.SeekStart); err != nil {
-			return nil, err
-		}
+// transcribeUpload decodes an uploaded file directly from the request and transcribes it.
+// onSegment and progress, if not nil, are called as decoding advances.
+func (s *TranscriptionService) transcribeUpload(ctx context.Context, file *multipart.FileHeader, opts TranscriptionOptions, onSegment func(SegmentInfo), progress ProgressFunc) (*TranscriptionResponse, error) {
+	format := uploadFormat(file)
 
-		switch codec {
-		case "Vorbis":
-			format = &audio.VorbisFormat{}
-		case "Opus":
-			format = &audio.OpusFormat{}
-		default:
-			return nil, fmt.Errorf("unsupported OGG codec: %s", codec)
+	// Check file size
+	if file.Size > s.config.Audio.MaxFileSize*1024*1024 {
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		return nil, &requestError{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("File too large. Maximum size is %dMB", s.config.Audio.MaxFileSize),
 		}
-	case ".opus":
-		format = &audio.OpusFormat{}
-	default:
-		return nil, fmt.Errorf("unsupported audio format: %s", ext)
 	}
 
-	return format.ConvertToSamples(filename, s.config.Audio.SampleRate)
-}
-
-// getAudioMetadata retrieves audio metadata using the appropriate format handler.
-func (s *TranscriptionService) getAudioMetadata(filename string) (audio.AudioMetadata, error) {
-	file, err := os.Open(filename)
+	src, err := file.Open()
 	if err != nil {
-		return audio.AudioMetadata{}, err
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		return nil, &requestError{Status: http.StatusInternalServerError, Message: "Failed to read audio file"}
 	}
-	defer file.Close()
+	defer src.Close()
 
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return audio.AudioMetadata{}, err
-	}
-
-	ext := strings.ToLower(filepath.Ext(filename))
-	var format audio.Format
-
-	switch ext {
-	case ".wav":
-		format = &audio.WAVFormat{}
-	case ".mp3":
-		format = &audio.MP3Format{} // Add MP3 format
-	case ".flac":
-		format = &audio.FLACFormat{} // Add FLAC format
-	case ".aac", ".m4a":
-		format = &audio.AACFormat{} // Add AAC format
-	case ".ogg":
-		codec, err := detectOggCodec(file)
-		if err != nil {
-			return audio.AudioMetadata{}, fmt.Errorf("failed to detect OGG codec: %v", err)
-		}
-
-		if _, err := file.Seek(0, io.SeekStart); err != nil {
-			return audio.AudioMetadata{}, err
-		}
+	return s.transcribeAudio(ctx, src, file.Filename, opts, onSegment, progress)
+}
 
-		switch codec {
-		case "Vorbis":
-			format = &audio.VorbisFormat{}
-		case "Opus":
-			format = &audio.OpusFormat{}
-		default:
-			return audio.AudioMetadata{}, fmt.Errorf("unsupported OGG codec: %s", codec)
-		}
-	case ".opus":
-		format = &audio.OpusFormat{}
+// poolError converts a failure to get an inference slot into a client error.
+func (s *TranscriptionService) poolError(err error) error {
+	switch {
+	case errors.Is(err, ErrQueueFull):
+		return &requestError{Status: http.StatusTooManyRequests, Message: "Transcription queue is full, try again later", RetryAfter: s.pool.RetryAfter()}
+	case errors.Is(err, ErrQueueTimeout):
+		return &requestError{Status: http.StatusServiceUnavailable, Message: "Timed out waiting for a transcription slot", RetryAfter: s.pool.RetryAfter()}
 	default:
-		return audio.AudioMetadata{}, fmt.Errorf("unsupported format: %s", ext)
+		return err
 	}
-
-	return format.GetMetadata(filename, fileInfo.Size())
 }
 
-// detectOggCodec detects the codec used in an OGG container.
-func detectOggCodec(file *os.File) (string, error) {
-	// Store current position to restore later
-	startPos, err := file.Seek(0, io.SeekCurrent)
+// saveUploadAs copies an uploaded file to the given path.
+func saveUploadAs(file *multipart.FileHeader, path string) error {
+	dst, err := os.Create(path)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer file.Seek(startPos, io.SeekStart)
+	defer dst.Close()
 
-	// Read OGG page header
-	header := make([]byte, 27) // Standard OGG page header size
-	n, err := file.Read(header)
-	if err != nil || n < 27 {
-		return "", fmt.Errorf("failed to read OGG header: %v", err)
+	if err := copyUpload(file, dst); err != nil {
+		os.Remove(path)
+		return err
 	}
+	return nil
+}
 
-	// Verify OGG capture pattern
-	if !bytes.Equal(header[:4], oggCapturePattern) {
-		return "", fmt.Errorf("not an OGG file (got %x, expected %x)", header[:4], oggCapturePattern)
+func copyUpload(file *multipart.FileHeader, dst io.Writer) error {
+	src, err := file.Open()
+	if err != nil {
+		return err
 	}
+	defer src.Close()
 
-	// Get number of page segments
-	numSegments := int(header[26])
-
-	// Read segment table
-	segmentTable := make([]byte, numSegments)
-	n, err = file.Read(segmentTable)
-	if err != nil || n < numSegments {
-		return "", fmt.Errorf("failed to read segment table: %v", err)
-	}
+	_, err = io.Copy(dst, src)
+	return err
+}
 
-	// Calculate total data size from segment table
-	var totalSize int
-	for _, size := range segmentTable {
-		totalSize += int(size)
+// checkDuration rejects the audio file at path if its header reports a
+// duration over the limit in opts, so that it is not queued. Files that cannot
+// be read are left for the transcription to report.
+func (s *TranscriptionService) checkDuration(path string, opts TranscriptionOptions) error {
+	if opts.MaxDuration <= 0 || opts.Truncate {
+		return nil
 	}
-
-	// Read first page data
-	data := make([]byte, totalSize)
-	n, err = file.Read(data)
-	if err != nil || n < totalSize {
-		return "", fmt.Errorf("failed to read page data: %v", err)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
 	}
+	defer file.Close()
 
-	// Look for codec headers in the first few bytes of data
-	if len(data) > 7 && bytes.Equal(data[1:7], []byte("vorbis")) {
-		return "Vorbis", nil
+	stream, audioInfo, err := audio.DecodeNamed(file, path, 0)
+	if err != nil {
+		return nil
 	}
-	if len(data) > 8 && bytes.Contains(data[:8], []byte("OpusHead")) {
-		return "Opus", nil
+	stream.Close()
+	if audioInfo.Duration > opts.MaxDuration {
+		return durationError(audioInfo.Duration, opts.MaxDuration)
 	}
+	return nil
+}
 
-	// If no codec was detected, try searching the entire first page
-	if bytes.Contains(data, []byte("vorbis")) {
-		return "Vorbis", nil
-	}
-	if bytes.Contains(data, []byte("OpusHead")) {
-		return "Opus", nil
+// transcribeFile runs an engine over an audio file on disk.
+func (s *TranscriptionService) transcribeFile(ctx context.Context, filename string, opts TranscriptionOptions, progress ProgressFunc) (*TranscriptionResponse, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		metrics.TranscriptionRequests.WithLabelValues("error", strings.ToLower(filepath.Ext(filename))).Inc()
+		return nil, fmt.Errorf("failed to open audio file: %v", err)
 	}
+	defer file.Close()
 
-	// Debug output
-	fmt.Printf("OGG header: %x\n", header)
-	fmt.Printf("First 32 bytes of data: %x\n", data[:min(32, len(data))])
-	fmt.Printf("Searching for Vorbis header: %x\n", vorbisHeader)
-	fmt.Printf("Searching for Opus header: %x\n", opusHeader)
-	fmt.Printf("Total data size: %d\n", totalSize)
-
-	return "", fmt.Errorf("unknown OGG codec (first page size: %d bytes)", totalSize)
+	return s.transcribeAudio(ctx, file, filename, opts, nil, progress)
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
+// transcribeAudio decodes audio from r, runs an engine over it and records
+// metrics. name is the file name the audio was uploaded under; its extension
+// labels metrics and hints at the format. It waits for a free inference slot;
+// ctx bounds the wait. If onSegment is not nil it is called with each segment as
+// soon as it is decoded, and if progress is not nil it is called with the
+// percentage of audio processed.
+func (s *TranscriptionService) transcribeAudio(ctx context.Context, r io.Reader, name string, opts TranscriptionOptions, onSegment func(SegmentInfo), progress ProgressFunc) (*TranscriptionResponse, error) {
+	format := strings.ToLower(filepath.Ext(name))
+	timer := prometheus.NewTimer(metrics.TranscriptionDuration.WithLabelValues(format))
+	defer timer.ObserveDuration()
 
-// convertOggVorbisToSamples converts an OGG Vorbis file to a slice of float32 samples.
-func (s *TranscriptionService) convertOggVorbisToSamples(file *os.File) ([]float32, error) {
-	// Ensure we're at the start of the file
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("failed to seek to start: %v", err)
-	}
+	// Record start time and memory stats
+	startTime := time.Now()
+	var memStats runtime.MemStats
+	runtime.GC() // Run GC before measuring
+	runtime.ReadMemStats(&memStats)
+	startAlloc := memStats.Alloc
+	startGC := memStats.NumGC
+	startPause := memStats.PauseTotalNs
 
-	// Try to detect codec again to ensure we have a Vorbis stream
-	codec, err := detectOggCodec(file)
+	// The preprocessing the request or the configured profile asks for
+	stages, err := s.preprocessStages(opts.Preprocess)
 	if err != nil {
-		return nil, fmt.Errorf("failed to verify codec: %v", err)
-	}
-	if codec != "Vorbis" {
-		return nil, fmt.Errorf("expected Vorbis codec, got %s", codec)
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		return nil, &requestError{Status: http.StatusBadRequest, Message: err.Error()}
 	}
 
-	// Reset to start again for actual decoding
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("failed to seek to start: %v", err)
+	// Identify the format and read the header, keeping the channels apart
+	// when they are transcribed separately or one is picked
+	byChannel := opts.Channels == ChannelsSeparate || opts.Channel != nil
+	decode := audio.DecodeNamed
+	if byChannel {
+		decode = audio.DecodeNamedChannels
 	}
-
-	decoder, err := oggvorbis.NewReader(file)
+	stream, audioInfo, err := decode(r, name, s.config.Audio.SampleRate)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create OGG decoder: %v", err)
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		return nil, fmt.Errorf("Failed to get audio metadata: %v", err)
 	}
-
-	// Read all samples
-	var samples []float32
-	buffer := make([]float32, 16384) // Read in chunks
-	for {
-		n, err := decoder.Read(buffer)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read OGG data: %v", err)
+	channels := 1 // Interleaved in the stream
+	if byChannel {
+		channels = max(audioInfo.Channels, 1)
+	}
+	if opts.Channel != nil && *opts.Channel >= channels {
+		stream.Close()
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		return nil, &requestError{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("Channel %d does not exist: the audio has %d channels", *opts.Channel, channels),
 		}
-		samples = append(samples, buffer[:n]...)
 	}
 
-	// Convert to mono if stereo
-	if decoder.Channels() > 1 {
-		samples = audio.ConvertToMono(samples, decoder.Channels())
+	// Reject audio the header says is too long before decoding any of it
+	if opts.MaxDuration > 0 && !opts.Truncate && audioInfo.Duration > opts.MaxDuration {
+		stream.Close()
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		return nil, durationError(audioInfo.Duration, opts.MaxDuration)
 	}
 
-	// Resample to 16kHz if needed
-	if decoder.SampleRate() != s.config.Audio.SampleRate {
-		samples = resampleAudio(samples, decoder.SampleRate(), s.config.Audio.SampleRate)
+	// Decode to samples at the engine's rate as the input is read, stopping
+	// at the limit in case the header understated the length
+	var samples []float32
+	var truncated bool
+	if opts.MaxDuration > 0 {
+		samples, truncated, err = audio.ReadAtMost(stream, int(opts.MaxDuration*float64(s.config.Audio.SampleRate))*channels)
+	} else {
+		samples, err = audio.ReadAll(stream)
 	}
-
-	return samples, nil
-}
-
-// convertWavToSamples converts a WAV file to a slice of float32 samples.
-func (s *TranscriptionService) convertWavToSamples(file *os.File) ([]float32, error) {
-	decoder := wav.NewDecoder(file)
-	if !decoder.IsValidFile() {
-		return nil, fmt.Errorf("invalid WAV file")
+	if err != nil {
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		return nil, fmt.Errorf("Failed to convert audio: %v", err)
 	}
-
-	// Get the format before reading the buffer
-	format := decoder.Format()
-
-	// Read audio buffer
-	buf, err := decoder.FullPCMBuffer()
-	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("failed to read PCM buffer: %v", err)
+	if truncated && !opts.Truncate {
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		return nil, durationError(0, opts.MaxDuration)
 	}
 
-	// Convert int buffer to float32 samples
-	numSamples := len(buf.Data)
-	samples := make([]float32, numSamples)
-
-	// Scale factor for 16-bit audio
-	scale := float32(1.0 / 32768.0)
-
-	for i, sample := range buf.Data {
-		samples[i] = float32(sample) * scale
+	// One track per channel to transcribe, or the mono mix
+	tracks := [][]float32{samples}
+	if channels > 1 {
+		tracks = audio.Deinterleave(samples, channels)
 	}
-
-	// Convert to target sample rate if needed
-	if format.SampleRate != s.config.Audio.SampleRate {
-		samples = resampleAudio(samples, format.SampleRate, s.config.Audio.SampleRate)
+	if opts.Channel != nil {
+		tracks = [][]float32{tracks[*opts.Channel]}
 	}
 
-	return samples, nil
-}
-
-// convertOpusToSamples converts an Opus file to a slice of float32 samples.
-func (s *TranscriptionService) convertOpusToSamples(file *os.File) ([]float32, error) {
-	// Start from beginning of file
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("failed to seek to start: %v", err)
+	// Calculate actual duration from samples
+	duration := float64(len(tracks[0])) / float64(s.config.Audio.SampleRate)
+	if audioInfo.Duration == 0 && !truncated {
+		// Not recorded in the header of a non-seekable stream
+		audioInfo.Duration = duration
+	}
+
+	// Clean up the audio for the model, then keep only the speech in each
+	// track, remembering where it came from
+	for _, track := range tracks {
+		audio.Preprocess(track, s.config.Audio.SampleRate, stages, s.preprocessOptions())
+	}
+	var sources [][]float32 // Whole tracks, which segment times refer to, kept for diarization
+	if opts.Diarize {
+		sources = slices.Clone(tracks)
+	}
+	timelines := make([]audio.SpeechTimeline, len(tracks))
+	var vadReport *VADReport
+	if opts.VAD {
+		vadReport = &VADReport{TotalDuration: duration * float64(len(tracks))}
+		for i, track := range tracks {
+			regions := audio.DetectSpeech(track, s.config.Audio.SampleRate, opts.vadOptions())
+			tracks[i], timelines[i] = audio.JoinSpeech(track, regions, s.config.Audio.SampleRate)
+			vadReport.SpeechDuration += float64(len(tracks[i])) / float64(s.config.Audio.SampleRate)
+			vadReport.Regions += len(regions)
+		}
 	}
 
-	// Create Opus decoder
-	decoder := opus.NewDecoder()
-
-	var pcm []float32
-	const frameSize = 960 // 20ms at 48kHz
-
-	var headerRead bool
-	var streamStarted bool
-
-	// Read OGG pages and extract Opus packets
-	for {
-		// Read OGG page header
-		header := make([]byte, 27)
-		n, err := file.Read(header)
-		if err == io.EOF {
+	// Wait for an inference slot
+	engine, err := s.pool.Acquire(ctx)
+	if err != nil {
+		metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+		return nil, s.poolError(err)
+	}
+	var processed, processingTime float64 // Audio this slot decoded, and how long it took
+	defer func() { s.pool.Release(engine, processed, processingTime) }()
+
+	// Identify the language once, from the start of the first track with
+	// speech, so every track and chunk is decoded in it. Engines that cannot
+	// are left to pick it themselves, unless candidates were given.
+	var detected LanguageProbability
+	if _, ok := engine.(LanguageDetector); opts.Language == "auto" && (ok || len(opts.Languages) > 0) {
+		limit := int(s.detectSeconds() * float64(s.config.Audio.SampleRate))
+		for _, track := range tracks {
+			if len(track) == 0 {
+				continue
+			}
+			languages, err := detectLanguage(engine, track[:min(len(track), limit)], opts.Languages, opts.Threads)
+			if err != nil {
+				metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+				return nil, err
+			}
+			detected = languages[0]
+			opts.Language = detected.Code
 			break
 		}
-		if err != nil || n < 27 {
-			return nil, fmt.Errorf("failed to read OGG page header: %v", err)
-		}
+	}
+
+	// Set up callbacks for collecting segments
+	var totalProb float64
+	var tokenCount int
+	var segments []SegmentInfo
 
-		// Verify OGG capture pattern
-		if !bytes.Equal(header[:4], []byte("OggS")) {
-			return nil, fmt.Errorf("invalid OGG page header")
+	segmentCallback := func(seg SegmentInfo) {
+		for _, token := range seg.Tokens {
+			totalProb += token.Probability
+			tokenCount++
+		}
+		segments = append(segments, seg)
+		if onSegment != nil {
+			onSegment(seg)
 		}
+	}
 
-		// Get number of segments
-		numSegments := int(header[26])
+	// Track CPU time using rusage only
+	var rusageStart, rusageEnd syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &rusageStart)
 
-		// Read segment table
-		segmentTable := make([]byte, numSegments)
-		if _, err := io.ReadFull(file, segmentTable); err != nil {
-			return nil, fmt.Errorf("failed to read segment table: %v", err)
+	// Process audio, one channel after another in the same slot, which may
+	// borrow others to decode long audio in chunks
+	processStart := time.Now()
+	var chunks int
+	var diarization *DiarizationReport
+	if opts.Diarize {
+		diarization = &DiarizationReport{Method: DiarizeClustering}
+	}
+	for i, track := range tracks {
+		onTrackSegment, trackProgress := segmentCallback, progress
+		if byChannel {
+			channel := i
+			if opts.Channel != nil {
+				channel = *opts.Channel
+			}
+			label := channelLabel(channel, audioInfo.Channels)
+			onTrackSegment = func(seg SegmentInfo) {
+				seg.Channel = &channel
+				seg.ChannelLabel = label
+				segmentCallback(seg)
+			}
 		}
-
-		// Handle header packets
-		if !headerRead {
-			// Skip OpusHead and OpusTags packets
-			size := 0
-			for _, s := range segmentTable {
-				size += int(s)
+		if opts.VAD {
+			timeline, tagged := timelines[i], onTrackSegment
+			onTrackSegment = func(seg SegmentInfo) {
+				seg.StartTime, seg.EndTime = timeline.Start(seg.StartTime), timeline.End(seg.EndTime)
+				tokens := make([]TokenInfo, len(seg.Tokens))
+				for j, token := range seg.Tokens {
+					token.StartTime, token.EndTime = timeline.Start(token.StartTime), timeline.End(token.EndTime)
+					tokens[j] = token
+				}
+				seg.Tokens = tokens
+				tagged(seg)
 			}
-			if _, err := file.Seek(int64(size), io.SeekCurrent); err != nil {
-				return nil, fmt.Errorf("failed to skip header: %v", err)
+		}
+		if len(tracks) > 1 && progress != nil {
+			trackProgress = func(percent int) { progress((i*100 + percent) / len(tracks)) }
+		}
+		if opts.VAD && len(track) == 0 {
+			// No speech found: nothing for the engine to do
+			if trackProgress != nil {
+				trackProgress(100)
 			}
-			headerRead = true
 			continue
 		}
-
-		// Process data packets
-		for i, size := range segmentTable {
-			if size == 0 {
-				continue
-			}
-
-			// Read Opus packet
-			packet := make([]byte, size)
-			if _, err := io.ReadFull(file, packet); err != nil {
-				return nil, fmt.Errorf("failed to read Opus packet: %v", err)
+		first := len(segments)
+		decoded, n, err := s.transcribeTrack(ctx, engine, track, opts, onTrackSegment, trackProgress)
+		processed += decoded
+		chunks += n
+		if err != nil {
+			metrics.TranscriptionRequests.WithLabelValues("error", format).Inc()
+			return nil, err
+		}
+		if opts.Diarize {
+			// Speakers are numbered on from those of earlier channels
+			speakers, turns := s.diarizeTrack(segments[first:], sources[i], opts, diarization.Speakers)
+			diarization.Speakers += speakers
+			if turns {
+				diarization.Method = DiarizeTurns
 			}
+		}
+	}
+	processingTime = time.Since(processStart).Seconds()
+	if chunks == len(tracks) {
+		chunks = 0 // Not reported unless long audio was split
+	}
 
-			// Skip the first data packet if we haven't seen a header
-			if !streamStarted {
-				streamStarted = true
-				continue
-			}
+	// Interleave the channels' segments into one conversation
+	if len(tracks) > 1 {
+		sort.SliceStable(segments, func(a, b int) bool { return segments[a].StartTime < segments[b].StartTime })
+	}
+	var text strings.Builder
+	for _, seg := range segments {
+		text.WriteString(seg.Text)
+	}
 
-			// Create output buffer for decoded PCM data
-			outputPCM := make([]byte, frameSize*2) // 16-bit samples = 2 bytes per sample
+	// Calculate CPU time
+	syscall.Getrusage(syscall.RUSAGE_SELF, &rusageEnd)
 
-			// Decode Opus frame
-			_, bandwidth, err := decoder.Decode(packet, outputPCM)
-			if err != nil {
-				fmt.Printf("Warning: failed to decode frame %d: %v (bandwidth: %v)\n", i, err, bandwidth)
-				continue
-			}
+	cpuTimeUser := time.Duration(rusageEnd.Utime.Nano() - rusageStart.Utime.Nano())
+	cpuTimeSystem := time.Duration(rusageEnd.Stime.Nano() - rusageStart.Stime.Nano())
+	cpuTimeTotal := cpuTimeUser + cpuTimeSystem
 
-			// Convert decoded bytes to float32 samples
-			frame := make([]float32, frameSize)
-			for i := 0; i < frameSize; i++ {
-				// Convert 16-bit PCM to float32
-				sample := int16(outputPCM[i*2]) | (int16(outputPCM[i*2+1]) << 8)
-				frame[i] = float32(sample) / 32768.0
-			}
+	// Record CPU time metrics
+	metrics.CPUTime.WithLabelValues("user").Observe(cpuTimeUser.Seconds())
+	metrics.CPUTime.WithLabelValues("system").Observe(cpuTimeSystem.Seconds())
+	metrics.CPUTime.WithLabelValues("total").Observe(cpuTimeTotal.Seconds())
 
-			// Append decoded samples
-			pcm = append(pcm, frame...)
-		}
+	// Calculate average confidence across all tokens
+	confidence := 0.0
+	if tokenCount > 0 {
+		confidence = totalProb / float64(tokenCount)
 	}
 
-	if len(pcm) == 0 {
-		return nil, fmt.Errorf("no valid Opus frames decoded")
-	}
+	// Calculate final memory stats
+	runtime.GC() // Run GC after processing
+	runtime.ReadMemStats(&memStats)
 
-	// Resample to target sample rate
-	return resampleAudio(pcm, 48000, s.config.Audio.SampleRate), nil
-}
+	const bytesToMB = 1024 * 1024
 
-// resampleAudio resamples audio samples from one sample rate to another using linear interpolation.
-// For production, consider using a better resampling algorithm.
-func resampleAudio(samples []float32, srcRate, dstRate int) []float32 {
-	ratio := float64(srcRate) / float64(dstRate)
-	outLen := int(float64(len(samples)) / ratio)
-	resampled := make([]float32, outLen)
-
-	for i := range resampled {
-		pos := float64(i) * ratio
-		idx := int(pos)
-		if idx >= len(samples)-1 {
-			break
-		}
-		frac := float32(pos - float64(idx))
-		resampled[i] = samples[idx]*(1-frac) + samples[idx+1]*frac
+	response := &TranscriptionResponse{
+		Text:           text.String(),
+		Segments:       segments,
+		Duration:       duration,
+		ProcessingTime: time.Since(startTime).Seconds(),
+		Confidence:     confidence,
+		AudioInfo:      audioInfo,
+		Options:        opts,
+		Truncated:      truncated,
+		VAD:            vadReport,
+		Chunks:         chunks,
+		Preprocess:     stages,
+
+		DetectedLanguage:    detected.Code,
+		LanguageProbability: detected.Probability,
+		Diarization:         diarization,
+
+		MemoryUsage: MemStats{
+			AllocatedMB:   float64(memStats.Alloc-startAlloc) / bytesToMB,
+			TotalAllocMB:  float64(memStats.TotalAlloc) / bytesToMB,
+			SystemMB:      float64(memStats.Sys) / bytesToMB,
+			HeapInUseMB:   float64(memStats.HeapInuse) / bytesToMB,
+			StackInUseMB:  float64(memStats.StackInuse) / bytesToMB,
+			GcCycles:      memStats.NumGC - startGC,
+			GcPauseMicros: (memStats.PauseTotalNs - startPause) / 1000,
+		},
+		Timestamp: time.Now(),
+		ComputeTime: struct {
+			CPUTime float64 `json:"cpu_time_seconds"`
+			GPUTime float64 `json:"gpu_time_seconds,omitempty"`
+		}{
+			CPUTime: cpuTimeTotal.Seconds(),
+			// GPU time would be added here if available from the model
+		},
 	}
 
-	return resampled
+	// Record memory metrics
+	metrics.MemoryUsage.WithLabelValues("allocated").Set(float64(memStats.Alloc))
+	metrics.MemoryUsage.WithLabelValues("system").Set(float64(memStats.Sys))
+	metrics.MemoryUsage.WithLabelValues("heap").Set(float64(memStats.HeapInuse))
+
+	// Record audio duration
+	metrics.AudioDuration.WithLabelValues(format).Observe(duration)
+
+	// Record request success
+	metrics.TranscriptionRequests.WithLabelValues("success", format).Inc()
+
+	return response, nil
 }
 
 // durationToSeconds converts a time.Duration to seconds.
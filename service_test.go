@@ -5,8 +5,13 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -16,7 +21,10 @@ import (
 	"testing"
 
 	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/VA7DBI/whisperAPI/metrics"
+	"github.com/VA7DBI/whisperAPI/middleware"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -30,8 +38,15 @@ func setupTestServer(t *testing.T) *gin.Engine {
 	cfg := &config.Config{}
 	cfg.Audio.SampleRate = 16000
 	cfg.Audio.MaxFileSize = 25
+	cfg.Whisper.Engine = "fake"
 	cfg.Whisper.ModelPath = "models/ggml-base.bin"
 
+	// Set TEST_WHISPER_MODEL to transcribe with a real model instead
+	if model := os.Getenv("TEST_WHISPER_MODEL"); model != "" {
+		cfg.Whisper.Engine = "whisper"
+		cfg.Whisper.ModelPath = model
+	}
+
 	service, err := NewTranscriptionService(cfg)
 	assert.NoError(t, err)
 
@@ -39,6 +54,49 @@ func setupTestServer(t *testing.T) *gin.Engine {
 	return r
 }
 
+// testWAVRate is the sample rate of the WAV files written by the tests.
+const testWAVRate = 16000
+
+// encodeTestWAV returns a 16 kHz 16-bit WAV file holding interleaved samples
+// of the given number of channels.
+func encodeTestWAV(channels int, samples []int16) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	buf.WriteString("RIFF")
+	binary.Write(&buf, le, uint32(36+len(samples)*2))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, le, uint32(16))
+	binary.Write(&buf, le, uint16(1)) // PCM
+	binary.Write(&buf, le, uint16(channels))
+	binary.Write(&buf, le, uint32(testWAVRate))
+	binary.Write(&buf, le, uint32(testWAVRate*2*channels))
+	binary.Write(&buf, le, uint16(2*channels))
+	binary.Write(&buf, le, uint16(16))
+	buf.WriteString("data")
+	binary.Write(&buf, le, uint32(len(samples)*2))
+	binary.Write(&buf, le, samples)
+	return buf.Bytes()
+}
+
+// writeTestWAV writes a 16 kHz 16-bit WAV file with a 440 Hz tone on each of
+// its channels.
+func writeTestWAV(t *testing.T, seconds float64, channels int) string {
+	n := int(seconds * testWAVRate)
+	samples := make([]int16, 0, n*channels)
+	for i := 0; i < n; i++ {
+		tone := int16(8000 * math.Sin(2*math.Pi*440*float64(i)/testWAVRate))
+		for ch := 0; ch < channels; ch++ {
+			samples = append(samples, tone)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "tone.wav")
+	if err := os.WriteFile(path, encodeTestWAV(channels, samples), 0644); err != nil {
+		t.Fatalf("Failed to write WAV: %v", err)
+	}
+	return path
+}
+
 func createTestAudioFiles(t *testing.T) (string, string, string, string, string) {
 	// Create test fixtures directory if it doesn't exist
 	fixturesDir := "test_fixtures"
@@ -190,6 +248,267 @@ func testTranscriptionWithExpectedError(t *testing.T, r *gin.Engine, audioPath s
 	assert.Contains(t, w.Body.String(), expectedError)
 }
 
+// postAudio uploads the file at path to /transcribe under the given filename.
+func postAudio(r *gin.Engine, path, filename, token, query string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, _ := writer.CreateFormFile("audio", filename)
+	data, _ := os.ReadFile(path)
+	part.Write(data)
+	writer.WriteField("language", "en")
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/transcribe"+query, &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestTranscribeHandler_EndToEnd(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = 16000
+	cfg.Audio.MaxFileSize = 25
+	cfg.Auth.Enabled = true
+	cfg.Auth.Tokens = []string{"test-token"}
+
+	engine := NewFakeEngine(nil)
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{engine})
+	authMiddleware, err := middleware.NewAuthMiddleware(cfg)
+	assert.NoError(t, err)
+
+	r := gin.New()
+	r.POST("/transcribe", authMiddleware.Handler(), service.TranscribeHandler)
+
+	wavPath := writeTestWAV(t, 2, 1)
+	post := func(token string, query string) *httptest.ResponseRecorder {
+		return postAudio(r, wavPath, "tone.wav", token, query)
+	}
+
+	// Requests without a valid token never reach the engine
+	assert.Equal(t, http.StatusUnauthorized, post("", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, post("wrong", "").Code)
+	calls, _ := engine.Calls()
+	assert.Equal(t, 0, calls)
+
+	successes := testutil.ToFloat64(metrics.TranscriptionRequests.WithLabelValues("success", ".wav"))
+
+	w := post("test-token", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response TranscriptionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, fakeScript, response.Text)
+	assert.InDelta(t, 2.0, response.Duration, 0.01)
+	assert.Equal(t, "WAV", response.AudioInfo.Format)
+	assert.InDelta(t, 0.9, response.Confidence, 1e-9)
+	if assert.Len(t, response.Segments, 1) {
+		assert.InDelta(t, 2.0, response.Segments[0].EndTime, 0.01)
+	}
+	assert.Equal(t, successes+1, testutil.ToFloat64(metrics.TranscriptionRequests.WithLabelValues("success", ".wav")))
+
+	calls, opts := engine.Calls()
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "en", opts.Language)
+
+	// Subtitle output runs through the same pipeline
+	w = post("test-token", "?output=srt")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "00:00:00,000 --> 00:00:02,000")
+
+	// Engine failures surface as server errors
+	engine.Err = errors.New("Failed to process audio: boom")
+	w = post("test-token", "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "boom")
+}
+
+func TestTranscribeHandler_MaxDuration(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = 16000
+	cfg.Audio.MaxFileSize = 25
+	cfg.Audio.MaxDuration = 1
+	cfg.Audio.MaxDurationMode = "reject"
+	cfg.Audio.TokenMaxDuration = map[string]int{"long-token": 10}
+	cfg.Auth.Enabled = true
+	cfg.Auth.Tokens = []string{"test-token", "long-token"}
+
+	engine := NewFakeEngine(nil)
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{engine})
+	authMiddleware, err := middleware.NewAuthMiddleware(cfg)
+	assert.NoError(t, err)
+	r := gin.New()
+	r.POST("/transcribe", authMiddleware.Handler(), service.TranscribeHandler)
+
+	// The header gives the length, so the file is rejected before decoding
+	wavPath := writeTestWAV(t, 2, 1)
+	w := postAudio(r, wavPath, "tone.wav", "test-token", "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	var errResponse ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResponse))
+	assert.Equal(t, CodeAudioTooLong, errResponse.Code)
+	assert.InDelta(t, 2.0, errResponse.DurationSeconds, 0.01)
+	assert.Equal(t, 1.0, errResponse.MaxDurationSeconds)
+	calls, _ := engine.Calls()
+	assert.Equal(t, 0, calls)
+
+	// A header without the length is caught while decoding
+	data, err := os.ReadFile(wavPath)
+	assert.NoError(t, err)
+	binary.LittleEndian.PutUint32(data[40:], 0xFFFFFFFF)
+	streamedPath := filepath.Join(t.TempDir(), "streamed.wav")
+	assert.NoError(t, os.WriteFile(streamedPath, data, 0644))
+	w = postAudio(r, streamedPath, "streamed.wav", "test-token", "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	errResponse = ErrorResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResponse))
+	assert.Equal(t, CodeAudioTooLong, errResponse.Code)
+	assert.Zero(t, errResponse.DurationSeconds)
+
+	// Tokens can have their own limit
+	w = postAudio(r, wavPath, "tone.wav", "long-token", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// In truncate mode the audio is cut at the limit
+	cfg.Audio.MaxDurationMode = "truncate"
+	w = postAudio(r, streamedPath, "streamed.wav", "test-token", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response TranscriptionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.True(t, response.Truncated)
+	assert.InDelta(t, 1.0, response.Duration, 1e-9)
+	assert.Zero(t, response.AudioInfo.Duration)
+}
+
+func TestTranscribeHandler_ContentDetection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = 16000
+	cfg.Audio.MaxFileSize = 25
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{NewFakeEngine(nil)})
+
+	r := gin.New()
+	r.POST("/transcribe", service.TranscribeHandler)
+	wavPath := writeTestWAV(t, 1, 1)
+
+	tests := []struct {
+		filename string
+		mismatch bool
+	}{
+		{"tone.wav", false},
+		{"recording.bin", false},
+		{"recording", false},
+		{"misnamed.mp3", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			w := postAudio(r, wavPath, tt.filename, "", "")
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var response TranscriptionResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, "WAV", response.AudioInfo.Format)
+			assert.Equal(t, tt.mismatch, response.AudioInfo.ExtensionMismatch)
+			if tt.mismatch {
+				assert.Equal(t, ".mp3", response.AudioInfo.Extension)
+			}
+		})
+	}
+}
+
+// onsetEngine reports a segment where its audio starts and another a second
+// later, so tests can tell the channels apart by timing.
+type onsetEngine struct{}
+
+func (e *onsetEngine) Transcribe(samples []float32, opts TranscriptionOptions, onSegment func(SegmentInfo), onProgress ProgressFunc) error {
+	onset := 0
+	for onset < len(samples) && samples[onset] == 0 {
+		onset++
+	}
+	start := float64(onset) / EngineSampleRate
+	onSegment(SegmentInfo{Text: fmt.Sprintf(" %.1f", start), StartTime: start, EndTime: start + 0.5})
+	onSegment(SegmentInfo{Text: fmt.Sprintf(" %.1f", start+1), StartTime: start + 1, EndTime: start + 1.5})
+	if onProgress != nil {
+		onProgress(100)
+	}
+	return nil
+}
+
+func (e *onsetEngine) Close() error { return nil }
+
+// stereoCallWAV returns a two-second 16 kHz stereo WAV file in which the left
+// channel starts speaking at once and the right channel after half a second.
+func stereoCallWAV() []byte {
+	var samples []int16
+	for i := 0; i < 2*testWAVRate; i++ {
+		tone := int16(8000 * math.Sin(2*math.Pi*440*float64(i)/testWAVRate))
+		right := int16(0)
+		if i >= testWAVRate/2 {
+			right = tone
+		}
+		samples = append(samples, tone+1, right)
+	}
+	return encodeTestWAV(2, samples)
+}
+
+func TestTranscribeAudio_Channels(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = 16000
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{&onsetEngine{}})
+	wav := stereoCallWAV()
+	transcribe := func(opts TranscriptionOptions, progress ProgressFunc) (*TranscriptionResponse, error) {
+		return service.transcribeAudio(context.Background(), bytes.NewReader(wav), "call.wav", opts, nil, progress)
+	}
+
+	// Each channel is transcribed on its own and the segments merged by time
+	var progress []int
+	response, err := transcribe(TranscriptionOptions{Channels: ChannelsSeparate}, func(percent int) { progress = append(progress, percent) })
+	if assert.NoError(t, err) {
+		assert.Equal(t, " 0.0 0.5 1.0 1.5", response.Text)
+		assert.InDelta(t, 2.0, response.Duration, 1e-9)
+		assert.Equal(t, 2, response.AudioInfo.Channels)
+		var labels []string
+		for _, seg := range response.Segments {
+			labels = append(labels, seg.ChannelLabel)
+		}
+		assert.Equal(t, []string{"left", "right", "left", "right"}, labels)
+		if assert.NotNil(t, response.Segments[1].Channel) {
+			assert.Equal(t, 1, *response.Segments[1].Channel)
+		}
+	}
+	assert.Equal(t, []int{50, 100}, progress)
+
+	// One channel can be picked
+	right := 1
+	response, err = transcribe(TranscriptionOptions{Channel: &right}, nil)
+	if assert.NoError(t, err) && assert.Len(t, response.Segments, 2) {
+		assert.Equal(t, " 0.5 1.5", response.Text)
+		assert.Equal(t, "right", response.Segments[0].ChannelLabel)
+	}
+
+	// The mix has speech from the start and no channel tags
+	response, err = transcribe(TranscriptionOptions{}, nil)
+	if assert.NoError(t, err) && assert.Len(t, response.Segments, 2) {
+		assert.Equal(t, " 0.0 1.0", response.Text)
+		assert.Nil(t, response.Segments[0].Channel)
+		assert.Empty(t, response.Segments[0].ChannelLabel)
+	}
+
+	// A channel the audio does not have is a client error
+	missing := 2
+	_, err = transcribe(TranscriptionOptions{Channel: &missing}, nil)
+	assert.Equal(t, http.StatusBadRequest, errorStatus(err))
+}
+
 func TestHealthCheck(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
@@ -270,3 +589,98 @@ func TestAPIDocumentation(t *testing.T) {
 	assert.NotEmpty(t, getOp["summary"])
 	assert.NotEmpty(t, getOp["responses"])
 }
+
+func TestTranscribeAudio_VAD(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = 16000
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{&onsetEngine{}})
+	wav := stereoCallWAV()
+	right := 1
+
+	// The silence before the right channel speaks is not transcribed, and
+	// the times are those in the original audio
+	response, err := service.transcribeAudio(context.Background(), bytes.NewReader(wav), "call.wav", TranscriptionOptions{Channel: &right, VAD: true}, nil, nil)
+	if assert.NoError(t, err) && assert.Len(t, response.Segments, 2) {
+		assert.InDelta(t, 0.5, response.Segments[0].StartTime, 1e-3)
+		assert.InDelta(t, 1.5, response.Segments[1].StartTime, 1e-3)
+		assert.InDelta(t, 2.0, response.Segments[1].EndTime, 1e-3)
+		if assert.NotNil(t, response.VAD) {
+			assert.Equal(t, 1, response.VAD.Regions)
+			assert.InDelta(t, 2.0, response.VAD.TotalDuration, 1e-9)
+			assert.InDelta(t, 1.7, response.VAD.SpeechDuration, 0.05)
+		}
+	}
+
+	// Without speech the engine is not run: a tone that never stops is the
+	// noise floor
+	var progress []int
+	left := 0
+	response, err = service.transcribeAudio(context.Background(), bytes.NewReader(wav), "call.wav", TranscriptionOptions{Channel: &left, VAD: true}, nil, func(percent int) { progress = append(progress, percent) })
+	if assert.NoError(t, err) {
+		assert.Empty(t, response.Segments)
+		assert.Empty(t, response.Text)
+		assert.Equal(t, 0, response.VAD.Regions)
+		assert.Zero(t, response.VAD.SpeechDuration)
+	}
+	assert.Equal(t, []int{100}, progress)
+
+	// Without detection there is no report
+	response, err = service.transcribeAudio(context.Background(), bytes.NewReader(wav), "call.wav", TranscriptionOptions{}, nil, nil)
+	if assert.NoError(t, err) {
+		assert.Nil(t, response.VAD)
+	}
+}
+
+// peakEngine records the loudest sample it was given.
+type peakEngine struct {
+	peak float32
+}
+
+func (e *peakEngine) Transcribe(samples []float32, opts TranscriptionOptions, onSegment func(SegmentInfo), onProgress ProgressFunc) error {
+	e.peak = 0
+	for _, v := range samples {
+		e.peak = max(e.peak, v, -v)
+	}
+	return nil
+}
+
+func (e *peakEngine) Close() error { return nil }
+
+func TestTranscribeAudio_Preprocess(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = 16000
+	cfg.Audio.Preprocess.Profile = "radio"
+	cfg.Audio.Preprocess.Profiles = map[string][]string{"radio": {"highpass", "loudnorm"}}
+	engine := &peakEngine{}
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{engine})
+	wav := stereoCallWAV()
+	transcribe := func(preprocess string) (*TranscriptionResponse, error) {
+		opts := service.defaultOptions()
+		if preprocess != "" {
+			opts.Preprocess = preprocess
+		}
+		return service.transcribeAudio(context.Background(), bytes.NewReader(wav), "call.wav", opts, nil, nil)
+	}
+
+	// The configured profile applies by default and is reported
+	response, err := transcribe("")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"highpass", "loudnorm"}, response.Preprocess)
+		assert.Equal(t, "radio", response.Options.Preprocess)
+	}
+	normalized := engine.peak
+
+	// none turns it off, and a request can name its own stages
+	response, err = transcribe("none")
+	if assert.NoError(t, err) {
+		assert.Empty(t, response.Preprocess)
+		assert.Greater(t, engine.peak, normalized)
+	}
+	response, err = transcribe("loudnorm")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"loudnorm"}, response.Preprocess)
+	}
+
+	_, err = transcribe("reverb")
+	assert.Equal(t, http.StatusBadRequest, errorStatus(err))
+}
@@ -0,0 +1,339 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VA7DBI/whisperAPI/audio"
+	"github.com/VA7DBI/whisperAPI/metrics"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// Stream message types.
+const (
+	StreamPartial = "partial" // Current hypothesis for audio that is still changing
+	StreamFinal   = "final"   // Segments that will not change
+	StreamDone    = "done"    // Sent after the remaining audio has been flushed
+	StreamError   = "error"   // Sent before the server closes the stream on an error
+	StreamEnd     = "end"     // Sent by the client to flush and close the stream
+)
+
+// StreamOpus is the /stream encoding for Opus packets, one per message.
+const StreamOpus = "opus"
+
+// Limits for the /stream audio parameters
+const (
+	MinStreamSampleRate = 8000
+	MaxStreamSampleRate = 192000
+	MaxStreamChannels   = 8
+)
+
+// StreamMessage is a JSON message sent to /stream clients. Segment times are
+// seconds from the start of the stream.
+type StreamMessage struct {
+	Type      string        `json:"type"`
+	Segments  []SegmentInfo `json:"segments,omitempty"`
+	AudioTime float64       `json:"audio_time_seconds"` // Audio received so far
+	Error     string        `json:"error,omitempty"`
+}
+
+// StreamControl is a JSON text message sent by /stream clients.
+type StreamControl struct {
+	Type string `json:"type"` // "end"
+}
+
+// streamSession is the state of one /stream connection. Audio is buffered at
+// the client's sample rate from the end of the last final segment; each pass
+// decodes the whole buffer, so text near its end is revised as more audio
+// arrives until it falls outside the overlap.
+type streamSession struct {
+	service *TranscriptionService
+	opts    TranscriptionOptions
+	decode  func(data []byte) ([]float32, error)
+	rate    int // Sample rate of decoded audio
+
+	window  float64
+	step    float64
+	overlap float64
+
+	buf      []float32 // Audio not yet covered by a final segment
+	base     int64     // Stream position of buf[0] in samples
+	received int64     // Samples received since the last pass
+}
+
+// frame is a WebSocket message with its type.
+type frame struct {
+	binary bool
+	data   []byte
+}
+
+// frameCodec receives messages of either type, which websocket.Message cannot
+// tell apart.
+var frameCodec = websocket.Codec{
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		f := v.(*frame)
+		f.binary = payloadType == websocket.BinaryFrame
+		f.data = data
+		return nil
+	},
+}
+
+// StreamHandler transcribes live audio sent over a WebSocket.
+// @Summary     Real-time transcription over WebSocket
+// @Description Upgrades to a WebSocket. The client sends audio as binary messages (raw PCM in any chunk size, or one Opus packet per message) and a {"type":"end"} text message to flush. The server sends JSON StreamMessages: "partial" with the current hypothesis for recent audio, "final" with segments that will not change, then "done". Segment times are relative to the start of the stream. A stream that runs past the maximum audio duration for the API key ends with an "error" message.
+// @Tags        transcription
+// @Produce     json
+// @Param       encoding query string false "Audio encoding: s16le (default), f32le or opus"
+// @Param       sample_rate query integer false "PCM sample rate in Hz (default 16000; Opus is decoded at 48000)"
+// @Param       channels query integer false "Interleaved channels, mixed to mono (default 1)"
+// @Param       language query string false "Spoken language (ISO 639-1 code) or auto to detect"
+// @Param       translate query boolean false "Translate the transcription to English"
+// @Param       initial_prompt query string false "Initial prompt to guide the decoder"
+// @Param       temperature query number false "Sampling temperature (0.0-1.0)"
+// @Param       threads query integer false "Number of decoding threads (up to the configured max_threads)"
+// @Success     101 {object} StreamMessage "Switching protocols; messages follow over the WebSocket"
+// @Failure     400 {object} ErrorResponse "Invalid audio parameters or decoding options"
+// @Failure     401 {object} ErrorResponse "Unauthorized (invalid or missing API key)"
+// @Security    ApiKeyAuth
+// @Router      /stream [get]
+func (s *TranscriptionService) StreamHandler(c *gin.Context) {
+	session, err := s.newStreamSession(c)
+	if err != nil {
+		metrics.TranscriptionRequests.WithLabelValues("error", "stream").Inc()
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	server := websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			ws.MaxPayloadBytes = s.config.Stream.MaxMessageBytes
+			session.run(c.Request.Context(), ws)
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// newStreamSession validates the query parameters of a /stream request.
+func (s *TranscriptionService) newStreamSession(c *gin.Context) (*streamSession, error) {
+	opts, err := s.parseOptionValues(c.GetQuery)
+	if err != nil {
+		return nil, err
+	}
+	opts.MaxDuration = s.maxDuration(c)
+
+	channels := 1
+	if v := c.Query("channels"); v != "" {
+		channels, err = strconv.Atoi(v)
+		if err != nil || channels < 1 || channels > MaxStreamChannels {
+			return nil, fmt.Errorf("invalid channels %q: must be between 1 and %d", v, MaxStreamChannels)
+		}
+	}
+
+	session := &streamSession{
+		service: s,
+		opts:    opts,
+		window:  s.config.Stream.WindowSeconds,
+		step:    s.config.Stream.StepSeconds,
+		overlap: s.config.Stream.OverlapSeconds,
+	}
+	if session.overlap >= session.window {
+		// Otherwise nothing would ever become final
+		session.overlap = session.window / 2
+	}
+
+	encoding := c.DefaultQuery("encoding", audio.PCMS16LE)
+	if encoding == StreamOpus {
+		decoder, err := audio.NewOpusPacketDecoder(channels)
+		if err != nil {
+			return nil, err
+		}
+		session.decode = decoder.Decode
+		session.rate = audio.OpusSampleRate
+		return session, nil
+	}
+
+	decoder, err := audio.NewPCMDecoder(encoding, channels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encoding %q: expected %s, %s or %s", encoding, audio.PCMS16LE, audio.PCMF32LE, StreamOpus)
+	}
+	session.decode = func(data []byte) ([]float32, error) { return decoder.Decode(data), nil }
+
+	session.rate = EngineSampleRate
+	if v := c.Query("sample_rate"); v != "" {
+		session.rate, err = strconv.Atoi(v)
+		if err != nil || session.rate < MinStreamSampleRate || session.rate > MaxStreamSampleRate {
+			return nil, fmt.Errorf("invalid sample_rate %q: must be between %d and %d", v, MinStreamSampleRate, MaxStreamSampleRate)
+		}
+	}
+	return session, nil
+}
+
+// run reads audio from the client until it ends the stream, decoding a pass
+// every step.
+func (st *streamSession) run(ctx context.Context, ws *websocket.Conn) {
+	err := st.receive(ctx, ws)
+	if errors.Is(err, io.EOF) {
+		// The client went away without ending the stream
+		return
+	}
+
+	metrics.AudioDuration.WithLabelValues("stream").Observe(st.audioTime())
+	if err != nil {
+		metrics.TranscriptionRequests.WithLabelValues("error", "stream").Inc()
+		st.send(ws, StreamMessage{Type: StreamError, Error: err.Error()})
+		return
+	}
+	metrics.TranscriptionRequests.WithLabelValues("success", "stream").Inc()
+	st.send(ws, StreamMessage{Type: StreamDone})
+}
+
+// receive handles messages until the client sends an end message, which flushes
+// the remaining audio.
+func (st *streamSession) receive(ctx context.Context, ws *websocket.Conn) error {
+	for {
+		var msg frame
+		if err := frameCodec.Receive(ws, &msg); err != nil {
+			if errors.Is(err, websocket.ErrFrameTooLarge) {
+				return fmt.Errorf("message too large: maximum is %d bytes", ws.MaxPayloadBytes)
+			}
+			return err
+		}
+
+		if !msg.binary {
+			var ctl StreamControl
+			if err := json.Unmarshal(msg.data, &ctl); err != nil || ctl.Type != StreamEnd {
+				return fmt.Errorf("unexpected control message: expected {\"type\":%q}", StreamEnd)
+			}
+			return st.pass(ctx, ws, true)
+		}
+
+		samples, err := st.decode(msg.data)
+		if err != nil {
+			return err
+		}
+		st.buf = append(st.buf, samples...)
+		st.received += int64(len(samples))
+		if st.opts.MaxDuration > 0 && st.audioTime() > st.opts.MaxDuration {
+			return durationError(0, st.opts.MaxDuration)
+		}
+
+		if float64(st.received) >= st.step*float64(st.rate) {
+			st.received = 0
+			if err := st.pass(ctx, ws, false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pass decodes the buffered audio and sends the segments that are now final
+// and a partial hypothesis for the rest. When flushing, every segment is final.
+func (st *streamSession) pass(ctx context.Context, ws *websocket.Conn, flush bool) error {
+	if len(st.buf) == 0 {
+		return nil
+	}
+
+	samples := st.buf
+	if st.rate != EngineSampleRate {
+		samples = audio.ResampleAudio(st.buf, st.rate, EngineSampleRate)
+	}
+	duration := float64(len(samples)) / EngineSampleRate
+
+	engine, err := st.service.pool.Acquire(ctx)
+	if err != nil {
+		return st.service.poolError(err)
+	}
+	var segments []SegmentInfo
+	start := time.Now()
+	err = engine.Transcribe(samples, st.opts, func(seg SegmentInfo) { segments = append(segments, seg) }, nil)
+	if err != nil {
+		st.service.pool.Release(engine, 0, 0)
+		return err
+	}
+	st.service.pool.Release(engine, duration, time.Since(start).Seconds())
+
+	// Segments ending before the overlap will not change. Once the buffer
+	// fills the window, everything that starts before the overlap is
+	// committed so the buffer does not keep growing.
+	stable := duration - st.overlap
+	full := duration >= st.window
+	n := 0
+	for _, seg := range segments {
+		if !flush && seg.EndTime > stable && !(full && seg.StartTime < stable) {
+			break
+		}
+		n++
+	}
+	final, partial := segments[:n], segments[n:]
+
+	offset := float64(st.base) / float64(st.rate)
+	if len(final) > 0 {
+		if err := st.send(ws, StreamMessage{Type: StreamFinal, Segments: shiftSegments(final, offset)}); err != nil {
+			return err
+		}
+	}
+	if !flush {
+		if err := st.send(ws, StreamMessage{Type: StreamPartial, Segments: shiftSegments(partial, offset)}); err != nil {
+			return err
+		}
+	}
+
+	// Drop the audio the final segments cover
+	var commit float64
+	switch {
+	case flush:
+		commit = duration
+	case len(final) > 0:
+		commit = final[len(final)-1].EndTime
+	}
+	if full && commit < stable {
+		// Nothing but silence or partial text before the overlap
+		commit = stable
+	}
+	drop := min(int(commit*float64(st.rate)), len(st.buf))
+	if drop > 0 {
+		st.buf = append(st.buf[:0], st.buf[drop:]...)
+		st.base += int64(drop)
+	}
+	return nil
+}
+
+// send writes a message to the client.
+func (st *streamSession) send(ws *websocket.Conn, msg StreamMessage) error {
+	msg.AudioTime = st.audioTime()
+	return websocket.JSON.Send(ws, msg)
+}
+
+// audioTime returns the seconds of audio received so far.
+func (st *streamSession) audioTime() float64 {
+	return float64(st.base+int64(len(st.buf))) / float64(st.rate)
+}
+
+// shiftSegments returns copies of segments with their times moved by offset seconds.
+func shiftSegments(segments []SegmentInfo, offset float64) []SegmentInfo {
+	shifted := make([]SegmentInfo, len(segments))
+	for i, seg := range segments {
+		seg.StartTime += offset
+		seg.EndTime += offset
+		tokens := make([]TokenInfo, len(seg.Tokens))
+		for j, token := range seg.Tokens {
+			token.StartTime += offset
+			token.EndTime += offset
+			tokens[j] = token
+		}
+		seg.Tokens = tokens
+		shifted[i] = seg
+	}
+	return shifted
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+// secondEngine hears one word per second of audio, with a shorter last word
+// for any remainder.
+type secondEngine struct{}
+
+func (e *secondEngine) Transcribe(samples []float32, opts TranscriptionOptions, onSegment func(SegmentInfo), onProgress ProgressFunc) error {
+	duration := float64(len(samples)) / EngineSampleRate
+	for start := 0.0; start < duration; start++ {
+		end := math.Min(start+1, duration)
+		onSegment(SegmentInfo{
+			Text:      " word",
+			StartTime: start,
+			EndTime:   end,
+			Tokens:    []TokenInfo{{Text: " word", Probability: 0.9, StartTime: start, EndTime: end}},
+		})
+	}
+	return nil
+}
+
+func (e *secondEngine) Close() error {
+	return nil
+}
+
+// setupStreamServer serves /stream with the given engine, applying any
+// changes to the default test configuration.
+func setupStreamServer(t *testing.T, engine Engine, configure ...func(*config.Config)) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.Audio.SampleRate = 16000
+	cfg.Stream.WindowSeconds = 10
+	cfg.Stream.StepSeconds = 1
+	cfg.Stream.OverlapSeconds = 2
+	cfg.Stream.MaxMessageBytes = 1 << 20
+	for _, fn := range configure {
+		fn(cfg)
+	}
+
+	service := NewTranscriptionServiceWithEngines(cfg, []Engine{engine})
+	t.Cleanup(service.Close)
+
+	r := gin.New()
+	r.GET("/stream", service.StreamHandler)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dialStream opens a /stream WebSocket with the given query string.
+func dialStream(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/stream?" + query
+	ws, err := websocket.Dial(url, "", server.URL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// readStream reads messages until the server sends done or error.
+func readStream(t *testing.T, ws *websocket.Conn) []StreamMessage {
+	var messages []StreamMessage
+	for {
+		var msg StreamMessage
+		if !assert.NoError(t, websocket.JSON.Receive(ws, &msg)) {
+			return messages
+		}
+		messages = append(messages, msg)
+		if msg.Type == StreamDone || msg.Type == StreamError {
+			return messages
+		}
+	}
+}
+
+// s16le encodes n samples of a tone as 16-bit PCM.
+func s16le(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		binary.Write(&buf, binary.LittleEndian, int16(8000*math.Sin(float64(i)/10)))
+	}
+	return buf.Bytes()
+}
+
+func TestStreamHandler_SlidingWindow(t *testing.T) {
+	server := setupStreamServer(t, &secondEngine{})
+	ws := dialStream(t, server, "encoding=s16le&sample_rate=16000")
+
+	// 5.5 seconds in 100ms messages, split mid-sample
+	audio := s16le(88000)
+	for len(audio) > 0 {
+		n := min(3201, len(audio))
+		assert.NoError(t, websocket.Message.Send(ws, audio[:n]))
+		audio = audio[n:]
+	}
+	assert.NoError(t, websocket.JSON.Send(ws, StreamControl{Type: StreamEnd}))
+
+	messages := readStream(t, ws)
+	if !assert.NotEmpty(t, messages) {
+		return
+	}
+	done := messages[len(messages)-1]
+	assert.Equal(t, StreamDone, done.Type)
+	assert.InDelta(t, 5.5, done.AudioTime, 1e-9)
+
+	// Final segments cover the stream once, in order, in stream time
+	var finals []SegmentInfo
+	partials := 0
+	for _, msg := range messages {
+		switch msg.Type {
+		case StreamFinal:
+			finals = append(finals, msg.Segments...)
+		case StreamPartial:
+			partials++
+		}
+	}
+	assert.NotZero(t, partials)
+	if assert.Len(t, finals, 6) {
+		for i, seg := range finals {
+			assert.InDelta(t, float64(i), seg.StartTime, 1e-3)
+			assert.InDelta(t, math.Min(float64(i+1), 5.5), seg.EndTime, 1e-3)
+			assert.InDelta(t, seg.StartTime, seg.Tokens[0].StartTime, 1e-9)
+		}
+	}
+
+	// A final segment was sent before the stream ended
+	assert.Equal(t, StreamFinal, messages[2].Type)
+}
+
+func TestStreamHandler_ResamplesAndMixes(t *testing.T) {
+	server := setupStreamServer(t, &secondEngine{})
+	ws := dialStream(t, server, "encoding=f32le&sample_rate=48000&channels=2")
+
+	// 2 seconds of stereo float samples
+	var buf bytes.Buffer
+	for i := 0; i < 2*48000; i++ {
+		v := float32(0.5 * math.Sin(float64(i)/20))
+		binary.Write(&buf, binary.LittleEndian, [2]float32{v, -v})
+	}
+	assert.NoError(t, websocket.Message.Send(ws, buf.Bytes()))
+	assert.NoError(t, websocket.JSON.Send(ws, StreamControl{Type: StreamEnd}))
+
+	messages := readStream(t, ws)
+	done := messages[len(messages)-1]
+	assert.Equal(t, StreamDone, done.Type)
+	assert.InDelta(t, 2.0, done.AudioTime, 1e-9)
+
+	last := messages[len(messages)-2]
+	assert.Equal(t, StreamFinal, last.Type)
+	if assert.NotEmpty(t, last.Segments) {
+		assert.InDelta(t, 2.0, last.Segments[len(last.Segments)-1].EndTime, 1e-3)
+	}
+}
+
+func TestStreamHandler_Errors(t *testing.T) {
+	server := setupStreamServer(t, &secondEngine{})
+
+	for _, query := range []string{
+		"encoding=mp3",
+		"sample_rate=100",
+		"channels=0",
+		"temperature=5",
+	} {
+		resp, err := http.Get(server.URL + "/stream?" + query)
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+			resp.Body.Close()
+		}
+	}
+
+	// Text messages other than end are rejected
+	ws := dialStream(t, server, "")
+	assert.NoError(t, websocket.Message.Send(ws, "hello"))
+	messages := readStream(t, ws)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, StreamError, messages[0].Type)
+		assert.Contains(t, messages[0].Error, "control message")
+	}
+
+	// Engine failures end the stream with an error
+	server = setupStreamServer(t, &FakeEngine{Err: assert.AnError})
+	ws = dialStream(t, server, "")
+	assert.NoError(t, websocket.Message.Send(ws, s16le(16000)))
+	messages = readStream(t, ws)
+	if assert.NotEmpty(t, messages) {
+		assert.Equal(t, StreamError, messages[len(messages)-1].Type)
+	}
+}
+
+func TestStreamHandler_MaxDuration(t *testing.T) {
+	server := setupStreamServer(t, &secondEngine{}, func(cfg *config.Config) {
+		cfg.Audio.MaxDuration = 2
+	})
+	ws := dialStream(t, server, "")
+
+	// The third second runs past the limit
+	for i := 0; i < 3; i++ {
+		if err := websocket.Message.Send(ws, s16le(16000)); err != nil {
+			break
+		}
+	}
+	messages := readStream(t, ws)
+	if assert.NotEmpty(t, messages) {
+		last := messages[len(messages)-1]
+		assert.Equal(t, StreamError, last.Type)
+		assert.Contains(t, last.Error, "maximum of 2 seconds")
+	}
+}
+
+func TestShiftSegments(t *testing.T) {
+	segments := []SegmentInfo{{StartTime: 1, EndTime: 2, Tokens: []TokenInfo{{StartTime: 1.5, EndTime: 2}}}}
+	shifted := shiftSegments(segments, 10)
+	assert.Equal(t, 11.0, shifted[0].StartTime)
+	assert.Equal(t, 12.0, shifted[0].EndTime)
+	assert.Equal(t, 11.5, shifted[0].Tokens[0].StartTime)
+
+	// The input is not modified
+	assert.Equal(t, 1.5, segments[0].Tokens[0].StartTime)
+}
@@ -0,0 +1,521 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Output formats for transcription results
+const (
+	OutputJSON = "json"
+	OutputSRT  = "srt"
+	OutputVTT  = "vtt"
+	OutputTTML = "ttml"
+	OutputASS  = "ass"
+	OutputSSE  = "sse" // Server-Sent Events with segments as they are decoded
+)
+
+// outputContentTypes maps output formats to their response content types.
+var outputContentTypes = map[string]string{
+	OutputJSON: "application/json; charset=utf-8",
+	OutputSRT:  "application/x-subrip; charset=utf-8",
+	OutputVTT:  "text/vtt; charset=utf-8",
+	OutputTTML: "application/ttml+xml; charset=utf-8",
+	OutputASS:  "text/x-ass; charset=utf-8",
+	OutputSSE:  "text/event-stream",
+}
+
+// acceptedMediaTypes maps Accept header media types to output formats.
+var acceptedMediaTypes = map[string]string{
+	"application/json":     OutputJSON,
+	"application/x-subrip": OutputSRT,
+	"text/srt":             OutputSRT,
+	"text/vtt":             OutputVTT,
+	"application/ttml+xml": OutputTTML,
+	"text/x-ass":           OutputASS,
+	"text/x-ssa":           OutputASS,
+	"text/event-stream":    OutputSSE,
+}
+
+// SubtitleOptions controls how segments are split into subtitle cues.
+type SubtitleOptions struct {
+	MaxLineLength int     // Maximum characters per line, 0 keeps each segment on one line
+	MaxLines      int     // Maximum lines per cue, 0 means no limit
+	MinDuration   float64 // Minimum cue duration in seconds
+	WordTimings   bool    // Emit word-level timing (WebVTT <c> tags)
+}
+
+// wordTiming is a word with its start and end time in seconds.
+type wordTiming struct {
+	Text  string
+	Start float64
+	End   float64
+}
+
+// subtitleCue is a timed block of one or more lines of words.
+type subtitleCue struct {
+	Start   float64
+	End     float64
+	Lines   [][]wordTiming
+	Speaker string // Set when the segment was diarized
+}
+
+// parseOutputFormat selects the response format from the "output" or "format" query
+// parameter, falling back to the Accept header. JSON is the default.
+func parseOutputFormat(c *gin.Context) (string, error) {
+	for _, key := range []string{"output", "format"} {
+		if v := strings.ToLower(c.Query(key)); v != "" {
+			if v == "webvtt" {
+				v = OutputVTT
+			}
+			if _, ok := outputContentTypes[v]; !ok {
+				return "", fmt.Errorf("unsupported output format %q: must be one of json, srt, vtt, ttml, ass or sse", v)
+			}
+			return v, nil
+		}
+	}
+
+	for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if format, ok := acceptedMediaTypes[mediaType]; ok {
+			return format, nil
+		}
+	}
+
+	return OutputJSON, nil
+}
+
+// parseSubtitleOptions reads the subtitle layout options from the query string or form,
+// on top of the configured defaults.
+func (s *TranscriptionService) parseSubtitleOptions(c *gin.Context) (SubtitleOptions, error) {
+	opts := SubtitleOptions{
+		MaxLineLength: s.config.Subtitles.MaxLineLength,
+		MaxLines:      s.config.Subtitles.MaxLines,
+		MinDuration:   s.config.Subtitles.MinDuration,
+	}
+
+	value := func(key string) string {
+		if v := c.Query(key); v != "" {
+			return v
+		}
+		return c.PostForm(key)
+	}
+
+	if v := value("max_line_length"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 200 {
+			return opts, fmt.Errorf("invalid max_line_length %q: must be between 0 and 200", v)
+		}
+		opts.MaxLineLength = n
+	}
+	if v := value("max_lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 10 {
+			return opts, fmt.Errorf("invalid max_lines %q: must be between 0 and 10", v)
+		}
+		opts.MaxLines = n
+	}
+	if v := value("min_duration"); v != "" {
+		d, err := strconv.ParseFloat(v, 64)
+		if err != nil || d < 0 || d > 10 {
+			return opts, fmt.Errorf("invalid min_duration %q: must be between 0 and 10 seconds", v)
+		}
+		opts.MinDuration = d
+	}
+	if v := value("word_timestamps"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid word_timestamps value %q: expected true or false", v)
+		}
+		opts.WordTimings = b
+	}
+
+	return opts, nil
+}
+
+// renderSubtitles renders a transcription in the given subtitle format.
+func renderSubtitles(format string, response *TranscriptionResponse, opts SubtitleOptions) (string, error) {
+	cues := buildCues(response.Segments, opts)
+
+	switch format {
+	case OutputSRT:
+		return renderSRT(cues), nil
+	case OutputVTT:
+		return renderVTT(cues, opts.WordTimings), nil
+	case OutputTTML:
+		return renderTTML(cues, response.Options.Language)
+	case OutputASS:
+		return renderASS(cues), nil
+	default:
+		return "", fmt.Errorf("unsupported subtitle format %q", format)
+	}
+}
+
+// segmentWords groups the text tokens of a segment into timed words. A new word
+// starts at every token that begins with a space. If the tokens carry no usable
+// timestamps the segment duration is spread over the words by length.
+func segmentWords(seg SegmentInfo) []wordTiming {
+	var words []wordTiming
+	timed := true
+	for _, token := range seg.Tokens {
+		if isSpecialToken(token.Text) || token.Text == "" {
+			continue
+		}
+		if token.EndTime <= token.StartTime {
+			timed = false
+		}
+		if len(words) == 0 || strings.HasPrefix(token.Text, " ") {
+			words = append(words, wordTiming{
+				Text:  strings.TrimSpace(token.Text),
+				Start: token.StartTime,
+				End:   token.EndTime,
+			})
+			continue
+		}
+		last := &words[len(words)-1]
+		last.Text += token.Text
+		last.End = token.EndTime
+	}
+
+	// Drop tokens that were only whitespace
+	result := words[:0]
+	for _, w := range words {
+		if w.Text != "" {
+			result = append(result, w)
+		}
+	}
+
+	// Fall back to the segment text when there are no tokens at all
+	if len(result) == 0 {
+		for _, field := range strings.Fields(seg.Text) {
+			result = append(result, wordTiming{Text: field})
+		}
+		timed = false
+	}
+
+	if !timed {
+		spreadWordTimings(result, seg.StartTime, seg.EndTime)
+	}
+	return result
+}
+
+// spreadWordTimings assigns word times proportionally to their length.
+func spreadWordTimings(words []wordTiming, start, end float64) {
+	total := 0
+	for _, w := range words {
+		total += utf8.RuneCountInString(w.Text)
+	}
+	if total == 0 {
+		return
+	}
+
+	pos := start
+	perRune := (end - start) / float64(total)
+	for i := range words {
+		words[i].Start = pos
+		pos += float64(utf8.RuneCountInString(words[i].Text)) * perRune
+		words[i].End = pos
+	}
+}
+
+// buildCues splits segments into cues that respect the line length and line count
+// limits, using word timestamps for the cue boundaries.
+func buildCues(segments []SegmentInfo, opts SubtitleOptions) []subtitleCue {
+	var cues []subtitleCue
+
+	for _, seg := range segments {
+		words := segmentWords(seg)
+		if len(words) == 0 {
+			continue
+		}
+
+		if opts.MaxLineLength <= 0 {
+			cues = append(cues, subtitleCue{Start: seg.StartTime, End: seg.EndTime, Lines: [][]wordTiming{words}, Speaker: seg.Speaker})
+			continue
+		}
+
+		first := len(cues)
+		var lines [][]wordTiming
+		var line []wordTiming
+		lineLen := 0
+
+		flushCue := func() {
+			if len(lines) == 0 {
+				return
+			}
+			lastLine := lines[len(lines)-1]
+			cues = append(cues, subtitleCue{
+				Start:   lines[0][0].Start,
+				End:     lastLine[len(lastLine)-1].End,
+				Lines:   lines,
+				Speaker: seg.Speaker,
+			})
+			lines = nil
+		}
+		flushLine := func() {
+			if len(line) == 0 {
+				return
+			}
+			lines = append(lines, line)
+			line = nil
+			lineLen = 0
+			if opts.MaxLines > 0 && len(lines) >= opts.MaxLines {
+				flushCue()
+			}
+		}
+
+		for _, w := range words {
+			wordLen := utf8.RuneCountInString(w.Text)
+			if len(line) > 0 && lineLen+1+wordLen > opts.MaxLineLength {
+				flushLine()
+			}
+			if len(line) > 0 {
+				lineLen++
+			}
+			line = append(line, w)
+			lineLen += wordLen
+		}
+		flushLine()
+		flushCue()
+
+		// Keep the segment boundaries on the outer cues
+		if len(cues) > first {
+			cues[first].Start = seg.StartTime
+			cues[len(cues)-1].End = math.Max(cues[len(cues)-1].End, seg.EndTime)
+		}
+	}
+
+	// Enforce the minimum cue duration without overlapping the next cue
+	if opts.MinDuration > 0 {
+		for i := range cues {
+			if cues[i].End-cues[i].Start >= opts.MinDuration {
+				continue
+			}
+			end := cues[i].Start + opts.MinDuration
+			if i+1 < len(cues) && end > cues[i+1].Start {
+				end = math.Max(cues[i+1].Start, cues[i].End)
+			}
+			cues[i].End = end
+		}
+	}
+
+	return cues
+}
+
+// cueLineText joins the words of a cue line.
+func cueLineText(line []wordTiming) string {
+	parts := make([]string, len(line))
+	for i, w := range line {
+		parts[i] = w.Text
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatTimestamp formats seconds as HH:MM:SS followed by the separator and milliseconds.
+func formatTimestamp(seconds float64, sep string) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	ms := int64(math.Round(seconds * 1000))
+	hours := ms / 3_600_000
+	ms -= hours * 3_600_000
+	minutes := ms / 60_000
+	ms -= minutes * 60_000
+	secs := ms / 1000
+	ms -= secs * 1000
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", hours, minutes, secs, sep, ms)
+}
+
+// formatASSTimestamp formats seconds as H:MM:SS.cc as used by ASS subtitles.
+func formatASSTimestamp(seconds float64) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	cs := int64(math.Round(seconds * 100))
+	hours := cs / 360_000
+	cs -= hours * 360_000
+	minutes := cs / 6000
+	cs -= minutes * 6000
+	secs := cs / 100
+	cs -= secs * 100
+	return fmt.Sprintf("%d:%02d:%02d.%02d", hours, minutes, secs, cs)
+}
+
+// renderSRT renders cues as SubRip (.srt) subtitles.
+func renderSRT(cues []subtitleCue) string {
+	var b strings.Builder
+	for i, cue := range cues {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n", i+1, formatTimestamp(cue.Start, ","), formatTimestamp(cue.End, ","))
+		for l, line := range cue.Lines {
+			if l == 0 && cue.Speaker != "" {
+				fmt.Fprintf(&b, "[%s] ", cue.Speaker)
+			}
+			b.WriteString(cueLineText(line))
+			b.WriteByte('\n')
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// renderVTT renders cues as WebVTT (.vtt) subtitles, optionally with word-level
+// <c> timing tags.
+func renderVTT(cues []subtitleCue, wordTimings bool) string {
+	escaper := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, cue := range cues {
+		fmt.Fprintf(&b, "%s --> %s\n", formatTimestamp(cue.Start, "."), formatTimestamp(cue.End, "."))
+		for l, line := range cue.Lines {
+			if l == 0 && cue.Speaker != "" {
+				// The voice span runs to the end of the cue
+				fmt.Fprintf(&b, "<v %s>", escaper.Replace(cue.Speaker))
+			}
+			if !wordTimings {
+				b.WriteString(escaper.Replace(cueLineText(line)))
+				b.WriteByte('\n')
+				continue
+			}
+			for i, w := range line {
+				if l == 0 && i == 0 {
+					// The cue start time covers the first word
+					b.WriteString(escaper.Replace(w.Text))
+					continue
+				}
+				sep := " "
+				if i == 0 {
+					sep = ""
+				}
+				fmt.Fprintf(&b, "<%s><c>%s%s</c>", formatTimestamp(w.Start, "."), sep, escaper.Replace(w.Text))
+			}
+			b.WriteByte('\n')
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// renderTTML renders cues as a TTML document.
+func renderTTML(cues []subtitleCue, language string) (string, error) {
+	if language == "" || language == "auto" {
+		language = "en"
+	}
+
+	// Speakers are declared as agents that cues refer to
+	var speakers []string
+	for _, cue := range cues {
+		if cue.Speaker != "" && !slices.Contains(speakers, cue.Speaker) {
+			speakers = append(speakers, cue.Speaker)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	if len(speakers) == 0 {
+		fmt.Fprintf(&b, "<tt xmlns=\"http://www.w3.org/ns/ttml\" xml:lang=\"%s\">\n", xmlAttr(language))
+	} else {
+		fmt.Fprintf(&b, "<tt xmlns=\"http://www.w3.org/ns/ttml\" xmlns:ttm=\"http://www.w3.org/ns/ttml#metadata\" xml:lang=\"%s\">\n", xmlAttr(language))
+		b.WriteString("  <head>\n    <metadata>\n")
+		for _, speaker := range speakers {
+			fmt.Fprintf(&b, "      <ttm:agent xml:id=\"%s\" type=\"person\"/>\n", xmlAttr(speaker))
+		}
+		b.WriteString("    </metadata>\n  </head>\n")
+	}
+	b.WriteString("  <body>\n    <div>\n")
+	for _, cue := range cues {
+		fmt.Fprintf(&b, "      <p begin=\"%s\" end=\"%s\"", formatTimestamp(cue.Start, "."), formatTimestamp(cue.End, "."))
+		if cue.Speaker != "" {
+			fmt.Fprintf(&b, " ttm:agent=\"%s\"", xmlAttr(cue.Speaker))
+		}
+		b.WriteString(">")
+		for i, line := range cue.Lines {
+			if i > 0 {
+				b.WriteString("<br/>")
+			}
+			if err := xml.EscapeText(&b, []byte(cueLineText(line))); err != nil {
+				return "", err
+			}
+		}
+		b.WriteString("</p>\n")
+	}
+	b.WriteString("    </div>\n  </body>\n</tt>\n")
+	return b.String(), nil
+}
+
+// xmlAttr escapes s for use as an XML attribute value.
+func xmlAttr(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s)) // Writing to a strings.Builder cannot fail
+	return b.String()
+}
+
+// assHeader is the script header and default style for ASS output.
+const assHeader = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 384
+PlayResY: 288
+WrapStyle: 0
+ScaledBorderAndShadow: yes
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,20,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,2,0,2,10,10,10,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`
+
+// renderASS renders cues as ASS subtitles with karaoke (\k) word timing.
+func renderASS(cues []subtitleCue) string {
+	// Braces start override blocks in ASS, so replace them in the text
+	escaper := strings.NewReplacer("{", "(", "}", ")", "\n", " ")
+
+	var b strings.Builder
+	b.WriteString(assHeader)
+	for _, cue := range cues {
+		fmt.Fprintf(&b, "Dialogue: 0,%s,%s,Default,%s,0,0,0,,", formatASSTimestamp(cue.Start), formatASSTimestamp(cue.End), strings.ReplaceAll(cue.Speaker, ",", " "))
+		pos := cue.Start
+		for l, line := range cue.Lines {
+			if l > 0 {
+				b.WriteString(`\N`)
+			}
+			for i, w := range line {
+				// Account for silence before the word
+				if gap := centiseconds(w.Start - pos); gap > 0 {
+					fmt.Fprintf(&b, `{\k%d}`, gap)
+				}
+				sep := " "
+				if i == len(line)-1 {
+					sep = ""
+				}
+				fmt.Fprintf(&b, `{\k%d}%s%s`, centiseconds(w.End-math.Max(w.Start, pos)), escaper.Replace(w.Text), sep)
+				pos = math.Max(pos, w.End)
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// centiseconds converts seconds to whole centiseconds, clamped at zero.
+func centiseconds(seconds float64) int {
+	if seconds <= 0 {
+		return 0
+	}
+	return int(math.Round(seconds * 100))
+}
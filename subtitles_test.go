@@ -0,0 +1,217 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatTimestamps(t *testing.T) {
+	assert.Equal(t, "00:00:00,000", formatTimestamp(0, ","))
+	assert.Equal(t, "01:02:03.457", formatTimestamp(3723.4567, "."))
+	assert.Equal(t, "0:00:01.50", formatASSTimestamp(1.5))
+	assert.Equal(t, "1:02:03.46", formatASSTimestamp(3723.4567))
+}
+
+func TestSegmentWords(t *testing.T) {
+	words := segmentWords(testSegments()[0])
+	assert.Equal(t, []wordTiming{
+		{Text: "Hello", Start: 0, End: 0.5},
+		{Text: "world.", Start: 0.5, End: 1.3},
+	}, words)
+
+	// Without token timestamps the segment is spread over the words by length
+	untimed := SegmentInfo{Text: "ab cd", StartTime: 2, EndTime: 3, Tokens: []TokenInfo{
+		{Text: " ab"}, {Text: " cd"},
+	}}
+	words = segmentWords(untimed)
+	assert.Len(t, words, 2)
+	assert.InDelta(t, 2.0, words[0].Start, 1e-9)
+	assert.InDelta(t, 2.5, words[0].End, 1e-9)
+	assert.InDelta(t, 3.0, words[1].End, 1e-9)
+}
+
+func TestBuildCues_SplitsLongSegments(t *testing.T) {
+	seg := SegmentInfo{Text: "one two three four five six", StartTime: 10, EndTime: 16}
+	for i, w := range strings.Fields(seg.Text) {
+		start := 10 + float64(i)
+		seg.Tokens = append(seg.Tokens, TokenInfo{Text: " " + w, StartTime: start, EndTime: start + 1})
+	}
+
+	cues := buildCues([]SegmentInfo{seg}, SubtitleOptions{MaxLineLength: 9, MaxLines: 2})
+	if assert.Len(t, cues, 2) {
+		assert.Equal(t, []string{"one two", "three"}, cueTexts(cues[0]))
+		assert.Equal(t, []string{"four five", "six"}, cueTexts(cues[1]))
+		assert.InDelta(t, 10.0, cues[0].Start, 1e-9)
+		assert.InDelta(t, 13.0, cues[0].End, 1e-9)
+		assert.InDelta(t, 13.0, cues[1].Start, 1e-9)
+		assert.InDelta(t, 16.0, cues[1].End, 1e-9)
+	}
+
+	// No line limit keeps the segment as a single cue
+	cues = buildCues([]SegmentInfo{seg}, SubtitleOptions{})
+	assert.Len(t, cues, 1)
+}
+
+func TestBuildCues_MinDuration(t *testing.T) {
+	segments := []SegmentInfo{
+		{Text: "Hi.", StartTime: 0, EndTime: 0.2},
+		{Text: "There.", StartTime: 0.5, EndTime: 1.0},
+		{Text: "End.", StartTime: 5, EndTime: 5.1},
+	}
+
+	cues := buildCues(segments, SubtitleOptions{MinDuration: 1})
+	assert.InDelta(t, 0.5, cues[0].End, 1e-9, "extension stops at the next cue")
+	assert.InDelta(t, 1.5, cues[1].End, 1e-9)
+	assert.InDelta(t, 6.0, cues[2].End, 1e-9)
+}
+
+func TestRenderSRTAndVTT(t *testing.T) {
+	cues := buildCues(testSegments(), SubtitleOptions{})
+
+	assert.Equal(t, "1\n00:00:00,000 --> 00:00:01,500\nHello world.\n\n"+
+		"2\n00:01:01,250 --> 01:02:03,500\nOver.\n\n", renderSRT(cues))
+
+	assert.Equal(t, "WEBVTT\n\n00:00:00.000 --> 00:00:01.500\nHello world.\n\n"+
+		"00:01:01.250 --> 01:02:03.500\nOver.\n\n", renderVTT(cues, false))
+
+	assert.Equal(t, "WEBVTT\n\n00:00:00.000 --> 00:00:01.500\nHello<00:00:00.500><c> world.</c>\n\n"+
+		"00:01:01.250 --> 01:02:03.500\nOver.\n\n", renderVTT(cues, true))
+}
+
+func TestRenderTTML(t *testing.T) {
+	segments := []SegmentInfo{{Text: "Fish & chips <now>", StartTime: 1, EndTime: 2}}
+	ttml, err := renderTTML(buildCues(segments, SubtitleOptions{MaxLineLength: 11}), "en")
+	assert.NoError(t, err)
+	assert.Contains(t, ttml, `xml:lang="en"`)
+	assert.Contains(t, ttml, `<p begin="00:00:01.000" end="00:00:02.000">Fish &amp;<br/>chips &lt;now&gt;</p>`)
+
+	// The document must be well-formed XML
+	decoder := xml.NewDecoder(strings.NewReader(ttml))
+	for {
+		if _, err := decoder.Token(); err != nil {
+			assert.Equal(t, "EOF", err.Error())
+			break
+		}
+	}
+}
+
+func TestRenderASS(t *testing.T) {
+	ass := renderASS(buildCues(testSegments()[:1], SubtitleOptions{}))
+	assert.True(t, strings.HasPrefix(ass, "[Script Info]"))
+	assert.Contains(t, ass, `Dialogue: 0,0:00:00.00,0:00:01.50,Default,,0,0,0,,{\k50}Hello {\k80}world.`)
+}
+
+func TestRenderSubtitles_Speakers(t *testing.T) {
+	segments := []SegmentInfo{
+		{Text: " Unit 12, status?", StartTime: 0, EndTime: 1.5, Speaker: "SPEAKER_00"},
+		{Text: " Available.", StartTime: 2, EndTime: 3, Speaker: "SPEAKER_01"},
+	}
+	cues := buildCues(segments, SubtitleOptions{MaxLineLength: 8})
+	if assert.Len(t, cues, 2) {
+		assert.Equal(t, "SPEAKER_00", cues[0].Speaker)
+	}
+
+	assert.Equal(t, "1\n00:00:00,000 --> 00:00:01,500\n[SPEAKER_00] Unit 12,\nstatus?\n\n"+
+		"2\n00:00:02,000 --> 00:00:03,000\n[SPEAKER_01] Available.\n\n", renderSRT(cues))
+	assert.Contains(t, renderVTT(cues, false), "00:00:02.000 --> 00:00:03.000\n<v SPEAKER_01>Available.\n")
+	assert.Contains(t, renderASS(cues), "Dialogue: 0,0:00:02.00,0:00:03.00,Default,SPEAKER_01,0,0,0,,")
+
+	ttml, err := renderTTML(cues, "en")
+	assert.NoError(t, err)
+	assert.Contains(t, ttml, `<ttm:agent xml:id="SPEAKER_01" type="person"/>`)
+	assert.Contains(t, ttml, `<p begin="00:00:02.000" end="00:00:03.000" ttm:agent="SPEAKER_01">Available.</p>`)
+	decoder := xml.NewDecoder(strings.NewReader(ttml))
+	for {
+		if _, err := decoder.Token(); err != nil {
+			assert.Equal(t, "EOF", err.Error())
+			break
+		}
+	}
+}
+
+func TestRenderTTML_EscapesAttributes(t *testing.T) {
+	cues := []subtitleCue{{Start: 0, End: 1, Lines: [][]wordTiming{{{Text: "Hello", Start: 0, End: 1}}}, Speaker: `A&B "x"`}}
+	ttml, err := renderTTML(cues, `en" foo="bar`)
+	assert.NoError(t, err)
+	assert.Contains(t, ttml, `xml:lang="en&#34; foo=&#34;bar"`)
+	assert.Contains(t, ttml, `<ttm:agent xml:id="A&amp;B &#34;x&#34;" type="person"/>`)
+
+	decoder := xml.NewDecoder(strings.NewReader(ttml))
+	for {
+		if _, err := decoder.Token(); err != nil {
+			assert.Equal(t, "EOF", err.Error())
+			break
+		}
+	}
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		url    string
+		accept string
+		want   string
+		err    bool
+	}{
+		{"/transcribe", "", OutputJSON, false},
+		{"/transcribe?output=srt", "", OutputSRT, false},
+		{"/transcribe?format=WebVTT", "", OutputVTT, false},
+		{"/transcribe", "text/vtt", OutputVTT, false},
+		{"/transcribe", "text/html, application/ttml+xml;q=0.9", OutputTTML, false},
+		{"/transcribe?output=ass", "text/vtt", OutputASS, false},
+		{"/transcribe?output=docx", "", "", true},
+	}
+
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", tt.url, nil)
+		if tt.accept != "" {
+			c.Request.Header.Set("Accept", tt.accept)
+		}
+
+		got, err := parseOutputFormat(c)
+		if tt.err {
+			assert.Error(t, err, tt.url)
+			continue
+		}
+		assert.NoError(t, err, tt.url)
+		assert.Equal(t, tt.want, got, tt.url)
+	}
+}
+
+func TestParseSubtitleOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.Subtitles.MaxLineLength = 42
+	cfg.Subtitles.MaxLines = 2
+	s := &TranscriptionService{config: cfg}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/transcribe?max_line_length=30&min_duration=1.5&word_timestamps=true", nil)
+	opts, err := s.parseSubtitleOptions(c)
+	assert.NoError(t, err)
+	assert.Equal(t, SubtitleOptions{MaxLineLength: 30, MaxLines: 2, MinDuration: 1.5, WordTimings: true}, opts)
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/transcribe?max_lines=-1", nil)
+	_, err = s.parseSubtitleOptions(c)
+	assert.Error(t, err)
+}
+
+func cueTexts(cue subtitleCue) []string {
+	lines := make([]string, len(cue.Lines))
+	for i, line := range cue.Lines {
+		lines[i] = cueLineText(line)
+	}
+	return lines
+}
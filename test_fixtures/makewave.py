@@ -1,6 +1,7 @@
 # Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
 # SPDX-License-Identifier: BSD-3-Clause
  
+import os
 import sys
 from gtts import gTTS
 from pydub import AudioSegment
@@ -36,14 +37,19 @@ audio.export(ogg_path, format="ogg", codec="libvorbis",
             parameters=["-ar", "16000", "-ac", "1"])
 print(f"OGG/Vorbis file saved as {ogg_path}")
 
-# Export as Opus (using opusenc directly)
+# Export as Opus (using opusenc directly). Low-bitrate 16 kHz speech is
+# encoded with SILK, which the pure-Go decoder used without cgo supports.
+opus_wav_path = "test_16k.wav"
+audio.set_frame_rate(16000).export(opus_wav_path, format="wav")
 opus_path = "test.opus"
 subprocess.run([
     "opusenc",
-    "--bitrate", "64",
-    wav_path,
+    "--speech",
+    "--bitrate", "24",
+    opus_wav_path,
     opus_path
 ], check=True)
+os.remove(opus_wav_path)
 print(f"Opus file saved as {opus_path}")
 
 # Export as FLAC
@@ -60,4 +66,13 @@ try:
 except Exception as e:
     print(f"Warning: Failed to create AAC file (ffmpeg/aac encoder may not be available): {e}")
 
+# Export as M4A, the same AAC in an MP4 container
+m4a_path = "test.m4a"
+try:
+    audio.export(m4a_path, format="ipod", codec="aac",
+                parameters=["-ar", "16000", "-ac", "1", "-b:a", "64k"])
+    print(f"M4A file saved as {m4a_path}")
+except Exception as e:
+    print(f"Warning: Failed to create M4A file (ffmpeg/aac encoder may not be available): {e}")
+
 print("\nAll audio files generated successfully!")
\ No newline at end of file
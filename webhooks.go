@@ -0,0 +1,208 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/VA7DBI/whisperAPI/metrics"
+)
+
+// Webhook request headers. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the signing secret.
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookSender delivers signed job notifications with retries.
+type WebhookSender struct {
+	client          *http.Client
+	secret          string
+	tokenSecrets    map[string]string // Keyed by token hash
+	allowedNetworks []*net.IPNet
+	maxAttempts     int
+	backoff         time.Duration
+	maxBackoff      time.Duration
+	now             func() time.Time
+}
+
+// NewWebhookSender creates a webhook sender from the configuration.
+func NewWebhookSender(cfg *config.Config) *WebhookSender {
+	tokenSecrets := make(map[string]string, len(cfg.Webhooks.TokenSecrets))
+	for token, secret := range cfg.Webhooks.TokenSecrets {
+		tokenSecrets[hashToken(token)] = secret
+	}
+
+	var allowedNetworks []*net.IPNet
+	for _, cidr := range cfg.Webhooks.AllowedNetworks {
+		// LoadConfig has already rejected entries that do not parse
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			allowedNetworks = append(allowedNetworks, network)
+		}
+	}
+
+	maxAttempts := cfg.Webhooks.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	w := &WebhookSender{
+		secret:          cfg.Webhooks.Secret,
+		tokenSecrets:    tokenSecrets,
+		allowedNetworks: allowedNetworks,
+		maxAttempts:     maxAttempts,
+		backoff:         time.Duration(cfg.Webhooks.InitialBackoff) * time.Second,
+		maxBackoff:      time.Duration(cfg.Webhooks.MaxBackoff) * time.Second,
+		now:             time.Now,
+	}
+
+	// Addresses are checked as they are dialled, after DNS resolution and on
+	// every redirect, so a callback host cannot resolve to an internal service.
+	// Proxies are not used, as they would make the checked address the proxy's.
+	dialer := &net.Dialer{Timeout: 30 * time.Second, Control: w.checkDial}
+	w.client = &http.Client{
+		Timeout:   time.Duration(cfg.Webhooks.Timeout) * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext, ForceAttemptHTTP2: true},
+	}
+	return w
+}
+
+// allowedAddress reports whether callbacks may connect to ip: any public
+// address, and private, loopback or link-local ones only within the
+// configured allowed networks.
+func (w *WebhookSender) allowedAddress(ip net.IP) bool {
+	for _, network := range w.allowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// checkDial refuses connections to addresses callbacks may not reach.
+func (w *WebhookSender) checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !w.allowedAddress(ip) {
+		return fmt.Errorf("callback address %s is not allowed: private, loopback and link-local networks must be listed in webhooks.allowed_networks", host)
+	}
+	return nil
+}
+
+// hashToken returns the hex SHA-256 of an API token, so jobs never store the token itself.
+func hashToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// secretFor returns the signing secret for a token hash, falling back to the
+// configured default secret.
+func (w *WebhookSender) secretFor(tokenHash string) string {
+	if secret, ok := w.tokenSecrets[tokenHash]; ok && tokenHash != "" {
+		return secret
+	}
+	return w.secret
+}
+
+// validateCallbackURL checks that a callback URL is an absolute http(s) URL.
+func validateCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid callback_url %q: must be an absolute http or https URL", raw)
+	}
+	return nil
+}
+
+// signWebhook returns the signature header value for a payload sent at timestamp.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send posts the payload to the callback URL, retrying with exponential backoff
+// until it is accepted, the attempts run out or ctx is cancelled. It returns the
+// number of attempts made and the last error.
+func (w *WebhookSender) Send(ctx context.Context, callbackURL, tokenHash, event string, body []byte) (int, error) {
+	secret := w.secretFor(tokenHash)
+	delay := w.backoff
+
+	var lastErr error
+	for attempt := 1; attempt <= w.maxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return attempt - 1, ctx.Err()
+			}
+			delay *= 2
+			if w.maxBackoff > 0 && delay > w.maxBackoff {
+				delay = w.maxBackoff
+			}
+		}
+
+		lastErr = w.post(ctx, callbackURL, secret, event, body)
+		if lastErr == nil {
+			metrics.WebhookDeliveries.WithLabelValues("success").Inc()
+			return attempt, nil
+		}
+		metrics.WebhookDeliveries.WithLabelValues("error").Inc()
+	}
+
+	metrics.WebhookDeliveries.WithLabelValues("dead_letter").Inc()
+	return w.maxAttempts, lastErr
+}
+
+// post makes a single signed delivery attempt.
+func (w *WebhookSender) post(ctx context.Context, callbackURL, secret, event string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	// A fresh timestamp per attempt lets receivers reject stale replays
+	timestamp := w.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "whisperAPI-webhook")
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, signWebhook(secret, timestamp, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback returned status %d", resp.StatusCode)
+	}
+	return nil
+}
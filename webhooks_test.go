@@ -0,0 +1,229 @@
+// Copyright (c) 2024-2025 Darcy Buskermolen <darcy@dbitech.ca>
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VA7DBI/whisperAPI/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWebhookSender(maxAttempts int) *WebhookSender {
+	cfg := &config.Config{}
+	cfg.Webhooks.Secret = "default-secret"
+	cfg.Webhooks.TokenSecrets = map[string]string{"token-a": "secret-a"}
+	cfg.Webhooks.MaxAttempts = maxAttempts
+	cfg.Webhooks.Timeout = 5
+	// Test servers listen on loopback
+	cfg.Webhooks.AllowedNetworks = []string{"127.0.0.0/8", "::1/128"}
+
+	sender := NewWebhookSender(cfg)
+	sender.backoff = time.Millisecond
+	return sender
+}
+
+// webhookRecorder is a callback endpoint that fails a number of times before accepting.
+type webhookRecorder struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.requests = append(rec.requests, r)
+	rec.bodies = append(rec.bodies, body)
+	if len(rec.requests) <= rec.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rec *webhookRecorder) count() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return len(rec.requests)
+}
+
+func (rec *webhookRecorder) get(i int) (*http.Request, []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.requests[i], rec.bodies[i]
+}
+
+func TestSignWebhook(t *testing.T) {
+	assert.Equal(t, "sha256=5ad265e6615b64b835cae994e1526056136c85c5a0d090d4f35b730288b456de",
+		signWebhook("secret", 1700000000, []byte(`{"id":"abc"}`)))
+}
+
+func TestWebhookSender_SecretFor(t *testing.T) {
+	sender := newTestWebhookSender(1)
+	assert.Equal(t, "secret-a", sender.secretFor(hashToken("token-a")))
+	assert.Equal(t, "default-secret", sender.secretFor(hashToken("token-b")))
+	assert.Equal(t, "default-secret", sender.secretFor(""))
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	assert.NoError(t, validateCallbackURL("https://example.com/hooks/whisper"))
+	assert.NoError(t, validateCallbackURL("http://10.0.0.5:9000/cb"))
+	assert.Error(t, validateCallbackURL("ftp://example.com/cb"))
+	assert.Error(t, validateCallbackURL("/relative"))
+	assert.Error(t, validateCallbackURL("https://"))
+}
+
+func TestWebhookSender_AllowedAddress(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Webhooks.AllowedNetworks = []string{"10.1.0.0/16"}
+	sender := NewWebhookSender(cfg)
+
+	for addr, allowed := range map[string]bool{
+		"93.184.215.14":    true,
+		"2606:4700::1111":  true,
+		"10.1.2.3":         true,
+		"10.2.0.1":         false,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"169.254.169.254":  false,
+		"192.168.1.1":      false,
+		"172.16.0.1":       false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+		"0.0.0.0":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		assert.Equal(t, allowed, sender.allowedAddress(net.ParseIP(addr)), addr)
+	}
+}
+
+func TestWebhookSender_RejectsPrivateAddress(t *testing.T) {
+	rec := &webhookRecorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.Webhooks.Secret = "default-secret"
+	cfg.Webhooks.Timeout = 5
+
+	attempts, err := NewWebhookSender(cfg).Send(context.Background(), server.URL, "", "job.done", []byte(`{}`))
+	assert.ErrorContains(t, err, "not allowed")
+	assert.Equal(t, 1, attempts)
+	assert.Zero(t, rec.count())
+}
+
+func TestWebhookSender_RetriesWithSignature(t *testing.T) {
+	rec := &webhookRecorder{failures: 2}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	sender := newTestWebhookSender(5)
+	sender.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	body := []byte(`{"id":"abc"}`)
+	attempts, err := sender.Send(context.Background(), server.URL, hashToken("token-a"), "job.done", body)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 3, rec.count())
+
+	req, received := rec.get(2)
+	assert.Equal(t, "job.done", req.Header.Get(WebhookEventHeader))
+	assert.Equal(t, "1700000000", req.Header.Get(WebhookTimestampHeader))
+	assert.Equal(t, signWebhook("secret-a", 1700000000, body), req.Header.Get(WebhookSignatureHeader))
+	assert.Equal(t, body, received)
+}
+
+func TestWebhookSender_GivesUp(t *testing.T) {
+	rec := &webhookRecorder{failures: 100}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	attempts, err := newTestWebhookSender(3).Send(context.Background(), server.URL, "", "job.failed", []byte(`{}`))
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 3, rec.count())
+}
+
+func TestJobWebhooks(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		status   string
+	}{
+		{"delivered", 1, "delivered"},
+		{"dead letter", 100, "failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &webhookRecorder{failures: tt.failures}
+			server := httptest.NewServer(rec)
+			defer server.Close()
+
+			manager, r := setupJobTest(t, func(context.Context, string, TranscriptionOptions, ProgressFunc) (*TranscriptionResponse, error) {
+				return &TranscriptionResponse{Text: "hello"}, nil
+			})
+			manager.webhooks = newTestWebhookSender(3)
+			assert.NoError(t, manager.Start())
+			defer manager.Stop()
+
+			job := submitJob(t, r, map[string]string{"callback_url": server.URL})
+			assert.Equal(t, "pending", job.WebhookStatus)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for job.WebhookStatus == "pending" && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+				_, job = jobRequest(r, "GET", job.ID)
+			}
+			assert.Equal(t, tt.status, job.WebhookStatus)
+
+			// The payload is the final job state, signed with the default secret
+			req, body := rec.get(0)
+			var payload JobResponse
+			assert.NoError(t, json.Unmarshal(body, &payload))
+			assert.Equal(t, "done", payload.Status)
+			assert.Equal(t, "hello", payload.Result.Text)
+			assert.Empty(t, payload.WebhookStatus)
+
+			timestamp, _ := strconv.ParseInt(req.Header.Get(WebhookTimestampHeader), 10, 64)
+			assert.Equal(t, signWebhook("default-secret", timestamp, body), req.Header.Get(WebhookSignatureHeader))
+
+			letters, err := manager.store.ListDeadLetters()
+			assert.NoError(t, err)
+			if tt.status == "failed" {
+				if assert.Len(t, letters, 1) {
+					assert.Equal(t, job.ID, letters[0].JobID)
+					assert.Equal(t, 3, letters[0].Attempts)
+				}
+			} else {
+				assert.Empty(t, letters)
+			}
+		})
+	}
+}
+
+func TestCreateJob_CallbackValidation(t *testing.T) {
+	manager, r := setupJobTest(t, nil)
+
+	// No signing secret configured
+	w := postJob(r, map[string]string{"callback_url": "https://example.com/cb"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	manager.webhooks = newTestWebhookSender(1)
+	w = postJob(r, map[string]string{"callback_url": "mailto:ops@example.com"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}